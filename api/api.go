@@ -0,0 +1,116 @@
+package api
+
+import (
+	"github.com/failuretoload/datamonster/archive"
+	"github.com/failuretoload/datamonster/auth"
+	"github.com/failuretoload/datamonster/history"
+	"github.com/failuretoload/datamonster/notify"
+	"github.com/failuretoload/datamonster/openapi"
+	"github.com/failuretoload/datamonster/ratelimit"
+	"github.com/failuretoload/datamonster/settlement"
+	"github.com/failuretoload/datamonster/store"
+	"github.com/failuretoload/datamonster/survivor"
+	"github.com/failuretoload/datamonster/survivor/showdown"
+	"github.com/failuretoload/datamonster/timeline"
+	"github.com/failuretoload/datamonster/token"
+	"github.com/failuretoload/datamonster/user"
+	"github.com/failuretoload/datamonster/web"
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	Title   = "Datamonster API"
+	Version = "2.0.0"
+)
+
+// Versions lists every API version served, oldest first. Set a version's
+// Deprecation once its successor replaces what it serves.
+var Versions = []web.Version{
+	{Name: "v1"},
+	{Name: "v2"},
+}
+
+// Controller is implemented by every resource package. Routes registered in
+// RegisterRoutes require an authenticated caller and must be described in
+// DescribeRoutes so the published specification stays complete.
+type Controller interface {
+	RegisterRoutes(r chi.Router)
+	DescribeRoutes(d *openapi.Document)
+}
+
+// VersionedController is implemented by controllers whose routes or payloads
+// differ between API versions. Controllers implementing only Controller serve
+// the same routes in every version.
+type VersionedController interface {
+	Controller
+	RegisterVersionRoutes(version string, r chi.Router)
+	DescribeVersionRoutes(version string, d *openapi.Document)
+}
+
+// PublicController is implemented by controllers that also serve routes to
+// anonymous callers. Describe them with openapi.Route.Public set.
+type PublicController interface {
+	RegisterPublicRoutes(r chi.Router)
+}
+
+// DefaultBudget applies to every caller across all versioned routes, in
+// addition to the budgets of individual routes.
+var DefaultBudget = ratelimit.PerMinute(300)
+
+// Scopes lists every scope a personal access token can be granted.
+var Scopes = []string{settlement.ScopeRead, settlement.ScopeWrite, survivor.ScopeRead, survivor.ScopeWrite, user.ScopeRead, user.ScopeWrite}
+
+// Controllers returns every resource controller. Changes to settlements and
+// survivors are announced to publisher, and streamed to clients from hub.
+// Showdowns are played in showdowns.
+func Controllers(conn store.Connection, hub *notify.Hub, publisher notify.Publisher, showdowns *showdown.Sessions) []Controller {
+	return []Controller{
+		survivor.NewController(conn, publisher),
+		settlement.NewController(conn, publisher),
+		archive.NewController(conn),
+		history.NewController(conn, publisher, settlement.ScopeRead, settlement.ScopeWrite),
+		notify.NewController(conn, hub, settlement.ScopeRead),
+		showdown.NewController(conn, showdowns),
+		timeline.NewController(conn, settlement.ScopeRead, settlement.ScopeWrite),
+		token.NewController(conn, Scopes...),
+		user.NewController(conn),
+	}
+}
+
+// Mount registers the controllers' routes below each version's prefix on r and
+// returns the specification of everything it registered. The oldest version is
+// also served without a prefix, as it was before the API was versioned.
+func Mount(r chi.Router, versions []web.Version, controllers ...Controller) *openapi.Document {
+	doc := openapi.New(Title, Version)
+	for _, v := range versions {
+		r.Route(v.Prefix(), func(r chi.Router) {
+			mountVersion(r, v, doc.Group(v.Prefix()), controllers)
+		})
+	}
+	r.Group(func(r chi.Router) {
+		mountVersion(r, versions[0], doc, controllers)
+	})
+	return doc
+}
+
+func mountVersion(r chi.Router, v web.Version, spec *openapi.Document, controllers []Controller) {
+	r.Use(v.Headers)
+	r.Use(ratelimit.Limit("api", DefaultBudget))
+	for _, c := range controllers {
+		if pc, ok := c.(PublicController); ok {
+			pc.RegisterPublicRoutes(r)
+		}
+	}
+	r.Group(func(r chi.Router) {
+		r.Use(auth.Required)
+		for _, c := range controllers {
+			if vc, ok := c.(VersionedController); ok {
+				vc.RegisterVersionRoutes(v.Name, r)
+				vc.DescribeVersionRoutes(v.Name, spec)
+				continue
+			}
+			c.RegisterRoutes(r)
+			c.DescribeRoutes(spec)
+		}
+	})
+}
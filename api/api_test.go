@@ -0,0 +1,108 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/failuretoload/datamonster/auth"
+	"github.com/failuretoload/datamonster/notify"
+	storeMocks "github.com/failuretoload/datamonster/store/mocks"
+	"github.com/failuretoload/datamonster/survivor/showdown"
+	"github.com/failuretoload/datamonster/web"
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/suite"
+)
+
+type SpecTestSuite struct {
+	suite.Suite
+	router *chi.Mux
+}
+
+func (suite *SpecTestSuite) SetupTest() {
+	suite.router = chi.NewRouter()
+}
+
+func (suite *SpecTestSuite) Test_Spec_DescribesEveryRegisteredRoute() {
+	doc := Mount(suite.router, Versions, Controllers(&storeMocks.MockConnection{}, notify.NewHub(), notify.NewHub(), showdown.NewSessions(&storeMocks.MockConnection{}, notify.NewHub()))...)
+
+	registered := 0
+	err := chi.Walk(suite.router, func(method string, route string, handler http.Handler, middlewares ...func(http.Handler) http.Handler) error {
+		registered++
+		suite.True(doc.Has(method, route), "%s %s is registered but missing from the OpenAPI spec", method, route)
+		return nil
+	})
+
+	suite.NoError(err)
+	suite.Equal(registered, len(doc.Operations()), "the spec should not describe routes that are not registered: %v", doc.Operations())
+}
+
+func (suite *SpecTestSuite) Test_Mount_MarksDeprecatedVersions() {
+	db := &storeMocks.MockConnection{}
+	db.SetRow(&storeMocks.ErrorRow{Error: pgx.ErrNoRows})
+	versions := []web.Version{
+		{Name: "v1", Deprecation: time.Date(2026, time.January, 1, 0, 0, 0, 0, time.UTC), Sunset: time.Date(2027, time.January, 1, 0, 0, 0, 0, time.UTC), Successor: "v2"},
+		{Name: "v2"},
+	}
+	suite.router.Use(asUser)
+	Mount(suite.router, versions, Controllers(db, notify.NewHub(), notify.NewHub(), showdown.NewSessions(db, notify.NewHub()))...)
+
+	w := httptest.NewRecorder()
+	suite.router.ServeHTTP(w, httptest.NewRequest("GET", "/v1/settlements/1", nil))
+	suite.Equal(404, w.Code, "v1 routes should be served")
+	suite.Equal("@1767225600", w.Header().Get("Deprecation"), "deprecated versions should announce it")
+	suite.Equal("Fri, 01 Jan 2027 00:00:00 GMT", w.Header().Get("Sunset"), "deprecated versions should announce their sunset")
+	suite.Equal(`</v2>; rel="successor-version"`, w.Header().Get("Link"), "deprecated versions should link their successor")
+
+	w = httptest.NewRecorder()
+	suite.router.ServeHTTP(w, httptest.NewRequest("GET", "/v2/settlements/1", nil))
+	suite.Equal(404, w.Code, "v2 routes should be served alongside v1")
+	suite.Empty(w.Header().Get("Deprecation"), "current versions should not be deprecated")
+
+}
+
+func (suite *SpecTestSuite) Test_Mount_ServesTheOldestVersionWithoutAPrefix() {
+	db := &storeMocks.MockConnection{}
+	db.SetRow(&storeMocks.ErrorRow{Error: pgx.ErrNoRows})
+	versions := []web.Version{
+		{Name: "v1", Deprecation: time.Date(2026, time.January, 1, 0, 0, 0, 0, time.UTC), Successor: "v2"},
+		{Name: "v2"},
+	}
+	suite.router.Use(asUser)
+	Mount(suite.router, versions, Controllers(db, notify.NewHub(), notify.NewHub(), showdown.NewSessions(db, notify.NewHub()))...)
+
+	w := httptest.NewRecorder()
+	suite.router.ServeHTTP(w, httptest.NewRequest("GET", "/settlements/1", nil))
+
+	suite.Equal(404, w.Code)
+	suite.Equal(web.ProblemContentType, w.Header().Get("Content-Type"), "unversioned routes should reach the v1 controllers")
+	suite.Equal("@1767225600", w.Header().Get("Deprecation"), "unversioned routes should carry the v1 headers")
+}
+
+func (suite *SpecTestSuite) Test_Mount_RequiresAuthenticationByDefault() {
+	doc := Mount(suite.router, Versions, Controllers(&storeMocks.MockConnection{}, notify.NewHub(), notify.NewHub(), showdown.NewSessions(&storeMocks.MockConnection{}, notify.NewHub()))...)
+
+	w := httptest.NewRecorder()
+	suite.router.ServeHTTP(w, httptest.NewRequest("GET", "/v2/settlements", nil))
+
+	suite.Equal(401, w.Code, "anonymous callers should be rejected")
+	suite.Contains(w.Header().Get("WWW-Authenticate"), "Bearer", "anonymous callers should be challenged")
+	for path, item := range doc.Paths {
+		for method, op := range item {
+			suite.NotEmpty(op.Security, "%s %s should be documented as protected", method, path)
+		}
+	}
+}
+
+// asUser authenticates every request as a user, who is not limited by scopes.
+func asUser(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(auth.WithPrincipal(r.Context(), auth.Principal{Subject: "userId"})))
+	})
+}
+
+func TestSpecTestSuite(t *testing.T) {
+	suite.Run(t, new(SpecTestSuite))
+}
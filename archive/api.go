@@ -0,0 +1,163 @@
+package archive
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/failuretoload/datamonster/auth"
+	"github.com/failuretoload/datamonster/metrics"
+	"github.com/failuretoload/datamonster/openapi"
+	"github.com/failuretoload/datamonster/ratelimit"
+	"github.com/failuretoload/datamonster/settlement"
+	"github.com/failuretoload/datamonster/store"
+	"github.com/failuretoload/datamonster/survivor"
+	"github.com/failuretoload/datamonster/user"
+	"github.com/failuretoload/datamonster/web"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// importBudget limits how quickly a caller can import settlements.
+var importBudget = ratelimit.PerMinute(10)
+
+// Controller exports and imports settlements. Both need the scopes of the
+// settlements and of their survivors.
+type Controller struct {
+	repo *postgresRepo
+}
+
+func NewController(conn store.Connection) *Controller {
+	return &Controller{repo: newRepo(conn)}
+}
+
+// Sources of imported documents.
+const (
+	SourceDatamonster = "datamonster"
+	SourceSheet       = "csv"
+	SourceKDMManager  = "kdm-manager"
+)
+
+// ImportQuery selects the source of an imported document and whether to only
+// report how it translates. Survivor sheets hold no settlement, which is named
+// by Name and placed in lantern year Year.
+type ImportQuery struct {
+	Source string `query:"source" validate:"oneof=datamonster csv kdm-manager"`
+	DryRun bool   `query:"dryRun"`
+	Name   string `query:"name" validate:"max=64"`
+	Year   *int   `query:"year" validate:"min=1,max=99"`
+}
+
+// ReportDTO is the document an import would create and the fields of the
+// source it would drop.
+type ReportDTO struct {
+	Document Document `json:"document"`
+	Unmapped []string `json:"unmapped"`
+}
+
+// ImportedDTO holds the ids given to an imported settlement and its survivors,
+// which are listed in the order of the document, and the fields of the source
+// that were dropped.
+type ImportedDTO struct {
+	Settlement int      `json:"settlement"`
+	Survivors  []int    `json:"survivors"`
+	Unmapped   []string `json:"unmapped,omitempty"`
+}
+
+func (c Controller) RegisterRoutes(r chi.Router) {
+	read := r.With(auth.RequireScope(settlement.ScopeRead), auth.RequireScope(survivor.ScopeRead))
+	write := r.With(auth.RequireScope(settlement.ScopeWrite), auth.RequireScope(survivor.ScopeWrite))
+	read.Get("/settlements/{id}/export", c.export)
+	write.With(ratelimit.Limit("settlements.import", importBudget)).Post("/settlements/import", c.importSettlement)
+}
+
+func (c Controller) DescribeRoutes(d *openapi.Document) {
+	tags := []string{"settlements"}
+	d.Add(openapi.Route{Method: http.MethodGet, Path: "/settlements/{id}/export", ID: "exportSettlement", Summary: "Export a settlement with its survivors and innovations as a versioned document", Tags: tags, Scopes: []string{settlement.ScopeRead, survivor.ScopeRead}, Response: Document{}})
+	d.Add(openapi.Route{Method: http.MethodPost, Path: "/settlements/import", ID: "importSettlement", Summary: "Found a settlement from an exported document, a CSV survivor sheet or a kdm-manager export, giving it and its survivors new ids; a dry run reports the translated document and the fields it would drop", Tags: tags, Params: openapi.QueryParams(ImportQuery{}), Scopes: []string{settlement.ScopeWrite, survivor.ScopeWrite}, Request: Document{}, Response: ImportedDTO{}, Status: http.StatusCreated})
+}
+
+func (c Controller) export(w http.ResponseWriter, r *http.Request) {
+	settlementId, ok := web.PathId(w, r, "id", "settlement")
+	if !ok {
+		return
+	}
+	u, _ := user.FromContext(r.Context())
+	doc, err := c.repo.Export(r.Context(), u.Subject, settlementId)
+	if err != nil {
+		web.WriteError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="settlement-%d.json"`, settlementId))
+	web.MakeJsonResponse(w, http.StatusOK, doc)
+}
+
+func (c Controller) importSettlement(w http.ResponseWriter, r *http.Request) {
+	u, _ := user.FromContext(r.Context())
+	query := ImportQuery{Source: SourceDatamonster}
+	if err := web.DecodeQuery(r, &query); err != nil {
+		web.WriteError(w, r, err)
+		return
+	}
+	if query.Source == SourceSheet && query.Name == "" {
+		web.WriteError(w, r, &web.ValidationError{Fields: []web.FieldError{{Field: "name", Message: "is required to import a survivor sheet"}}})
+		return
+	}
+	doc, unmapped, err := read(w, r, query)
+	var requestErr *web.RequestError
+	if errors.As(err, &requestErr) {
+		web.WriteError(w, r, err)
+		return
+	}
+	if err := doc.validate(err); err != nil {
+		web.WriteError(w, r, err)
+		return
+	}
+	if query.DryRun {
+		web.MakeJsonResponse(w, http.StatusOK, ReportDTO{Document: doc, Unmapped: unmapped})
+		return
+	}
+	settlementId, survivors, err := c.repo.Import(r.Context(), u.Subject, doc)
+	if err != nil {
+		web.WriteError(w, r, err)
+		return
+	}
+	metrics.SettlementsCreated.Inc()
+	web.MakeJsonResponse(w, http.StatusCreated, ImportedDTO{Settlement: settlementId, Survivors: survivors, Unmapped: unmapped})
+}
+
+// read decodes the body of an import from its source. Documents translated from
+// other trackers are checked against the rules of the document's fields too.
+func read(w http.ResponseWriter, r *http.Request, q ImportQuery) (Document, []string, error) {
+	var doc Document
+	var unmapped []string
+	var err error
+	body := http.MaxBytesReader(w, r.Body, web.MaxRequestBodyBytes)
+	switch q.Source {
+	case SourceSheet:
+		doc, unmapped, err = fromSheet(body, q.Name, q.Year)
+	case SourceKDMManager:
+		var data []byte
+		if data, err = io.ReadAll(body); err == nil {
+			doc, unmapped, err = fromKDMManager(data)
+		}
+	default:
+		return doc, []string{}, web.DecodeAndValidate(w, r, &doc)
+	}
+	var tooLarge *http.MaxBytesError
+	var invalid *web.ValidationError
+	switch {
+	case errors.As(err, &tooLarge):
+		return doc, nil, &web.RequestError{Status: http.StatusRequestEntityTooLarge, Message: fmt.Sprintf("request body must not exceed %d bytes", web.MaxRequestBodyBytes)}
+	case err != nil && !errors.As(err, &invalid):
+		return doc, nil, &web.RequestError{Status: http.StatusBadRequest, Message: err.Error()}
+	}
+	errs := &web.ValidationError{}
+	for _, found := range []error{err, web.Validate(doc)} {
+		if v, ok := found.(*web.ValidationError); ok {
+			errs.Fields = append(errs.Fields, v.Fields...)
+		}
+	}
+	return doc, unmapped, errs.OrNil()
+}
@@ -0,0 +1,331 @@
+package archive
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/failuretoload/datamonster/auth"
+	storeMocks "github.com/failuretoload/datamonster/store/mocks"
+	"github.com/failuretoload/datamonster/timeline"
+	"github.com/failuretoload/datamonster/user"
+	"github.com/failuretoload/datamonster/web"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/suite"
+)
+
+const testUserId = "userId"
+
+type ArchiveApiTestSuite struct {
+	suite.Suite
+	db     *storeMocks.MockConnection
+	router *chi.Mux
+}
+
+func (suite *ArchiveApiTestSuite) SetupTest() {
+	suite.db = &storeMocks.MockConnection{}
+	suite.router = chi.NewRouter()
+	suite.router.Use(asUser)
+	NewController(suite.db).RegisterRoutes(suite.router)
+}
+
+func (suite *ArchiveApiTestSuite) Test_Export_WritesTheSettlementWithItsSurvivors() {
+	dead := "dead"
+	suite.db.SetRow(&storeMocks.RowSequence{Rows: []pgx.Row{
+		&SettlementRow{Settlement{Name: "Hollow", SurvivalLimit: 3, CollectiveCognition: 5, Year: 4}},
+		&InnovationsRow{Innovations: []string{"Language", "Paint"}},
+	}})
+	suite.db.SetRows(&storeMocks.MockRows{Rows: []pgx.Row{
+		&SurvivorRow{Survivor{Name: "Zach", Gender: "M", Birth: 1, Movement: 5, Disorders: []string{"Squeamish"}}},
+		&SurvivorRow{Survivor{Name: "Lucy", Gender: "F", Birth: 3, Movement: 5, Status: &dead, Disorders: []string{}}},
+	}})
+	w := httptest.NewRecorder()
+
+	suite.router.ServeHTTP(w, httptest.NewRequest("GET", "/settlements/1/export", nil))
+
+	suite.Require().Equal(http.StatusOK, w.Code)
+	suite.Equal(`attachment; filename="settlement-1.json"`, w.Header().Get("Content-Disposition"))
+	var doc Document
+	suite.Require().NoError(json.Unmarshal(w.Body.Bytes(), &doc))
+	suite.Equal(Format, doc.Format)
+	suite.Equal(Version, doc.Version)
+	suite.False(doc.ExportedAt.IsZero())
+	suite.Equal(Settlement{Name: "Hollow", SurvivalLimit: 3, CollectiveCognition: 5, Year: 4}, doc.Settlement)
+	suite.Equal([]string{"Language", "Paint"}, doc.Innovations)
+	suite.Equal([]Survivor{
+		{Name: "Zach", Gender: "M", Birth: 1, Movement: 5, Disorders: []string{"Squeamish"}},
+		{Name: "Lucy", Gender: "F", Birth: 3, Movement: 5, Status: &dead, Disorders: []string{}},
+	}, doc.Survivors)
+	suite.Contains(suite.db.Statements[0].SQL, "REPEATABLE READ")
+	suite.Equal([]any{1, testUserId}, suite.db.Statements[1].Args)
+	suite.NotContains(w.Body.String(), `"id"`, "documents should not carry ids")
+}
+
+func (suite *ArchiveApiTestSuite) Test_Export_ReportsReadErrors() {
+	suite.db.SetRow(&storeMocks.RowSequence{Rows: []pgx.Row{
+		&SettlementRow{Settlement{Name: "Hollow", SurvivalLimit: 3, Year: 4}},
+		&InnovationsRow{Innovations: []string{}},
+	}})
+	suite.db.SetRows(&storeMocks.MockRows{Error: errors.New("connection reset")})
+	w := httptest.NewRecorder()
+
+	suite.router.ServeHTTP(w, httptest.NewRequest("GET", "/settlements/1/export", nil))
+
+	suite.Equal(http.StatusInternalServerError, w.Code, "an export missing survivors should not be written")
+	suite.Empty(w.Header().Get("Content-Disposition"))
+}
+
+func (suite *ArchiveApiTestSuite) Test_Export_HidesOtherPlayersSettlements() {
+	suite.db.SetRow(&storeMocks.ErrorRow{Error: pgx.ErrNoRows})
+	w := httptest.NewRecorder()
+
+	suite.router.ServeHTTP(w, httptest.NewRequest("GET", "/settlements/1/export", nil))
+
+	suite.Equal(http.StatusNotFound, w.Code)
+}
+
+func (suite *ArchiveApiTestSuite) Test_Import_RecreatesTheSettlementWithNewIds() {
+	suite.db.SetRow(&storeMocks.RowSequence{Rows: []pgx.Row{
+		&storeMocks.InsertRow{Id: 7}, &storeMocks.InsertRow{Id: 20}, &storeMocks.InsertRow{Id: 21},
+	}})
+	body := `{"format": "datamonster.settlement", "version": 1, "exportedAt": "2026-10-01T12:00:00Z",
+		"settlement": {"name": "Hollow", "survivalLimit": 3, "departingSurvival": 1, "collectiveCognition": 5, "year": 2},
+		"innovations": ["Language"],
+		"survivors": [
+			{"name": "Lucy", "gender": "F", "birth": 2, "movement": 5, "status": "retired"},
+			{"name": "Zach", "gender": "M", "birth": 0, "movement": 5, "disorders": ["Squeamish"]}
+		]}`
+	w := httptest.NewRecorder()
+
+	suite.router.ServeHTTP(w, httptest.NewRequest("POST", "/settlements/import", strings.NewReader(body)))
+
+	suite.Require().Equal(http.StatusCreated, w.Code, w.Body.String())
+	suite.JSONEq(`{"settlement": 7, "survivors": [20, 21]}`, w.Body.String())
+	suite.True(suite.db.Committed)
+	suite.Equal([]any{testUserId, "Hollow", 3, 1, 5, 2}, suite.db.Statements[0].Args)
+	var survivors, events []storeMocks.Statement
+	for _, s := range suite.db.Statements {
+		switch {
+		case strings.HasPrefix(s.SQL, "INSERT INTO campaign.survivor"):
+			survivors = append(survivors, s)
+		case strings.HasPrefix(s.SQL, "INSERT INTO campaign.settlement_event"):
+			events = append(events, s)
+		}
+	}
+	suite.Require().Len(survivors, 2)
+	suite.Equal(7, survivors[0].Args[0], "survivors should join the new settlement")
+	suite.Equal([]string{"Squeamish"}, survivors[1].Args[19])
+	suite.Require().Len(events, 5)
+	suite.Equal([]any{"SettlementFounded", "SurvivorBorn", "LanternYearAdvanced", "SurvivorBorn", "InnovationAdopted"},
+		[]any{events[0].Args[1], events[1].Args[1], events[2].Args[1], events[3].Args[1], events[4].Args[1]})
+	suite.JSONEq(`{"survivorId": 21, "name": "Zach", "gender": "M", "birth": 0}`, string(events[1].Args[2].([]byte)))
+}
+
+func (suite *ArchiveApiTestSuite) Test_Import_ReportsEveryInvalidField() {
+	body := `{"format": "other", "version": 2,
+		"settlement": {"name": "", "year": 1},
+		"innovations": ["Language", "Language"],
+		"survivors": [
+			{"name": "Lucy", "gender": "F"},
+			{"name": "Lucy", "gender": "X"}
+		]}`
+	w := httptest.NewRecorder()
+
+	suite.router.ServeHTTP(w, httptest.NewRequest("POST", "/settlements/import", strings.NewReader(body)))
+
+	suite.Require().Equal(http.StatusUnprocessableEntity, w.Code)
+	var problem web.ValidationProblem
+	suite.Require().NoError(json.Unmarshal(w.Body.Bytes(), &problem))
+	fields := []string{}
+	for _, f := range problem.Errors {
+		fields = append(fields, f.Field)
+	}
+	suite.Equal([]string{"settlement.name", "format", "version", "innovations[1]", "survivors[1].gender", "survivors[1].name"}, fields)
+	suite.Empty(suite.db.Statements, "nothing should be imported from an invalid document")
+}
+
+func (suite *ArchiveApiTestSuite) Test_Import_RejectsUnknownFields() {
+	w := httptest.NewRecorder()
+
+	suite.router.ServeHTTP(w, httptest.NewRequest("POST", "/settlements/import", strings.NewReader(`{"format": "datamonster.settlement", "version": 1, "settlement": {"name": "Hollow", "year": 1}, "owner": "someone"}`)))
+
+	suite.Equal(http.StatusUnprocessableEntity, w.Code)
+	suite.Contains(w.Body.String(), `"field":"owner"`)
+}
+
+func (suite *ArchiveApiTestSuite) Test_Import_DryRunReportsHowASheetTranslates() {
+	sheet := "\ufeffName,Sex,Born in LY,Hunt XP,Movement,Status,Disorders,Weapon Proficiency\n" +
+		"Lucy,female,2,3,,retired,\"Squeamish; Hoarder\",Sword\n" +
+		",,,,,,,\n" +
+		"Zach,M,1,0,6,alive,,\n"
+	w := httptest.NewRecorder()
+
+	suite.router.ServeHTTP(w, httptest.NewRequest("POST", "/settlements/import?source=csv&name=Hollow&dryRun=true", strings.NewReader(sheet)))
+
+	suite.Require().Equal(http.StatusOK, w.Code, w.Body.String())
+	var report ReportDTO
+	suite.Require().NoError(json.Unmarshal(w.Body.Bytes(), &report))
+	retired := "retired"
+	suite.Equal(Settlement{Name: "Hollow", SurvivalLimit: 1, Year: 2}, report.Document.Settlement, "the settlement should reach the latest birth")
+	suite.Equal([]Survivor{
+		{Name: "Lucy", Gender: "F", Birth: 2, HuntXp: 3, Movement: 5, Status: &retired, Disorders: []string{"Squeamish", "Hoarder"}},
+		{Name: "Zach", Gender: "M", Birth: 1, Movement: 6},
+	}, report.Document.Survivors)
+	suite.Equal([]string{"Weapon Proficiency"}, report.Unmapped)
+	suite.Empty(suite.db.Statements, "a dry run should not import anything")
+}
+
+func (suite *ArchiveApiTestSuite) Test_Import_ReportsSheetCellsByRow() {
+	sheet := "name,gender,survival\nLucy,F,lots\nZach,X,1\n"
+	w := httptest.NewRecorder()
+
+	suite.router.ServeHTTP(w, httptest.NewRequest("POST", "/settlements/import?source=csv&name=Hollow&year=3", strings.NewReader(sheet)))
+
+	suite.Require().Equal(http.StatusUnprocessableEntity, w.Code)
+	suite.Contains(w.Body.String(), `{"field":"rows[2].survival","message":"must be a whole number"}`)
+	suite.Contains(w.Body.String(), `"field":"survivors[1].gender"`)
+}
+
+func (suite *ArchiveApiTestSuite) Test_Import_RequiresANameForSheets() {
+	w := httptest.NewRecorder()
+
+	suite.router.ServeHTTP(w, httptest.NewRequest("POST", "/settlements/import?source=csv", strings.NewReader("name\nLucy\n")))
+
+	suite.Equal(http.StatusUnprocessableEntity, w.Code)
+	suite.Contains(w.Body.String(), `"field":"name"`)
+}
+
+func (suite *ArchiveApiTestSuite) Test_Import_RejectsUnreadableSheets() {
+	w := httptest.NewRecorder()
+
+	suite.router.ServeHTTP(w, httptest.NewRequest("POST", "/settlements/import?source=csv&name=Hollow", strings.NewReader("name\n\"Lucy\n")))
+
+	suite.Equal(http.StatusBadRequest, w.Code)
+}
+
+func (suite *ArchiveApiTestSuite) Test_Import_TranslatesKDMManagerExports() {
+	suite.db.SetRow(&storeMocks.RowSequence{Rows: []pgx.Row{&storeMocks.InsertRow{Id: 7}, &storeMocks.InsertRow{Id: 20}}})
+	export := `{
+		"settlement": {
+			"sheet": {"name": "Hollow", "survival_limit": 4, "lantern_year": 3, "innovations": ["language", "black_lantern"],
+				"principles": ["cannibalize"], "death_count": 0},
+			"meta": {"version": "1.2"}
+		},
+		"user_assets": {"survivors": [
+			{"sheet": {"name": "Lucy", "sex": "F", "born_in_ly": 1, "hunt_xp": 2, "Insanity": 4, "Movement": 5,
+				"dead": true, "retired": true, "disorders": ["squeamish"], "fighting_arts": ["clutch_fighter"], "epithets": []}}
+		]},
+		"campaign": "People of the Lantern"
+	}`
+	w := httptest.NewRecorder()
+
+	suite.router.ServeHTTP(w, httptest.NewRequest("POST", "/settlements/import?source=kdm-manager", strings.NewReader(export)))
+
+	suite.Require().Equal(http.StatusCreated, w.Code, w.Body.String())
+	suite.JSONEq(`{"settlement": 7, "survivors": [20],
+		"unmapped": ["campaign", "settlement.meta", "settlement.sheet.principles", "user_assets.survivors[].sheet.fighting_arts"]}`, w.Body.String())
+	suite.Equal([]any{testUserId, "Hollow", 4, 0, 0, 3}, suite.db.Statements[0].Args)
+	var survivor storeMocks.Statement
+	for _, s := range suite.db.Statements {
+		if strings.HasPrefix(s.SQL, "INSERT INTO campaign.survivor") {
+			survivor = s
+		}
+	}
+	dead := "dead"
+	suite.Equal([]any{7, "Lucy", "F", 1, &dead, 2, 0, 5, 0, 0, 0, 0, 0, 4, 0, 0, 0, 0, 0, []string{"Squeamish"}}, survivor.Args)
+}
+
+func (suite *ArchiveApiTestSuite) Test_Import_ReportsKDMManagerFieldsByPath() {
+	export := `{"settlement": {"sheet": {"name": "Hollow", "lantern_year": "three"}},
+		"user_assets": {"survivors": [{"sheet": {"name": "Lucy", "sex": "F"}}, {"sheet": {"name": "Zach", "sex": "M", "dead": "yes"}}]}}`
+	w := httptest.NewRecorder()
+
+	suite.router.ServeHTTP(w, httptest.NewRequest("POST", "/settlements/import?source=kdm-manager&dryRun=true", strings.NewReader(export)))
+
+	suite.Require().Equal(http.StatusUnprocessableEntity, w.Code)
+	var problem web.ValidationProblem
+	suite.Require().NoError(json.Unmarshal(w.Body.Bytes(), &problem))
+	suite.Equal([]web.FieldError{
+		{Field: "settlement.sheet.lantern_year", Message: "must be a whole number"},
+		{Field: "user_assets.survivors[1].sheet.dead", Message: "must be true or false"},
+	}, problem.Errors)
+}
+
+func (suite *ArchiveApiTestSuite) Test_Events_PlaceSurvivorsInTheYearTheyWereBorn() {
+	d := Document{
+		Settlement: Settlement{Name: "Hollow", Year: 3},
+		Survivors:  []Survivor{{Name: "Late", Birth: 9}, {Name: "Second", Birth: 2}, {Name: "First", Birth: 0}},
+	}
+
+	stream := events(d, []int{1, 2, 3})
+
+	suite.Equal([]timeline.Event{
+		timeline.SettlementFounded{Name: "Hollow"},
+		timeline.SurvivorBorn{SurvivorId: 3, Name: "First", Birth: 0},
+		timeline.LanternYearAdvanced{Year: 2},
+		timeline.SurvivorBorn{SurvivorId: 2, Name: "Second", Birth: 2},
+		timeline.LanternYearAdvanced{Year: 3},
+		timeline.SurvivorBorn{SurvivorId: 1, Name: "Late", Birth: 9},
+	}, stream, "survivors born after the current year should join in it")
+}
+
+// asUser authenticates every request as a user, who is not limited by scopes.
+func asUser(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := auth.WithPrincipal(r.Context(), auth.Principal{Subject: testUserId})
+		next.ServeHTTP(w, r.WithContext(user.WithUser(ctx, user.User{Subject: testUserId})))
+	})
+}
+
+func TestArchiveApiTestSuite(t *testing.T) {
+	suite.Run(t, new(ArchiveApiTestSuite))
+}
+
+type SettlementRow struct {
+	Settlement
+}
+
+func (s *SettlementRow) Scan(dest ...any) error {
+	*dest[0].(*string) = s.Name
+	*dest[1].(*int) = s.SurvivalLimit
+	*dest[2].(*int) = s.DepartingSurvival
+	*dest[3].(*int) = s.CollectiveCognition
+	*dest[4].(*int) = s.Year
+	return nil
+}
+
+type InnovationsRow struct {
+	Innovations []string
+}
+
+func (i *InnovationsRow) Scan(dest ...any) error {
+	*dest[0].(*[]string) = i.Innovations
+	return nil
+}
+
+type SurvivorRow struct {
+	Survivor
+}
+
+func (s *SurvivorRow) Scan(dest ...any) error {
+	values := []any{s.Name, s.Gender, s.Birth, s.Status, s.HuntXp, s.Survival, s.Movement, s.Accuracy, s.Strength, s.Evasion,
+		s.Luck, s.Speed, s.Insanity, s.SystemicPressure, s.Torment, s.Lumi, s.Courage, s.Understanding, s.Disorders}
+	for i, v := range values {
+		switch d := dest[i].(type) {
+		case *string:
+			*d = v.(string)
+		case *int:
+			*d = v.(int)
+		case **string:
+			*d = v.(*string)
+		case *[]string:
+			*d = v.([]string)
+		}
+	}
+	return nil
+}
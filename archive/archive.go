@@ -0,0 +1,134 @@
+// Package archive exports settlements, with their survivors and innovations, as
+// self-contained documents and imports them back as new settlements.
+package archive
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/failuretoload/datamonster/timeline"
+	"github.com/failuretoload/datamonster/web"
+)
+
+// Format identifies settlement documents.
+const Format = "datamonster.settlement"
+
+// Version is the version of the document format written by exports. Imports
+// read every version up to it.
+const Version = 1
+
+// Document is an exported settlement. It holds no ids, so that it can be
+// imported into any account.
+type Document struct {
+	Format     string     `json:"format" validate:"required"`
+	Version    int        `json:"version" validate:"min=1"`
+	ExportedAt time.Time  `json:"exportedAt"`
+	Settlement Settlement `json:"settlement"`
+	// Innovations are listed in the order the settlement adopted them.
+	Innovations []string   `json:"innovations" validate:"max=200"`
+	Survivors   []Survivor `json:"survivors" validate:"max=500"`
+}
+
+type Settlement struct {
+	Name                string `json:"name" validate:"required,max=64"`
+	SurvivalLimit       int    `json:"survivalLimit" validate:"min=0,max=99"`
+	DepartingSurvival   int    `json:"departingSurvival" validate:"min=0,max=99"`
+	CollectiveCognition int    `json:"collectiveCognition" validate:"min=0,max=999"`
+	Year                int    `json:"year" validate:"min=1,max=99"`
+}
+
+type Survivor struct {
+	Name             string   `json:"name" validate:"required,max=64"`
+	Gender           string   `json:"gender" validate:"required,oneof=M F"`
+	Birth            int      `json:"birth" validate:"min=0,max=99"`
+	Status           *string  `json:"status,omitempty" validate:"oneof=dead retired skipsHunt"`
+	HuntXp           int      `json:"huntXp" validate:"min=0,max=16"`
+	Survival         int      `json:"survival" validate:"min=0,max=99"`
+	Movement         int      `json:"movement" validate:"min=0,max=99"`
+	Accuracy         int      `json:"accuracy" validate:"min=-99,max=99"`
+	Strength         int      `json:"strength" validate:"min=-99,max=99"`
+	Evasion          int      `json:"evasion" validate:"min=-99,max=99"`
+	Luck             int      `json:"luck" validate:"min=-99,max=99"`
+	Speed            int      `json:"speed" validate:"min=-99,max=99"`
+	Insanity         int      `json:"insanity" validate:"min=0,max=999"`
+	SystemicPressure int      `json:"systemicPressure" validate:"min=0,max=99"`
+	Torment          int      `json:"torment" validate:"min=0,max=99"`
+	Lumi             int      `json:"lumi" validate:"min=0,max=999"`
+	Courage          int      `json:"courage" validate:"min=0,max=9"`
+	Understanding    int      `json:"understanding" validate:"min=0,max=9"`
+	Disorders        []string `json:"disorders" validate:"max=3"`
+}
+
+// validate checks what the rules of the document's fields cannot: its format
+// and version, each survivor, and that names are not repeated. Errors found by
+// the field rules are reported along with them.
+func (d Document) validate(found error) error {
+	errs := &web.ValidationError{}
+	if v, ok := found.(*web.ValidationError); ok {
+		errs.Fields = append(errs.Fields, v.Fields...)
+	} else if found != nil {
+		return found
+	}
+	if d.Format != "" && d.Format != Format {
+		errs.Add("format", "must be "+Format)
+	}
+	if d.Version > Version {
+		errs.Add("version", fmt.Sprintf("must be at most %d, upgrade the server to import newer documents", Version))
+	}
+	for i, innovation := range d.Innovations {
+		field := "innovations[" + strconv.Itoa(i) + "]"
+		switch {
+		case strings.TrimSpace(innovation) == "" || len(innovation) > 64:
+			errs.Add(field, "must be between 1 and 64 characters")
+		case slices.Contains(d.Innovations[:i], innovation):
+			errs.Add(field, "is listed twice")
+		}
+	}
+	names := map[string]bool{}
+	for i, s := range d.Survivors {
+		prefix := "survivors[" + strconv.Itoa(i) + "]."
+		if v, ok := web.Validate(s).(*web.ValidationError); ok {
+			for _, f := range v.Fields {
+				errs.Add(prefix+f.Field, f.Message)
+			}
+		}
+		if names[s.Name] {
+			errs.Add(prefix+"name", "is used by another survivor")
+		}
+		names[s.Name] = true
+	}
+	return errs.OrNil()
+}
+
+// events lists the timeline of an imported settlement the way migration 0008
+// backfills existing ones: its founding, the survivors born in each lantern
+// year and the years that passed, followed by its innovations. ids are the new
+// ids of the document's survivors.
+func events(d Document, ids []int) []timeline.Event {
+	born := func(s Survivor) int { return min(max(s.Birth, 1), d.Settlement.Year) }
+	order := make([]int, len(d.Survivors))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return born(d.Survivors[order[a]]) < born(d.Survivors[order[b]]) })
+
+	stream := []timeline.Event{timeline.SettlementFounded{Name: d.Settlement.Name}}
+	next := 0
+	for year := 1; year <= d.Settlement.Year; year++ {
+		for ; next < len(order) && born(d.Survivors[order[next]]) == year; next++ {
+			s := d.Survivors[order[next]]
+			stream = append(stream, timeline.SurvivorBorn{SurvivorId: ids[order[next]], Name: s.Name, Gender: s.Gender, Birth: s.Birth})
+		}
+		if year < d.Settlement.Year {
+			stream = append(stream, timeline.LanternYearAdvanced{Year: year + 1})
+		}
+	}
+	for _, innovation := range d.Innovations {
+		stream = append(stream, timeline.InnovationAdopted{Innovation: innovation})
+	}
+	return stream
+}
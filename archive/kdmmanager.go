@@ -0,0 +1,214 @@
+package archive
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/failuretoload/datamonster/web"
+)
+
+// object reads the fields of a JSON object, matching their names regardless of
+// case, and remembers the fields it did not read.
+type object struct {
+	// path names the object in errors and group in the report of unmapped
+	// fields, where the items of a list are reported together.
+	path   string
+	group  string
+	fields map[string]json.RawMessage
+	names  map[string]string
+	errs   *web.ValidationError
+}
+
+func newObject(path, group string, raw json.RawMessage, errs *web.ValidationError) *object {
+	o := &object{path: path, group: group, fields: map[string]json.RawMessage{}, names: map[string]string{}, errs: errs}
+	var fields map[string]json.RawMessage
+	if len(raw) > 0 && json.Unmarshal(raw, &fields) != nil {
+		errs.Add(path, "must be an object")
+	}
+	for name, value := range fields {
+		o.fields[strings.ToLower(name)] = value
+		o.names[strings.ToLower(name)] = name
+	}
+	return o
+}
+
+// take removes a field from the object and reports whether it has a value.
+func (o *object) take(name string) (json.RawMessage, string, bool) {
+	value, ok := o.fields[name]
+	field := join(o.path, o.name(name))
+	delete(o.fields, name)
+	return value, field, ok && !empty(value)
+}
+
+// name returns the name of a field as it is spelled in the export.
+func (o *object) name(name string) string {
+	if spelled, ok := o.names[name]; ok {
+		return spelled
+	}
+	return name
+}
+
+func (o *object) object(name string) *object {
+	value, field, _ := o.take(name)
+	return newObject(field, join(o.group, o.name(name)), value, o.errs)
+}
+
+// list returns the objects of a list.
+func (o *object) list(name string) []*object {
+	value, field, ok := o.take(name)
+	var items []json.RawMessage
+	if ok && json.Unmarshal(value, &items) != nil {
+		o.errs.Add(field, "must be a list")
+	}
+	objects := make([]*object, len(items))
+	for i, item := range items {
+		objects[i] = newObject(field+"["+strconv.Itoa(i)+"]", join(o.group, o.name(name))+"[]", item, o.errs)
+	}
+	return objects
+}
+
+func (o *object) int(name string, dst *int) {
+	if value, field, ok := o.take(name); ok && json.Unmarshal(value, dst) != nil {
+		o.errs.Add(field, "must be a whole number")
+	}
+}
+
+func (o *object) string(name string, dst *string) {
+	if value, field, ok := o.take(name); ok && json.Unmarshal(value, dst) != nil {
+		o.errs.Add(field, "must be a string")
+	}
+}
+
+func (o *object) bool(name string) bool {
+	var b bool
+	if value, field, ok := o.take(name); ok && json.Unmarshal(value, &b) != nil {
+		o.errs.Add(field, "must be true or false")
+	}
+	return b
+}
+
+// handles reads a list of kdm-manager handles, such as "cannibalize", as the
+// names they stand for.
+func (o *object) handles(name string) []string {
+	var handles []string
+	if value, field, ok := o.take(name); ok && json.Unmarshal(value, &handles) != nil {
+		o.errs.Add(field, "must be a list of strings")
+	}
+	names := []string{}
+	for _, h := range handles {
+		if h = title(h); h != "" {
+			names = append(names, h)
+		}
+	}
+	return names
+}
+
+// unmapped adds the fields left with a value to report.
+func (o *object) unmapped(report map[string]bool) {
+	for name, value := range o.fields {
+		if !empty(value) {
+			report[join(o.group, o.names[name])] = true
+		}
+	}
+}
+
+func join(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+// empty reports whether a JSON value holds nothing worth importing.
+func empty(value json.RawMessage) bool {
+	switch string(bytes.TrimSpace(value)) {
+	case "", "null", `""`, "[]", "{}", "0", "false":
+		return true
+	}
+	return false
+}
+
+// title turns a handle into a name: "black_lantern" becomes "Black Lantern".
+func title(handle string) string {
+	words := strings.FieldsFunc(handle, func(r rune) bool { return r == '_' || unicode.IsSpace(r) })
+	for i, w := range words {
+		runes := []rune(w)
+		runes[0] = unicode.ToUpper(runes[0])
+		words[i] = string(runes)
+	}
+	return strings.Join(words, " ")
+}
+
+// fromKDMManager translates a kdm-manager settlement export. The settlement is
+// read from settlement.sheet and its survivors from
+// user_assets.survivors[].sheet. It also returns the fields with a value it
+// could not map, naming every survivor's fields together.
+func fromKDMManager(body []byte) (Document, []string, error) {
+	d := Document{Format: Format, Version: Version, Survivors: []Survivor{}}
+	var root map[string]json.RawMessage
+	if err := json.Unmarshal(body, &root); err != nil || root == nil {
+		return d, nil, errors.New("the kdm-manager export must be a JSON object")
+	}
+	errs := &web.ValidationError{}
+	report := map[string]bool{}
+	export := newObject("", "", body, errs)
+
+	settlement := export.object("settlement")
+	sheet := settlement.object("sheet")
+	s := &d.Settlement
+	s.SurvivalLimit = 1
+	sheet.string("name", &s.Name)
+	sheet.int("survival_limit", &s.SurvivalLimit)
+	sheet.int("lantern_year", &s.Year)
+	s.Year = max(s.Year, 1)
+	d.Innovations = sheet.handles("innovations")
+	sheet.unmapped(report)
+	settlement.unmapped(report)
+
+	assets := export.object("user_assets")
+	for _, survivor := range assets.list("survivors") {
+		sheet := survivor.object("sheet")
+		v := Survivor{Movement: 5}
+		sheet.string("name", &v.Name)
+		sheet.string("sex", &v.Gender)
+		v.Gender = gender(v.Gender)
+		sheet.int("born_in_ly", &v.Birth)
+		sheet.int("hunt_xp", &v.HuntXp)
+		sheet.int("survival", &v.Survival)
+		sheet.int("insanity", &v.Insanity)
+		sheet.int("movement", &v.Movement)
+		sheet.int("accuracy", &v.Accuracy)
+		sheet.int("strength", &v.Strength)
+		sheet.int("evasion", &v.Evasion)
+		sheet.int("luck", &v.Luck)
+		sheet.int("speed", &v.Speed)
+		sheet.int("courage", &v.Courage)
+		sheet.int("understanding", &v.Understanding)
+		v.Disorders = sheet.handles("disorders")
+		// A survivor marked dead, retired and skipping the hunt is dead.
+		for _, st := range []struct {
+			field, status string
+		}{{"skip_next_hunt", "skipsHunt"}, {"retired", "retired"}, {"dead", "dead"}} {
+			if status := st.status; sheet.bool(st.field) {
+				v.Status = &status
+			}
+		}
+		sheet.unmapped(report)
+		survivor.unmapped(report)
+		d.Survivors = append(d.Survivors, v)
+	}
+	assets.unmapped(report)
+	export.unmapped(report)
+
+	unmapped := make([]string, 0, len(report))
+	for field := range report {
+		unmapped = append(unmapped, field)
+	}
+	sort.Strings(unmapped)
+	return d, unmapped, errs.OrNil()
+}
@@ -0,0 +1,115 @@
+package archive
+
+import (
+	"context"
+	"time"
+
+	"github.com/failuretoload/datamonster/history"
+	"github.com/failuretoload/datamonster/store"
+	"github.com/failuretoload/datamonster/timeline"
+	"github.com/failuretoload/datamonster/tracing"
+	"github.com/jackc/pgx/v5"
+)
+
+type postgresRepo struct {
+	pool store.Connection
+}
+
+func newRepo(conn store.Connection) *postgresRepo {
+	return &postgresRepo{pool: conn}
+}
+
+const survivorColumns = `name, gender, birth, status, huntxp, survival, movement, accuracy, strength, evasion, luck, speed,
+	insanity, systemic_pressure, torment, lumi, courage, understanding, disorders`
+
+// Export reads one of owner's settlements into a document.
+func (r postgresRepo) Export(ctx context.Context, owner string, settlementId int) (Document, error) {
+	ctx, span := tracing.Start(ctx, "archive.Export")
+	defer span.End()
+	d := Document{Format: Format, Version: Version, ExportedAt: time.Now().UTC()}
+	err := pgx.BeginFunc(ctx, r.pool, func(tx pgx.Tx) error {
+		// Every query reads the settlement as it was when the first one ran.
+		if _, err := tx.Exec(ctx, `SET TRANSACTION ISOLATION LEVEL REPEATABLE READ, READ ONLY`); err != nil {
+			return err
+		}
+		s := &d.Settlement
+		query := `SELECT name, survival_limit, departing_survival, collective_cognition, year FROM campaign.settlement WHERE id = $1 AND owner = $2`
+		err := tx.QueryRow(ctx, query, settlementId, owner).Scan(&s.Name, &s.SurvivalLimit, &s.DepartingSurvival, &s.CollectiveCognition, &s.Year)
+		if err != nil {
+			return store.TranslateError(err, "settlement")
+		}
+		if d.Innovations, err = timeline.Innovations(ctx, tx, settlementId); err != nil {
+			return err
+		}
+		d.Survivors, err = survivors(ctx, tx, settlementId)
+		return err
+	})
+	return d, err
+}
+
+func survivors(ctx context.Context, q store.Querier, settlementId int) ([]Survivor, error) {
+	rows, err := q.Query(ctx, `SELECT `+survivorColumns+` FROM campaign.survivor WHERE settlement = $1 ORDER BY id`, settlementId)
+	if err != nil {
+		return nil, store.TranslateError(err, "survivor")
+	}
+	defer rows.Close()
+	survivors := []Survivor{}
+	for rows.Next() {
+		var s Survivor
+		err := rows.Scan(&s.Name, &s.Gender, &s.Birth, &s.Status, &s.HuntXp, &s.Survival, &s.Movement, &s.Accuracy, &s.Strength, &s.Evasion,
+			&s.Luck, &s.Speed, &s.Insanity, &s.SystemicPressure, &s.Torment, &s.Lumi, &s.Courage, &s.Understanding, &s.Disorders)
+		if err != nil {
+			return nil, store.TranslateError(err, "survivor")
+		}
+		survivors = append(survivors, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, store.TranslateError(err, "survivor")
+	}
+	return survivors, nil
+}
+
+// Import founds a settlement for owner from d, with new ids, and returns the ids
+// of the settlement and of its survivors in the order of the document. Their
+// creation is recorded as if they were made one by one.
+func (r postgresRepo) Import(ctx context.Context, owner string, d Document) (int, []int, error) {
+	ctx, span := tracing.Start(ctx, "archive.Import")
+	defer span.End()
+	var settlementId int
+	ids := make([]int, len(d.Survivors))
+	err := pgx.BeginFunc(ctx, r.pool, func(tx pgx.Tx) error {
+		s := d.Settlement
+		query := `INSERT INTO campaign.settlement (owner, name, survival_limit, departing_survival, collective_cognition, year)
+			VALUES ($1, $2, $3, $4, $5, $6) RETURNING id`
+		err := tx.QueryRow(ctx, query, owner, s.Name, s.SurvivalLimit, s.DepartingSurvival, s.CollectiveCognition, s.Year).Scan(&settlementId)
+		if err != nil {
+			return store.TranslateError(err, "settlement")
+		}
+		if err := history.Record(ctx, tx, history.Change{Settlement: settlementId, Entity: history.EntitySettlement, EntityId: settlementId, Action: history.ActionCreate}); err != nil {
+			return err
+		}
+		insert := `INSERT INTO campaign.survivor (settlement, ` + survivorColumns + `)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20) RETURNING id`
+		for i, v := range d.Survivors {
+			disorders := v.Disorders
+			if disorders == nil {
+				disorders = []string{}
+			}
+			err := tx.QueryRow(ctx, insert, settlementId, v.Name, v.Gender, v.Birth, v.Status, v.HuntXp, v.Survival, v.Movement, v.Accuracy, v.Strength,
+				v.Evasion, v.Luck, v.Speed, v.Insanity, v.SystemicPressure, v.Torment, v.Lumi, v.Courage, v.Understanding, disorders).Scan(&ids[i])
+			if err != nil {
+				return store.TranslateError(err, "survivor")
+			}
+			if err := history.Record(ctx, tx, history.Change{Settlement: settlementId, Entity: history.EntitySurvivor, EntityId: ids[i], Action: history.ActionCreate}); err != nil {
+				return err
+			}
+		}
+		for _, e := range events(d, ids) {
+			if err := timeline.Append(ctx, tx, settlementId, e); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return settlementId, ids, err
+}
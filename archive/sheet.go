@@ -0,0 +1,184 @@
+package archive
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/failuretoload/datamonster/web"
+)
+
+// column reads the cells of a survivor sheet column into a survivor. set
+// reports whether it could read the cell.
+type column struct {
+	field string
+	set   func(s *Survivor, cell string) bool
+}
+
+func text(field string, get func(*Survivor) *string) column {
+	return column{field, func(s *Survivor, cell string) bool { *get(s) = cell; return true }}
+}
+
+func number(field string, get func(*Survivor) *int) column {
+	return column{field, func(s *Survivor, cell string) bool {
+		n, err := strconv.Atoi(cell)
+		*get(s) = n
+		return err == nil
+	}}
+}
+
+var (
+	nameColumn   = text("name", func(s *Survivor) *string { return &s.Name })
+	genderColumn = column{"gender", func(s *Survivor, cell string) bool { s.Gender = gender(cell); return true }}
+	birthColumn  = number("birth", func(s *Survivor) *int { return &s.Birth })
+	huntXpColumn = number("huntXp", func(s *Survivor) *int { return &s.HuntXp })
+)
+
+// sheetColumns maps the headers of survivor sheets, lower cased and stripped
+// of everything but letters and digits, to the columns they name.
+var sheetColumns = map[string]column{
+	"name":             nameColumn,
+	"survivor":         nameColumn,
+	"gender":           genderColumn,
+	"sex":              genderColumn,
+	"birth":            birthColumn,
+	"born":             birthColumn,
+	"borninly":         birthColumn,
+	"birthyear":        birthColumn,
+	"status":           {"status", func(s *Survivor, cell string) bool { s.Status = status(cell); return true }},
+	"huntxp":           huntXpColumn,
+	"xp":               huntXpColumn,
+	"survival":         number("survival", func(s *Survivor) *int { return &s.Survival }),
+	"movement":         number("movement", func(s *Survivor) *int { return &s.Movement }),
+	"accuracy":         number("accuracy", func(s *Survivor) *int { return &s.Accuracy }),
+	"strength":         number("strength", func(s *Survivor) *int { return &s.Strength }),
+	"evasion":          number("evasion", func(s *Survivor) *int { return &s.Evasion }),
+	"luck":             number("luck", func(s *Survivor) *int { return &s.Luck }),
+	"speed":            number("speed", func(s *Survivor) *int { return &s.Speed }),
+	"insanity":         number("insanity", func(s *Survivor) *int { return &s.Insanity }),
+	"systemicpressure": number("systemicPressure", func(s *Survivor) *int { return &s.SystemicPressure }),
+	"torment":          number("torment", func(s *Survivor) *int { return &s.Torment }),
+	"lumi":             number("lumi", func(s *Survivor) *int { return &s.Lumi }),
+	"courage":          number("courage", func(s *Survivor) *int { return &s.Courage }),
+	"understanding":    number("understanding", func(s *Survivor) *int { return &s.Understanding }),
+	"disorders": {"disorders", func(s *Survivor, cell string) bool {
+		s.Disorders = strings.FieldsFunc(cell, func(r rune) bool { return r == ',' || r == ';' || r == '|' })
+		for i := range s.Disorders {
+			s.Disorders[i] = strings.TrimSpace(s.Disorders[i])
+		}
+		return true
+	}},
+}
+
+// fromSheet translates a CSV survivor sheet, with a header row, into a
+// settlement named name. The settlement is in lantern year year, or by default
+// the latest year a survivor was born in. It also returns the headers of the
+// columns it could not map. Sheets that cannot be read fail with a plain error
+// and invalid cells with a *web.ValidationError, naming the row of the sheet
+// they are on with the header as row 1.
+func fromSheet(body io.Reader, name string, year *int) (Document, []string, error) {
+	d := Document{Format: Format, Version: Version, Settlement: Settlement{Name: name, SurvivalLimit: 1, Year: 1}, Survivors: []Survivor{}}
+	reader := csv.NewReader(body)
+	reader.TrimLeadingSpace = true
+	// Spreadsheets may leave out the empty cells that end a row.
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if errors.Is(err, io.EOF) {
+		return d, nil, errors.New("the survivor sheet is empty")
+	}
+	if err != nil {
+		return d, nil, fmt.Errorf("unable to read the survivor sheet: %w", err)
+	}
+	columns := make([]*column, len(header))
+	unmapped := []string{}
+	named := false
+	for i, h := range header {
+		h = strings.TrimSpace(strings.TrimPrefix(h, "\ufeff"))
+		if c, ok := sheetColumns[normalize(h)]; ok {
+			columns[i] = &c
+			named = named || c.field == "name"
+		} else if h != "" {
+			unmapped = append(unmapped, h)
+		}
+	}
+	errs := &web.ValidationError{}
+	if !named {
+		errs.Add("columns", "must include the survivors' name")
+		return d, unmapped, errs
+	}
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return d, unmapped, fmt.Errorf("unable to read the survivor sheet: %w", err)
+		}
+		line, _ := reader.FieldPos(0)
+		if strings.TrimSpace(strings.Join(record, "")) == "" {
+			continue
+		}
+		s := Survivor{Movement: 5}
+		for i, cell := range record {
+			cell = strings.TrimSpace(cell)
+			if i >= len(columns) || columns[i] == nil || cell == "" {
+				continue
+			}
+			if !columns[i].set(&s, cell) {
+				errs.Add(fmt.Sprintf("rows[%d].%s", line, columns[i].field), "must be a whole number")
+			}
+		}
+		d.Survivors = append(d.Survivors, s)
+		d.Settlement.Year = max(d.Settlement.Year, s.Birth)
+	}
+	if year != nil {
+		d.Settlement.Year = *year
+	}
+	return d, unmapped, errs.OrNil()
+}
+
+// normalize lower cases a header and strips everything but letters and digits,
+// so that "Hunt XP" and "hunt_xp" name the same column.
+func normalize(header string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(header) {
+		if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// gender reads the usual spellings of a survivor's gender. Others are kept for
+// validation to report.
+func gender(value string) string {
+	switch normalize(value) {
+	case "m", "male":
+		return "M"
+	case "f", "female":
+		return "F"
+	}
+	return value
+}
+
+// status reads the usual spellings of a survivor's status, and nil for living
+// survivors. Others are kept for validation to report.
+func status(value string) *string {
+	var s string
+	switch normalize(value) {
+	case "", "alive", "active", "living":
+		return nil
+	case "dead", "deceased":
+		s = "dead"
+	case "retired":
+		s = "retired"
+	case "skipshunt", "skiphunt", "skipnexthunt":
+		s = "skipsHunt"
+	default:
+		s = value
+	}
+	return &s
+}
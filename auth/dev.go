@@ -0,0 +1,190 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/MicahParks/jwkset"
+	"github.com/MicahParks/keyfunc/v3"
+	"github.com/failuretoload/datamonster/web"
+	"github.com/go-chi/chi/v5"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const DevIssuerName = "datamonster-dev"
+
+// DevIssuer signs tokens with a local RSA key so the API can run and be tested
+// without an identity provider. It must never be enabled in production.
+type DevIssuer struct {
+	key  *rsa.PrivateKey
+	kid  string
+	keys keyfunc.Keyfunc
+}
+
+// NewDevIssuer loads the signing key from keyFile, creating it when the file does
+// not exist so tokens survive restarts. An empty keyFile uses a throwaway key.
+func NewDevIssuer(keyFile string) (*DevIssuer, error) {
+	key, err := loadOrCreateKey(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	thumbprint := sha256.Sum256(der)
+	kid := base64.RawURLEncoding.EncodeToString(thumbprint[:8])
+
+	jwk, err := jwkset.NewJWKFromKey(&key.PublicKey, jwkset.JWKOptions{
+		Metadata: jwkset.JWKMetadataOptions{ALG: jwkset.AlgRS256, KID: kid, USE: jwkset.UseSig},
+	})
+	if err != nil {
+		return nil, err
+	}
+	storage := jwkset.NewMemoryStorage()
+	if err := storage.KeyWrite(context.Background(), jwk); err != nil {
+		return nil, err
+	}
+	keys, err := keyfunc.New(keyfunc.Options{Storage: storage})
+	if err != nil {
+		return nil, err
+	}
+	return &DevIssuer{key: key, kid: kid, keys: keys}, nil
+}
+
+func (d *DevIssuer) Name() string {
+	return ProviderDev
+}
+
+func (d *DevIssuer) Keys() keyfunc.Keyfunc {
+	return d.keys
+}
+
+// Mint signs a token for subject that expires after ttl. Extra claims are added
+// to, and may override, the registered claims; a nil value removes the claim.
+func (d *DevIssuer) Mint(subject string, ttl time.Duration, extra jwt.MapClaims) (string, error) {
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss": DevIssuerName,
+		"sub": subject,
+		"iat": now.Unix(),
+		"nbf": now.Unix(),
+		"exp": now.Add(ttl).Unix(),
+	}
+	for k, v := range extra {
+		if v == nil {
+			delete(claims, k)
+			continue
+		}
+		claims[k] = v
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = d.kid
+	return token.SignedString(d.key)
+}
+
+type DevTokenRequest struct {
+	Subject    string `json:"subject" validate:"required,max=255"`
+	TTLSeconds int    `json:"ttlSeconds" validate:"min=0,max=604800"`
+	Audience   string `json:"audience,omitempty" validate:"max=255"`
+	// Scopes, when given, mint a machine client token limited to them.
+	Scopes []string `json:"scopes,omitempty"`
+}
+
+type DevTokenResponse struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// RegisterRoutes exposes the public key set and a token minting endpoint. Both
+// are unauthenticated by design.
+func (d *DevIssuer) RegisterRoutes(r chi.Router) {
+	r.Get("/dev/jwks.json", func(w http.ResponseWriter, r *http.Request) {
+		raw, err := d.keys.Storage().JSONPublic(r.Context())
+		if err != nil {
+			web.WriteError(w, r, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(raw)
+	})
+	r.Post("/dev/token", func(w http.ResponseWriter, r *http.Request) {
+		var body DevTokenRequest
+		if err := web.DecodeAndValidate(w, r, &body); err != nil {
+			web.WriteError(w, r, err)
+			return
+		}
+		ttl := time.Duration(body.TTLSeconds) * time.Second
+		if ttl == 0 {
+			ttl = 24 * time.Hour
+		}
+		extra := jwt.MapClaims{}
+		if body.Audience != "" {
+			extra["aud"] = body.Audience
+		}
+		if len(body.Scopes) > 0 {
+			extra["client_id"] = body.Subject
+			extra["scope"] = strings.Join(body.Scopes, " ")
+		}
+		token, err := d.Mint(body.Subject, ttl, extra)
+		if err != nil {
+			web.WriteError(w, r, err)
+			return
+		}
+		web.MakeJsonResponse(w, http.StatusOK, DevTokenResponse{Token: token, ExpiresAt: time.Now().Add(ttl).UTC()})
+	})
+}
+
+func loadOrCreateKey(path string) (*rsa.PrivateKey, error) {
+	if path != "" {
+		raw, err := os.ReadFile(path)
+		if err == nil {
+			return parseKey(raw)
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("unable to read dev signing key: %w", err)
+		}
+	}
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	if path != "" {
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			return nil, err
+		}
+		block := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+		if err := os.WriteFile(path, block, 0o600); err != nil {
+			return nil, fmt.Errorf("unable to save dev signing key: %w", err)
+		}
+	}
+	return key, nil
+}
+
+func parseKey(raw []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(raw)
+	if block == nil {
+		return nil, errors.New("dev signing key is not PEM encoded")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse dev signing key: %w", err)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("dev signing key must be an RSA key")
+	}
+	return key, nil
+}
@@ -0,0 +1,47 @@
+package auth
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/suite"
+)
+
+type DevIssuerTestSuite struct {
+	suite.Suite
+}
+
+func (suite *DevIssuerTestSuite) Test_Mint_ProducesVerifiableTokens() {
+	issuer, err := NewDevIssuer("")
+	suite.Require().NoError(err)
+
+	token, err := issuer.Mint("dev-user", time.Hour, jwt.MapClaims{"aud": "datamonster"})
+	suite.Require().NoError(err)
+
+	parsed, err := jwt.Parse(token, issuer.Keys().Keyfunc)
+	suite.Require().NoError(err)
+	subject, _ := parsed.Claims.GetSubject()
+	suite.Equal("dev-user", subject, "the token should carry the subject")
+	issuerClaim, _ := parsed.Claims.GetIssuer()
+	suite.Equal(DevIssuerName, issuerClaim, "the token should name the dev issuer")
+}
+
+func (suite *DevIssuerTestSuite) Test_NewDevIssuer_ReusesItsKeyFile() {
+	keyFile := filepath.Join(suite.T().TempDir(), "dev-key.pem")
+	first, err := NewDevIssuer(keyFile)
+	suite.Require().NoError(err)
+	token, err := first.Mint("dev-user", time.Hour, nil)
+	suite.Require().NoError(err)
+
+	second, err := NewDevIssuer(keyFile)
+	suite.Require().NoError(err)
+	_, err = jwt.Parse(token, second.Keys().Keyfunc)
+
+	suite.NoError(err, "tokens should survive a restart when a key file is configured")
+}
+
+func TestDevIssuerTestSuite(t *testing.T) {
+	suite.Run(t, new(DevIssuerTestSuite))
+}
@@ -0,0 +1,37 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/MicahParks/keyfunc/v3"
+)
+
+// NewJWKS verifies tokens against keys published at one or more JWKS URLs. The
+// first fetch happens in the background so startup does not depend on the
+// network; readiness reports whether keys have arrived.
+func NewJWKS(ctx context.Context, urls []string) (Provider, error) {
+	if len(urls) == 0 {
+		return nil, errors.New("at least one JWKS URL is required")
+	}
+	keys, err := keyfunc.NewDefaultCtx(ctx, urls)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create keyfunc: %w", err)
+	}
+	return keyProvider{name: ProviderJWKS, keys: keys}, nil
+}
+
+// NewJWKSFile verifies tokens against a JWK Set stored on disk.
+func NewJWKSFile(path string) (Provider, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read JWKS file: %w", err)
+	}
+	keys, err := keyfunc.NewJWKSetJSON(raw)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse JWKS file %s: %w", path, err)
+	}
+	return keyProvider{name: ProviderFile, keys: keys}, nil
+}
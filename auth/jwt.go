@@ -0,0 +1,130 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/MicahParks/keyfunc/v3"
+	"github.com/failuretoload/datamonster/config"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// JWTOptions are the claim checks applied to every bearer token on top of its
+// signature. Empty Issuer and Audience disable those checks.
+type JWTOptions struct {
+	Issuer     string
+	Audience   []string
+	Leeway     time.Duration
+	Algorithms []string
+}
+
+// JWTOptionsFor derives the checks from configuration, defaulting the issuer
+// for providers that know it.
+func JWTOptionsFor(cfg config.Auth) JWTOptions {
+	issuer := cfg.Issuer
+	if issuer == "" {
+		switch cfg.Provider {
+		case ProviderOIDC:
+			issuer = cfg.OIDCIssuer
+		case ProviderDev:
+			issuer = DevIssuerName
+		}
+	}
+	return JWTOptions{
+		Issuer:     issuer,
+		Audience:   cfg.Audience,
+		Leeway:     cfg.Leeway.Duration,
+		Algorithms: cfg.Algorithms,
+	}
+}
+
+// JWTVerifier authenticates bearer JWTs signed by a provider's keys.
+type JWTVerifier struct {
+	keys   keyfunc.Keyfunc
+	parser *jwt.Parser
+	opts   JWTOptions
+}
+
+func NewJWTVerifier(keys keyfunc.Keyfunc, opts JWTOptions) *JWTVerifier {
+	options := []jwt.ParserOption{
+		jwt.WithValidMethods(opts.Algorithms),
+		jwt.WithLeeway(opts.Leeway),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+	}
+	if opts.Issuer != "" {
+		options = append(options, jwt.WithIssuer(opts.Issuer))
+	}
+	return &JWTVerifier{keys: keys, parser: jwt.NewParser(options...), opts: opts}
+}
+
+// Authenticate verifies raw and returns its principal. Tokens whose client_id
+// equals their subject were issued to a machine client through the client
+// credentials grant (RFC 9068) and carry only the scopes they were granted.
+func (v *JWTVerifier) Authenticate(_ context.Context, raw string) (Principal, error) {
+	claims := jwt.MapClaims{}
+	if _, err := v.parser.ParseWithClaims(raw, claims, v.keys.Keyfunc); err != nil {
+		return Principal{}, invalidToken(err)
+	}
+	if len(v.opts.Audience) > 0 {
+		audience, err := claims.GetAudience()
+		if err != nil || !slices.ContainsFunc(audience, func(aud string) bool { return slices.Contains(v.opts.Audience, aud) }) {
+			return Principal{}, authError{code: "invalid_token", description: "token audience is not accepted"}
+		}
+	}
+	subject, err := claims.GetSubject()
+	if err != nil || subject == "" {
+		return Principal{}, authError{code: "invalid_token", description: "token has no subject"}
+	}
+	principal := Principal{Subject: subject}
+	if clientID, _ := claims["client_id"].(string); clientID == subject {
+		principal.Machine = true
+		principal.Scopes = scopesOf(claims)
+	}
+	return principal, nil
+}
+
+// scopesOf reads the space separated scope claim, falling back to scp, which
+// some issuers send as a list.
+func scopesOf(claims jwt.MapClaims) []string {
+	if scopes, ok := claims["scope"].(string); ok {
+		return strings.Fields(scopes)
+	}
+	switch scopes := claims["scp"].(type) {
+	case string:
+		return strings.Fields(scopes)
+	case []any:
+		list := []string{}
+		for _, s := range scopes {
+			if str, ok := s.(string); ok {
+				list = append(list, str)
+			}
+		}
+		return list
+	}
+	return nil
+}
+
+// invalidToken maps parser failures to descriptions that are safe to return to
+// the client; anything unexpected is reported generically.
+func invalidToken(err error) authError {
+	description := "token is invalid"
+	switch {
+	case errors.Is(err, jwt.ErrTokenMalformed):
+		description = "token is malformed"
+	case errors.Is(err, jwt.ErrTokenSignatureInvalid), errors.Is(err, jwt.ErrTokenUnverifiable):
+		description = "token signature could not be verified"
+	case errors.Is(err, jwt.ErrTokenExpired):
+		description = "token has expired"
+	case errors.Is(err, jwt.ErrTokenNotValidYet), errors.Is(err, jwt.ErrTokenUsedBeforeIssued):
+		description = "token is not valid yet"
+	case errors.Is(err, jwt.ErrTokenInvalidIssuer):
+		description = "token issuer is not accepted"
+	case errors.Is(err, jwt.ErrTokenRequiredClaimMissing):
+		description = "token is missing a required claim"
+	}
+	return authError{code: "invalid_token", description: description}
+}
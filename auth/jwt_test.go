@@ -0,0 +1,146 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/failuretoload/datamonster/web"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/suite"
+)
+
+type JWTTestSuite struct {
+	suite.Suite
+	issuer  *DevIssuer
+	handler http.Handler
+}
+
+func (suite *JWTTestSuite) SetupSuite() {
+	issuer, err := NewDevIssuer("")
+	suite.Require().NoError(err)
+	suite.issuer = issuer
+
+	opts := JWTOptions{
+		Issuer:     DevIssuerName,
+		Audience:   []string{"datamonster"},
+		Leeway:     30 * time.Second,
+		Algorithms: []string{"RS256"},
+	}
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, _ := PrincipalFrom(r.Context())
+		_, _ = w.Write([]byte(principal.Subject))
+	})
+	suite.handler = Authenticate(NewJWTVerifier(issuer.Keys(), opts))(Required(next))
+}
+
+func (suite *JWTTestSuite) mint(ttl time.Duration, claims jwt.MapClaims) string {
+	base := jwt.MapClaims{"aud": "datamonster"}
+	for k, v := range claims {
+		base[k] = v
+	}
+	token, err := suite.issuer.Mint("user-1", ttl, base)
+	suite.Require().NoError(err)
+	return token
+}
+
+func (suite *JWTTestSuite) foreignToken() string {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	suite.Require().NoError(err)
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss": DevIssuerName, "sub": "user-1", "aud": "datamonster", "exp": time.Now().Add(time.Hour).Unix(),
+	})
+	signed, err := token.SignedString(key)
+	suite.Require().NoError(err)
+	return signed
+}
+
+func (suite *JWTTestSuite) Test_Authenticate() {
+	now := time.Now()
+	tests := []struct {
+		name      string
+		header    string
+		status    int
+		challenge string
+	}{
+		{"valid token", "Bearer " + suite.mint(time.Hour, nil), http.StatusOK, ""},
+		{"scheme is case insensitive", "bearer " + suite.mint(time.Hour, nil), http.StatusOK, ""},
+		{"machine client", "Bearer " + suite.mint(time.Hour, jwt.MapClaims{"client_id": "user-1", "scope": "settlements:read"}), http.StatusOK, ""},
+		{"expired within leeway", "Bearer " + suite.mint(-10*time.Second, nil), http.StatusOK, ""},
+		{"missing header", "", http.StatusUnauthorized, `Bearer realm="datamonster"`},
+		{"scheme without token", "Bearer", http.StatusUnauthorized, `error="invalid_request"`},
+		{"wrong scheme", "Basic dXNlcjpwYXNz", http.StatusUnauthorized, `error="invalid_request"`},
+		{"extra parts", "Bearer a b", http.StatusUnauthorized, `error="invalid_request"`},
+		{"malformed token", "Bearer not-a-jwt", http.StatusUnauthorized, `error_description="token is malformed"`},
+		{"expired beyond leeway", "Bearer " + suite.mint(-time.Minute, nil), http.StatusUnauthorized, `error_description="token has expired"`},
+		{"not valid yet", "Bearer " + suite.mint(time.Hour, jwt.MapClaims{"nbf": now.Add(time.Minute).Unix()}), http.StatusUnauthorized, `error_description="token is not valid yet"`},
+		{"no expiry", "Bearer " + suite.mint(time.Hour, jwt.MapClaims{"exp": nil}), http.StatusUnauthorized, `error_description="token is missing a required claim"`},
+		{"wrong issuer", "Bearer " + suite.mint(time.Hour, jwt.MapClaims{"iss": "someone-else"}), http.StatusUnauthorized, `error_description="token issuer is not accepted"`},
+		{"wrong audience", "Bearer " + suite.mint(time.Hour, jwt.MapClaims{"aud": "another-api"}), http.StatusUnauthorized, `error_description="token audience is not accepted"`},
+		{"one accepted audience", "Bearer " + suite.mint(time.Hour, jwt.MapClaims{"aud": []string{"another-api", "datamonster"}}), http.StatusOK, ""},
+		{"missing subject", "Bearer " + suite.mint(time.Hour, jwt.MapClaims{"sub": ""}), http.StatusUnauthorized, `error_description="token has no subject"`},
+		{"unknown signing key", "Bearer " + suite.foreignToken(), http.StatusUnauthorized, `error="invalid_token"`},
+		{"disallowed algorithm", "Bearer " + suite.hmacToken(), http.StatusUnauthorized, `error="invalid_token"`},
+	}
+
+	for _, tt := range tests {
+		suite.Run(tt.name, func() {
+			req := httptest.NewRequest(http.MethodGet, "/settlements", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			rr := httptest.NewRecorder()
+
+			suite.handler.ServeHTTP(rr, req)
+
+			suite.Equal(tt.status, rr.Code)
+			if tt.status == http.StatusOK {
+				suite.Equal("user-1", rr.Body.String(), "the subject should be passed on in the context")
+				return
+			}
+			suite.Contains(rr.Header().Get("WWW-Authenticate"), tt.challenge)
+			suite.Equal(web.ProblemContentType, rr.Header().Get("Content-Type"))
+		})
+	}
+}
+
+func (suite *JWTTestSuite) hmacToken() string {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"iss": DevIssuerName, "sub": "user-1", "aud": "datamonster", "exp": time.Now().Add(time.Hour).Unix(),
+	})
+	signed, err := token.SignedString([]byte("secret"))
+	suite.Require().NoError(err)
+	return signed
+}
+
+func (suite *JWTTestSuite) Test_Authenticate_ReadsMachineClientScopes() {
+	verifier := NewJWTVerifier(suite.issuer.Keys(), JWTOptions{Algorithms: []string{"RS256"}})
+	tests := []struct {
+		name     string
+		claims   jwt.MapClaims
+		expected Principal
+	}{
+		{"user", jwt.MapClaims{"scope": "openid profile"}, Principal{Subject: "user-1"}},
+		{"client with scope", jwt.MapClaims{"client_id": "user-1", "scope": "a b"}, Principal{Subject: "user-1", Machine: true, Scopes: []string{"a", "b"}}},
+		{"client with scp list", jwt.MapClaims{"client_id": "user-1", "scp": []string{"a"}}, Principal{Subject: "user-1", Machine: true, Scopes: []string{"a"}}},
+		{"client without scopes", jwt.MapClaims{"client_id": "user-1"}, Principal{Subject: "user-1", Machine: true}},
+		{"client acting for a user", jwt.MapClaims{"client_id": "web-app", "scope": "a"}, Principal{Subject: "user-1"}},
+	}
+
+	for _, tt := range tests {
+		suite.Run(tt.name, func() {
+			principal, err := verifier.Authenticate(context.Background(), suite.mint(time.Hour, tt.claims))
+
+			suite.NoError(err)
+			suite.Equal(tt.expected, principal)
+		})
+	}
+}
+
+func TestJWTTestSuite(t *testing.T) {
+	suite.Run(t, new(JWTTestSuite))
+}
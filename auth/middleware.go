@@ -0,0 +1,150 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/failuretoload/datamonster/logging"
+	"github.com/failuretoload/datamonster/web"
+)
+
+const realm = "datamonster"
+
+// Authenticator turns a bearer token into the principal it identifies.
+type Authenticator interface {
+	Authenticate(ctx context.Context, token string) (Principal, error)
+}
+
+// authError is a failed authentication or authorization attempt described in
+// RFC 6750 terms.
+type authError struct {
+	code        string
+	description string
+	scope       string
+}
+
+func (e authError) Error() string {
+	return e.description
+}
+
+var errMissingToken = authError{description: "a bearer token is required"}
+
+// ErrInvalidToken is returned by authenticators for tokens they do not accept.
+var ErrInvalidToken error = authError{code: "invalid_token", description: "token is invalid"}
+
+// Dispatch sends tokens starting with one of the prefixes to its authenticator
+// and every other token to fallback.
+func Dispatch(fallback Authenticator, prefixed map[string]Authenticator) Authenticator {
+	return dispatcher{fallback: fallback, prefixed: prefixed}
+}
+
+type dispatcher struct {
+	fallback Authenticator
+	prefixed map[string]Authenticator
+}
+
+func (d dispatcher) Authenticate(ctx context.Context, token string) (Principal, error) {
+	for prefix, authenticator := range d.prefixed {
+		if strings.HasPrefix(token, prefix) {
+			return authenticator.Authenticate(ctx, token)
+		}
+	}
+	return d.fallback.Authenticate(ctx, token)
+}
+
+// Authenticate identifies the caller of every request that presents a bearer
+// token. Requests without one pass through anonymously so that public routes
+// keep working; protected routes reject them with Required. A token that is
+// present but invalid is always rejected.
+//
+// Browsers cannot set headers on EventSource and WebSocket requests, so streams
+// may pass the token in the access_token query parameter instead.
+func Authenticate(authenticator Authenticator) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			header := r.Header.Get("Authorization")
+			if token := r.URL.Query().Get("access_token"); header == "" && token != "" && web.Streaming(r) {
+				header = "Bearer " + token
+			}
+			if header == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			raw, err := bearerToken(header)
+			if err != nil {
+				challenge(w, r, http.StatusUnauthorized, err)
+				return
+			}
+			principal, err := authenticator.Authenticate(r.Context(), raw)
+			if err != nil {
+				challenge(w, r, http.StatusUnauthorized, err)
+				return
+			}
+			ctx := logging.With(r.Context(), "user_id", principal.Subject)
+			next.ServeHTTP(w, r.WithContext(WithPrincipal(ctx, principal)))
+		})
+	}
+}
+
+// Required rejects anonymous requests.
+func Required(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := PrincipalFrom(r.Context()); !ok {
+			challenge(w, r, http.StatusUnauthorized, errMissingToken)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequireScope rejects anonymous requests and machine clients that were not
+// granted scope.
+func RequireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			principal, ok := PrincipalFrom(r.Context())
+			if !ok {
+				challenge(w, r, http.StatusUnauthorized, errMissingToken)
+				return
+			}
+			if !principal.HasScope(scope) {
+				challenge(w, r, http.StatusForbidden, authError{
+					code:        "insufficient_scope",
+					description: fmt.Sprintf("token lacks the %s scope", scope),
+					scope:       scope,
+				})
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func bearerToken(header string) (string, error) {
+	parts := strings.Fields(header)
+	if len(parts) != 2 || !strings.EqualFold(parts[0], "bearer") {
+		return "", authError{code: "invalid_request", description: "the Authorization header must be of the form: Bearer <token>"}
+	}
+	return parts[1], nil
+}
+
+func challenge(w http.ResponseWriter, r *http.Request, status int, err error) {
+	var authErr authError
+	if !errors.As(err, &authErr) {
+		logging.FromContext(r.Context()).Error("unable to authenticate", "error", err)
+		authErr = authError{code: "invalid_token", description: "token is invalid"}
+	}
+	value := fmt.Sprintf("Bearer realm=%q", realm)
+	if authErr.code != "" {
+		value += fmt.Sprintf(", error=%q, error_description=%q", authErr.code, authErr.description)
+		logging.FromContext(r.Context()).Info("rejected access token", "reason", authErr.description)
+	}
+	if authErr.scope != "" {
+		value += fmt.Sprintf(", scope=%q", authErr.scope)
+	}
+	w.Header().Set("WWW-Authenticate", value)
+	web.WriteProblem(w, r, status, authErr.description)
+}
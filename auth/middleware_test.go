@@ -0,0 +1,104 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type MiddlewareTestSuite struct {
+	suite.Suite
+}
+
+func (suite *MiddlewareTestSuite) Test_RequireScope() {
+	tests := []struct {
+		name      string
+		principal *Principal
+		status    int
+		challenge string
+	}{
+		{"anonymous", nil, http.StatusUnauthorized, `Bearer realm="datamonster"`},
+		{"user", &Principal{Subject: "user"}, http.StatusOK, ""},
+		{"client with scope", &Principal{Subject: "ci", Machine: true, Scopes: []string{"settlements:read"}}, http.StatusOK, ""},
+		{"client without scope", &Principal{Subject: "ci", Machine: true, Scopes: []string{"survivors:read"}}, http.StatusForbidden, `error="insufficient_scope", error_description="token lacks the settlements:read scope", scope="settlements:read"`},
+	}
+	handler := RequireScope("settlements:read")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	for _, tt := range tests {
+		suite.Run(tt.name, func() {
+			req := httptest.NewRequest(http.MethodGet, "/settlements", nil)
+			if tt.principal != nil {
+				req = req.WithContext(WithPrincipal(req.Context(), *tt.principal))
+			}
+			rr := httptest.NewRecorder()
+
+			handler.ServeHTTP(rr, req)
+
+			suite.Equal(tt.status, rr.Code)
+			suite.Contains(rr.Header().Get("WWW-Authenticate"), tt.challenge)
+		})
+	}
+}
+
+func (suite *MiddlewareTestSuite) Test_Authenticate_LetsAnonymousRequestsThrough() {
+	reached, authenticated := false, false
+	handler := Authenticate(nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reached = true
+		_, authenticated = PrincipalFrom(r.Context())
+	}))
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/healthz", nil))
+
+	suite.True(reached, "requests without credentials should reach public routes")
+	suite.False(authenticated, "requests without credentials should be anonymous")
+}
+
+type fixedAuthenticator Principal
+
+func (f fixedAuthenticator) Authenticate(context.Context, string) (Principal, error) {
+	return Principal(f), nil
+}
+
+func (suite *MiddlewareTestSuite) Test_Dispatch_RoutesTokensByPrefix() {
+	authenticator := Dispatch(fixedAuthenticator{Subject: "jwt"}, map[string]Authenticator{"pat_": fixedAuthenticator{Subject: "pat"}})
+
+	jwtPrincipal, _ := authenticator.Authenticate(context.Background(), "eyJhbGciOi")
+	patPrincipal, _ := authenticator.Authenticate(context.Background(), "pat_secret")
+
+	suite.Equal("jwt", jwtPrincipal.Subject, "unprefixed tokens should use the fallback")
+	suite.Equal("pat", patPrincipal.Subject, "prefixed tokens should use their authenticator")
+}
+
+func (suite *MiddlewareTestSuite) Test_Authenticate_AcceptsQueryTokensOnlyForStreams() {
+	tests := []struct {
+		name          string
+		header        string
+		value         string
+		authenticated bool
+	}{
+		{"event stream", "Accept", "text/event-stream", true},
+		{"websocket", "Upgrade", "websocket", true},
+		{"plain request", "Accept", "application/json", false},
+	}
+	for _, tt := range tests {
+		suite.Run(tt.name, func() {
+			authenticated := false
+			handler := Authenticate(fixedAuthenticator{Subject: "user"})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_, authenticated = PrincipalFrom(r.Context())
+			}))
+			req := httptest.NewRequest(http.MethodGet, "/settlements/1/events?access_token=secret", nil)
+			req.Header.Set(tt.header, tt.value)
+
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+
+			suite.Equal(tt.authenticated, authenticated)
+		})
+	}
+}
+
+func TestMiddlewareTestSuite(t *testing.T) {
+	suite.Run(t, new(MiddlewareTestSuite))
+}
@@ -0,0 +1,47 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type discoveryDocument struct {
+	Issuer  string `json:"issuer"`
+	JWKSURI string `json:"jwks_uri"`
+}
+
+// NewOIDC discovers the JWKS URL of an OpenID Connect issuer and verifies tokens
+// against the keys published there.
+func NewOIDC(ctx context.Context, issuer string) (Provider, error) {
+	discoveryURL := strings.TrimSuffix(issuer, "/") + "/.well-known/openid-configuration"
+	reqCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(reqCtx, http.MethodGet, discoveryURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("unable to fetch OIDC discovery document: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("OIDC discovery at %s returned %s", discoveryURL, resp.Status)
+	}
+	var doc discoveryDocument
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("unable to parse OIDC discovery document: %w", err)
+	}
+	if doc.JWKSURI == "" {
+		return nil, fmt.Errorf("OIDC discovery document at %s has no jwks_uri", discoveryURL)
+	}
+	remote, err := NewJWKS(ctx, []string{doc.JWKSURI})
+	if err != nil {
+		return nil, err
+	}
+	return keyProvider{name: ProviderOIDC, keys: remote.Keys()}, nil
+}
@@ -0,0 +1,31 @@
+package auth
+
+import (
+	"context"
+	"slices"
+)
+
+type ctxPrincipalKey struct{}
+
+// Principal is the authenticated caller. Users act with their full rights;
+// machine clients, including scripts using a personal access token, are limited
+// to the scopes their token was granted.
+type Principal struct {
+	Subject string
+	Machine bool
+	Scopes  []string
+}
+
+// HasScope reports whether the principal may perform operations guarded by scope.
+func (p Principal) HasScope(scope string) bool {
+	return !p.Machine || slices.Contains(p.Scopes, scope)
+}
+
+func WithPrincipal(ctx context.Context, p Principal) context.Context {
+	return context.WithValue(ctx, ctxPrincipalKey{}, p)
+}
+
+func PrincipalFrom(ctx context.Context) (Principal, bool) {
+	p, ok := ctx.Value(ctxPrincipalKey{}).(Principal)
+	return p, ok
+}
@@ -0,0 +1,56 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/MicahParks/keyfunc/v3"
+	"github.com/failuretoload/datamonster/config"
+)
+
+const (
+	ProviderWorkOS = "workos"
+	ProviderOIDC   = "oidc"
+	ProviderJWKS   = "jwks"
+	ProviderFile   = "file"
+	ProviderDev    = "dev"
+)
+
+// Provider supplies the keys that verify bearer token signatures. Remote
+// providers refresh their keys in the background for as long as the context
+// they were created with is alive.
+type Provider interface {
+	Name() string
+	Keys() keyfunc.Keyfunc
+}
+
+// NewProvider builds the provider selected by cfg.Provider.
+func NewProvider(ctx context.Context, cfg config.Auth) (Provider, error) {
+	switch cfg.Provider {
+	case ProviderWorkOS:
+		return NewWorkOS(ctx, cfg.WorkOSAPIKey, cfg.WorkOSClientID)
+	case ProviderOIDC:
+		return NewOIDC(ctx, cfg.OIDCIssuer)
+	case ProviderJWKS:
+		return NewJWKS(ctx, cfg.JWKSURLs)
+	case ProviderFile:
+		return NewJWKSFile(cfg.JWKSFile)
+	case ProviderDev:
+		return NewDevIssuer(cfg.DevKeyFile)
+	default:
+		return nil, fmt.Errorf("unknown auth provider %q", cfg.Provider)
+	}
+}
+
+type keyProvider struct {
+	name string
+	keys keyfunc.Keyfunc
+}
+
+func (p keyProvider) Name() string {
+	return p.name
+}
+
+func (p keyProvider) Keys() keyfunc.Keyfunc {
+	return p.keys
+}
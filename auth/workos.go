@@ -0,0 +1,22 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/workos/workos-go/v4/pkg/usermanagement"
+)
+
+// NewWorkOS verifies tokens issued by WorkOS User Management for clientID.
+func NewWorkOS(ctx context.Context, apiKey, clientID string) (Provider, error) {
+	usermanagement.SetAPIKey(apiKey)
+	jwksUrl, workosErr := usermanagement.GetJWKSURL(clientID)
+	if workosErr != nil {
+		return nil, fmt.Errorf("unable to retrieve JWKS: %w", workosErr)
+	}
+	remote, err := NewJWKS(ctx, []string{jwksUrl.String()})
+	if err != nil {
+		return nil, err
+	}
+	return keyProvider{name: ProviderWorkOS, keys: remote.Keys()}, nil
+}
@@ -2,31 +2,132 @@ package main
 
 import (
 	"context"
+	"log/slog"
+	"os"
+	"time"
 
+	"github.com/failuretoload/datamonster/api"
+	"github.com/failuretoload/datamonster/auth"
+	"github.com/failuretoload/datamonster/config"
+	"github.com/failuretoload/datamonster/health"
+	"github.com/failuretoload/datamonster/lifecycle"
+	"github.com/failuretoload/datamonster/logging"
+	"github.com/failuretoload/datamonster/metrics"
+	"github.com/failuretoload/datamonster/notify"
+	"github.com/failuretoload/datamonster/openapi"
+	"github.com/failuretoload/datamonster/ratelimit"
 	"github.com/failuretoload/datamonster/server"
-	"github.com/failuretoload/datamonster/settlement"
+	"github.com/failuretoload/datamonster/store/migrations"
 	postgres "github.com/failuretoload/datamonster/store/postgres"
-	"github.com/failuretoload/datamonster/survivor"
+	"github.com/failuretoload/datamonster/survivor/showdown"
+	"github.com/failuretoload/datamonster/token"
+	"github.com/failuretoload/datamonster/tracing"
+	"github.com/failuretoload/datamonster/user"
+	"github.com/go-chi/chi/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 var (
+	cfg        config.Config
+	flushSpans func(context.Context) error
 	connPool   *pgxpool.Pool
+	provider   auth.Provider
 	app        server.Server
 	appContext context.Context
 )
 
 func init() {
+	var err error
+	cfg, err = config.Load()
+	if err != nil {
+		fatal("invalid configuration", err)
+	}
+	slog.SetDefault(logging.New(cfg.Log, os.Stdout))
 	appContext = context.Background()
-	connPool = postgres.InitConnPool(appContext)
-	app = server.NewServer(appContext)
+	flushSpans, err = tracing.Init(appContext, cfg.Tracing, os.Stdout)
+	if err != nil {
+		fatal("unable to configure tracing", err)
+	}
+	connPool, err = postgres.InitConnPool(appContext, cfg.Database)
+	if err != nil {
+		fatal("unable to connect to the database", err)
+	}
+	if cfg.Database.Migrate {
+		if err := migrations.Apply(appContext, connPool); err != nil {
+			fatal("unable to migrate database", err)
+		}
+	}
+	provider, err = auth.NewProvider(appContext, cfg.Auth)
+	if err != nil {
+		fatal("unable to configure authentication", err)
+	}
+	authenticator := auth.Dispatch(
+		auth.NewJWTVerifier(provider.Keys(), auth.JWTOptionsFor(cfg.Auth)),
+		map[string]auth.Authenticator{token.Prefix: token.NewAuthenticator(connPool)},
+	)
+	app = server.NewServer(cfg, authenticator)
 }
 
 func main() {
-	defer connPool.Close()
-	settlementController := settlement.NewController(connPool)
-	survivorController := survivor.NewController(connPool)
-	survivorController.RegisterRoutes(app.Mux)
-	settlementController.RegisterRoutes(app.Mux)
-	app.Run()
+	var limits ratelimit.Store = ratelimit.NewMemory()
+	if cfg.RateLimit.Backend == "postgres" {
+		limits = ratelimit.NewPostgres(connPool)
+	}
+	hub := notify.NewHub()
+	var publisher notify.Publisher = hub
+	if cfg.Notify.Backend == "postgres" {
+		publisher = notify.NewPostgres(hub, connPool)
+	}
+	showdowns := showdown.NewSessions(connPool, publisher)
+
+	var spec *openapi.Document
+	app.Mux.Group(func(r chi.Router) {
+		if cfg.RateLimit.Enabled {
+			r.Use(ratelimit.Install(limits))
+		}
+		r.Use(user.Provision(connPool))
+		spec = api.Mount(r, api.Versions, api.Controllers(connPool, hub, publisher, showdowns)...)
+	})
+	openapi.RegisterRoutes(app.Mux, spec)
+
+	checker := health.NewChecker(2 * time.Second)
+	checker.Add("postgres", health.Postgres(connPool))
+	checker.Add("migrations", health.Migrations(connPool))
+	checker.Add("jwks", health.JWKS(provider.Keys()))
+	checker.RegisterRoutes(app.Mux)
+
+	metrics.MustRegister(postgres.NewCollector(connPool))
+	metrics.RegisterRoutes(app.Mux)
+
+	if dev, ok := provider.(*auth.DevIssuer); ok {
+		slog.Warn("using the development token issuer, never enable it in production")
+		dev.RegisterRoutes(app.Mux)
+	}
+
+	// Components stop in registration order: fail readiness first and keep
+	// serving until load balancers notice, save and end showdowns and event
+	// streams, which would otherwise never finish, then drain requests before
+	// closing the pool they use.
+	lc := lifecycle.New(cfg.Server.ShutdownTimeout.Duration)
+	lc.OnShutdown("readiness", checker.Drain)
+	lc.OnShutdown("drain delay", lifecycle.Delay(cfg.Server.DrainDelay.Duration))
+	lc.OnShutdown("showdowns", showdowns.Close)
+	lc.OnShutdown("event streams", hub.Close)
+	lc.Serve("http server", app.Start, app.Shutdown)
+	if pg, ok := publisher.(*notify.Postgres); ok {
+		lc.Go("change notifications", pg.Run)
+	}
+	if pg, ok := limits.(*ratelimit.Postgres); ok && cfg.RateLimit.Enabled {
+		lc.Go("rate limit pruning", func(ctx context.Context) error { return pg.Run(ctx, 10*time.Minute) })
+	}
+	lc.OnShutdown("database pool", lifecycle.Close(connPool.Close))
+	lc.OnShutdown("tracing", flushSpans)
+	if err := lc.Wait(appContext); err != nil {
+		fatal("unclean shutdown", err)
+	}
+}
+
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
 }
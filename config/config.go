@@ -0,0 +1,288 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Config holds every setting the API server reads at startup. Values come from
+// Defaults, then an optional JSON file, then environment variables named by the
+// env tags.
+type Config struct {
+	Server    Server    `json:"server"`
+	Database  Database  `json:"database"`
+	Auth      Auth      `json:"auth"`
+	CORS      CORS      `json:"cors"`
+	Security  Security  `json:"security"`
+	Log       Log       `json:"log"`
+	Tracing   Tracing   `json:"tracing"`
+	RateLimit RateLimit `json:"rateLimit"`
+	Notify    Notify    `json:"notify"`
+}
+
+// Server configures the HTTP server. On shutdown, readiness fails for
+// DrainDelay before connections are refused, so that load balancers notice and
+// stop routing requests here; the delay counts towards ShutdownTimeout.
+type Server struct {
+	Addr              string   `json:"addr" env:"LISTEN_ADDR"`
+	RequestTimeout    Duration `json:"requestTimeout" env:"REQUEST_TIMEOUT"`
+	ReadHeaderTimeout Duration `json:"readHeaderTimeout" env:"READ_HEADER_TIMEOUT"`
+	ShutdownTimeout   Duration `json:"shutdownTimeout" env:"SHUTDOWN_TIMEOUT"`
+	DrainDelay        Duration `json:"drainDelay" env:"DRAIN_DELAY"`
+}
+
+type Database struct {
+	ConnString string `json:"connString" env:"CONN_STRING"`
+	Migrate    bool   `json:"migrate" env:"RUN_MIGRATIONS"`
+}
+
+// Auth selects how bearer tokens are verified. Provider is one of workos, oidc,
+// jwks, file or dev; only the settings of the selected provider are required.
+// Issuer and Audience are only checked when set, except that the oidc and dev
+// providers default Issuer to their own.
+type Auth struct {
+	Provider       string   `json:"provider" env:"AUTH_PROVIDER"`
+	Issuer         string   `json:"issuer" env:"JWT_ISSUER"`
+	Audience       []string `json:"audience" env:"JWT_AUDIENCE"`
+	Leeway         Duration `json:"leeway" env:"JWT_LEEWAY"`
+	Algorithms     []string `json:"algorithms" env:"JWT_ALGORITHMS"`
+	WorkOSAPIKey   string   `json:"workosApiKey" env:"WORKOS_API_KEY"`
+	WorkOSClientID string   `json:"workosClientId" env:"WORKOS_CLIENT_ID"`
+	OIDCIssuer     string   `json:"oidcIssuer" env:"OIDC_ISSUER"`
+	JWKSURLs       []string `json:"jwksUrls" env:"JWKS_URLS"`
+	JWKSFile       string   `json:"jwksFile" env:"JWKS_FILE"`
+	DevKeyFile     string   `json:"devKeyFile" env:"DEV_SIGNING_KEY_FILE"`
+}
+
+type CORS struct {
+	AllowedOrigins   []string `json:"allowedOrigins" env:"WEB_CLIENT"`
+	AllowedMethods   []string `json:"allowedMethods" env:"CORS_ALLOWED_METHODS"`
+	AllowedHeaders   []string `json:"allowedHeaders" env:"CORS_ALLOWED_HEADERS"`
+	AllowCredentials bool     `json:"allowCredentials" env:"CORS_ALLOW_CREDENTIALS"`
+	MaxAge           int      `json:"maxAge" env:"CORS_MAX_AGE"`
+}
+
+type Security struct {
+	STSSeconds            int64  `json:"stsSeconds" env:"STS_SECONDS"`
+	STSIncludeSubdomains  bool   `json:"stsIncludeSubdomains" env:"STS_INCLUDE_SUBDOMAINS"`
+	STSPreload            bool   `json:"stsPreload" env:"STS_PRELOAD"`
+	FrameDeny             bool   `json:"frameDeny" env:"FRAME_DENY"`
+	ContentSecurityPolicy string `json:"contentSecurityPolicy" env:"CONTENT_SECURITY_POLICY"`
+}
+
+// Log controls the structured logger. Level is debug, info, warn or error and
+// Format is json or text.
+type Log struct {
+	Level  string `json:"level" env:"LOG_LEVEL"`
+	Format string `json:"format" env:"LOG_FORMAT"`
+}
+
+// Tracing selects where spans are exported: none, stdout or otlp. Endpoint is
+// the OTLP/HTTP collector URL; when empty the standard OTEL_EXPORTER_OTLP_*
+// variables apply. SampleRatio is the fraction of new traces recorded.
+type Tracing struct {
+	Exporter    string  `json:"exporter" env:"TRACING_EXPORTER"`
+	Endpoint    string  `json:"endpoint" env:"TRACING_OTLP_ENDPOINT"`
+	SampleRatio float64 `json:"sampleRatio" env:"TRACING_SAMPLE_RATIO"`
+}
+
+// RateLimit selects where request budgets are tracked: memory keeps them per
+// instance and postgres shares them between instances.
+type RateLimit struct {
+	Enabled bool   `json:"enabled" env:"RATE_LIMIT_ENABLED"`
+	Backend string `json:"backend" env:"RATE_LIMIT_BACKEND"`
+}
+
+// Notify selects how change notifications reach streaming clients: memory
+// delivers them within the instance that made the change and postgres to every
+// instance through LISTEN/NOTIFY.
+type Notify struct {
+	Backend string `json:"backend" env:"NOTIFY_BACKEND"`
+}
+
+// Duration is a time.Duration written as a string such as "10s" in files and
+// environment variables.
+type Duration struct {
+	time.Duration
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	parsed, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	d.Duration = parsed
+	return nil
+}
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+func Defaults() Config {
+	return Config{
+		Server: Server{
+			Addr:              ":8080",
+			RequestTimeout:    Duration{10 * time.Second},
+			ReadHeaderTimeout: Duration{5 * time.Second},
+			ShutdownTimeout:   Duration{25 * time.Second},
+			DrainDelay:        Duration{5 * time.Second},
+		},
+		Database: Database{
+			Migrate: true,
+		},
+		Auth: Auth{
+			Provider:   "workos",
+			Leeway:     Duration{30 * time.Second},
+			Algorithms: []string{"RS256"},
+		},
+		CORS: CORS{
+			AllowedMethods:   []string{"HEAD", "GET", "POST", "PATCH", "DELETE", "OPTIONS"},
+			AllowedHeaders:   []string{"Origin", "Accept", "Authorization", "Content-Type", "X-CSRF-Token", "traceparent", "tracestate", "If-Match", "If-None-Match"},
+			AllowCredentials: true,
+			MaxAge:           3599, // Maximum value not ignored by any of major browsers
+		},
+		Security: Security{
+			STSSeconds:            31536000,
+			STSIncludeSubdomains:  true,
+			STSPreload:            true,
+			FrameDeny:             true,
+			ContentSecurityPolicy: "default-src 'self', frame-ancestors 'none'",
+		},
+		Log: Log{
+			Level:  "info",
+			Format: "json",
+		},
+		Tracing: Tracing{
+			Exporter:    "none",
+			SampleRatio: 1,
+		},
+		RateLimit: RateLimit{
+			Enabled: true,
+			Backend: "memory",
+		},
+		Notify: Notify{
+			Backend: "memory",
+		},
+	}
+}
+
+// Validate reports every invalid or missing setting at once.
+func (c Config) Validate() error {
+	var errs []error
+	if _, _, err := net.SplitHostPort(c.Server.Addr); err != nil {
+		errs = append(errs, fmt.Errorf("server.addr (LISTEN_ADDR) %q is not a valid listen address", c.Server.Addr))
+	}
+	if c.Server.RequestTimeout.Duration <= 0 {
+		errs = append(errs, errors.New("server.requestTimeout (REQUEST_TIMEOUT) must be positive"))
+	}
+	if c.Server.ReadHeaderTimeout.Duration <= 0 {
+		errs = append(errs, errors.New("server.readHeaderTimeout (READ_HEADER_TIMEOUT) must be positive"))
+	}
+	if c.Server.ShutdownTimeout.Duration <= 0 {
+		errs = append(errs, errors.New("server.shutdownTimeout (SHUTDOWN_TIMEOUT) must be positive"))
+	}
+	if c.Server.DrainDelay.Duration < 0 || c.Server.DrainDelay.Duration >= c.Server.ShutdownTimeout.Duration {
+		errs = append(errs, errors.New("server.drainDelay (DRAIN_DELAY) must not be negative and must be shorter than server.shutdownTimeout (SHUTDOWN_TIMEOUT)"))
+	}
+	if c.Database.ConnString == "" {
+		errs = append(errs, errors.New("database.connString (CONN_STRING) is required"))
+	}
+	errs = append(errs, c.Auth.validate()...)
+	if len(c.CORS.AllowedOrigins) == 0 {
+		errs = append(errs, errors.New("cors.allowedOrigins (WEB_CLIENT) requires at least one origin"))
+	}
+	for _, origin := range c.CORS.AllowedOrigins {
+		if origin == "*" {
+			continue
+		}
+		if u, err := url.Parse(origin); err != nil || u.Scheme == "" || u.Host == "" {
+			errs = append(errs, fmt.Errorf("cors.allowedOrigins (WEB_CLIENT) %q is not an absolute origin", origin))
+		}
+	}
+	if c.CORS.AllowCredentials && containsString(c.CORS.AllowedOrigins, "*") {
+		errs = append(errs, errors.New("cors.allowedOrigins (WEB_CLIENT) cannot contain * when credentials are allowed"))
+	}
+	if c.CORS.MaxAge < 0 {
+		errs = append(errs, errors.New("cors.maxAge (CORS_MAX_AGE) must not be negative"))
+	}
+	if c.Security.STSSeconds < 0 {
+		errs = append(errs, errors.New("security.stsSeconds (STS_SECONDS) must not be negative"))
+	}
+	if !containsString([]string{"debug", "info", "warn", "error"}, c.Log.Level) {
+		errs = append(errs, fmt.Errorf("log.level (LOG_LEVEL) %q must be one of debug, info, warn, error", c.Log.Level))
+	}
+	if !containsString([]string{"json", "text"}, c.Log.Format) {
+		errs = append(errs, fmt.Errorf("log.format (LOG_FORMAT) %q must be one of json, text", c.Log.Format))
+	}
+	if !containsString([]string{"none", "stdout", "otlp"}, c.Tracing.Exporter) {
+		errs = append(errs, fmt.Errorf("tracing.exporter (TRACING_EXPORTER) %q must be one of none, stdout, otlp", c.Tracing.Exporter))
+	}
+	if !containsString([]string{"memory", "postgres"}, c.RateLimit.Backend) {
+		errs = append(errs, fmt.Errorf("rateLimit.backend (RATE_LIMIT_BACKEND) %q must be one of memory, postgres", c.RateLimit.Backend))
+	}
+	if !containsString([]string{"memory", "postgres"}, c.Notify.Backend) {
+		errs = append(errs, fmt.Errorf("notify.backend (NOTIFY_BACKEND) %q must be one of memory, postgres", c.Notify.Backend))
+	}
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		errs = append(errs, errors.New("tracing.sampleRatio (TRACING_SAMPLE_RATIO) must be between 0 and 1"))
+	}
+	return errors.Join(errs...)
+}
+
+func (a Auth) validate() []error {
+	var errs []error
+	switch a.Provider {
+	case "workos":
+		if a.WorkOSAPIKey == "" {
+			errs = append(errs, errors.New("auth.workosApiKey (WORKOS_API_KEY) is required"))
+		}
+		if a.WorkOSClientID == "" {
+			errs = append(errs, errors.New("auth.workosClientId (WORKOS_CLIENT_ID) is required"))
+		}
+	case "oidc":
+		if u, err := url.Parse(a.OIDCIssuer); err != nil || u.Scheme == "" || u.Host == "" {
+			errs = append(errs, errors.New("auth.oidcIssuer (OIDC_ISSUER) must be an absolute URL"))
+		}
+	case "jwks":
+		if len(a.JWKSURLs) == 0 {
+			errs = append(errs, errors.New("auth.jwksUrls (JWKS_URLS) requires at least one URL"))
+		}
+	case "file":
+		if a.JWKSFile == "" {
+			errs = append(errs, errors.New("auth.jwksFile (JWKS_FILE) is required"))
+		}
+	case "dev":
+	default:
+		errs = append(errs, fmt.Errorf("auth.provider (AUTH_PROVIDER) %q must be one of workos, oidc, jwks, file, dev", a.Provider))
+	}
+	if a.Leeway.Duration < 0 || a.Leeway.Duration > 5*time.Minute {
+		errs = append(errs, errors.New("auth.leeway (JWT_LEEWAY) must be between 0 and 5m"))
+	}
+	if len(a.Algorithms) == 0 {
+		errs = append(errs, errors.New("auth.algorithms (JWT_ALGORITHMS) requires at least one algorithm"))
+	}
+	for _, alg := range a.Algorithms {
+		if !containsString(supportedAlgorithms, alg) {
+			errs = append(errs, fmt.Errorf("auth.algorithms (JWT_ALGORITHMS) %q must be one of %s", alg, strings.Join(supportedAlgorithms, ", ")))
+		}
+	}
+	return errs
+}
+
+// supportedAlgorithms are the asymmetric signing algorithms a JWKS can verify.
+// Symmetric algorithms and "none" are deliberately absent.
+var supportedAlgorithms = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}
+
+func containsString(values []string, target string) bool {
+	for _, v := range values {
+		if strings.TrimSpace(v) == target {
+			return true
+		}
+	}
+	return false
+}
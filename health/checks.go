@@ -0,0 +1,57 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/MicahParks/keyfunc/v3"
+	"github.com/failuretoload/datamonster/store"
+	"github.com/failuretoload/datamonster/store/migrations"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Postgres pings the database through the pool.
+func Postgres(pool *pgxpool.Pool) CheckFunc {
+	return func(ctx context.Context) (any, error) {
+		stat := pool.Stat()
+		details := map[string]int32{
+			"totalConns":    stat.TotalConns(),
+			"idleConns":     stat.IdleConns(),
+			"acquiredConns": stat.AcquiredConns(),
+			"maxConns":      stat.MaxConns(),
+		}
+		return details, pool.Ping(ctx)
+	}
+}
+
+// Migrations verifies the database schema is at the version this build expects.
+func Migrations(conn store.Connection) CheckFunc {
+	return func(ctx context.Context) (any, error) {
+		expected := migrations.Latest()
+		current, err := migrations.Current(ctx, conn)
+		details := map[string]int{"current": current, "expected": expected}
+		if err != nil {
+			return details, err
+		}
+		if current != expected {
+			return details, fmt.Errorf("schema is at version %d, expected %d", current, expected)
+		}
+		return details, nil
+	}
+}
+
+// JWKS verifies signing keys have been fetched so tokens can be validated.
+func JWKS(kf keyfunc.Keyfunc) CheckFunc {
+	return func(ctx context.Context) (any, error) {
+		keys, err := kf.Storage().KeyReadAll(ctx)
+		details := map[string]int{"keys": len(keys)}
+		if err != nil {
+			return details, err
+		}
+		if len(keys) == 0 {
+			return details, errors.New("no signing keys are available")
+		}
+		return details, nil
+	}
+}
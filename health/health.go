@@ -0,0 +1,117 @@
+package health
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/failuretoload/datamonster/web"
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	StatusOK   = "ok"
+	StatusFail = "fail"
+)
+
+// CheckFunc probes a dependency. The returned details are reported alongside the
+// result whether or not the check passed.
+type CheckFunc func(ctx context.Context) (details any, err error)
+
+type CheckResult struct {
+	Status    string `json:"status"`
+	LatencyMs int64  `json:"latencyMs"`
+	Details   any    `json:"details,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+type Report struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks,omitempty"`
+}
+
+// Checker serves liveness and readiness probes. Readiness runs every registered
+// check concurrently and fails if any of them fails or the server is draining.
+type Checker struct {
+	timeout  time.Duration
+	checks   map[string]CheckFunc
+	draining atomic.Bool
+}
+
+func NewChecker(timeout time.Duration) *Checker {
+	return &Checker{timeout: timeout, checks: map[string]CheckFunc{}}
+}
+
+func (c *Checker) Add(name string, check CheckFunc) {
+	c.checks[name] = check
+}
+
+// Drain makes readiness fail so load balancers stop routing new requests here
+// while in-flight ones finish.
+func (c *Checker) Drain(context.Context) error {
+	c.draining.Store(true)
+	return nil
+}
+
+func (c *Checker) RegisterRoutes(r chi.Router) {
+	r.Get("/healthz", c.live)
+	r.Get("/readyz", c.ready)
+}
+
+func (c *Checker) live(w http.ResponseWriter, r *http.Request) {
+	web.MakeJsonResponse(w, http.StatusOK, Report{Status: StatusOK})
+}
+
+func (c *Checker) ready(w http.ResponseWriter, r *http.Request) {
+	report := c.Run(r.Context())
+	status := http.StatusOK
+	if report.Status != StatusOK {
+		status = http.StatusServiceUnavailable
+	}
+	web.MakeJsonResponse(w, status, report)
+}
+
+// Run executes every check and summarises the results.
+func (c *Checker) Run(ctx context.Context) Report {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	report := Report{Status: StatusOK, Checks: map[string]CheckResult{}}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for name, check := range c.checks {
+		wg.Add(1)
+		go func(name string, check CheckFunc) {
+			defer wg.Done()
+			result := run(ctx, check)
+			mu.Lock()
+			defer mu.Unlock()
+			report.Checks[name] = result
+		}(name, check)
+	}
+	wg.Wait()
+
+	for _, result := range report.Checks {
+		if result.Status != StatusOK {
+			report.Status = StatusFail
+		}
+	}
+	if c.draining.Load() {
+		report.Status = StatusFail
+		report.Checks["shutdown"] = CheckResult{Status: StatusFail, Error: "server is draining"}
+	}
+	return report
+}
+
+func run(ctx context.Context, check CheckFunc) CheckResult {
+	start := time.Now()
+	details, err := check(ctx)
+	result := CheckResult{Status: StatusOK, LatencyMs: time.Since(start).Milliseconds(), Details: details}
+	if err != nil {
+		result.Status = StatusFail
+		result.Error = err.Error()
+	}
+	return result
+}
@@ -0,0 +1,80 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/suite"
+)
+
+type HealthTestSuite struct {
+	suite.Suite
+	target *Checker
+	router *chi.Mux
+}
+
+func (suite *HealthTestSuite) SetupTest() {
+	suite.target = NewChecker(time.Second)
+	suite.router = chi.NewRouter()
+	suite.target.RegisterRoutes(suite.router)
+}
+
+func (suite *HealthTestSuite) get(path string) (int, Report) {
+	w := httptest.NewRecorder()
+	suite.router.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+	body, _ := io.ReadAll(w.Result().Body)
+	report := Report{}
+	json.Unmarshal(body, &report)
+	return w.Code, report
+}
+
+func (suite *HealthTestSuite) Test_Live_IgnoresDependencies() {
+	suite.target.Add("postgres", func(ctx context.Context) (any, error) {
+		return nil, errors.New("connection refused")
+	})
+
+	status, report := suite.get("/healthz")
+
+	suite.Equal(200, status, "liveness should not depend on other services")
+	suite.Equal(StatusOK, report.Status)
+}
+
+func (suite *HealthTestSuite) Test_Ready_ReportsEachDependency() {
+	suite.target.Add("postgres", func(ctx context.Context) (any, error) {
+		return nil, nil
+	})
+	suite.target.Add("migrations", func(ctx context.Context) (any, error) {
+		return map[string]int{"current": 1, "expected": 2}, errors.New("schema is at version 1, expected 2")
+	})
+
+	status, report := suite.get("/readyz")
+
+	suite.Equal(503, status, "a failing dependency should make the server unready")
+	suite.Equal(StatusFail, report.Status)
+	suite.Equal(StatusOK, report.Checks["postgres"].Status, "passing checks should be reported")
+	suite.Equal(StatusFail, report.Checks["migrations"].Status, "failing checks should be reported")
+	suite.Equal("schema is at version 1, expected 2", report.Checks["migrations"].Error)
+	suite.Equal(map[string]any{"current": float64(1), "expected": float64(2)}, report.Checks["migrations"].Details)
+}
+
+func (suite *HealthTestSuite) Test_Ready_FailsWhileDraining() {
+	suite.target.Add("postgres", func(ctx context.Context) (any, error) {
+		return nil, nil
+	})
+	suite.target.Drain(context.Background())
+
+	status, report := suite.get("/readyz")
+
+	suite.Equal(503, status, "a draining server should not receive new traffic")
+	suite.Equal(StatusFail, report.Checks["shutdown"].Status)
+}
+
+func TestHealthTestSuite(t *testing.T) {
+	suite.Run(t, new(HealthTestSuite))
+}
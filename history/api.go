@@ -0,0 +1,140 @@
+package history
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/failuretoload/datamonster/auth"
+	"github.com/failuretoload/datamonster/notify"
+	"github.com/failuretoload/datamonster/openapi"
+	"github.com/failuretoload/datamonster/store"
+	"github.com/failuretoload/datamonster/user"
+	"github.com/failuretoload/datamonster/web"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Controller serves the history of settlements and announces undos to
+// publisher. Reading it requires readScope and undoing changes writeScope, the
+// scopes guarding the settlement itself.
+type Controller struct {
+	repo       *postgresRepo
+	publisher  notify.Publisher
+	readScope  string
+	writeScope string
+}
+
+func NewController(conn store.Connection, publisher notify.Publisher, readScope, writeScope string) *Controller {
+	return &Controller{repo: newRepo(conn), publisher: publisher, readScope: readScope, writeScope: writeScope}
+}
+
+type EventDTO struct {
+	Id         int            `json:"id"`
+	Entity     string         `json:"entity"`
+	EntityId   int            `json:"entityId"`
+	Action     string         `json:"action"`
+	Actor      string         `json:"actor"`
+	OccurredAt time.Time      `json:"occurredAt"`
+	Before     map[string]any `json:"before"`
+	After      map[string]any `json:"after"`
+	Reverts    *int           `json:"reverts,omitempty"`
+	RevertedBy *int           `json:"revertedBy,omitempty"`
+}
+
+// HistoryQuery selects the page of events listed, newest first.
+type HistoryQuery struct {
+	Limit  int    `query:"limit" validate:"min=1,max=200"`
+	Cursor string `query:"cursor"`
+}
+
+// UndoQuery names the event to undo, by default the most recent one that has
+// not been undone.
+type UndoQuery struct {
+	Event *int `query:"event" validate:"min=1"`
+}
+
+// cursor is the id of the last event of a page.
+type cursor struct {
+	Id int `json:"id"`
+}
+
+func (c Controller) RegisterRoutes(r chi.Router) {
+	r.With(auth.RequireScope(c.readScope)).Get("/settlements/{id}/history", c.getHistory)
+	r.With(auth.RequireScope(c.writeScope)).Post("/settlements/{id}/undo", c.undo)
+}
+
+func (c Controller) DescribeRoutes(d *openapi.Document) {
+	tags := []string{"history"}
+	d.Add(openapi.Route{Method: http.MethodGet, Path: "/settlements/{id}/history", ID: "getSettlementHistory", Summary: "List a page of the changes made to a settlement and its survivors, newest first", Tags: tags, Params: openapi.QueryParams(HistoryQuery{}), Scopes: []string{c.readScope}, Response: []EventDTO{}})
+	d.Add(openapi.Route{Method: http.MethodPost, Path: "/settlements/{id}/undo", ID: "undoSettlementChange", Summary: "Revert the most recent change to a settlement or its survivors, or the event named by event, answering 409 when later changes depend on it", Tags: tags, Params: openapi.QueryParams(UndoQuery{}), Scopes: []string{c.writeScope}, Response: EventDTO{}})
+}
+
+func (c Controller) getHistory(w http.ResponseWriter, r *http.Request) {
+	settlementId, ok := web.PathId(w, r, "id", "settlement")
+	if !ok {
+		return
+	}
+	query := HistoryQuery{Limit: 50}
+	if err := web.DecodeQuery(r, &query); err != nil {
+		web.WriteError(w, r, err)
+		return
+	}
+	page := store.Page{Column: "id", Desc: true, Limit: query.Limit}
+	if query.Cursor != "" {
+		var after cursor
+		if err := web.DecodeCursor(query.Cursor, &after); err != nil {
+			web.WriteError(w, r, err)
+			return
+		}
+		page.After = &store.Position{Value: after.Id, Id: after.Id}
+	}
+	u, _ := user.FromContext(r.Context())
+	events, more, err := c.repo.List(r.Context(), u.Subject, settlementId, page)
+	if err != nil {
+		web.WriteError(w, r, err)
+		return
+	}
+	if more {
+		web.SetNextPage(w, r, web.EncodeCursor(cursor{Id: events[len(events)-1].Id}))
+	}
+	payload := make([]EventDTO, len(events))
+	for i, e := range events {
+		payload[i] = domainToDto(e)
+	}
+	web.MakeJsonResponse(w, http.StatusOK, payload)
+}
+
+func (c Controller) undo(w http.ResponseWriter, r *http.Request) {
+	settlementId, ok := web.PathId(w, r, "id", "settlement")
+	if !ok {
+		return
+	}
+	var query UndoQuery
+	if err := web.DecodeQuery(r, &query); err != nil {
+		web.WriteError(w, r, err)
+		return
+	}
+	u, _ := user.FromContext(r.Context())
+	event, err := c.repo.Undo(r.Context(), u.Subject, settlementId, query.Event)
+	if err != nil {
+		web.WriteError(w, r, err)
+		return
+	}
+	c.publisher.Publish(r.Context(), notify.Change{Settlement: settlementId, Entity: event.Entity, EntityId: event.EntityId, Action: event.Action, Version: versionOf(event.After)})
+	web.MakeJsonResponse(w, http.StatusOK, domainToDto(event))
+}
+
+func domainToDto(e Event) EventDTO {
+	return EventDTO{
+		Id:         e.Id,
+		Entity:     e.Entity,
+		EntityId:   e.EntityId,
+		Action:     e.Action,
+		Actor:      e.Actor,
+		OccurredAt: e.OccurredAt,
+		Before:     e.Before,
+		After:      e.After,
+		Reverts:    e.Reverts,
+		RevertedBy: e.RevertedBy,
+	}
+}
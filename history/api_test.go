@@ -0,0 +1,243 @@
+package history
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/failuretoload/datamonster/auth"
+	"github.com/failuretoload/datamonster/notify"
+	storeMocks "github.com/failuretoload/datamonster/store/mocks"
+	"github.com/failuretoload/datamonster/user"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/suite"
+)
+
+const testUserId = "userId"
+
+type HistoryApiTestSuite struct {
+	suite.Suite
+	db     *storeMocks.MockConnection
+	router *chi.Mux
+}
+
+func (suite *HistoryApiTestSuite) SetupTest() {
+	suite.db = &storeMocks.MockConnection{}
+	suite.router = chi.NewRouter()
+	suite.router.Use(asUser)
+	NewController(suite.db, notify.NewHub(), "settlements:read", "settlements:write").RegisterRoutes(suite.router)
+}
+
+func (suite *HistoryApiTestSuite) Test_GetHistory_ListsEventsNewestFirst() {
+	suite.db.SetRow(&storeMocks.InsertRow{Id: 1})
+	suite.db.SetRows(&storeMocks.MockRows{Rows: []pgx.Row{
+		&EventRow{Event{Id: 9, Settlement: 1, Entity: EntitySurvivor, EntityId: 3, Action: ActionUpdate, Actor: testUserId, After: map[string]any{"version": float64(2)}}},
+		&EventRow{Event{Id: 8, Settlement: 1, Entity: EntitySurvivor, EntityId: 3, Action: ActionCreate, Actor: testUserId}},
+	}})
+	w := httptest.NewRecorder()
+
+	suite.router.ServeHTTP(w, httptest.NewRequest("GET", "/settlements/1/history?limit=1", nil))
+
+	suite.Equal(200, w.Code)
+	suite.Contains(suite.db.SQL, "FROM campaign.event WHERE settlement = $1 ORDER BY id DESC, id DESC LIMIT $2")
+	suite.Equal([]any{1, 2}, suite.db.Args)
+	suite.Contains(w.Header().Get("Link"), `rel="next"`)
+	events := []EventDTO{}
+	suite.NoError(json.Unmarshal(w.Body.Bytes(), &events))
+	suite.Require().Len(events, 1)
+	suite.Equal(9, events[0].Id)
+	suite.Equal("update", events[0].Action)
+}
+
+func (suite *HistoryApiTestSuite) Test_GetHistory_ReportsReadErrors() {
+	suite.db.SetRow(&storeMocks.InsertRow{Id: 1})
+	suite.db.SetRows(&storeMocks.MockRows{Error: errors.New("connection reset")})
+	w := httptest.NewRecorder()
+
+	suite.router.ServeHTTP(w, httptest.NewRequest("GET", "/settlements/1/history", nil))
+
+	suite.Equal(500, w.Code, "a partly read history should not be answered as complete")
+}
+
+func (suite *HistoryApiTestSuite) Test_GetHistory_HidesOtherPlayersSettlements() {
+	suite.db.SetRow(&storeMocks.ErrorRow{Error: pgx.ErrNoRows})
+	w := httptest.NewRecorder()
+
+	suite.router.ServeHTTP(w, httptest.NewRequest("GET", "/settlements/1/history", nil))
+
+	suite.Equal(404, w.Code)
+	suite.Equal([]any{1, testUserId}, suite.db.Args)
+}
+
+func (suite *HistoryApiTestSuite) Test_Undo_RestoresTheRecordBeforeTheMostRecentEvent() {
+	before := map[string]any{"name": "Zach", "huntxp": float64(1), "version": float64(2)}
+	suite.db.SetRow(&storeMocks.RowSequence{Rows: []pgx.Row{
+		&storeMocks.InsertRow{Id: 1},
+		&EventRow{Event{Id: 9, Settlement: 1, Entity: EntitySurvivor, EntityId: 3, Action: ActionUpdate, Before: before, After: map[string]any{"version": float64(3)}}},
+		&CurrentRow{Version: 3},
+		&EventRow{Event{Id: 10, Settlement: 1, Entity: EntitySurvivor, EntityId: 3, Action: ActionUndo, Reverts: intPtr(9)}},
+	}})
+	w := httptest.NewRecorder()
+
+	suite.router.ServeHTTP(w, httptest.NewRequest("POST", "/settlements/1/undo", nil))
+
+	suite.Equal(200, w.Code)
+	suite.Contains(suite.db.Statements[1].SQL, "ORDER BY id DESC LIMIT 1", "the most recent event should be undone")
+	revert := suite.db.Statements[3]
+	suite.Contains(revert.SQL, "UPDATE campaign.survivor SET (name, gender, birth, huntxp")
+	suite.Contains(revert.SQL, "jsonb_populate_record(NULL::campaign.survivor, $1::jsonb)")
+	suite.Equal([]any{before, 3}, revert.Args)
+	record := suite.db.Statements[4]
+	suite.Contains(record.SQL, "INSERT INTO campaign.event")
+	suite.Equal("undo", record.Args[3])
+	suite.Equal(intPtr(9), record.Args[6])
+	suite.True(suite.db.Committed)
+	event := EventDTO{}
+	suite.NoError(json.Unmarshal(w.Body.Bytes(), &event))
+	suite.Equal(10, event.Id)
+	suite.Equal(intPtr(9), event.Reverts)
+}
+
+func (suite *HistoryApiTestSuite) Test_Undo_DeletesCreatedSurvivors() {
+	suite.db.SetRow(&storeMocks.RowSequence{Rows: []pgx.Row{
+		&storeMocks.InsertRow{Id: 1},
+		&EventRow{Event{Id: 4, Settlement: 1, Entity: EntitySurvivor, EntityId: 3, Action: ActionCreate, After: map[string]any{"version": float64(1)}}},
+		&CurrentRow{Version: 1},
+		&EventRow{Event{Id: 5, Settlement: 1, Entity: EntitySurvivor, EntityId: 3, Action: ActionUndo, Reverts: intPtr(4)}},
+	}})
+	w := httptest.NewRecorder()
+
+	suite.router.ServeHTTP(w, httptest.NewRequest("POST", "/settlements/1/undo?event=4", nil))
+
+	suite.Equal(200, w.Code)
+	suite.Equal([]any{1, 4}, suite.db.Statements[1].Args, "the named event should be undone")
+	suite.Equal("DELETE FROM campaign.survivor WHERE id = $1", suite.db.Statements[3].SQL)
+	removed := suite.db.Statements[5]
+	suite.Contains(removed.SQL, "INSERT INTO campaign.settlement_event")
+	suite.Equal([]any{1, "SurvivorRemoved", []byte(`{"survivorId":3}`), testUserId}, removed.Args)
+}
+
+func (suite *HistoryApiTestSuite) Test_Undo_MovesTheTimelineBackToTheRestoredYear() {
+	suite.db.SetRow(&storeMocks.RowSequence{Rows: []pgx.Row{
+		&storeMocks.InsertRow{Id: 1},
+		&EventRow{Event{Id: 9, Settlement: 1, Entity: EntitySettlement, EntityId: 1, Action: ActionUpdate, Before: map[string]any{"year": float64(2)}, After: map[string]any{"version": float64(3)}}},
+		&CurrentRow{Version: 3, Year: 3},
+		&EventRow{Event{Id: 10, Settlement: 1, Entity: EntitySettlement, EntityId: 1, Action: ActionUndo, Reverts: intPtr(9)}},
+	}})
+	w := httptest.NewRecorder()
+
+	suite.router.ServeHTTP(w, httptest.NewRequest("POST", "/settlements/1/undo", nil))
+
+	suite.Equal(200, w.Code)
+	advanced := suite.db.Statements[5]
+	suite.Contains(advanced.SQL, "INSERT INTO campaign.settlement_event")
+	suite.Equal([]any{1, "LanternYearAdvanced", []byte(`{"year":2}`), testUserId}, advanced.Args)
+}
+
+func (suite *HistoryApiTestSuite) Test_Undo_RefusesWhenTheRecordChangedSince() {
+	suite.db.SetRow(&storeMocks.RowSequence{Rows: []pgx.Row{
+		&storeMocks.InsertRow{Id: 1},
+		&EventRow{Event{Id: 9, Settlement: 1, Entity: EntitySettlement, EntityId: 1, Action: ActionUpdate, After: map[string]any{"version": float64(3)}}},
+		&CurrentRow{Version: 4},
+	}})
+	w := httptest.NewRecorder()
+
+	suite.router.ServeHTTP(w, httptest.NewRequest("POST", "/settlements/1/undo?event=9", nil))
+
+	suite.Equal(409, w.Code, "undoing would overwrite later changes")
+	suite.Contains(w.Body.String(), "settlement 1 has changed since event 9")
+	suite.False(suite.db.Committed)
+}
+
+func (suite *HistoryApiTestSuite) Test_Undo_RefusesUnsafeEvents() {
+	tests := []struct {
+		name   string
+		event  Event
+		detail string
+	}{
+		{"undo", Event{Id: 9, Entity: EntitySurvivor, Action: ActionUndo}, "event 9 is an undo"},
+		{"undone", Event{Id: 9, Entity: EntitySurvivor, Action: ActionUpdate, RevertedBy: intPtr(10)}, "already been undone by event 10"},
+		{"founding", Event{Id: 9, Entity: EntitySettlement, Action: ActionCreate}, "founding a settlement cannot be undone"},
+	}
+	for _, tt := range tests {
+		suite.Run(tt.name, func() {
+			suite.db.SetRow(&storeMocks.RowSequence{Rows: []pgx.Row{&storeMocks.InsertRow{Id: 1}, &EventRow{tt.event}}})
+			w := httptest.NewRecorder()
+
+			suite.router.ServeHTTP(w, httptest.NewRequest("POST", "/settlements/1/undo?event=9", nil))
+
+			suite.Equal(409, w.Code)
+			suite.Contains(w.Body.String(), tt.detail)
+		})
+	}
+}
+
+func (suite *HistoryApiTestSuite) Test_Undo_ReportsWhenThereIsNothingToUndo() {
+	suite.db.SetRow(&storeMocks.RowSequence{Rows: []pgx.Row{
+		&storeMocks.InsertRow{Id: 1},
+		&storeMocks.ErrorRow{Error: pgx.ErrNoRows},
+	}})
+	w := httptest.NewRecorder()
+
+	suite.router.ServeHTTP(w, httptest.NewRequest("POST", "/settlements/1/undo", nil))
+
+	suite.Equal(409, w.Code)
+	suite.Contains(w.Body.String(), "there is nothing to undo")
+}
+
+// asUser authenticates every request as a user, who is not limited by scopes.
+func asUser(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := auth.WithPrincipal(r.Context(), auth.Principal{Subject: testUserId})
+		next.ServeHTTP(w, r.WithContext(user.WithUser(ctx, user.User{Subject: testUserId})))
+	})
+}
+
+func TestHistoryApiTestSuite(t *testing.T) {
+	suite.Run(t, new(HistoryApiTestSuite))
+}
+
+type EventRow struct {
+	Event Event
+}
+
+func (er *EventRow) Scan(dest ...any) error {
+	e := er.Event
+	if e.OccurredAt.IsZero() {
+		e.OccurredAt = time.Date(2024, 3, 1, 20, 0, 0, 0, time.UTC)
+	}
+	*dest[0].(*int) = e.Id
+	*dest[1].(*int) = e.Settlement
+	*dest[2].(*string) = e.Entity
+	*dest[3].(*int) = e.EntityId
+	*dest[4].(*string) = e.Action
+	*dest[5].(*string) = e.Actor
+	*dest[6].(*time.Time) = e.OccurredAt
+	*dest[7].(*map[string]any) = e.Before
+	*dest[8].(*map[string]any) = e.After
+	*dest[9].(**int) = e.Reverts
+	*dest[10].(**int) = e.RevertedBy
+	return nil
+}
+
+// CurrentRow is the version and snapshot of the record an undo reverts.
+type CurrentRow struct {
+	Version int
+	Year    int
+}
+
+func (cr *CurrentRow) Scan(dest ...any) error {
+	*dest[0].(*int) = cr.Version
+	*dest[1].(*[]byte) = []byte(fmt.Sprintf(`{"version": %d, "year": %d}`, cr.Version, cr.Year))
+	return nil
+}
+
+func intPtr(i int) *int {
+	return &i
+}
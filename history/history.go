@@ -0,0 +1,56 @@
+// Package history keeps the append-only log of changes made to settlements and
+// their survivors, and undoes them.
+package history
+
+import (
+	"context"
+
+	"github.com/failuretoload/datamonster/auth"
+	"github.com/failuretoload/datamonster/store"
+)
+
+// Entities whose changes are recorded.
+const (
+	EntitySettlement = "settlement"
+	EntitySurvivor   = "survivor"
+)
+
+// Actions an event records.
+const (
+	ActionCreate = "create"
+	ActionUpdate = "update"
+	ActionUndo   = "undo"
+)
+
+// Tables maps entities to the tables storing them.
+var Tables = map[string]string{
+	EntitySettlement: "campaign.settlement",
+	EntitySurvivor:   "campaign.survivor",
+}
+
+// Change describes a change to a settlement or one of its survivors.
+type Change struct {
+	Settlement int
+	Entity     string
+	EntityId   int
+	Action     string
+	// Before is the record as it was before the change, as selected by
+	// to_jsonb, and nil for creations.
+	Before []byte
+	// Reverts is the event an undo reverts.
+	Reverts *int
+}
+
+// Record appends an event for c, made by the caller of the request, capturing
+// the record as it is after the change. It must run in the transaction that
+// made the change so the log and the data never diverge.
+func Record(ctx context.Context, q store.Querier, c Change) error {
+	actor := ""
+	if p, ok := auth.PrincipalFrom(ctx); ok {
+		actor = p.Subject
+	}
+	query := `INSERT INTO campaign.event (settlement, entity, entity_id, action, actor, before, reverts, after)
+		SELECT $1::integer, $2::text, $3::integer, $4::text, $5::text, $6::jsonb, $7::bigint, (SELECT to_jsonb(t) FROM ` + Tables[c.Entity] + ` t WHERE t.id = $3)`
+	_, err := q.Exec(ctx, query, c.Settlement, c.Entity, c.EntityId, c.Action, actor, c.Before, c.Reverts)
+	return store.TranslateError(err, "event")
+}
@@ -0,0 +1,205 @@
+package history
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/failuretoload/datamonster/domain"
+	"github.com/failuretoload/datamonster/store"
+	"github.com/failuretoload/datamonster/timeline"
+	"github.com/failuretoload/datamonster/tracing"
+	"github.com/jackc/pgx/v5"
+)
+
+type postgresRepo struct {
+	pool store.Connection
+}
+
+// Event is a recorded change. Before and After are the record as stored, and
+// nil before a creation and after an undone creation.
+type Event struct {
+	Id         int
+	Settlement int
+	Entity     string
+	EntityId   int
+	Action     string
+	Actor      string
+	OccurredAt time.Time
+	Before     map[string]any
+	After      map[string]any
+	Reverts    *int
+	RevertedBy *int
+}
+
+const columns = `id, settlement, entity, entity_id, action, actor, occurred_at, before, after, reverts,
+	(SELECT u.id FROM campaign.event u WHERE u.reverts = event.id) AS reverted_by`
+
+// revertible lists the columns of each entity an undo restores. Ids, owners and
+// versions are never restored; the version is incremented instead.
+var revertible = map[string][]string{
+	EntitySettlement: {"name", "survival_limit", "departing_survival", "collective_cognition", "year"},
+	EntitySurvivor: {"name", "gender", "birth", "huntxp", "survival", "movement", "accuracy", "strength", "evasion", "luck",
+		"speed", "insanity", "systemic_pressure", "torment", "lumi", "courage", "understanding", "status", "disorders"},
+}
+
+func newRepo(conn store.Connection) *postgresRepo {
+	return &postgresRepo{pool: conn}
+}
+
+// List returns one page of the events of one of owner's settlements and whether
+// another page follows.
+func (r postgresRepo) List(ctx context.Context, owner string, settlementId int, page store.Page) ([]Event, bool, error) {
+	ctx, span := tracing.Start(ctx, "history.List")
+	defer span.End()
+	if err := store.Owned(ctx, r.pool, owner, settlementId); err != nil {
+		return nil, false, err
+	}
+	query, args := page.Apply(`SELECT `+columns+` FROM campaign.event`, []string{"settlement = $1"}, []any{settlementId})
+	rows, err := r.pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, false, store.TranslateError(err, "event")
+	}
+	defer rows.Close()
+	events := []Event{}
+	for rows.Next() {
+		e, err := scan(rows)
+		if err != nil {
+			return events, false, store.TranslateError(err, "event")
+		}
+		events = append(events, e)
+	}
+	if err := rows.Err(); err != nil {
+		return events, false, store.TranslateError(err, "event")
+	}
+	events, more := store.More(events, page)
+	return events, more, nil
+}
+
+// Undo reverts an event of one of owner's settlements and returns the event
+// recording the undo. When id is nil the most recent event that has not been
+// undone is reverted. Events can only be undone while the record they changed
+// has not changed since.
+func (r postgresRepo) Undo(ctx context.Context, owner string, settlementId int, id *int) (Event, error) {
+	ctx, span := tracing.Start(ctx, "history.Undo")
+	defer span.End()
+	var undo Event
+	err := pgx.BeginFunc(ctx, r.pool, func(tx pgx.Tx) error {
+		if err := store.Owned(ctx, tx, owner, settlementId); err != nil {
+			return err
+		}
+		e, err := target(ctx, tx, settlementId, id)
+		if err != nil {
+			return err
+		}
+		if err := undoable(e); err != nil {
+			return err
+		}
+		table := Tables[e.Entity]
+		var version int
+		var current []byte
+		err = tx.QueryRow(ctx, `SELECT version, to_jsonb(t) FROM `+table+` t WHERE t.id = $1 FOR UPDATE`, e.EntityId).Scan(&version, &current)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return domain.Conflict("%s %d no longer exists", e.Entity, e.EntityId)
+		}
+		if err != nil {
+			return store.TranslateError(err, e.Entity)
+		}
+		if version != versionOf(e.After) {
+			return domain.Conflict("%s %d has changed since event %d, undo the later changes first", e.Entity, e.EntityId, e.Id)
+		}
+		if err := revert(ctx, tx, e); err != nil {
+			return err
+		}
+		if err := compensate(ctx, tx, e, current); err != nil {
+			return err
+		}
+		change := Change{Settlement: settlementId, Entity: e.Entity, EntityId: e.EntityId, Action: ActionUndo, Before: current, Reverts: &e.Id}
+		if err := Record(ctx, tx, change); err != nil {
+			return err
+		}
+		undo, err = scan(tx.QueryRow(ctx, `SELECT `+columns+` FROM campaign.event WHERE reverts = $1`, e.Id))
+		return store.TranslateError(err, "event")
+	})
+	return undo, err
+}
+
+// target finds the event an undo reverts.
+func target(ctx context.Context, q store.Querier, settlementId int, id *int) (Event, error) {
+	if id != nil {
+		e, err := scan(q.QueryRow(ctx, `SELECT `+columns+` FROM campaign.event WHERE settlement = $1 AND id = $2`, settlementId, *id))
+		return e, store.TranslateError(err, "event")
+	}
+	query := `SELECT ` + columns + ` FROM campaign.event WHERE settlement = $1 AND action <> 'undo'
+		AND NOT EXISTS (SELECT 1 FROM campaign.event u WHERE u.reverts = event.id) ORDER BY id DESC LIMIT 1`
+	e, err := scan(q.QueryRow(ctx, query, settlementId))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return e, domain.Conflict("there is nothing to undo")
+	}
+	return e, store.TranslateError(err, "event")
+}
+
+func undoable(e Event) error {
+	switch {
+	case e.Action == ActionUndo:
+		return domain.Conflict("event %d is an undo and cannot be undone", e.Id)
+	case e.RevertedBy != nil:
+		return domain.Conflict("event %d has already been undone by event %d", e.Id, *e.RevertedBy)
+	case e.Entity == EntitySettlement && e.Action == ActionCreate:
+		return domain.Conflict("founding a settlement cannot be undone")
+	}
+	return nil
+}
+
+// revert deletes created records and restores the revertible columns of
+// updated ones from the event's before snapshot.
+func revert(ctx context.Context, q store.Querier, e Event) error {
+	table := Tables[e.Entity]
+	if e.Action == ActionCreate {
+		_, err := q.Exec(ctx, `DELETE FROM `+table+` WHERE id = $1`, e.EntityId)
+		return store.TranslateError(err, e.Entity)
+	}
+	cols := strings.Join(revertible[e.Entity], ", ")
+	query := `UPDATE ` + table + ` SET (` + cols + `) = (SELECT ` + cols + ` FROM jsonb_populate_record(NULL::` + table + `, $1::jsonb)),
+		version = version + 1 WHERE id = $2`
+	_, err := q.Exec(ctx, query, e.Before, e.EntityId)
+	return store.TranslateError(err, e.Entity)
+}
+
+// compensate appends the domain event that takes back what e changed in the
+// settlement's timeline, if anything. current is the record before the undo.
+func compensate(ctx context.Context, q store.Querier, e Event, current []byte) error {
+	switch {
+	case e.Entity == EntitySurvivor && e.Action == ActionCreate:
+		return timeline.Append(ctx, q, e.Settlement, timeline.SurvivorRemoved{SurvivorId: e.EntityId})
+	case e.Entity == EntitySettlement && e.Action == ActionUpdate:
+		var record map[string]any
+		if err := json.Unmarshal(current, &record); err != nil {
+			return err
+		}
+		if year := yearOf(e.Before); year != yearOf(record) {
+			return timeline.Append(ctx, q, e.Settlement, timeline.LanternYearAdvanced{Year: year})
+		}
+	}
+	return nil
+}
+
+// versionOf reads the version of a record snapshot.
+func versionOf(record map[string]any) int {
+	version, _ := record["version"].(float64)
+	return int(version)
+}
+
+// yearOf reads the lantern year of a settlement snapshot.
+func yearOf(record map[string]any) int {
+	year, _ := record["year"].(float64)
+	return int(year)
+}
+
+func scan(row pgx.Row) (Event, error) {
+	var e Event
+	err := row.Scan(&e.Id, &e.Settlement, &e.Entity, &e.EntityId, &e.Action, &e.Actor, &e.OccurredAt, &e.Before, &e.After, &e.Reverts, &e.RevertedBy)
+	return e, err
+}
@@ -0,0 +1,171 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Manager starts the application's long running components and stops them when
+// the process is asked to terminate. Components are stopped in the order they
+// were registered, sharing a single deadline.
+type Manager struct {
+	timeout time.Duration
+	steps   []step
+	failed  chan failure
+	once    sync.Once
+}
+
+type step struct {
+	name string
+	stop func(ctx context.Context) error
+}
+
+type failure struct {
+	name string
+	err  error
+}
+
+func New(timeout time.Duration) *Manager {
+	return &Manager{
+		timeout: timeout,
+		failed:  make(chan failure, 1),
+	}
+}
+
+// OnShutdown registers a function that releases a resource during shutdown.
+func (m *Manager) OnShutdown(name string, stop func(ctx context.Context) error) {
+	m.steps = append(m.steps, step{name: name, stop: stop})
+}
+
+// Serve runs start in the background and registers stop as its shutdown step.
+// start must block until stop is called; returning earlier is treated as a
+// failure and triggers shutdown.
+func (m *Manager) Serve(name string, start func() error, stop func(ctx context.Context) error) {
+	go func() {
+		err := start()
+		if err == nil {
+			err = errors.New("stopped unexpectedly")
+		}
+		m.fail(name, err)
+	}()
+	m.OnShutdown(name, stop)
+}
+
+// Go runs a background worker. The worker's context is cancelled when shutdown
+// reaches it, and shutdown waits for the worker to return.
+func (m *Manager) Go(name string, worker func(ctx context.Context) error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if err := worker(ctx); err != nil && !errors.Is(err, context.Canceled) {
+			m.fail(name, err)
+		}
+	}()
+	m.OnShutdown(name, func(stopCtx context.Context) error {
+		cancel()
+		select {
+		case <-done:
+			return nil
+		case <-stopCtx.Done():
+			return stopCtx.Err()
+		}
+	})
+}
+
+// Wait blocks until the process receives SIGINT or SIGTERM, ctx is cancelled or
+// a component fails, and then shuts everything down. A second signal received
+// during shutdown terminates the process immediately.
+func (m *Manager) Wait(ctx context.Context) error {
+	sigCtx, stopSignals := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	var cause error
+	select {
+	case <-sigCtx.Done():
+		slog.Info("shutdown requested")
+	case f := <-m.failed:
+		cause = fmt.Errorf("%s failed: %w", f.name, f.err)
+		slog.Error("shutting down after a component failed", "error", cause)
+	}
+	stopSignals()
+	return errors.Join(cause, m.Shutdown())
+}
+
+// Shutdown stops every registered component in registration order. It keeps
+// going after a component fails or the deadline passes so that every resource
+// gets a chance to be released, and reports all failures together.
+func (m *Manager) Shutdown() error {
+	var err error
+	m.once.Do(func() {
+		err = m.shutdown()
+	})
+	return err
+}
+
+func (m *Manager) shutdown() error {
+	ctx, cancel := context.WithTimeout(context.Background(), m.timeout)
+	defer cancel()
+	slog.Info("stopping components", "count", len(m.steps), "timeout", m.timeout)
+
+	var errs []error
+	began := time.Now()
+	for i, s := range m.steps {
+		start := time.Now()
+		logger := slog.With("component", s.name, "step", fmt.Sprintf("%d/%d", i+1, len(m.steps)))
+		logger.Info("stopping component")
+		if err := s.stop(ctx); err != nil {
+			logger.Error("failed to stop component", "error", err)
+			errs = append(errs, fmt.Errorf("stopping %s: %w", s.name, err))
+			continue
+		}
+		logger.Info("stopped component", "duration", time.Since(start).Round(time.Millisecond))
+	}
+	slog.Info("shutdown finished", "duration", time.Since(began).Round(time.Millisecond))
+	return errors.Join(errs...)
+}
+
+// Close adapts a blocking close function without a context, such as
+// pgxpool.Pool.Close, into a shutdown step that respects the deadline.
+func Close(closeFn func()) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			closeFn()
+		}()
+		select {
+		case <-done:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Delay is a shutdown step that waits for d, or until the deadline if sooner,
+// giving other systems time to react to the steps before it.
+func Delay(d time.Duration) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (m *Manager) fail(name string, err error) {
+	select {
+	case m.failed <- failure{name: name, err: err}:
+	default:
+	}
+}
@@ -0,0 +1,85 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type ManagerTestSuite struct {
+	suite.Suite
+	target *Manager
+}
+
+func (suite *ManagerTestSuite) SetupTest() {
+	suite.target = New(100 * time.Millisecond)
+}
+
+func (suite *ManagerTestSuite) Test_Shutdown_StopsComponentsInRegistrationOrder() {
+	stopped := []string{}
+	suite.target.OnShutdown("http server", func(ctx context.Context) error {
+		stopped = append(stopped, "http server")
+		return nil
+	})
+	suite.target.Go("worker", func(ctx context.Context) error {
+		<-ctx.Done()
+		stopped = append(stopped, "worker")
+		return ctx.Err()
+	})
+	suite.target.OnShutdown("database pool", Close(func() {
+		stopped = append(stopped, "database pool")
+	}))
+
+	err := suite.target.Shutdown()
+
+	suite.NoError(err)
+	suite.Equal([]string{"http server", "worker", "database pool"}, stopped, "components should stop in order")
+}
+
+func (suite *ManagerTestSuite) Test_Shutdown_ReportsComponentsThatMissTheDeadline() {
+	released := false
+	suite.target.OnShutdown("stuck", func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	suite.target.OnShutdown("database pool", func(ctx context.Context) error {
+		released = true
+		return nil
+	})
+
+	err := suite.target.Shutdown()
+
+	suite.ErrorIs(err, context.DeadlineExceeded)
+	suite.ErrorContains(err, "stopping stuck")
+	suite.True(released, "later components should still be stopped")
+}
+
+func (suite *ManagerTestSuite) Test_Delay_EndsAtTheDeadline() {
+	suite.target.OnShutdown("drain delay", Delay(time.Hour))
+
+	err := suite.target.Shutdown()
+
+	suite.ErrorIs(err, context.DeadlineExceeded, "the delay should not outlast the shutdown deadline")
+}
+
+func (suite *ManagerTestSuite) Test_Wait_ShutsDownWhenAComponentFails() {
+	stopped := false
+	suite.target.Serve("http server", func() error {
+		return errors.New("address already in use")
+	}, func(ctx context.Context) error {
+		stopped = true
+		return nil
+	})
+
+	err := suite.target.Wait(context.Background())
+
+	suite.ErrorContains(err, "http server failed: address already in use")
+	suite.True(stopped, "components should be stopped after a failure")
+}
+
+func TestManagerTestSuite(t *testing.T) {
+	suite.Run(t, new(ManagerTestSuite))
+}
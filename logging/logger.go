@@ -0,0 +1,61 @@
+package logging
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+
+	"github.com/failuretoload/datamonster/config"
+)
+
+// New builds the application logger. Every record passes through the redacting
+// handler before it is written.
+func New(cfg config.Log, w io.Writer) *slog.Logger {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(cfg.Level)); err != nil {
+		level = slog.LevelInfo
+	}
+	opts := &slog.HandlerOptions{Level: level}
+	var handler slog.Handler = slog.NewJSONHandler(w, opts)
+	if strings.EqualFold(cfg.Format, "text") {
+		handler = slog.NewTextHandler(w, opts)
+	}
+	return slog.New(NewRedactingHandler(handler))
+}
+
+type ctxLoggerKey struct{}
+
+// requestLog collects the attributes added while a request is handled so the
+// completion record carries them too.
+type requestLog struct {
+	mu    sync.Mutex
+	attrs []any
+}
+
+type ctxRequestLogKey struct{}
+
+// FromContext returns the logger of the current request, or the default logger
+// outside of one.
+func FromContext(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(ctxLoggerKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+func WithLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, ctxLoggerKey{}, logger)
+}
+
+// With returns a context whose logger carries args, which are also added to the
+// record logged when the request completes.
+func With(ctx context.Context, args ...any) context.Context {
+	if rl, ok := ctx.Value(ctxRequestLogKey{}).(*requestLog); ok {
+		rl.mu.Lock()
+		rl.attrs = append(rl.attrs, args...)
+		rl.mu.Unlock()
+	}
+	return WithLogger(ctx, FromContext(ctx).With(args...))
+}
@@ -0,0 +1,110 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/failuretoload/datamonster/config"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/stretchr/testify/suite"
+)
+
+type LoggingTestSuite struct {
+	suite.Suite
+	out    *bytes.Buffer
+	logger *slog.Logger
+}
+
+func (suite *LoggingTestSuite) SetupTest() {
+	suite.out = &bytes.Buffer{}
+	suite.logger = New(config.Log{Level: "debug", Format: "json"}, suite.out)
+}
+
+func (suite *LoggingTestSuite) records() []map[string]any {
+	records := []map[string]any{}
+	for _, line := range strings.Split(strings.TrimSpace(suite.out.String()), "\n") {
+		record := map[string]any{}
+		suite.Require().NoError(json.Unmarshal([]byte(line), &record))
+		records = append(records, record)
+	}
+	return records
+}
+
+func (suite *LoggingTestSuite) Test_New_RedactsCredentials() {
+	tests := []struct {
+		name string
+		args []any
+		key  string
+	}{
+		{name: "sensitive key", args: []any{"token", "anything"}, key: "token"},
+		{name: "suffixed sensitive key", args: []any{"access_token", "anything"}, key: "access_token"},
+		{name: "sql arguments", args: []any{"args", []any{"Lantern Bearer", 1}}, key: "args"},
+		{name: "bearer credential", args: []any{"header", "Bearer abc.def"}, key: "header"},
+		{name: "jwt", args: []any{"reason", "rejected eyJhbGciOi.eyJzdWIi.c2lnbmF0dXJl"}, key: "reason"},
+		{name: "personal access token", args: []any{"error", errors.New("unknown token dm_pat_abcdef")}, key: "error"},
+	}
+	for _, test := range tests {
+		suite.Run(test.name, func() {
+			suite.out.Reset()
+
+			suite.logger.Info("event", test.args...)
+
+			value := suite.records()[0][test.key].(string)
+			suite.Contains(value, Redacted)
+			suite.NotContains(value, "anything")
+			suite.NotContains(value, "abc.def")
+			suite.NotContains(value, "eyJ")
+			suite.NotContains(value, "dm_pat_")
+		})
+	}
+}
+
+func (suite *LoggingTestSuite) Test_New_RedactsGroupsAndLoggerAttributes() {
+	suite.logger.With("password", "hunter2").Info("event", slog.Group("request", "authorization", "Bearer abc"))
+
+	record := suite.records()[0]
+	suite.Equal(Redacted, record["password"])
+	suite.Equal(Redacted, record["request"].(map[string]any)["authorization"])
+}
+
+func (suite *LoggingTestSuite) Test_New_FiltersByLevel() {
+	logger := New(config.Log{Level: "warn", Format: "text"}, suite.out)
+
+	logger.Info("ignored")
+	logger.Warn("kept")
+
+	suite.NotContains(suite.out.String(), "ignored")
+	suite.Contains(suite.out.String(), "msg=kept")
+}
+
+func (suite *LoggingTestSuite) Test_Middleware_CorrelatesRequestLogs() {
+	handler := middleware.RequestID(Middleware(suite.logger)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := With(r.Context(), "user_id", "userId")
+		FromContext(ctx).Info("handling")
+		w.WriteHeader(http.StatusTeapot)
+	})))
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/v2/settlements", nil))
+
+	records := suite.records()
+	suite.Require().Len(records, 2)
+	handling, completed := records[0], records[1]
+	suite.NotEmpty(handling["request_id"])
+	suite.Equal(handling["request_id"], completed["request_id"])
+	suite.Equal("userId", handling["user_id"])
+	suite.Equal("userId", completed["user_id"], "attributes added by handlers should reach the completion record")
+	suite.Equal("request completed", completed["msg"])
+	suite.Equal("WARN", completed["level"])
+	suite.Equal(float64(http.StatusTeapot), completed["status"])
+	suite.Equal("/v2/settlements", completed["path"])
+}
+
+func TestLoggingTestSuite(t *testing.T) {
+	suite.Run(t, new(LoggingTestSuite))
+}
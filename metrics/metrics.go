@@ -0,0 +1,49 @@
+package metrics
+
+import (
+	"github.com/go-chi/chi/v5"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "datamonster"
+
+// Registry holds every metric the server exposes. It is separate from the
+// Prometheus default registry so that only collectors registered here are
+// served.
+var Registry = prometheus.NewRegistry()
+
+var (
+	SettlementsCreated = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "settlements_created_total",
+		Help:      "Settlements created.",
+	})
+	SurvivorsDied = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "survivors_died_total",
+		Help:      "Survivors recorded as dead.",
+	})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		SettlementsCreated,
+		SurvivorsDied,
+		requests,
+		requestDuration,
+	)
+}
+
+// RegisterRoutes serves the registry in the Prometheus text format at /metrics.
+func RegisterRoutes(r chi.Router) {
+	r.Get("/metrics", promhttp.HandlerFor(Registry, promhttp.HandlerOpts{}).ServeHTTP)
+}
+
+// MustRegister adds collectors, such as the pool collector, to Registry.
+func MustRegister(cs ...prometheus.Collector) {
+	Registry.MustRegister(cs...)
+}
@@ -0,0 +1,59 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/suite"
+)
+
+type MetricsTestSuite struct {
+	suite.Suite
+	router *chi.Mux
+}
+
+func (suite *MetricsTestSuite) SetupTest() {
+	requests.Reset()
+	requestDuration.Reset()
+	suite.router = chi.NewRouter()
+	suite.router.Use(Middleware)
+	suite.router.Route("/v2", func(r chi.Router) {
+		r.Get("/settlements/{id}", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNotFound)
+		})
+	})
+	RegisterRoutes(suite.router)
+}
+
+func (suite *MetricsTestSuite) Test_Middleware_LabelsRequestsByRoutePattern() {
+	suite.router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/v2/settlements/1", nil))
+	suite.router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/v2/settlements/2", nil))
+
+	suite.Equal(2.0, testutil.ToFloat64(requests.WithLabelValues("/v2/settlements/{id}", "GET", "404")))
+	suite.Equal(1, testutil.CollectAndCount(requestDuration), "ids should not create new series")
+}
+
+func (suite *MetricsTestSuite) Test_Middleware_GroupsUnmatchedPaths() {
+	suite.router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/wp-admin.php", nil))
+
+	suite.Equal(1.0, testutil.ToFloat64(requests.WithLabelValues(unmatched, "GET", "404")))
+}
+
+func (suite *MetricsTestSuite) Test_RegisterRoutes_ServesPrometheusText() {
+	SettlementsCreated.Inc()
+	w := httptest.NewRecorder()
+
+	suite.router.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+
+	suite.Equal(200, w.Code)
+	suite.True(strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain"))
+	suite.Contains(w.Body.String(), "datamonster_settlements_created_total")
+}
+
+func TestMetricsTestSuite(t *testing.T) {
+	suite.Run(t, new(MetricsTestSuite))
+}
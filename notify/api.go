@@ -0,0 +1,85 @@
+package notify
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/failuretoload/datamonster/auth"
+	"github.com/failuretoload/datamonster/logging"
+	"github.com/failuretoload/datamonster/openapi"
+	"github.com/failuretoload/datamonster/store"
+	"github.com/failuretoload/datamonster/user"
+	"github.com/failuretoload/datamonster/web"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Heartbeat is how often an idle stream sends a comment, so that proxies do not
+// close it.
+var Heartbeat = 15 * time.Second
+
+// reconnect is how long clients wait before reconnecting to a stream that
+// ended.
+const reconnect = 3 * time.Second
+
+// Controller streams the changes of settlements as server-sent events. Watching
+// a settlement requires readScope, the scope guarding the settlement itself.
+type Controller struct {
+	pool      store.Connection
+	hub       *Hub
+	readScope string
+}
+
+func NewController(conn store.Connection, hub *Hub, readScope string) *Controller {
+	return &Controller{pool: conn, hub: hub, readScope: readScope}
+}
+
+func (c Controller) RegisterRoutes(r chi.Router) {
+	r.With(auth.RequireScope(c.readScope), web.Unbounded).Get("/settlements/{id}/events", c.stream)
+}
+
+func (c Controller) DescribeRoutes(d *openapi.Document) {
+	d.Add(openapi.Route{Method: http.MethodGet, Path: "/settlements/{id}/events", ID: "streamSettlementChanges", Summary: "Stream a change event whenever the settlement or one of its survivors changes, until the client disconnects", Tags: []string{"notifications"}, Scopes: []string{c.readScope}, Response: Change{}, ResponseType: "text/event-stream"})
+}
+
+func (c Controller) stream(w http.ResponseWriter, r *http.Request) {
+	settlementId, ok := web.PathId(w, r, "id", "settlement")
+	if !ok {
+		return
+	}
+	u, _ := user.FromContext(r.Context())
+	if err := store.Owned(r.Context(), c.pool, u.Subject, settlementId); err != nil {
+		web.WriteError(w, r, err)
+		return
+	}
+	sub := c.hub.Subscribe(settlementId)
+	defer sub.Close()
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "retry: %d\n\n", reconnect.Milliseconds())
+	heartbeat := time.NewTicker(Heartbeat)
+	defer heartbeat.Stop()
+	for {
+		if err := rc.Flush(); err != nil {
+			logging.FromContext(r.Context()).Warn("unable to flush event stream", "error", err)
+			return
+		}
+		select {
+		case <-r.Context().Done():
+			return
+		case change, ok := <-sub.C:
+			if !ok {
+				return
+			}
+			data, _ := json.Marshal(change)
+			fmt.Fprintf(w, "event: change\ndata: %s\n\n", data)
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": heartbeat\n\n")
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package notify
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/failuretoload/datamonster/auth"
+	storeMocks "github.com/failuretoload/datamonster/store/mocks"
+	"github.com/failuretoload/datamonster/user"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/suite"
+)
+
+const testUserId = "userId"
+
+type NotifyApiTestSuite struct {
+	suite.Suite
+	db     *storeMocks.MockConnection
+	hub    *Hub
+	server *httptest.Server
+}
+
+func (suite *NotifyApiTestSuite) SetupTest() {
+	suite.db = &storeMocks.MockConnection{}
+	suite.db.SetRow(&storeMocks.InsertRow{Id: 1})
+	suite.hub = NewHub()
+	router := chi.NewRouter()
+	router.Use(asUser)
+	NewController(suite.db, suite.hub, "settlements:read").RegisterRoutes(router)
+	suite.server = httptest.NewServer(router)
+}
+
+func (suite *NotifyApiTestSuite) TearDownTest() {
+	suite.server.Close()
+}
+
+// open connects to the stream of a settlement and waits until it is subscribed.
+func (suite *NotifyApiTestSuite) open(ctx context.Context, path string) (*http.Response, *bufio.Reader) {
+	req, err := http.NewRequestWithContext(ctx, "GET", suite.server.URL+path, nil)
+	suite.Require().NoError(err)
+	req.Header.Set("Accept", "text/event-stream")
+	resp, err := http.DefaultClient.Do(req)
+	suite.Require().NoError(err)
+	suite.Require().Equal(200, resp.StatusCode)
+	body := bufio.NewReader(resp.Body)
+	suite.Equal("retry: 3000\n", suite.line(body))
+	suite.Equal("\n", suite.line(body))
+	return resp, body
+}
+
+func (suite *NotifyApiTestSuite) line(body *bufio.Reader) string {
+	line, err := body.ReadString('\n')
+	suite.Require().NoError(err)
+	return line
+}
+
+func (suite *NotifyApiTestSuite) Test_Stream_PushesChangesOfTheSettlement() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	resp, body := suite.open(ctx, "/settlements/1/events")
+	defer resp.Body.Close()
+
+	suite.Equal("text/event-stream", resp.Header.Get("Content-Type"))
+	suite.Equal([]any{1, testUserId}, suite.db.Args)
+	suite.hub.Publish(ctx, Change{Settlement: 2, Entity: "settlement", EntityId: 2, Action: "update", Version: 3})
+	suite.hub.Publish(ctx, Change{Settlement: 1, Entity: "survivor", EntityId: 4, Action: "update", Version: 2})
+
+	suite.Equal("event: change\n", suite.line(body), "changes of other settlements should not be streamed")
+	suite.Equal(`data: {"settlement":1,"entity":"survivor","entityId":4,"action":"update","version":2}`+"\n", suite.line(body))
+	suite.Equal("\n", suite.line(body))
+}
+
+func (suite *NotifyApiTestSuite) Test_Stream_SendsHeartbeats() {
+	defer func(d time.Duration) { Heartbeat = d }(Heartbeat)
+	Heartbeat = 10 * time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	resp, body := suite.open(ctx, "/settlements/1/events")
+	defer resp.Body.Close()
+
+	suite.Equal(": heartbeat\n", suite.line(body))
+}
+
+func (suite *NotifyApiTestSuite) Test_Stream_EndsWhenTheHubCloses() {
+	resp, body := suite.open(context.Background(), "/settlements/1/events")
+	defer resp.Body.Close()
+
+	suite.NoError(suite.hub.Close(context.Background()))
+
+	_, err := body.ReadString('\n')
+	suite.Error(err, "the stream should end so that shutdown does not wait for it")
+}
+
+func (suite *NotifyApiTestSuite) Test_Stream_HidesOtherPlayersSettlements() {
+	suite.db.SetRow(&storeMocks.ErrorRow{Error: pgx.ErrNoRows})
+
+	resp, err := http.Get(suite.server.URL + "/settlements/1/events")
+
+	suite.Require().NoError(err)
+	defer resp.Body.Close()
+	suite.Equal(404, resp.StatusCode)
+}
+
+func (suite *NotifyApiTestSuite) Test_Hub_DropsSubscribersThatFallBehind() {
+	slow := suite.hub.Subscribe(1)
+	for i := 0; i <= buffer; i++ {
+		suite.hub.Deliver(Change{Settlement: 1, EntityId: i})
+	}
+
+	received := 0
+	for range slow.C {
+		received++
+	}
+	suite.Equal(buffer, received, "the channel should be closed once its buffer overflows")
+	slow.Close()
+	fresh := suite.hub.Subscribe(1)
+	suite.hub.Deliver(Change{Settlement: 1})
+	suite.Len(fresh.C, 1)
+}
+
+func (suite *NotifyApiTestSuite) Test_Hub_RefusesSubscriptionsOnceClosed() {
+	suite.NoError(suite.hub.Close(context.Background()))
+
+	_, open := <-suite.hub.Subscribe(1).C
+
+	suite.False(open)
+}
+
+// asUser authenticates every request as a user, who is not limited by scopes.
+func asUser(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := auth.WithPrincipal(r.Context(), auth.Principal{Subject: testUserId})
+		next.ServeHTTP(w, r.WithContext(user.WithUser(ctx, user.User{Subject: testUserId})))
+	})
+}
+
+func TestNotifyApiTestSuite(t *testing.T) {
+	suite.Run(t, new(NotifyApiTestSuite))
+}
@@ -0,0 +1,123 @@
+// Package notify tells clients watching a settlement when it or one of its
+// survivors changes, so they can reload what changed instead of polling.
+package notify
+
+import (
+	"context"
+	"sync"
+)
+
+// Change announces that a record of a settlement was created, updated or had a
+// change undone. Version is the record's version after the change, when it
+// still exists.
+type Change struct {
+	Settlement int    `json:"settlement"`
+	Entity     string `json:"entity"`
+	EntityId   int    `json:"entityId"`
+	Action     string `json:"action"`
+	Version    int    `json:"version,omitempty"`
+}
+
+// Publisher announces changes after they are committed. Notifications are best
+// effort: failing to publish one is logged rather than reported, since the
+// change itself succeeded.
+type Publisher interface {
+	Publish(ctx context.Context, c Change)
+}
+
+// buffer is how many changes a subscriber may fall behind before it is
+// dropped.
+const buffer = 16
+
+// Hub fans changes out to the subscribers of their settlement within this
+// process.
+type Hub struct {
+	mu          sync.Mutex
+	subscribers map[int]map[*Subscription]struct{}
+	closed      bool
+}
+
+func NewHub() *Hub {
+	return &Hub{subscribers: map[int]map[*Subscription]struct{}{}}
+}
+
+// Publish delivers c straight to the hub's subscribers, for deployments with a
+// single instance.
+func (h *Hub) Publish(_ context.Context, c Change) {
+	h.Deliver(c)
+}
+
+// Deliver hands c to every subscriber of its settlement. A subscriber that has
+// fallen behind is dropped, closing its channel, so that its client reconnects
+// and reloads rather than silently missing changes.
+func (h *Hub) Deliver(c Change) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for s := range h.subscribers[c.Settlement] {
+		select {
+		case s.c <- c:
+		default:
+			h.remove(s)
+		}
+	}
+}
+
+// Subscribe returns a subscription to the changes of a settlement. Once the hub
+// is closed the subscription's channel is closed straight away.
+func (h *Hub) Subscribe(settlementId int) *Subscription {
+	s := &Subscription{c: make(chan Change, buffer), hub: h, settlement: settlementId}
+	s.C = s.c
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		close(s.c)
+		return s
+	}
+	if h.subscribers[settlementId] == nil {
+		h.subscribers[settlementId] = map[*Subscription]struct{}{}
+	}
+	h.subscribers[settlementId][s] = struct{}{}
+	return s
+}
+
+// Close ends every subscription, so that streams finish before the server
+// waits for in-flight requests during shutdown.
+func (h *Hub) Close(context.Context) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closed = true
+	for _, subscribers := range h.subscribers {
+		for s := range subscribers {
+			h.remove(s)
+		}
+	}
+	return nil
+}
+
+// remove unsubscribes s and closes its channel. The caller holds h.mu.
+func (h *Hub) remove(s *Subscription) {
+	subscribers := h.subscribers[s.settlement]
+	if _, ok := subscribers[s]; !ok {
+		return
+	}
+	delete(subscribers, s)
+	if len(subscribers) == 0 {
+		delete(h.subscribers, s.settlement)
+	}
+	close(s.c)
+}
+
+// Subscription receives the changes of one settlement on C until it is closed.
+type Subscription struct {
+	C          <-chan Change
+	c          chan Change
+	hub        *Hub
+	settlement int
+}
+
+// Close unsubscribes. It is safe to call more than once.
+func (s *Subscription) Close() {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+	s.hub.remove(s)
+}
@@ -0,0 +1,81 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Channel is the Postgres notification channel changes are published on.
+const Channel = "datamonster_changes"
+
+// retryDelay is how long Run waits before listening again after losing its
+// connection.
+const retryDelay = 5 * time.Second
+
+// Postgres publishes changes with NOTIFY so that every instance listening on
+// Channel delivers them to its own hub, including the one that published them.
+type Postgres struct {
+	hub  *Hub
+	pool *pgxpool.Pool
+}
+
+func NewPostgres(hub *Hub, pool *pgxpool.Pool) *Postgres {
+	return &Postgres{hub: hub, pool: pool}
+}
+
+func (p *Postgres) Publish(ctx context.Context, c Change) {
+	payload, err := json.Marshal(c)
+	if err == nil {
+		_, err = p.pool.Exec(ctx, `SELECT pg_notify($1, $2)`, Channel, string(payload))
+	}
+	if err != nil {
+		slog.WarnContext(ctx, "unable to publish change", "settlement", c.Settlement, "error", err)
+	}
+}
+
+// Run delivers the changes published by every instance to the hub until ctx is
+// cancelled, listening again whenever the connection is lost.
+func (p *Postgres) Run(ctx context.Context) error {
+	for {
+		err := p.listen(ctx)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		slog.ErrorContext(ctx, "lost the change notification connection", "error", err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(retryDelay):
+		}
+	}
+}
+
+// listen holds a connection of its own for as long as it listens, and closes it
+// afterwards rather than returning a listening connection to the pool.
+func (p *Postgres) listen(ctx context.Context) error {
+	pooled, err := p.pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	conn := pooled.Hijack()
+	defer conn.Close(context.Background())
+	if _, err := conn.Exec(ctx, `LISTEN `+Channel); err != nil {
+		return err
+	}
+	for {
+		n, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+		var c Change
+		if err := json.Unmarshal([]byte(n.Payload), &c); err != nil {
+			slog.WarnContext(ctx, "ignoring malformed change notification", "error", err)
+			continue
+		}
+		p.hub.Deliver(c)
+	}
+}
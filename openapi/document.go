@@ -0,0 +1,250 @@
+package openapi
+
+import (
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/failuretoload/datamonster/web"
+)
+
+const Version = "3.0.3"
+
+type Document struct {
+	OpenAPI    string              `json:"openapi"`
+	Info       Info                `json:"info"`
+	Paths      map[string]PathItem `json:"paths"`
+	Components Components          `json:"components"`
+	prefix     string
+	idPrefix   string
+}
+
+type Info struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+type Components struct {
+	Schemas         map[string]*Schema        `json:"schemas"`
+	SecuritySchemes map[string]SecurityScheme `json:"securitySchemes,omitempty"`
+}
+
+// BearerAuth names the security scheme protected operations require.
+const BearerAuth = "bearerAuth"
+
+type SecurityScheme struct {
+	Type         string `json:"type"`
+	Scheme       string `json:"scheme,omitempty"`
+	BearerFormat string `json:"bearerFormat,omitempty"`
+	Description  string `json:"description,omitempty"`
+}
+
+// SecurityRequirement maps scheme names to the scopes they need. Bearer
+// schemes never list scopes.
+type SecurityRequirement map[string][]string
+
+// PathItem maps lower case HTTP methods to their operation.
+type PathItem map[string]*Operation
+
+type Operation struct {
+	OperationID string                `json:"operationId,omitempty"`
+	Summary     string                `json:"summary,omitempty"`
+	Tags        []string              `json:"tags,omitempty"`
+	Parameters  []Parameter           `json:"parameters,omitempty"`
+	RequestBody *RequestBody          `json:"requestBody,omitempty"`
+	Responses   map[string]Response   `json:"responses"`
+	Security    []SecurityRequirement `json:"security,omitempty"`
+	// RequiredScopes are the scopes a machine client token needs; users are
+	// not restricted by them.
+	RequiredScopes []string `json:"x-required-scopes,omitempty"`
+}
+
+type Parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required"`
+	Schema      *Schema `json:"schema"`
+}
+
+type RequestBody struct {
+	Required bool                 `json:"required"`
+	Content  map[string]MediaType `json:"content"`
+}
+
+type Response struct {
+	Description string               `json:"description"`
+	Content     map[string]MediaType `json:"content,omitempty"`
+}
+
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+// Route describes an endpoint in terms of the Go types it exchanges. Request and
+// Response hold zero values of the body types and are left nil when there is no body.
+// ResponseType is the media type of the response, application/json by default.
+// Table marks lists that can also be written as CSV or XLSX, see
+// web.NegotiateList; their format query parameter is documented with the names
+// of web.ListFormats. Routes require a bearer token unless Public is set.
+type Route struct {
+	Method       string
+	Path         string
+	ID           string
+	Summary      string
+	Tags         []string
+	Params       []Parameter
+	Request      any
+	Response     any
+	ResponseType string
+	Table        bool
+	Status       int
+	Public       bool
+	Scopes       []string
+}
+
+func New(title, version string) *Document {
+	return &Document{
+		OpenAPI: Version,
+		Info:    Info{Title: title, Version: version},
+		Paths:   map[string]PathItem{},
+		Components: Components{
+			Schemas: map[string]*Schema{},
+			SecuritySchemes: map[string]SecurityScheme{
+				BearerAuth: {
+					Type:         "http",
+					Scheme:       "bearer",
+					BearerFormat: "JWT",
+					Description:  "A user token, or a machine client token limited to the operation's x-required-scopes.",
+				},
+			},
+		},
+	}
+}
+
+var pathParam = regexp.MustCompile(`\{([^}:]+)(:[^}]*)?\}`)
+
+// Group returns a view of the document that prefixes every added path, used to
+// describe routes mounted below a common path such as an API version. Operation
+// ids are prefixed as well so they stay unique across groups.
+func (d *Document) Group(prefix string) *Document {
+	group := *d
+	group.prefix = d.prefix + prefix
+	group.idPrefix = d.idPrefix + strings.ReplaceAll(strings.Trim(prefix, "/"), "/", "")
+	return &group
+}
+
+// Add records a route. Path parameters in chi syntax are converted to OpenAPI
+// syntax and documented as integers unless r.Params already declares them.
+func (d *Document) Add(r Route) {
+	path := normalizePath(d.prefix + r.Path)
+	op := &Operation{
+		OperationID: d.operationID(r.ID),
+		Summary:     r.Summary,
+		Tags:        r.Tags,
+		Responses:   map[string]Response{},
+	}
+	if !r.Public {
+		op.Security = []SecurityRequirement{{BearerAuth: []string{}}}
+		op.RequiredScopes = r.Scopes
+	}
+
+	declared := map[string]bool{}
+	for _, p := range r.Params {
+		declared[p.In+":"+p.Name] = true
+	}
+	for _, match := range pathParam.FindAllStringSubmatch(path, -1) {
+		if !declared["path:"+match[1]] {
+			op.Parameters = append(op.Parameters, Parameter{Name: match[1], In: "path", Required: true, Schema: &Schema{Type: "integer"}})
+		}
+	}
+	for _, p := range r.Params {
+		if r.Table && p.In == "query" && p.Name == web.FormatParam {
+			p.Schema = &Schema{Type: "string", Enum: web.ListFormatNames()}
+		}
+		op.Parameters = append(op.Parameters, p)
+	}
+
+	if r.Request != nil {
+		op.RequestBody = &RequestBody{
+			Required: true,
+			Content:  map[string]MediaType{"application/json": {Schema: d.SchemaFor(r.Request)}},
+		}
+		op.Responses[strconv.Itoa(http.StatusUnprocessableEntity)] = Response{
+			Description: "The request contains invalid fields",
+			Content:     map[string]MediaType{web.ProblemContentType: {Schema: d.SchemaFor(web.ValidationProblem{})}},
+		}
+	}
+
+	status := r.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	success := Response{Description: http.StatusText(status)}
+	if r.Response != nil {
+		mediaType := r.ResponseType
+		if mediaType == "" {
+			mediaType = "application/json"
+		}
+		success.Content = map[string]MediaType{mediaType: {Schema: d.SchemaFor(r.Response)}}
+		if r.Table {
+			success.Content[web.MediaCSV] = MediaType{Schema: &Schema{Type: "string"}}
+			success.Content[web.MediaXLSX] = MediaType{Schema: &Schema{Type: "string", Format: "binary"}}
+		}
+	}
+	op.Responses[strconv.Itoa(status)] = success
+	op.Responses[strconv.Itoa(http.StatusTooManyRequests)] = Response{
+		Description: "The caller's rate limit is exhausted; retry after the number of seconds in the Retry-After header",
+		Content:     map[string]MediaType{web.ProblemContentType: {Schema: d.SchemaFor(web.Problem{})}},
+	}
+	op.Responses["default"] = Response{
+		Description: "An RFC 7807 problem document",
+		Content:     map[string]MediaType{web.ProblemContentType: {Schema: d.SchemaFor(web.Problem{})}},
+	}
+
+	item, ok := d.Paths[path]
+	if !ok {
+		item = PathItem{}
+		d.Paths[path] = item
+	}
+	item[strings.ToLower(r.Method)] = op
+}
+
+// Has reports whether an operation is documented for the method and chi route pattern.
+func (d *Document) Has(method, path string) bool {
+	item, ok := d.Paths[normalizePath(d.prefix+path)]
+	if !ok {
+		return false
+	}
+	_, ok = item[strings.ToLower(method)]
+	return ok
+}
+
+// Operations lists every documented operation as "METHOD /path".
+func (d *Document) Operations() []string {
+	ops := []string{}
+	for path, item := range d.Paths {
+		for method := range item {
+			ops = append(ops, strings.ToUpper(method)+" "+path)
+		}
+	}
+	sort.Strings(ops)
+	return ops
+}
+
+func (d *Document) operationID(id string) string {
+	if id == "" || d.idPrefix == "" {
+		return id
+	}
+	return d.idPrefix + strings.ToUpper(id[:1]) + id[1:]
+}
+
+func normalizePath(path string) string {
+	path = pathParam.ReplaceAllString(path, "{$1}")
+	if len(path) > 1 {
+		path = strings.TrimSuffix(path, "/")
+	}
+	return path
+}
@@ -0,0 +1,37 @@
+package openapi
+
+import (
+	"embed"
+	"encoding/json"
+	"io/fs"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+)
+
+//go:embed static
+var static embed.FS
+
+// RegisterRoutes serves the document at /openapi.json and a browsable reference
+// page under /docs. The page is served from this binary so it satisfies the
+// same origin content security policy.
+func RegisterRoutes(r chi.Router, d *Document) {
+	spec, err := json.Marshal(d)
+	if err != nil {
+		panic(err)
+	}
+	r.Get("/openapi.json", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(spec)
+	})
+
+	assets, err := fs.Sub(static, "static")
+	if err != nil {
+		panic(err)
+	}
+	files := http.StripPrefix("/docs", http.FileServer(http.FS(assets)))
+	r.Get("/docs", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/docs/", http.StatusMovedPermanently)
+	})
+	r.Get("/docs/*", files.ServeHTTP)
+}
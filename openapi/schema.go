@@ -0,0 +1,168 @@
+package openapi
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Nullable             bool               `json:"nullable,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	Enum                 []string           `json:"enum,omitempty"`
+	Minimum              *int               `json:"minimum,omitempty"`
+	Maximum              *int               `json:"maximum,omitempty"`
+	MinLength            *int               `json:"minLength,omitempty"`
+	MaxLength            *int               `json:"maxLength,omitempty"`
+	MinItems             *int               `json:"minItems,omitempty"`
+	MaxItems             *int               `json:"maxItems,omitempty"`
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// SchemaFor describes the JSON encoding of v. Named struct types are added to the
+// document's components and referenced, so shared DTOs are only described once.
+// Constraints are read from the same validate tags web.Validate enforces.
+func (d *Document) SchemaFor(v any) *Schema {
+	return d.schemaForType(reflect.TypeOf(v))
+}
+
+func (d *Document) schemaForType(t reflect.Type) *Schema {
+	switch t.Kind() {
+	case reflect.Pointer:
+		s := d.schemaForType(t.Elem())
+		if s.Ref == "" {
+			s.Nullable = true
+		}
+		return s
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", Format: "byte"}
+		}
+		return &Schema{Type: "array", Items: d.schemaForType(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: d.schemaForType(t.Elem())}
+	case reflect.Struct:
+		if t == timeType {
+			return &Schema{Type: "string", Format: "date-time"}
+		}
+		if t.Name() == "" {
+			return d.objectSchema(t)
+		}
+		if _, ok := d.Components.Schemas[t.Name()]; !ok {
+			// Reserve the name first so self referencing types terminate.
+			d.Components.Schemas[t.Name()] = &Schema{}
+			*d.Components.Schemas[t.Name()] = *d.objectSchema(t)
+		}
+		return &Schema{Ref: "#/components/schemas/" + t.Name()}
+	default:
+		return &Schema{}
+	}
+}
+
+func (d *Document) objectSchema(t reflect.Type) *Schema {
+	s := &Schema{Type: "object", Properties: map[string]*Schema{}}
+	d.addFields(s, t)
+	return s
+}
+
+func (d *Document) addFields(s *Schema, t reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		if field.Anonymous && tag == "" && field.Type.Kind() == reflect.Struct {
+			d.addFields(s, field.Type)
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		if name == "" {
+			name = field.Name
+		}
+		prop := d.schemaForType(field.Type)
+		if prop.Ref == "" {
+			applyRules(prop, field.Tag.Get("validate"))
+		}
+		s.Properties[name] = prop
+		if strings.Contains(","+field.Tag.Get("validate")+",", ",required,") {
+			s.Required = append(s.Required, name)
+		}
+	}
+}
+
+func applyRules(s *Schema, rules string) {
+	if rules == "" {
+		return
+	}
+	for _, rule := range strings.Split(rules, ",") {
+		name, arg, _ := strings.Cut(rule, "=")
+		switch name {
+		case "min", "max":
+			bound, err := strconv.Atoi(arg)
+			if err != nil {
+				continue
+			}
+			switch {
+			case s.Type == "array" && name == "min":
+				s.MinItems = &bound
+			case s.Type == "array":
+				s.MaxItems = &bound
+			case s.Type == "string" && name == "min":
+				s.MinLength = &bound
+			case s.Type == "string":
+				s.MaxLength = &bound
+			case name == "min":
+				s.Minimum = &bound
+			default:
+				s.Maximum = &bound
+			}
+		case "oneof":
+			if s.Type == "array" {
+				s.Items.Enum = strings.Fields(arg)
+				continue
+			}
+			s.Enum = strings.Fields(arg)
+		}
+	}
+}
+
+// QueryParams describes the `query` tagged fields of a struct decoded with
+// web.DecodeQuery as query parameters. Lists are documented as comma separated.
+func QueryParams(v any) []Parameter {
+	d := New("", "")
+	t := reflect.TypeOf(v)
+	params := []Parameter{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := field.Tag.Get("query")
+		if name == "" {
+			continue
+		}
+		schema := d.schemaForType(field.Type)
+		schema.Nullable = false
+		applyRules(schema, field.Tag.Get("validate"))
+		params = append(params, Parameter{Name: name, In: "query", Schema: schema})
+	}
+	return params
+}
@@ -0,0 +1,72 @@
+(function () {
+  "use strict";
+
+  function resolve(spec, schema) {
+    if (schema && schema.$ref) {
+      return resolve(spec, spec.components.schemas[schema.$ref.split("/").pop()]);
+    }
+    if (schema && schema.type === "array") {
+      return { type: "array", items: resolve(spec, schema.items) };
+    }
+    if (schema && schema.properties) {
+      var props = {};
+      Object.keys(schema.properties).forEach(function (name) {
+        props[name] = resolve(spec, schema.properties[name]);
+      });
+      return Object.assign({}, schema, { properties: props });
+    }
+    return schema;
+  }
+
+  function section(title, value) {
+    var wrapper = document.createElement("div");
+    var heading = document.createElement("h4");
+    heading.textContent = title;
+    var pre = document.createElement("pre");
+    pre.textContent = JSON.stringify(value, null, 2);
+    wrapper.appendChild(heading);
+    wrapper.appendChild(pre);
+    return wrapper;
+  }
+
+  function render(spec) {
+    document.getElementById("title").textContent = spec.info.title + " " + spec.info.version;
+    var root = document.getElementById("operations");
+    Object.keys(spec.paths).sort().forEach(function (path) {
+      Object.keys(spec.paths[path]).forEach(function (method) {
+        var op = spec.paths[path][method];
+        var details = document.createElement("details");
+        var summary = document.createElement("summary");
+        var verb = document.createElement("span");
+        verb.className = "method " + method;
+        verb.textContent = method;
+        summary.appendChild(verb);
+        summary.appendChild(document.createTextNode(" " + path + (op.summary ? " - " + op.summary : "")));
+        details.appendChild(summary);
+
+        var body = document.createElement("div");
+        body.className = "body";
+        if (op.security) {
+          body.appendChild(section("Authentication", { bearer: true, machineClientScopes: op["x-required-scopes"] || [] }));
+        }
+        if (op.parameters) {
+          body.appendChild(section("Parameters", op.parameters));
+        }
+        if (op.requestBody) {
+          body.appendChild(section("Request body", resolve(spec, op.requestBody.content["application/json"].schema)));
+        }
+        Object.keys(op.responses).forEach(function (status) {
+          var content = op.responses[status].content || {};
+          var media = Object.keys(content)[0];
+          body.appendChild(section(status + " " + op.responses[status].description, media ? resolve(spec, content[media].schema) : null));
+        });
+        details.appendChild(body);
+        root.appendChild(details);
+      });
+    });
+  }
+
+  fetch("../openapi.json")
+    .then(function (res) { return res.json(); })
+    .then(render);
+})();
@@ -0,0 +1,55 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Memory keeps buckets in process. Each instance enforces budgets on its own,
+// so use Postgres when several instances serve the same clients.
+type Memory struct {
+	mu        sync.Mutex
+	buckets   map[string]bucket
+	now       func() time.Time
+	lastSweep time.Time
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	full    time.Time
+}
+
+func NewMemory() *Memory {
+	return &Memory{buckets: map[string]bucket{}, now: time.Now}
+}
+
+func (m *Memory) Take(_ context.Context, key string, b Budget) (Result, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := m.now()
+	m.sweep(now)
+
+	tokens := float64(b.Limit)
+	if existing, ok := m.buckets[key]; ok {
+		tokens = refill(existing.tokens, now.Sub(existing.updated), b)
+	}
+	tokens, result := take(tokens, b)
+	m.buckets[key] = bucket{tokens: tokens, updated: now, full: now.Add(result.Reset)}
+	return result, nil
+}
+
+// sweep forgets buckets that have refilled completely, at most once a minute,
+// since they are indistinguishable from new ones.
+func (m *Memory) sweep(now time.Time) {
+	if now.Sub(m.lastSweep) < time.Minute {
+		return
+	}
+	m.lastSweep = now
+	for key, b := range m.buckets {
+		if !now.Before(b.full) {
+			delete(m.buckets, key)
+		}
+	}
+}
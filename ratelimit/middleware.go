@@ -0,0 +1,80 @@
+package ratelimit
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/failuretoload/datamonster/auth"
+	"github.com/failuretoload/datamonster/logging"
+	"github.com/failuretoload/datamonster/web"
+)
+
+type ctxStoreKey struct{}
+
+// Install makes store enforce the budgets declared with Limit on every route
+// below it. Without it, Limit lets every request through.
+func Install(store Store) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), ctxStoreKey{}, store)))
+		})
+	}
+}
+
+// Limit spends one request of budget from the caller's bucket for name,
+// rejecting the request with 429 once it is empty. Authenticated callers are
+// limited by user id and anonymous ones by IP address. Routes sharing a name
+// share a bucket.
+//
+// The RateLimit-* headers describe the innermost budget applied to a request.
+// If the store fails the request is let through, so that an unavailable
+// database does not also take down rate limited routes that do not need it.
+func Limit(name string, budget Budget) func(http.Handler) http.Handler {
+	policy := budget.String()
+	limit := strconv.Itoa(budget.Limit)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			store, ok := r.Context().Value(ctxStoreKey{}).(Store)
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+			result, err := store.Take(r.Context(), name+":"+caller(r), budget)
+			if err != nil {
+				logging.FromContext(r.Context()).Error("unable to apply rate limit", "budget", name, "error", err)
+				next.ServeHTTP(w, r)
+				return
+			}
+			headers := w.Header()
+			headers.Set("RateLimit-Policy", policy)
+			headers.Set("RateLimit-Limit", limit)
+			headers.Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+			headers.Set("RateLimit-Reset", strconv.Itoa(int(result.Reset.Seconds())))
+			if !result.Allowed {
+				headers.Set("Retry-After", strconv.Itoa(int(result.RetryAfter.Seconds())))
+				logging.FromContext(r.Context()).Warn("rate limit exceeded", "budget", name)
+				web.WriteProblem(w, r, http.StatusTooManyRequests, "rate limit exceeded, retry after "+headers.Get("Retry-After")+" seconds")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// caller identifies whose bucket a request is taken from. RemoteAddr is the
+// client address once middleware.RealIP has run.
+func caller(r *http.Request) string {
+	if principal, ok := auth.PrincipalFrom(r.Context()); ok {
+		return "user:" + principal.Subject
+	}
+	return "ip:" + host(r.RemoteAddr)
+}
+
+func host(addr string) string {
+	if h, _, err := net.SplitHostPort(addr); err == nil {
+		return h
+	}
+	return addr
+}
@@ -0,0 +1,67 @@
+package ratelimit
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/failuretoload/datamonster/store"
+)
+
+// Postgres keeps buckets in the database so that every instance enforces the
+// same budgets.
+type Postgres struct {
+	pool store.Connection
+}
+
+func NewPostgres(conn store.Connection) *Postgres {
+	return &Postgres{pool: conn}
+}
+
+// take refills and spends from the bucket in a single statement so concurrent
+// requests for the same key cannot both spend the last token.
+const takeQuery = `INSERT INTO campaign.rate_limit AS b (key, tokens, allowed, updated_at)
+	VALUES ($1, $2 - 1, true, now())
+	ON CONFLICT (key) DO UPDATE SET
+		allowed = LEAST($2, b.tokens + EXTRACT(EPOCH FROM now() - b.updated_at) * $3) >= 1,
+		tokens = LEAST($2, b.tokens + EXTRACT(EPOCH FROM now() - b.updated_at) * $3)
+			- CASE WHEN LEAST($2, b.tokens + EXTRACT(EPOCH FROM now() - b.updated_at) * $3) >= 1 THEN 1 ELSE 0 END,
+		updated_at = now()
+	RETURNING allowed, tokens`
+
+func (p *Postgres) Take(ctx context.Context, key string, b Budget) (Result, error) {
+	var allowed bool
+	var tokens float64
+	err := p.pool.QueryRow(ctx, takeQuery, key, float64(b.Limit), b.rate()).Scan(&allowed, &tokens)
+	if err != nil {
+		return Result{}, err
+	}
+	return resultFor(allowed, tokens, b), nil
+}
+
+// pruneAge is how long a bucket is kept after its last use. It exceeds the
+// period of every budget, so pruned buckets have refilled anyway.
+const pruneAge = 24 * time.Hour
+
+// Run prunes unused buckets every interval until ctx is cancelled.
+func (p *Postgres) Run(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			if err := p.Prune(ctx, pruneAge); err != nil && ctx.Err() == nil {
+				slog.ErrorContext(ctx, "unable to prune rate limit buckets", "error", err)
+			}
+		}
+	}
+}
+
+// Prune deletes buckets that have not been used for olderThan. Budgets longer
+// than olderThan are reset when their bucket is pruned.
+func (p *Postgres) Prune(ctx context.Context, olderThan time.Duration) error {
+	_, err := p.pool.Exec(ctx, `DELETE FROM campaign.rate_limit WHERE updated_at < now() - make_interval(secs => $1)`, olderThan.Seconds())
+	return err
+}
@@ -0,0 +1,82 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+)
+
+// Budget allows Limit requests per Period. Unused requests accumulate up to
+// Limit, so a client may burst through its whole budget at once and then has to
+// wait for it to refill.
+type Budget struct {
+	Limit  int
+	Period time.Duration
+}
+
+// PerMinute returns a budget of n requests a minute.
+func PerMinute(n int) Budget {
+	return Budget{Limit: n, Period: time.Minute}
+}
+
+// PerHour returns a budget of n requests an hour.
+func PerHour(n int) Budget {
+	return Budget{Limit: n, Period: time.Hour}
+}
+
+// String formats the budget as a RateLimit-Policy quota, e.g. 10;w=60.
+func (b Budget) String() string {
+	return fmt.Sprintf("%d;w=%d", b.Limit, int(b.Period.Seconds()))
+}
+
+// rate is the number of requests regained each second.
+func (b Budget) rate() float64 {
+	return float64(b.Limit) / b.Period.Seconds()
+}
+
+// Result is the outcome of taking a request from a bucket.
+type Result struct {
+	Allowed   bool
+	Remaining int
+	// Reset is how long until the bucket is full again.
+	Reset time.Duration
+	// RetryAfter is how long until the next request would be allowed.
+	RetryAfter time.Duration
+}
+
+// Store holds one token bucket per key.
+type Store interface {
+	Take(ctx context.Context, key string, b Budget) (Result, error)
+}
+
+// refill returns the tokens in a bucket that held tokens elapsed ago.
+func refill(tokens float64, elapsed time.Duration, b Budget) float64 {
+	return math.Min(float64(b.Limit), tokens+elapsed.Seconds()*b.rate())
+}
+
+// take spends a token from a bucket holding tokens if one is available and
+// returns the tokens left.
+func take(tokens float64, b Budget) (float64, Result) {
+	allowed := tokens >= 1
+	if allowed {
+		tokens--
+	}
+	return tokens, resultFor(allowed, tokens, b)
+}
+
+func resultFor(allowed bool, tokens float64, b Budget) Result {
+	r := Result{
+		Allowed:   allowed,
+		Remaining: int(math.Floor(tokens)),
+		Reset:     seconds((float64(b.Limit) - tokens) / b.rate()),
+	}
+	if tokens < 1 {
+		r.RetryAfter = seconds((1 - tokens) / b.rate())
+	}
+	return r
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(math.Ceil(s)) * time.Second
+}
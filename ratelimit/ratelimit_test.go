@@ -0,0 +1,130 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/failuretoload/datamonster/auth"
+	storeMocks "github.com/failuretoload/datamonster/store/mocks"
+	"github.com/stretchr/testify/suite"
+)
+
+type RateLimitTestSuite struct {
+	suite.Suite
+	store *Memory
+	now   time.Time
+}
+
+func (suite *RateLimitTestSuite) SetupTest() {
+	suite.now = time.Date(2026, time.October, 19, 12, 0, 0, 0, time.UTC)
+	suite.store = NewMemory()
+	suite.store.now = func() time.Time { return suite.now }
+}
+
+func (suite *RateLimitTestSuite) serve(handler http.Handler, principal *auth.Principal, remoteAddr string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", "/v2/settlements", nil)
+	req.RemoteAddr = remoteAddr
+	if principal != nil {
+		req = req.WithContext(auth.WithPrincipal(req.Context(), *principal))
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	return w
+}
+
+func (suite *RateLimitTestSuite) Test_Memory_RefillsOverThePeriod() {
+	budget := PerMinute(2)
+	take := func() Result {
+		r, err := suite.store.Take(context.Background(), "key", budget)
+		suite.Require().NoError(err)
+		return r
+	}
+
+	suite.Equal(Result{Allowed: true, Remaining: 1, Reset: 30 * time.Second}, take())
+	suite.Equal(Result{Allowed: true, Remaining: 0, Reset: time.Minute, RetryAfter: 30 * time.Second}, take())
+	suite.False(take().Allowed, "the bucket should be empty")
+
+	suite.now = suite.now.Add(30 * time.Second)
+	suite.True(take().Allowed, "a request should be regained every 30 seconds")
+	suite.False(take().Allowed)
+
+	suite.now = suite.now.Add(time.Hour)
+	suite.Equal(1, take().Remaining, "unused requests should accumulate only up to the limit")
+}
+
+func (suite *RateLimitTestSuite) Test_Limit_RejectsCallersOverBudget() {
+	handler := Install(suite.store)(Limit("settlements.create", PerMinute(1))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	})))
+	user := &auth.Principal{Subject: "userId"}
+
+	first := suite.serve(handler, user, "192.0.2.1:1234")
+	second := suite.serve(handler, user, "192.0.2.2:1234")
+
+	suite.Equal(http.StatusCreated, first.Code)
+	suite.Equal("1;w=60", first.Header().Get("RateLimit-Policy"))
+	suite.Equal("1", first.Header().Get("RateLimit-Limit"))
+	suite.Equal("0", first.Header().Get("RateLimit-Remaining"))
+	suite.Equal("60", first.Header().Get("RateLimit-Reset"))
+	suite.Equal(http.StatusTooManyRequests, second.Code, "users should be limited across addresses")
+	suite.Equal("60", second.Header().Get("Retry-After"))
+	suite.Equal("application/problem+json", second.Header().Get("Content-Type"))
+}
+
+func (suite *RateLimitTestSuite) Test_Limit_KeysCallersSeparately() {
+	handler := Install(suite.store)(Limit("settlements.create", PerMinute(1))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+
+	suite.Equal(200, suite.serve(handler, &auth.Principal{Subject: "one"}, "192.0.2.1:1234").Code)
+	suite.Equal(200, suite.serve(handler, &auth.Principal{Subject: "two"}, "192.0.2.1:1234").Code, "users should have their own budgets")
+	suite.Equal(200, suite.serve(handler, nil, "192.0.2.1:1234").Code, "anonymous callers should be limited by address")
+	suite.Equal(429, suite.serve(handler, nil, "192.0.2.1:5678").Code, "ports should not split an address's budget")
+	suite.Equal(200, suite.serve(handler, nil, "192.0.2.2:1234").Code)
+}
+
+func (suite *RateLimitTestSuite) Test_Limit_PassesThroughWithoutAStore() {
+	handler := Limit("settlements.create", PerMinute(1))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	suite.serve(handler, nil, "192.0.2.1:1234")
+
+	suite.Equal(200, suite.serve(handler, nil, "192.0.2.1:1234").Code)
+}
+
+func (suite *RateLimitTestSuite) Test_Limit_FailsOpenWhenTheStoreFails() {
+	db := &storeMocks.MockConnection{}
+	db.SetRow(&storeMocks.ErrorRow{Error: errors.New("connection refused")})
+	handler := Install(NewPostgres(db))(Limit("settlements.create", PerMinute(1))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+
+	w := suite.serve(handler, nil, "192.0.2.1:1234")
+
+	suite.Equal(200, w.Code)
+	suite.Empty(w.Header().Get("RateLimit-Limit"))
+}
+
+func (suite *RateLimitTestSuite) Test_Postgres_ReportsTheBucketState() {
+	db := &storeMocks.MockConnection{}
+	db.SetRow(&BucketRow{Allowed: false, Tokens: 0.5})
+
+	result, err := NewPostgres(db).Take(context.Background(), "key", PerMinute(10))
+
+	suite.NoError(err)
+	suite.Equal(Result{Allowed: false, Remaining: 0, Reset: 57 * time.Second, RetryAfter: 3 * time.Second}, result)
+}
+
+func TestRateLimitTestSuite(t *testing.T) {
+	suite.Run(t, new(RateLimitTestSuite))
+}
+
+type BucketRow struct {
+	Allowed bool
+	Tokens  float64
+}
+
+func (b *BucketRow) Scan(dest ...any) error {
+	*dest[0].(*bool) = b.Allowed
+	*dest[1].(*float64) = b.Tokens
+	return nil
+}
@@ -2,13 +2,16 @@ package server
 
 import (
 	"context"
-	"fmt"
-	"log"
+	"errors"
+	"log/slog"
 	"net/http"
-	"time"
 
-	"github.com/MicahParks/keyfunc/v3"
-	"github.com/failuretoload/datamonster/helpers"
+	"github.com/failuretoload/datamonster/auth"
+	"github.com/failuretoload/datamonster/config"
+	"github.com/failuretoload/datamonster/logging"
+	"github.com/failuretoload/datamonster/metrics"
+	"github.com/failuretoload/datamonster/tracing"
+	"github.com/failuretoload/datamonster/web"
 	"github.com/go-chi/cors"
 
 	"github.com/unrolled/secure"
@@ -17,64 +20,82 @@ import (
 	"github.com/go-chi/chi/v5/middleware"
 )
 
+// Server identifies the caller of every request that carries a bearer token.
+// Routes are public unless registered in a group using auth.Required or
+// auth.RequireScope.
 type Server struct {
-	Mux *chi.Mux
-	kf  keyfunc.Keyfunc
+	Mux  *chi.Mux
+	cfg  config.Config
+	http *http.Server
 }
 
-func NewServer(ctx context.Context) Server {
+func NewServer(cfg config.Config, authenticator auth.Authenticator) Server {
+	router := newRouter(cfg)
+	router.Use(auth.Authenticate(authenticator))
+	return Server{
+		Mux:  router,
+		cfg:  cfg,
+		http: &http.Server{Addr: cfg.Server.Addr, ReadHeaderTimeout: cfg.Server.ReadHeaderTimeout.Duration},
+	}
+}
+
+func newRouter(cfg config.Config) *chi.Mux {
 	router := chi.NewRouter()
 	router.Use(middleware.RequestID)
 	router.Use(middleware.RealIP)
-	router.Use(middleware.Logger)
+	router.Use(logging.Middleware(slog.Default()))
+	router.Use(metrics.Middleware)
+	router.Use(tracing.Middleware)
 	router.Use(middleware.Recoverer)
-	router.Use(middleware.URLFormat)
-	router.Use(middleware.Timeout(10 * time.Second))
+	router.Use(web.Timeout(cfg.Server.RequestTimeout.Duration))
 
-	secureMiddleware := secure.New(SecureOptions())
+	secureMiddleware := secure.New(SecureOptions(cfg.Security))
 	router.Use(secureMiddleware.Handler)
 	router.Use(HandleCacheControl)
+	return router
+}
 
-	keyfunc, err := GetKeyFunc(ctx)
-	if err != nil {
-		panic(fmt.Errorf("unable to create keyfunc %w", err))
-	}
-	return Server{
-		Mux: router,
-		kf:  keyfunc,
+// Start serves requests until Shutdown is called.
+func (s Server) Start() error {
+	slog.Info("starting server", "addr", s.cfg.Server.Addr)
+	s.http.Handler = finalHandler(s.cfg, s.Mux)
+	err := s.http.ListenAndServe()
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
 	}
+	return err
 }
 
-func (s Server) Run() {
-	log.Default().Println("Starting server on port 8080")
-	err := http.ListenAndServe(":8080", finalHandler(ValidateJWTNew(s.kf, s.Mux)))
-	if err != nil {
-		log.Default().Fatal(err)
-	}
+// Shutdown stops accepting connections and waits for in-flight requests to
+// finish or for ctx to expire, whichever comes first.
+func (s Server) Shutdown(ctx context.Context) error {
+	return s.http.Shutdown(ctx)
 }
 
-func finalHandler(next http.Handler) http.Handler {
-	secOptionsHandler := secure.New(SecureOptions()).Handler
-	corsHandler := CorsHandler()
+func finalHandler(cfg config.Config, next http.Handler) http.Handler {
+	secOptionsHandler := secure.New(SecureOptions(cfg.Security)).Handler
+	corsHandler := CorsHandler(cfg.CORS)
 	return corsHandler(
 		secOptionsHandler(
 			HandleCacheControl(next)))
 }
 
-func SecureOptions() secure.Options {
+func SecureOptions(cfg config.Security) secure.Options {
 	return secure.Options{
-		STSSeconds:            31536000,
-		STSIncludeSubdomains:  true,
-		STSPreload:            true,
-		FrameDeny:             true,
+		STSSeconds:            cfg.STSSeconds,
+		STSIncludeSubdomains:  cfg.STSIncludeSubdomains,
+		STSPreload:            cfg.STSPreload,
+		FrameDeny:             cfg.FrameDeny,
 		ForceSTSHeader:        true,
 		ContentTypeNosniff:    true,
 		BrowserXssFilter:      true,
 		CustomBrowserXssValue: "0",
-		ContentSecurityPolicy: "default-src 'self', frame-ancestors 'none'",
+		ContentSecurityPolicy: cfg.ContentSecurityPolicy,
 	}
 }
 
+// HandleCacheControl forbids caching responses. Handlers of versioned resources
+// replace the policy with web.SetETag so clients can revalidate them instead.
 func HandleCacheControl(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
 		headers := rw.Header()
@@ -85,14 +106,17 @@ func HandleCacheControl(next http.Handler) http.Handler {
 	})
 }
 
-func CorsHandler() func(http.Handler) http.Handler {
-	client := helpers.SafeGetEnv("WEB_CLIENT")
+// exposedHeaders are response headers browser clients may read.
+var exposedHeaders = []string{"ETag", "Link", "RateLimit-Policy", "RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "Retry-After"}
+
+func CorsHandler(cfg config.CORS) func(http.Handler) http.Handler {
 	c := cors.New(cors.Options{
-		AllowedOrigins:   []string{client},
-		AllowedMethods:   []string{"HEAD", "GET", "POST", "OPTIONS"},
-		AllowedHeaders:   []string{"Origin", "Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
-		AllowCredentials: true,
-		MaxAge:           3599, // Maximum value not ignored by any of major browsers
+		AllowedOrigins:   cfg.AllowedOrigins,
+		AllowedMethods:   cfg.AllowedMethods,
+		AllowedHeaders:   cfg.AllowedHeaders,
+		AllowCredentials: cfg.AllowCredentials,
+		MaxAge:           cfg.MaxAge,
+		ExposedHeaders:   exposedHeaders,
 	})
 	return c.Handler
 }
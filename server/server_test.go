@@ -0,0 +1,89 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/failuretoload/datamonster/auth"
+	"github.com/failuretoload/datamonster/config"
+	"github.com/failuretoload/datamonster/web"
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/suite"
+)
+
+type ServerTestSuite struct {
+	suite.Suite
+	target Server
+}
+
+func (suite *ServerTestSuite) SetupTest() {
+	issuer, err := auth.NewDevIssuer("")
+	suite.Require().NoError(err)
+	cfg := config.Defaults()
+	cfg.Auth.Provider = auth.ProviderDev
+	suite.target = NewServer(cfg, auth.NewJWTVerifier(issuer.Keys(), auth.JWTOptionsFor(cfg.Auth)))
+	suite.target.Mux.Get("/openapi.json", func(w http.ResponseWriter, r *http.Request) {})
+}
+
+func (suite *ServerTestSuite) Test_PublicRoutes_AreServedAnonymously() {
+	w := httptest.NewRecorder()
+
+	suite.target.Mux.ServeHTTP(w, httptest.NewRequest("GET", "/openapi.json", nil))
+
+	suite.Equal(http.StatusOK, w.Code, "routes outside protected groups should not need a token")
+}
+
+func (suite *ServerTestSuite) Test_PublicRoutes_RejectInvalidTokens() {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/openapi.json", nil)
+	req.Header.Set("Authorization", "Bearer not-a-jwt")
+
+	suite.target.Mux.ServeHTTP(w, req)
+
+	suite.Equal(http.StatusUnauthorized, w.Code, "a token that is present must be valid")
+}
+
+func (suite *ServerTestSuite) Test_Timeout_SparesOnlyUnboundedRoutes() {
+	slow := func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(5 * time.Millisecond)
+		if r.Context().Err() != nil {
+			w.WriteHeader(http.StatusGatewayTimeout)
+		}
+	}
+	router := chi.NewRouter()
+	router.Use(web.Timeout(time.Millisecond))
+	router.With(web.Unbounded).Get("/settlements/{id}/events", slow)
+	router.Get("/settlements/{id}", slow)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/settlements/1/events", nil))
+	suite.Equal(http.StatusOK, w.Code, "streams should stay open past the request timeout")
+
+	req := httptest.NewRequest("GET", "/settlements/1", nil)
+	req.Header.Set("Accept", "text/event-stream")
+	req.Header.Set("Upgrade", "websocket")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	suite.Equal(http.StatusGatewayTimeout, w.Code, "clients should not lift the timeout with their headers")
+}
+
+func (suite *ServerTestSuite) Test_Timeout_EndsUnboundedRoutesWithTheClient() {
+	parent, disconnect := context.WithCancel(context.Background())
+	ended := make(chan error, 1)
+	handler := web.Timeout(time.Millisecond)(web.Unbounded(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		disconnect()
+		<-r.Context().Done()
+		ended <- r.Context().Err()
+	})))
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/settlements/1/events", nil).WithContext(parent))
+
+	suite.Equal(context.Canceled, <-ended)
+}
+
+func TestServerTestSuite(t *testing.T) {
+	suite.Run(t, new(ServerTestSuite))
+}
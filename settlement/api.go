@@ -2,21 +2,40 @@ package settlement
 
 import (
 	"net/http"
+	"strings"
 
+	"github.com/failuretoload/datamonster/auth"
+	"github.com/failuretoload/datamonster/history"
+	"github.com/failuretoload/datamonster/metrics"
+	"github.com/failuretoload/datamonster/notify"
+	"github.com/failuretoload/datamonster/openapi"
+	"github.com/failuretoload/datamonster/ratelimit"
 	postgres "github.com/failuretoload/datamonster/settlement/internal"
 	"github.com/failuretoload/datamonster/store"
+	"github.com/failuretoload/datamonster/user"
 	"github.com/failuretoload/datamonster/web"
 
 	"github.com/go-chi/chi/v5"
 )
 
+// Scopes machine client tokens need to use the settlement routes.
+const (
+	ScopeRead  = "settlements:read"
+	ScopeWrite = "settlements:write"
+)
+
+// createBudget limits how quickly a caller can found settlements.
+var createBudget = ratelimit.PerMinute(10)
+
+// Controller serves settlements and announces their changes to publisher.
 type Controller struct {
-	repo *postgres.PostgresRepo
+	repo      *postgres.PostgresRepo
+	publisher notify.Publisher
 }
 
-func NewController(conn store.Connection) *Controller {
+func NewController(conn store.Connection, publisher notify.Publisher) *Controller {
 	repo := postgres.New(conn)
-	return &Controller{repo: repo}
+	return &Controller{repo: repo, publisher: publisher}
 }
 
 type SettlementDTO struct {
@@ -26,91 +45,271 @@ type SettlementDTO struct {
 	DepartingSurvival   int    `json:"departing"`
 	CollectiveCognition int    `json:"cc"`
 	Year                int    `json:"year"`
+	Version             int    `json:"version"`
+}
+
+// SettlementV2DTO replaces the abbreviated field names of SettlementDTO.
+type SettlementV2DTO struct {
+	Id                  int    `json:"id"`
+	Name                string `json:"name"`
+	SurvivalLimit       int    `json:"survivalLimit"`
+	DepartingSurvival   int    `json:"departingSurvival"`
+	CollectiveCognition int    `json:"collectiveCognition"`
+	Year                int    `json:"year"`
+	Version             int    `json:"version"`
+}
+
+// presenter converts a settlement into the payload of a particular API version.
+type presenter func(postgres.Settlement) any
+
+func presentV1(s postgres.Settlement) any {
+	return domainToDto(s)
+}
+
+func presentV2(s postgres.Settlement) any {
+	return SettlementV2DTO(domainToDto(s))
 }
 
 func (c Controller) RegisterRoutes(r chi.Router) {
-	r.Get("/settlements", c.getSettlements)
-	r.Post("/settlements", c.createSettlement)
+	c.registerRoutes(r, presentV1, false)
+}
+
+func (c Controller) RegisterVersionRoutes(version string, r chi.Router) {
+	if version == "v2" {
+		c.registerRoutes(r, presentV2, true)
+		return
+	}
+	c.RegisterRoutes(r)
+}
+
+// registerRoutes adds the settlement routes. Settlements can only be edited
+// through versions using the unabbreviated field names.
+func (c Controller) registerRoutes(r chi.Router, present presenter, editable bool) {
+	read := auth.RequireScope(ScopeRead)
+	write := auth.RequireScope(ScopeWrite)
+	r.With(read).Get("/settlements", c.getSettlements(present))
+	r.With(write, ratelimit.Limit("settlements.create", createBudget)).Post("/settlements", c.createSettlement(present))
 	r.Route("/settlements/{id}", func(r chi.Router) {
-		r.Get("/", c.getSettlement)
+		r.With(read).Get("/", c.getSettlement(present))
+		if editable {
+			r.With(write).Patch("/", c.updateSettlement(present))
+		}
 	})
 }
 
-func (c Controller) getSettlements(w http.ResponseWriter, r *http.Request) {
-	userID := r.Context().Value(web.UserIdKey).(string)
-	settlements, repoErr := c.repo.Select(r.Context(), userID)
-	if repoErr != nil {
-		web.MakeJsonResponse(w, http.StatusInternalServerError, "Error retrieving settlements")
+func (c Controller) DescribeRoutes(d *openapi.Document) {
+	c.describeRoutes(d, SettlementDTO{}, []SettlementDTO{}, false)
+}
+
+func (c Controller) DescribeVersionRoutes(version string, d *openapi.Document) {
+	if version == "v2" {
+		c.describeRoutes(d, SettlementV2DTO{}, []SettlementV2DTO{}, true)
 		return
 	}
-	data := domainListToDto(settlements)
-	web.MakeJsonResponse(w, http.StatusOK, data)
+	c.DescribeRoutes(d)
 }
 
-type CreateSettlementRequest struct {
-	Name string `json:"name"`
+func (c Controller) describeRoutes(d *openapi.Document, dto any, list any, editable bool) {
+	tags := []string{"settlements"}
+	d.Add(openapi.Route{Method: http.MethodGet, Path: "/settlements", ID: "listSettlements", Summary: "List a page of the caller's settlements, linking to the next page in the Link header", Tags: tags, Params: openapi.QueryParams(SettlementQuery{}), Scopes: []string{ScopeRead}, Response: list})
+	d.Add(openapi.Route{Method: http.MethodPost, Path: "/settlements", ID: "createSettlement", Summary: "Found a new settlement", Tags: tags, Scopes: []string{ScopeWrite}, Request: CreateSettlementRequest{}, Response: dto})
+	d.Add(openapi.Route{Method: http.MethodGet, Path: "/settlements/{id}", ID: "getSettlement", Summary: "Get a settlement, answering 304 when If-None-Match names its ETag", Tags: tags, Scopes: []string{ScopeRead}, Response: dto})
+	if editable {
+		d.Add(openapi.Route{Method: http.MethodPatch, Path: "/settlements/{id}", ID: "updateSettlement", Summary: "Update a settlement, answering 412 when If-Match does not name its current ETag", Tags: tags, Scopes: []string{ScopeWrite}, Request: UpdateSettlementRequest{}, Response: dto})
+	}
 }
 
-func (c Controller) createSettlement(w http.ResponseWriter, r *http.Request) {
-	userID, ok := r.Context().Value(web.UserIdKey).(string)
-	if !ok || userID == "" {
-		web.MakeJsonResponse(w, http.StatusBadRequest, "no user id provided")
-		return
+func (c Controller) getSettlements(present presenter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		u, _ := user.FromContext(r.Context())
+		query := SettlementQuery{Limit: 50, Sort: "created"}
+		if err := web.DecodeQuery(r, &query); err != nil {
+			web.WriteError(w, r, err)
+			return
+		}
+		page, err := pageFor(query)
+		if err != nil {
+			web.WriteError(w, r, err)
+			return
+		}
+		settlements, more, repoErr := c.repo.Select(r.Context(), u.Subject, page)
+		if repoErr != nil {
+			web.WriteError(w, r, repoErr)
+			return
+		}
+		if more {
+			web.SetNextPage(w, r, cursorAfter(settlements[len(settlements)-1], query.Sort))
+		}
+		data := presentList(settlements, present)
+		web.MakeJsonResponse(w, http.StatusOK, data)
 	}
-	var body CreateSettlementRequest
-	err := web.DecodeJsonRequest(r.Body, &body)
-	if err != nil {
-		web.MakeJsonResponse(w, http.StatusBadRequest, "invalid request body")
-		return
+}
+
+// SettlementQuery selects the page of settlements listed. Sort names a field,
+// prefixed with - for descending order; created lists settlements in the order
+// they were founded.
+type SettlementQuery struct {
+	Limit  int    `query:"limit" validate:"min=1,max=200"`
+	Cursor string `query:"cursor"`
+	Sort   string `query:"sort" validate:"oneof=created -created name -name year -year"`
+}
+
+// sortColumns maps sortable fields to their columns.
+var sortColumns = map[string]string{"created": "id", "name": "name", "year": "year"}
+
+// cursor is the position of the last settlement of a page. Name holds the
+// sort value when sorting by name and Value otherwise.
+type cursor struct {
+	Sort  string `json:"s"`
+	Name  string `json:"n,omitempty"`
+	Value int    `json:"v,omitempty"`
+	Id    int    `json:"id"`
+}
+
+func pageFor(q SettlementQuery) (store.Page, error) {
+	field, desc := strings.CutPrefix(q.Sort, "-")
+	page := store.Page{Column: sortColumns[field], Desc: desc, Limit: q.Limit}
+	if q.Cursor == "" {
+		return page, nil
 	}
-	if body.Name == "" {
-		web.MakeJsonResponse(w, http.StatusBadRequest, "name is required")
-		return
+	var c cursor
+	if err := web.DecodeCursor(q.Cursor, &c); err != nil {
+		return page, err
 	}
-	settlement := postgres.Settlement{
-		Owner:               userID,
-		Name:                body.Name,
-		SurvivalLimit:       1,
-		DepartingSurvival:   0,
-		CollectiveCognition: 0,
-		CurrentYear:         1,
+	if c.Sort != q.Sort {
+		return page, &web.ValidationError{Fields: []web.FieldError{{Field: "cursor", Message: "does not match the sort order"}}}
 	}
-	newId, insertErr := c.repo.Insert(r.Context(), settlement)
-	if insertErr != nil {
-		web.MakeJsonResponse(w, http.StatusInternalServerError, "Unable to create settlement")
-		return
+	page.After = &store.Position{Value: c.Value, Id: c.Id}
+	if field == "name" {
+		page.After.Value = c.Name
 	}
+	return page, nil
+}
 
-	settlement.Id = newId
-	dto := domainToDto(settlement)
-	web.MakeJsonResponse(w, http.StatusOK, dto)
+func cursorAfter(s postgres.Settlement, sort string) string {
+	c := cursor{Sort: sort, Id: s.Id}
+	switch strings.TrimPrefix(sort, "-") {
+	case "created":
+		c.Value = s.Id
+	case "name":
+		c.Name = s.Name
+	case "year":
+		c.Value = s.CurrentYear
+	}
+	return web.EncodeCursor(c)
 }
 
-func (c Controller) getSettlement(w http.ResponseWriter, r *http.Request) {
-	settlementId := chi.URLParam(r, "id")
-	settlement, repoErr := c.repo.Get(r.Context(), settlementId)
-	if repoErr != nil {
-		web.MakeJsonResponse(w, http.StatusInternalServerError, "Error retrieving settlement")
-		return
+type CreateSettlementRequest struct {
+	Name string `json:"name" validate:"required,max=64"`
+}
+
+func (c Controller) createSettlement(present presenter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		u, ok := user.FromContext(r.Context())
+		if !ok {
+			web.WriteProblem(w, r, http.StatusBadRequest, "no user provided")
+			return
+		}
+		var body CreateSettlementRequest
+		if err := web.DecodeAndValidate(w, r, &body); err != nil {
+			web.WriteError(w, r, err)
+			return
+		}
+		settlement := postgres.Settlement{
+			Owner:               u.Subject,
+			Name:                body.Name,
+			SurvivalLimit:       1,
+			DepartingSurvival:   0,
+			CollectiveCognition: 0,
+			CurrentYear:         1,
+		}
+		newId, insertErr := c.repo.Insert(r.Context(), settlement)
+		if insertErr != nil {
+			web.WriteError(w, r, insertErr)
+			return
+		}
+
+		metrics.SettlementsCreated.Inc()
+		settlement.Id = newId
+		settlement.Version = 1
+		web.SetETag(w, settlement.Version)
+		web.MakeJsonResponse(w, http.StatusOK, present(settlement))
+	}
+}
+
+func (c Controller) getSettlement(present presenter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		u, _ := user.FromContext(r.Context())
+		settlementId, ok := web.PathId(w, r, "id", "settlement")
+		if !ok {
+			return
+		}
+		settlement, repoErr := c.repo.Get(r.Context(), u.Subject, settlementId)
+		if repoErr != nil {
+			web.WriteError(w, r, repoErr)
+			return
+		}
+		if web.NotModified(w, r, settlement.Version) {
+			return
+		}
+		web.MakeJsonResponse(w, http.StatusOK, present(settlement))
+	}
+}
+
+// UpdateSettlementRequest changes the fields it includes.
+type UpdateSettlementRequest struct {
+	Name                *string `json:"name" validate:"min=1,max=64"`
+	SurvivalLimit       *int    `json:"survivalLimit" validate:"min=0"`
+	DepartingSurvival   *int    `json:"departingSurvival" validate:"min=0"`
+	CollectiveCognition *int    `json:"collectiveCognition" validate:"min=0"`
+	Year                *int    `json:"year" validate:"min=1"`
+}
+
+func (c Controller) updateSettlement(present presenter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		u, ok := user.FromContext(r.Context())
+		if !ok {
+			web.WriteProblem(w, r, http.StatusBadRequest, "no user provided")
+			return
+		}
+		id, ok := web.PathId(w, r, "id", "settlement")
+		if !ok {
+			return
+		}
+		version, err := web.IfMatch(r)
+		if err != nil {
+			web.WriteError(w, r, err)
+			return
+		}
+		var body UpdateSettlementRequest
+		if err := web.DecodeAndValidate(w, r, &body); err != nil {
+			web.WriteError(w, r, err)
+			return
+		}
+		changes := postgres.Changes{
+			Name:                body.Name,
+			SurvivalLimit:       body.SurvivalLimit,
+			DepartingSurvival:   body.DepartingSurvival,
+			CollectiveCognition: body.CollectiveCognition,
+			CurrentYear:         body.Year,
+		}
+		settlement, err := c.repo.Update(r.Context(), u.Subject, id, version, changes)
+		if err != nil {
+			web.WriteError(w, r, err)
+			return
+		}
+		c.publisher.Publish(r.Context(), notify.Change{Settlement: id, Entity: history.EntitySettlement, EntityId: id, Action: history.ActionUpdate, Version: settlement.Version})
+		web.SetETag(w, settlement.Version)
+		web.MakeJsonResponse(w, http.StatusOK, present(settlement))
 	}
-	dto := domainToDto(settlement)
-	web.MakeJsonResponse(w, http.StatusOK, dto)
 }
 
-func domainListToDto(settlements []postgres.Settlement) []SettlementDTO {
-	dtos := []SettlementDTO{}
+func presentList(settlements []postgres.Settlement, present presenter) []any {
+	payload := []any{}
 	for _, s := range settlements {
-		dto := SettlementDTO{
-			Id:                  s.Id,
-			Name:                s.Name,
-			SurvivalLimit:       s.SurvivalLimit,
-			DepartingSurvival:   s.DepartingSurvival,
-			CollectiveCognition: s.CollectiveCognition,
-			Year:                s.CurrentYear,
-		}
-		dtos = append(dtos, dto)
+		payload = append(payload, present(s))
 	}
-	return dtos
+	return payload
 }
 
 func domainToDto(s postgres.Settlement) SettlementDTO {
@@ -121,5 +320,6 @@ func domainToDto(s postgres.Settlement) SettlementDTO {
 		DepartingSurvival:   s.DepartingSurvival,
 		CollectiveCognition: s.CollectiveCognition,
 		Year:                s.CurrentYear,
+		Version:             s.Version,
 	}
 }
@@ -2,16 +2,20 @@ package settlement
 
 import (
 	"bytes"
-	"context"
 	"encoding/json"
 	"fmt"
 	"io"
+	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/failuretoload/datamonster/web"
 
+	"github.com/failuretoload/datamonster/auth"
+	"github.com/failuretoload/datamonster/notify"
 	storeMocks "github.com/failuretoload/datamonster/store/mocks"
+	"github.com/failuretoload/datamonster/user"
 	"github.com/go-chi/chi/v5"
 	"github.com/jackc/pgx/v5"
 	"github.com/stretchr/testify/suite"
@@ -25,13 +29,16 @@ type SettlementApiTestSuite struct {
 	suite.Suite
 	target *Controller
 	db     *storeMocks.MockConnection
+	hub    *notify.Hub
 	router *chi.Mux
 }
 
 func (suite *SettlementApiTestSuite) SetupTest() {
 	suite.db = &storeMocks.MockConnection{}
-	suite.target = NewController(suite.db)
+	suite.hub = notify.NewHub()
+	suite.target = NewController(suite.db, suite.hub)
 	suite.router = chi.NewRouter()
+	suite.router.Use(asUser)
 	suite.target.RegisterRoutes(suite.router)
 
 }
@@ -60,11 +67,9 @@ func (suite *SettlementApiTestSuite) Test_GetSettlements_ReturnsSettmentsList()
 		},
 	}
 	suite.db.SetRows(&rows)
-	req := httptest.NewRequest("GET", "/settlement", nil)
-	ctx := req.Context()
-	ctx = context.WithValue(ctx, web.UserIdKey, testUserId)
+	req := httptest.NewRequest("GET", "/settlements", nil)
 	w := httptest.NewRecorder()
-	suite.router.ServeHTTP(w, req.WithContext(ctx))
+	suite.router.ServeHTTP(w, req)
 	resp := w.Result()
 
 	suite.Equal(200, resp.StatusCode, "200 response should be returned")
@@ -74,6 +79,62 @@ func (suite *SettlementApiTestSuite) Test_GetSettlements_ReturnsSettmentsList()
 	suite.Equal(2, len(dto), "2 settlements should be returned")
 }
 
+func (suite *SettlementApiTestSuite) Test_GetSettlements_LinksToTheNextPage() {
+	suite.db.SetRows(&storeMocks.MockRows{Rows: []pgx.Row{
+		&SettlementRow{Id: 4, Name: "Ashes", CurrentYear: 7},
+		&SettlementRow{Id: 9, Name: "Dawn", CurrentYear: 3},
+		&SettlementRow{Id: 2, Name: "Dusk", CurrentYear: 1},
+	}})
+	w := httptest.NewRecorder()
+
+	suite.router.ServeHTTP(w, httptest.NewRequest("GET", "/settlements?limit=2&sort=-year", nil))
+
+	suite.Equal(200, w.Code)
+	dto := []SettlementDTO{}
+	suite.NoError(json.Unmarshal(w.Body.Bytes(), &dto))
+	suite.Len(dto, 2, "the extra row only signals that another page follows")
+	suite.Contains(suite.db.SQL, "ORDER BY year DESC, id DESC LIMIT $2")
+	suite.Equal([]any{testUserId, 3}, suite.db.Args)
+
+	link := w.Header().Get("Link")
+	suite.Regexp(`^</settlements\?cursor=[\w-]+&limit=2&sort=-year>; rel="next"$`, link)
+	next := link[1:strings.Index(link, ">")]
+	suite.db.SetRows(&storeMocks.MockRows{})
+	w = httptest.NewRecorder()
+
+	suite.router.ServeHTTP(w, httptest.NewRequest("GET", next, nil))
+
+	suite.Equal(200, w.Code)
+	suite.Contains(suite.db.SQL, "(year, id) < ($2, $3)")
+	suite.Equal([]any{testUserId, 3, 9, 3}, suite.db.Args, "the next page should start after the last settlement shown")
+	suite.Empty(w.Header().Get("Link"), "the last page should not link further")
+}
+
+func (suite *SettlementApiTestSuite) Test_GetSettlements_RejectsInvalidQueries() {
+	cursor := web.EncodeCursor(map[string]any{"s": "name", "n": "Dawn", "id": 9})
+	tests := []struct {
+		query    string
+		expected []web.FieldError
+	}{
+		{query: "limit=0&sort=age", expected: []web.FieldError{{Field: "limit", Message: "must be at least 1"}, {Field: "sort", Message: "must be one of created, -created, name, -name, year, -year"}}},
+		{query: "limit=many&page=2", expected: []web.FieldError{{Field: "limit", Message: "must be an integer"}, {Field: "page", Message: "is not a recognized parameter"}}},
+		{query: "cursor=not-a-cursor", expected: []web.FieldError{{Field: "cursor", Message: "is invalid"}}},
+		{query: "sort=year&cursor=" + cursor, expected: []web.FieldError{{Field: "cursor", Message: "does not match the sort order"}}},
+	}
+	for _, test := range tests {
+		suite.Run(test.query, func() {
+			w := httptest.NewRecorder()
+
+			suite.router.ServeHTTP(w, httptest.NewRequest("GET", "/settlements?"+test.query, nil))
+
+			suite.Equal(422, w.Code)
+			problem := web.ValidationProblem{}
+			suite.NoError(json.Unmarshal(w.Body.Bytes(), &problem))
+			suite.Equal(test.expected, problem.Errors)
+		})
+	}
+}
+
 func (suite *SettlementApiTestSuite) Test_GetSettlements_ReportsScanErrors() {
 	errorRows := storeMocks.MockRows{
 		Rows: []pgx.Row{
@@ -92,10 +153,7 @@ func (suite *SettlementApiTestSuite) Test_GetSettlements_ReportsScanErrors() {
 		},
 	}
 	suite.db.SetRows(&errorRows)
-	req := httptest.NewRequest("GET", "/settlement", nil)
-	ctx := req.Context()
-	ctx = context.WithValue(ctx, web.UserIdKey, testUserId)
-	req = req.WithContext(ctx)
+	req := httptest.NewRequest("GET", "/settlements", nil)
 	w := httptest.NewRecorder()
 
 	suite.router.ServeHTTP(w, req)
@@ -104,13 +162,20 @@ func (suite *SettlementApiTestSuite) Test_GetSettlements_ReportsScanErrors() {
 	suite.Equal(500, resp.StatusCode, "connection issues should result in server error")
 }
 
+func (suite *SettlementApiTestSuite) Test_GetSettlements_ReportsReadErrors() {
+	suite.db.SetRows(&storeMocks.MockRows{Error: fmt.Errorf("connection reset")})
+	req := httptest.NewRequest("GET", "/settlements", nil)
+	w := httptest.NewRecorder()
+
+	suite.router.ServeHTTP(w, req)
+
+	suite.Equal(500, w.Code, "a partly read list should not be answered as complete")
+}
+
 func (suite *SettlementApiTestSuite) Test_GetSettlements_ReportsConnectionErrors() {
 	err := fmt.Errorf("query error")
 	suite.db.SetError(err)
-	req := httptest.NewRequest("GET", "/settlement", nil)
-	ctx := req.Context()
-	ctx = context.WithValue(ctx, web.UserIdKey, testUserId)
-	req = req.WithContext(ctx)
+	req := httptest.NewRequest("GET", "/settlements", nil)
 	w := httptest.NewRecorder()
 
 	suite.router.ServeHTTP(w, req)
@@ -128,11 +193,8 @@ func (suite *SettlementApiTestSuite) Test_CreateSettlement_ReturnsASettlement()
 		Name: "Fun Forever",
 	}
 	reqBody, _ := json.Marshal(settlementRequest)
-	req := httptest.NewRequest("POST", "/settlement", bytes.NewReader(reqBody))
+	req := httptest.NewRequest("POST", "/settlements", bytes.NewReader(reqBody))
 
-	ctx := req.Context()
-	ctx = context.WithValue(ctx, web.UserIdKey, testUserId)
-	req = req.WithContext(ctx)
 	w := httptest.NewRecorder()
 
 	suite.router.ServeHTTP(w, req)
@@ -146,6 +208,21 @@ func (suite *SettlementApiTestSuite) Test_CreateSettlement_ReturnsASettlement()
 	suite.Equal("Fun Forever", dto.Name, "created settlement should have supplied name")
 }
 
+func (suite *SettlementApiTestSuite) Test_CreateSettlement_RecordsTheFounding() {
+	suite.db.SetRow(&storeMocks.InsertRow{Id: 7})
+	w := httptest.NewRecorder()
+
+	suite.router.ServeHTTP(w, httptest.NewRequest("POST", "/settlements", strings.NewReader(`{"name": "Fun Forever"}`)))
+
+	suite.Equal(200, w.Code)
+	suite.Contains(suite.db.SQL, "INSERT INTO campaign.event")
+	suite.Equal([]any{7, "settlement", 7, "create", testUserId, []byte(nil), (*int)(nil)}, suite.db.Args)
+	founded := suite.db.Statements[2]
+	suite.Contains(founded.SQL, "INSERT INTO campaign.settlement_event")
+	suite.Equal([]any{7, "SettlementFounded", []byte(`{"name":"Fun Forever"}`), testUserId}, founded.Args)
+	suite.True(suite.db.Committed, "the settlement and its event should be committed together")
+}
+
 type WrongRequest struct {
 	FancyName string `json:"soFancy"`
 }
@@ -155,17 +232,21 @@ func (suite *SettlementApiTestSuite) Test_CreateSettlement_EnforceRequestType()
 		FancyName: "Fun Forever",
 	}
 	reqBody, _ := json.Marshal(wrongRequest)
-	req := httptest.NewRequest("POST", "/settlement", bytes.NewReader(reqBody))
+	req := httptest.NewRequest("POST", "/settlements", bytes.NewReader(reqBody))
 
-	ctx := req.Context()
-	ctx = context.WithValue(ctx, web.UserIdKey, testUserId)
-	req = req.WithContext(ctx)
 	w := httptest.NewRecorder()
 
 	suite.router.ServeHTTP(w, req)
 	resp := w.Result()
 
-	suite.Equal(400, resp.StatusCode, "Request must be of type CreateSettlementRequest")
+	suite.Equal(422, resp.StatusCode, "Request must be of type CreateSettlementRequest")
+	body, _ := io.ReadAll(resp.Body)
+	problem := web.ValidationProblem{}
+	json.Unmarshal(body, &problem)
+	suite.ElementsMatch([]web.FieldError{
+		{Field: "soFancy", Message: "is not a recognized field"},
+		{Field: "name", Message: "is required"},
+	}, problem.Errors, "unknown and missing fields should be reported together")
 }
 
 func (suite *SettlementApiTestSuite) Test_CreateSettlement_RequiresAName() {
@@ -173,17 +254,14 @@ func (suite *SettlementApiTestSuite) Test_CreateSettlement_RequiresAName() {
 		Name: "",
 	}
 	reqBody, _ := json.Marshal(emptyRequest)
-	req := httptest.NewRequest("POST", "/settlement", bytes.NewReader(reqBody))
+	req := httptest.NewRequest("POST", "/settlements", bytes.NewReader(reqBody))
 
-	ctx := req.Context()
-	ctx = context.WithValue(ctx, web.UserIdKey, testUserId)
-	req = req.WithContext(ctx)
 	w := httptest.NewRecorder()
 
 	suite.router.ServeHTTP(w, req)
 	resp := w.Result()
 
-	suite.Equal(400, resp.StatusCode, "Settlement Name is required")
+	suite.Equal(422, resp.StatusCode, "Settlement Name is required")
 }
 
 func (suite *SettlementApiTestSuite) Test_CreateSettlement_ReportsCreationErrors() {
@@ -195,11 +273,8 @@ func (suite *SettlementApiTestSuite) Test_CreateSettlement_ReportsCreationErrors
 		Name: "Fun time",
 	}
 	reqBody, _ := json.Marshal(createRequest)
-	req := httptest.NewRequest("POST", "/settlement", bytes.NewReader(reqBody))
+	req := httptest.NewRequest("POST", "/settlements", bytes.NewReader(reqBody))
 
-	ctx := req.Context()
-	ctx = context.WithValue(ctx, web.UserIdKey, testUserId)
-	req = req.WithContext(ctx)
 	w := httptest.NewRecorder()
 
 	suite.router.ServeHTTP(w, req)
@@ -219,13 +294,7 @@ func (suite *SettlementApiTestSuite) Test_GetSettlement_ReturnsOneSettlement() {
 		CurrentYear:         1,
 	}
 	suite.db.SetRow(&row)
-	req := httptest.NewRequest("GET", "/settlement/1", nil)
-	ctx := req.Context()
-	rctx := chi.NewRouteContext()
-	rctx.URLParams.Add("id", "1")
-	ctx = context.WithValue(ctx, chi.RouteCtxKey, rctx)
-	ctx = context.WithValue(ctx, web.UserIdKey, testUserId)
-	req = req.WithContext(ctx)
+	req := httptest.NewRequest("GET", "/settlements/1", nil)
 	w := httptest.NewRecorder()
 
 	suite.router.ServeHTTP(w, req)
@@ -243,13 +312,7 @@ func (suite *SettlementApiTestSuite) Test_GetSettlement_ReportsScanErrors() {
 		Error: fmt.Errorf("scan error"),
 	}
 	suite.db.SetRow(&row)
-	req := httptest.NewRequest("GET", "/settlement/1", nil)
-	ctx := req.Context()
-	rctx := chi.NewRouteContext()
-	rctx.URLParams.Add("id", "1")
-	ctx = context.WithValue(ctx, chi.RouteCtxKey, rctx)
-	ctx = context.WithValue(ctx, web.UserIdKey, testUserId)
-	req = req.WithContext(ctx)
+	req := httptest.NewRequest("GET", "/settlements/1", nil)
 	w := httptest.NewRecorder()
 
 	suite.router.ServeHTTP(w, req)
@@ -257,6 +320,235 @@ func (suite *SettlementApiTestSuite) Test_GetSettlement_ReportsScanErrors() {
 
 	suite.Equal(500, resp.StatusCode, "return server error on failure")
 }
+
+func (suite *SettlementApiTestSuite) Test_GetSettlement_ReportsMissingSettlements() {
+	row := storeMocks.ErrorRow{
+		Error: pgx.ErrNoRows,
+	}
+	suite.db.SetRow(&row)
+	req := httptest.NewRequest("GET", "/settlements/1", nil)
+	w := httptest.NewRecorder()
+
+	suite.router.ServeHTTP(w, req)
+	resp := w.Result()
+
+	suite.Equal(404, resp.StatusCode, "return not found when the settlement does not exist")
+	suite.Equal(web.ProblemContentType, resp.Header.Get("Content-Type"), "errors should be problem documents")
+	body, _ := io.ReadAll(resp.Body)
+	problem := web.Problem{}
+	json.Unmarshal(body, &problem)
+	suite.Equal(404, problem.Status, "problem should carry the status code")
+	suite.Equal("settlement not found", problem.Detail, "problem should describe the missing resource")
+}
+
+func (suite *SettlementApiTestSuite) Test_GetSettlement_HidesOtherUsersSettlements() {
+	suite.db.SetRow(&storeMocks.ErrorRow{Error: pgx.ErrNoRows})
+	router := chi.NewRouter()
+	router.Use(asOtherUser)
+	suite.target.RegisterRoutes(router)
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/settlements/1", nil))
+
+	suite.Equal(404, w.Code, "another user's settlement should not be found")
+	suite.Contains(suite.db.SQL, "WHERE id = $1 AND owner = $2")
+	suite.Equal([]any{1, "otherUserId"}, suite.db.Args)
+	suite.Empty(w.Header().Get("ETag"))
+}
+
+func (suite *SettlementApiTestSuite) Test_Settlement_RejectsMalformedIds() {
+	router := suite.v2Router()
+	for _, req := range []*http.Request{
+		httptest.NewRequest("GET", "/settlements/first", nil),
+		httptest.NewRequest("PATCH", "/settlements/first", strings.NewReader(`{"name": "Fun Forever"}`)),
+	} {
+		w := httptest.NewRecorder()
+
+		router.ServeHTTP(w, req)
+
+		suite.Equal(400, w.Code, req.Method+" "+req.URL.Path)
+		suite.Empty(suite.db.SQL, "nothing should be queried")
+	}
+}
+
+func (suite *SettlementApiTestSuite) Test_GetSettlement_UsesVersionPayloads() {
+	row := SettlementRow{
+		Id:                  1,
+		Owner:               testUserId,
+		Name:                "Fun Forever",
+		SurvivalLimit:       3,
+		DepartingSurvival:   1,
+		CollectiveCognition: 2,
+		CurrentYear:         4,
+	}
+	suite.db.SetRow(&row)
+	router := chi.NewRouter()
+	router.Use(asUser)
+	suite.target.RegisterVersionRoutes("v2", router)
+	req := httptest.NewRequest("GET", "/settlements/1", nil)
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, req)
+	resp := w.Result()
+
+	suite.Equal(200, resp.StatusCode, "return OK on success")
+	body, _ := io.ReadAll(resp.Body)
+	payload := map[string]any{}
+	json.Unmarshal(body, &payload)
+	suite.Equal(float64(3), payload["survivalLimit"], "v2 should use descriptive field names")
+	suite.Equal(float64(2), payload["collectiveCognition"], "v2 should use descriptive field names")
+	suite.NotContains(payload, "cc", "v2 should not use abbreviated field names")
+}
+
+func (suite *SettlementApiTestSuite) Test_GetSettlement_ReturnsAnETag() {
+	suite.db.SetRow(&SettlementRow{Id: 1, Owner: testUserId, Name: "Fun Forever", Version: 3})
+	w := httptest.NewRecorder()
+
+	suite.router.ServeHTTP(w, httptest.NewRequest("GET", "/settlements/1", nil))
+
+	suite.Equal(200, w.Code)
+	suite.Equal(`"3"`, w.Header().Get("ETag"))
+	suite.Equal("private, no-cache", w.Header().Get("Cache-Control"))
+}
+
+func (suite *SettlementApiTestSuite) Test_GetSettlement_AnswersNotModified() {
+	suite.db.SetRow(&SettlementRow{Id: 1, Owner: testUserId, Name: "Fun Forever", Version: 3})
+	req := httptest.NewRequest("GET", "/settlements/1", nil)
+	req.Header.Set("If-None-Match", `"2", "3"`)
+	w := httptest.NewRecorder()
+
+	suite.router.ServeHTTP(w, req)
+
+	suite.Equal(304, w.Code, "a cached current version should not be sent again")
+	suite.Empty(w.Body.Bytes())
+	suite.Equal(`"3"`, w.Header().Get("ETag"))
+}
+
+func (suite *SettlementApiTestSuite) Test_UpdateSettlement_AppliesChangesToTheMatchingVersion() {
+	suite.db.SetRow(&SettlementRow{Id: 1, Owner: testUserId, Name: "Ashes", CurrentYear: 2, PreviousYear: 2, Version: 4})
+	sub := suite.hub.Subscribe(1)
+	req := httptest.NewRequest("PATCH", "/settlements/1", strings.NewReader(`{"name": "Ashes", "year": 2}`))
+	req.Header.Set("If-Match", `"3"`)
+	w := httptest.NewRecorder()
+
+	suite.v2Router().ServeHTTP(w, req)
+
+	suite.Equal(200, w.Code)
+	update := suite.db.Statements[0]
+	suite.Contains(update.SQL, "WHERE id = $3 AND owner = $4 FOR UPDATE) UPDATE campaign.settlement SET name = $1, year = $2, version = version + 1 FROM old WHERE id = old_id AND version = $5")
+	suite.Equal([]any{"Ashes", 2, 1, testUserId, 3}, update.Args)
+	suite.Contains(suite.db.SQL, "INSERT INTO campaign.event", "the change should be recorded")
+	suite.True(suite.db.Committed)
+	suite.Len(suite.db.Statements, 2, "keeping the year should not advance the timeline")
+	suite.Require().Len(sub.C, 1, "watchers of the settlement should be notified")
+	suite.Equal(notify.Change{Settlement: 1, Entity: "settlement", EntityId: 1, Action: "update", Version: 4}, <-sub.C)
+	suite.Equal(`"4"`, w.Header().Get("ETag"))
+	dto := SettlementV2DTO{}
+	suite.NoError(json.Unmarshal(w.Body.Bytes(), &dto))
+	suite.Equal(4, dto.Version)
+}
+
+func (suite *SettlementApiTestSuite) Test_UpdateSettlement_AdvancesTheLanternYear() {
+	suite.db.SetRow(&SettlementRow{Id: 1, Owner: testUserId, Name: "Ashes", CurrentYear: 3, PreviousYear: 2, Version: 4})
+	w := httptest.NewRecorder()
+
+	suite.v2Router().ServeHTTP(w, httptest.NewRequest("PATCH", "/settlements/1", strings.NewReader(`{"year": 3}`)))
+
+	suite.Equal(200, w.Code)
+	suite.Contains(suite.db.Statements[0].SQL, "year AS old_year")
+	advanced := suite.db.Statements[2]
+	suite.Contains(advanced.SQL, "INSERT INTO campaign.settlement_event")
+	suite.Equal([]any{1, "LanternYearAdvanced", []byte(`{"year":3}`), testUserId}, advanced.Args)
+	suite.Contains(suite.db.SQL, "INSERT INTO campaign.event")
+}
+
+func (suite *SettlementApiTestSuite) Test_UpdateSettlement_RejectsStaleVersions() {
+	suite.db.SetRow(&storeMocks.RowSequence{Rows: []pgx.Row{
+		&storeMocks.ErrorRow{Error: pgx.ErrNoRows},
+		&storeMocks.InsertRow{Id: 5},
+	}})
+	req := httptest.NewRequest("PATCH", "/settlements/1", strings.NewReader(`{"year": 3}`))
+	req.Header.Set("If-Match", `"4"`)
+	w := httptest.NewRecorder()
+
+	suite.v2Router().ServeHTTP(w, req)
+
+	suite.Equal(412, w.Code, "an update of an old version should fail")
+	suite.Contains(w.Body.String(), "current version is 5")
+}
+
+func (suite *SettlementApiTestSuite) Test_UpdateSettlement_ReportsMissingSettlements() {
+	suite.db.SetRow(&storeMocks.RowSequence{Rows: []pgx.Row{
+		&storeMocks.ErrorRow{Error: pgx.ErrNoRows},
+		&storeMocks.ErrorRow{Error: pgx.ErrNoRows},
+	}})
+	req := httptest.NewRequest("PATCH", "/settlements/1", strings.NewReader(`{"year": 3}`))
+	req.Header.Set("If-Match", `"4"`)
+	w := httptest.NewRecorder()
+
+	suite.v2Router().ServeHTTP(w, req)
+
+	suite.Equal(404, w.Code)
+}
+
+func (suite *SettlementApiTestSuite) Test_UpdateSettlement_RejectsWeakTags() {
+	req := httptest.NewRequest("PATCH", "/settlements/1", strings.NewReader(`{"year": 3}`))
+	req.Header.Set("If-Match", `W/"4"`)
+	w := httptest.NewRecorder()
+
+	suite.v2Router().ServeHTTP(w, req)
+
+	suite.Equal(412, w.Code)
+	suite.Empty(suite.db.SQL, "nothing should be updated")
+}
+
+func (suite *SettlementApiTestSuite) Test_UpdateSettlement_IsNotServedByV1() {
+	w := httptest.NewRecorder()
+
+	suite.router.ServeHTTP(w, httptest.NewRequest("PATCH", "/settlements/1", strings.NewReader(`{"year": 3}`)))
+
+	suite.Equal(405, w.Code)
+}
+
+func (suite *SettlementApiTestSuite) Test_CreateSettlement_RequiresTheWriteScopeForMachineClients() {
+	router := chi.NewRouter()
+	router.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			client := auth.Principal{Subject: "reporting", Machine: true, Scopes: []string{ScopeRead}}
+			next.ServeHTTP(w, r.WithContext(auth.WithPrincipal(r.Context(), client)))
+		})
+	})
+	suite.target.RegisterRoutes(router)
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/settlements", strings.NewReader(`{"name": "Fun Forever"}`)))
+
+	suite.Equal(403, w.Code, "clients should be limited to their scopes")
+	suite.Contains(w.Header().Get("WWW-Authenticate"), `scope="settlements:write"`)
+}
+
+// asUser authenticates every request as a user, who is not limited by scopes.
+func asUser(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := auth.WithPrincipal(r.Context(), auth.Principal{Subject: testUserId})
+		next.ServeHTTP(w, r.WithContext(user.WithUser(ctx, user.User{Subject: testUserId})))
+	})
+}
+
+func asOtherUser(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := auth.WithPrincipal(r.Context(), auth.Principal{Subject: "otherUserId"})
+		next.ServeHTTP(w, r.WithContext(user.WithUser(ctx, user.User{Subject: "otherUserId"})))
+	})
+}
+
+func (suite *SettlementApiTestSuite) v2Router() *chi.Mux {
+	router := chi.NewRouter()
+	router.Use(asUser)
+	suite.target.RegisterVersionRoutes("v2", router)
+	return router
+}
+
 func TestSettlementApiTestSuite(t *testing.T) {
 	suite.Run(t, new(SettlementApiTestSuite))
 }
@@ -269,6 +561,9 @@ type SettlementRow struct {
 	DepartingSurvival   int
 	CollectiveCognition int
 	CurrentYear         int
+	Version             int
+	// PreviousYear is the year before an update.
+	PreviousYear int
 }
 
 func (s *SettlementRow) Scan(dest ...any) error {
@@ -287,6 +582,10 @@ func (s *SettlementRow) Scan(dest ...any) error {
 	*departingSurvival = s.DepartingSurvival
 	*collectiveCognition = s.CollectiveCognition
 	*currentYear = s.CurrentYear
+	*dest[7].(*int) = s.Version
+	if len(dest) > 9 {
+		*dest[9].(*int) = s.PreviousYear
+	}
 
 	return nil
 }
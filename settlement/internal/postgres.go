@@ -2,8 +2,13 @@ package internal
 
 import (
 	"context"
-	"fmt"
+	"errors"
+
+	"github.com/failuretoload/datamonster/history"
 	"github.com/failuretoload/datamonster/store"
+	"github.com/failuretoload/datamonster/timeline"
+	"github.com/failuretoload/datamonster/tracing"
+	"github.com/jackc/pgx/v5"
 )
 
 type PostgresRepo struct {
@@ -18,43 +23,131 @@ type Settlement struct {
 	DepartingSurvival   int
 	CollectiveCognition int
 	CurrentYear         int
+	Version             int
+}
+
+// Changes lists the fields an update sets. Nil fields are left unchanged.
+type Changes struct {
+	Name                *string
+	SurvivalLimit       *int
+	DepartingSurvival   *int
+	CollectiveCognition *int
+	CurrentYear         *int
 }
 
+const columns = `id, owner, name, survival_limit, departing_survival, collective_cognition, year, version`
+
 func New(d store.Connection) *PostgresRepo {
 	return &PostgresRepo{pool: d}
 }
 
-func (r PostgresRepo) Select(ctx context.Context, userID string) ([]Settlement, error) {
-	query := fmt.Sprintf("SELECT * FROM campaign.settlement WHERE owner='%s'", userID)
-	rows, err := r.pool.Query(ctx, query)
+// Select returns one page of the user's settlements and whether another page
+// follows.
+func (r PostgresRepo) Select(ctx context.Context, userID string, page store.Page) ([]Settlement, bool, error) {
+	ctx, span := tracing.Start(ctx, "settlements.Select")
+	defer span.End()
+	query, args := page.Apply(`SELECT `+columns+` FROM campaign.settlement`, []string{"owner = $1"}, []any{userID})
+	rows, err := r.pool.Query(ctx, query, args...)
 	if err != nil {
-		return []Settlement{}, err
+		return []Settlement{}, false, store.TranslateError(err, "settlement")
 	}
 	defer rows.Close()
 	settlements := []Settlement{}
 	for rows.Next() {
-		var s Settlement
-		err := rows.Scan(&s.Id, &s.Owner, &s.Name, &s.SurvivalLimit, &s.DepartingSurvival, &s.CollectiveCognition, &s.CurrentYear)
+		s, err := scan(rows)
 		if err != nil {
-			return settlements, err
+			return settlements, false, store.TranslateError(err, "settlement")
 		}
 		settlements = append(settlements, s)
 	}
-	return settlements, nil
+	if err := rows.Err(); err != nil {
+		return settlements, false, store.TranslateError(err, "settlement")
+	}
+	settlements, more := store.More(settlements, page)
+	return settlements, more, nil
 }
 
-func (r PostgresRepo) Get(ctx context.Context, id string) (Settlement, error) {
-	query := `SELECT * FROM campaign.settlement WHERE id = $1 LIMIT 1`
-	var s Settlement
-	err := r.pool.QueryRow(ctx, query, id).Scan(&s.Id, &s.Owner, &s.Name, &s.SurvivalLimit, &s.DepartingSurvival, &s.CollectiveCognition, &s.CurrentYear)
-	return s, err
+// Get reads a settlement of owner. Other users' settlements are reported as not
+// found.
+func (r PostgresRepo) Get(ctx context.Context, owner string, id int) (Settlement, error) {
+	ctx, span := tracing.Start(ctx, "settlements.Get")
+	defer span.End()
+	query := `SELECT ` + columns + ` FROM campaign.settlement WHERE id = $1 AND owner = $2`
+	s, err := scan(r.pool.QueryRow(ctx, query, id, owner))
+	return s, store.TranslateError(err, "settlement")
 }
 
+// Insert founds s and records its creation.
 func (r PostgresRepo) Insert(ctx context.Context, s Settlement) (int, error) {
-	insert := "INSERT INTO campaign.settlement (owner, name, survival_limit, departing_survival, collective_cognition, year) "
-	values := fmt.Sprintf("VALUES ('%s', '%s', %d, %d, %d, %d) RETURNING id", s.Owner, s.Name, s.SurvivalLimit, s.DepartingSurvival, s.CollectiveCognition, s.CurrentYear)
-	query := insert + values
+	ctx, span := tracing.Start(ctx, "settlements.Insert")
+	defer span.End()
+	query := `INSERT INTO campaign.settlement (owner, name, survival_limit, departing_survival, collective_cognition, year)
+		VALUES ($1, $2, $3, $4, $5, $6) RETURNING id`
 	id := 0
-	err := r.pool.QueryRow(ctx, query).Scan(&id)
-	return id, err
+	err := pgx.BeginFunc(ctx, r.pool, func(tx pgx.Tx) error {
+		err := tx.QueryRow(ctx, query, s.Owner, s.Name, s.SurvivalLimit, s.DepartingSurvival, s.CollectiveCognition, s.CurrentYear).Scan(&id)
+		if err != nil {
+			return err
+		}
+		if err := timeline.Append(ctx, tx, id, timeline.SettlementFounded{Name: s.Name}); err != nil {
+			return err
+		}
+		if s.CurrentYear != 1 {
+			if err := timeline.Append(ctx, tx, id, timeline.LanternYearAdvanced{Year: s.CurrentYear}); err != nil {
+				return err
+			}
+		}
+		return history.Record(ctx, tx, history.Change{Settlement: id, Entity: history.EntitySettlement, EntityId: id, Action: history.ActionCreate})
+	})
+	return id, store.TranslateError(err, "settlement")
+}
+
+// Update applies c to one of owner's settlements and records the change. When
+// version is not nil the update only applies if the settlement is still at that
+// version.
+func (r PostgresRepo) Update(ctx context.Context, owner string, id int, version *int, c Changes) (Settlement, error) {
+	ctx, span := tracing.Start(ctx, "settlements.Update")
+	defer span.End()
+	u := &store.Update{}
+	store.SetIf(u, "name", c.Name)
+	store.SetIf(u, "survival_limit", c.SurvivalLimit)
+	store.SetIf(u, "departing_survival", c.DepartingSurvival)
+	store.SetIf(u, "collective_cognition", c.CollectiveCognition)
+	store.SetIf(u, "year", c.CurrentYear)
+	// old locks the row and keeps the record as it was for the event logs.
+	old := `WITH old AS (SELECT id AS old_id, to_jsonb(settlement) AS old_record, year AS old_year FROM campaign.settlement WHERE id = ` + u.Arg(id) +
+		` AND owner = ` + u.Arg(owner) + ` FOR UPDATE)`
+	where := "id = old_id"
+	if version != nil {
+		where += " AND version = " + u.Arg(*version)
+	}
+	query := old + ` UPDATE campaign.settlement SET ` + u.Assignments() + ` FROM old WHERE ` + where + ` RETURNING ` + columns + `, old_record, old_year`
+	var s Settlement
+	err := pgx.BeginFunc(ctx, r.pool, func(tx pgx.Tx) error {
+		var before []byte
+		var year int
+		var err error
+		s, err = scan(tx.QueryRow(ctx, query, u.Args...), &before, &year)
+		if err != nil {
+			return err
+		}
+		if s.CurrentYear != year {
+			if err := timeline.Append(ctx, tx, s.Id, timeline.LanternYearAdvanced{Year: s.CurrentYear}); err != nil {
+				return err
+			}
+		}
+		return history.Record(ctx, tx, history.Change{Settlement: s.Id, Entity: history.EntitySettlement, EntityId: s.Id, Action: history.ActionUpdate, Before: before})
+	})
+	if errors.Is(err, pgx.ErrNoRows) && version != nil {
+		return s, store.Stale(ctx, r.pool, "settlement", `SELECT version FROM campaign.settlement WHERE id = $1 AND owner = $2`, id, owner)
+	}
+	return s, store.TranslateError(err, "settlement")
+}
+
+// scan reads the settlement columns of row, followed by any extra columns.
+func scan(row pgx.Row, extra ...any) (Settlement, error) {
+	var s Settlement
+	dest := []any{&s.Id, &s.Owner, &s.Name, &s.SurvivalLimit, &s.DepartingSurvival, &s.CollectiveCognition, &s.CurrentYear, &s.Version}
+	err := row.Scan(append(dest, extra...)...)
+	return s, err
 }
@@ -0,0 +1,47 @@
+package store
+
+import (
+	"errors"
+
+	"github.com/failuretoload/datamonster/domain"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// Postgres SQLSTATE codes, see https://www.postgresql.org/docs/current/errcodes-appendix.html
+const (
+	codeUniqueViolation       = "23505"
+	codeForeignKeyViolation   = "23503"
+	codeNotNullViolation      = "23502"
+	codeCheckViolation        = "23514"
+	codeStringTooLong         = "22001"
+	codeNumericOutOfRange     = "22003"
+	codeInvalidTextFormat     = "22P02"
+	codeInsufficientPrivilege = "42501"
+)
+
+// TranslateError converts driver errors into domain errors. Errors that have no
+// domain meaning are returned unchanged and will be treated as internal errors.
+func TranslateError(err error, entity string) error {
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, pgx.ErrNoRows) {
+		return domain.Wrap(domain.KindNotFound, err, "%s not found", entity)
+	}
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return err
+	}
+	switch pgErr.Code {
+	case codeUniqueViolation:
+		return domain.Wrap(domain.KindConflict, err, "%s already exists", entity)
+	case codeForeignKeyViolation:
+		return domain.Wrap(domain.KindValidation, err, "%s references a record that does not exist", entity)
+	case codeNotNullViolation, codeCheckViolation, codeStringTooLong, codeNumericOutOfRange, codeInvalidTextFormat:
+		return domain.Wrap(domain.KindValidation, err, "%s is invalid", entity)
+	case codeInsufficientPrivilege:
+		return domain.Wrap(domain.KindForbidden, err, "%s cannot be accessed", entity)
+	}
+	return err
+}
@@ -0,0 +1,107 @@
+package migrations
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/failuretoload/datamonster/store"
+)
+
+//go:embed sql/*.sql
+var files embed.FS
+
+// lockID serialises migrations when several instances start at once.
+const lockID = 7_346_281
+
+type Migration struct {
+	Version int
+	Name    string
+	SQL     string
+}
+
+// All returns the embedded migrations ordered by version. Files are named
+// NNNN_description.sql.
+func All() []Migration {
+	entries, err := fs.ReadDir(files, "sql")
+	if err != nil {
+		panic(err)
+	}
+	migrations := []Migration{}
+	for _, entry := range entries {
+		prefix, name, ok := strings.Cut(strings.TrimSuffix(entry.Name(), ".sql"), "_")
+		version, err := strconv.Atoi(prefix)
+		if !ok || err != nil {
+			panic(fmt.Sprintf("migration %s is not named NNNN_description.sql", entry.Name()))
+		}
+		contents, err := files.ReadFile(path.Join("sql", entry.Name()))
+		if err != nil {
+			panic(err)
+		}
+		migrations = append(migrations, Migration{Version: version, Name: name, SQL: string(contents)})
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations
+}
+
+// Latest is the version the code expects the database to be at.
+func Latest() int {
+	all := All()
+	if len(all) == 0 {
+		return 0
+	}
+	return all[len(all)-1].Version
+}
+
+const createTable = `CREATE SCHEMA IF NOT EXISTS campaign;
+CREATE TABLE IF NOT EXISTS campaign.schema_migrations (
+    version    INTEGER PRIMARY KEY,
+    name       TEXT NOT NULL,
+    applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+)`
+
+// Current returns the highest applied migration version.
+func Current(ctx context.Context, conn store.Connection) (int, error) {
+	version := 0
+	err := conn.QueryRow(ctx, `SELECT COALESCE(MAX(version), 0) FROM campaign.schema_migrations`).Scan(&version)
+	return version, err
+}
+
+// Apply runs every pending migration in a single transaction.
+func Apply(ctx context.Context, conn store.Connection) error {
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock($1)`, lockID); err != nil {
+		return fmt.Errorf("unable to acquire migration lock: %w", err)
+	}
+	if _, err := tx.Exec(ctx, createTable); err != nil {
+		return fmt.Errorf("unable to create migration table: %w", err)
+	}
+	current := 0
+	if err := tx.QueryRow(ctx, `SELECT COALESCE(MAX(version), 0) FROM campaign.schema_migrations`).Scan(&current); err != nil {
+		return err
+	}
+	for _, m := range All() {
+		if m.Version <= current {
+			continue
+		}
+		slog.InfoContext(ctx, "applying migration", "version", m.Version, "name", m.Name)
+		if _, err := tx.Exec(ctx, m.SQL); err != nil {
+			return fmt.Errorf("migration %04d_%s failed: %w", m.Version, m.Name, err)
+		}
+		if _, err := tx.Exec(ctx, `INSERT INTO campaign.schema_migrations (version, name) VALUES ($1, $2)`, m.Version, m.Name); err != nil {
+			return err
+		}
+	}
+	return tx.Commit(ctx)
+}
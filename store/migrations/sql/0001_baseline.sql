@@ -0,0 +1,39 @@
+-- Tables originally created by datamonster.records. Every statement is guarded so
+-- databases initialised by that project are left untouched.
+CREATE SCHEMA IF NOT EXISTS campaign;
+
+CREATE TABLE IF NOT EXISTS campaign.settlement (
+    id                   SERIAL PRIMARY KEY,
+    owner                TEXT    NOT NULL,
+    name                 TEXT    NOT NULL,
+    survival_limit       INTEGER NOT NULL DEFAULT 1,
+    departing_survival   INTEGER NOT NULL DEFAULT 0,
+    collective_cognition INTEGER NOT NULL DEFAULT 0,
+    year                 INTEGER NOT NULL DEFAULT 1
+);
+
+CREATE INDEX IF NOT EXISTS settlement_owner_idx ON campaign.settlement (owner);
+
+CREATE TABLE IF NOT EXISTS campaign.survivor (
+    id                SERIAL PRIMARY KEY,
+    settlement        INTEGER NOT NULL REFERENCES campaign.settlement (id) ON DELETE CASCADE,
+    name              TEXT    NOT NULL,
+    gender            TEXT    NOT NULL,
+    birth             INTEGER NOT NULL DEFAULT 0,
+    huntxp            INTEGER NOT NULL DEFAULT 0,
+    survival          INTEGER NOT NULL DEFAULT 0,
+    movement          INTEGER NOT NULL DEFAULT 5,
+    accuracy          INTEGER NOT NULL DEFAULT 0,
+    strength          INTEGER NOT NULL DEFAULT 0,
+    evasion           INTEGER NOT NULL DEFAULT 0,
+    luck              INTEGER NOT NULL DEFAULT 0,
+    speed             INTEGER NOT NULL DEFAULT 0,
+    insanity          INTEGER NOT NULL DEFAULT 0,
+    systemic_pressure INTEGER NOT NULL DEFAULT 0,
+    torment           INTEGER NOT NULL DEFAULT 0,
+    lumi              INTEGER NOT NULL DEFAULT 0,
+    courage           INTEGER NOT NULL DEFAULT 0,
+    understanding     INTEGER NOT NULL DEFAULT 0,
+    status            TEXT,
+    UNIQUE (settlement, name)
+);
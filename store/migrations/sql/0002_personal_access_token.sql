@@ -0,0 +1,16 @@
+-- Personal access tokens let players script against the API. Only a SHA-256
+-- hash of each token is stored; the token itself is shown once when created.
+CREATE TABLE campaign.personal_access_token (
+    id           SERIAL PRIMARY KEY,
+    owner        TEXT        NOT NULL,
+    name         TEXT        NOT NULL,
+    prefix       TEXT        NOT NULL,
+    hash         TEXT        NOT NULL UNIQUE,
+    scopes       TEXT[]      NOT NULL,
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT now(),
+    expires_at   TIMESTAMPTZ NOT NULL,
+    last_used_at TIMESTAMPTZ,
+    revoked_at   TIMESTAMPTZ
+);
+
+CREATE INDEX personal_access_token_owner_idx ON campaign.personal_access_token (owner) WHERE revoked_at IS NULL;
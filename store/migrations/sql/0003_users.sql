@@ -0,0 +1,10 @@
+-- Profiles are created the first time a subject calls the API.
+CREATE TABLE campaign.users (
+    id                 SERIAL PRIMARY KEY,
+    subject            TEXT        NOT NULL UNIQUE,
+    display_name       TEXT        NOT NULL DEFAULT '',
+    default_expansions TEXT[]      NOT NULL DEFAULT '{}',
+    preferences        JSONB       NOT NULL DEFAULT '{}',
+    created_at         TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at         TIMESTAMPTZ NOT NULL DEFAULT now()
+);
@@ -0,0 +1,10 @@
+-- Token buckets shared by every API instance. They are cheap to rebuild, so the
+-- table is unlogged rather than paying for write-ahead logging on each request.
+CREATE UNLOGGED TABLE campaign.rate_limit (
+    key        TEXT PRIMARY KEY,
+    tokens     DOUBLE PRECISION NOT NULL,
+    allowed    BOOLEAN          NOT NULL,
+    updated_at TIMESTAMPTZ      NOT NULL
+);
+
+CREATE INDEX rate_limit_updated_at_idx ON campaign.rate_limit (updated_at);
@@ -0,0 +1,11 @@
+-- Disorders a survivor suffers from, filterable when listing survivors.
+ALTER TABLE campaign.survivor ADD COLUMN disorders TEXT[] NOT NULL DEFAULT '{}';
+
+CREATE INDEX survivor_disorders_idx ON campaign.survivor USING GIN (disorders);
+
+-- Lists are paged in (sort column, id) order. The (settlement, name) unique
+-- constraint already covers survivors sorted by name.
+CREATE INDEX survivor_settlement_birth_idx ON campaign.survivor (settlement, birth, id);
+CREATE INDEX survivor_settlement_huntxp_idx ON campaign.survivor (settlement, huntxp, id);
+CREATE INDEX settlement_owner_name_idx ON campaign.settlement (owner, name, id);
+CREATE INDEX settlement_owner_year_idx ON campaign.settlement (owner, year, id);
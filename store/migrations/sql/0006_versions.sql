@@ -0,0 +1,4 @@
+-- Versions change on every update. They are exposed as entity tags so that
+-- clients can make updates conditional on the version they last read.
+ALTER TABLE campaign.settlement ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE campaign.survivor ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
@@ -0,0 +1,17 @@
+-- Every change to a settlement or one of its survivors is appended here with
+-- snapshots of the record before and after it. Rows are never updated; an undo
+-- is itself an event naming the event it reverts.
+CREATE TABLE campaign.event (
+    id          BIGSERIAL PRIMARY KEY,
+    settlement  INTEGER     NOT NULL REFERENCES campaign.settlement (id) ON DELETE CASCADE,
+    entity      TEXT        NOT NULL CHECK (entity IN ('settlement', 'survivor')),
+    entity_id   INTEGER     NOT NULL,
+    action      TEXT        NOT NULL CHECK (action IN ('create', 'update', 'undo')),
+    actor       TEXT        NOT NULL,
+    occurred_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    before      JSONB,
+    after       JSONB,
+    reverts     BIGINT      UNIQUE REFERENCES campaign.event (id)
+);
+
+CREATE INDEX event_settlement_idx ON campaign.event (settlement, id);
@@ -0,0 +1,39 @@
+-- Domain events from which the state of a settlement is derived, numbered per
+-- settlement in the order they happened.
+CREATE TABLE campaign.settlement_event (
+    settlement  INTEGER     NOT NULL REFERENCES campaign.settlement (id) ON DELETE CASCADE,
+    seq         INTEGER     NOT NULL,
+    type        TEXT        NOT NULL,
+    payload     JSONB       NOT NULL,
+    actor       TEXT        NOT NULL,
+    occurred_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (settlement, seq)
+);
+
+-- States rebuilt from the events, so that rebuilding a settlement only replays
+-- the events that follow its latest snapshot. reached_year is the latest lantern
+-- year the settlement had reached by seq.
+CREATE TABLE campaign.settlement_snapshot (
+    settlement   INTEGER NOT NULL REFERENCES campaign.settlement (id) ON DELETE CASCADE,
+    seq          INTEGER NOT NULL,
+    reached_year INTEGER NOT NULL,
+    state        JSONB   NOT NULL,
+    PRIMARY KEY (settlement, seq)
+);
+
+-- Existing settlements get the stream of what is known about them: their
+-- founding, the survivors born in each lantern year and the years that passed.
+INSERT INTO campaign.settlement_event (settlement, seq, type, payload, actor)
+SELECT settlement, row_number() OVER (PARTITION BY settlement ORDER BY year, position, id), type, payload, actor
+FROM (
+    SELECT id AS settlement, 1 AS year, 0 AS position, 0 AS id, 'SettlementFounded' AS type,
+           jsonb_build_object('name', name) AS payload, owner AS actor
+    FROM campaign.settlement
+    UNION ALL
+    SELECT s.settlement, GREATEST(s.birth, 1), 1, s.id, 'SurvivorBorn',
+           jsonb_build_object('survivorId', s.id, 'name', s.name, 'gender', s.gender, 'birth', s.birth), t.owner
+    FROM campaign.survivor s JOIN campaign.settlement t ON t.id = s.settlement
+    UNION ALL
+    SELECT t.id, y - 1, 2, 0, 'LanternYearAdvanced', jsonb_build_object('year', y), t.owner
+    FROM campaign.settlement t CROSS JOIN LATERAL generate_series(2, t.year) AS y
+) AS backfill;
@@ -12,22 +12,31 @@ type MockConnection struct {
 	Rows pgx.Rows
 	Row  pgx.Row
 	err  error
+	// SQL and Args record the last statement run.
+	SQL  string
+	Args []any
+	// Statements records every statement run, in order.
+	Statements []Statement
+	// Committed reports whether a transaction was committed.
+	Committed bool
 }
 
 func (c MockConnection) Close() {
 	fmt.Println("Close called")
 }
-func (c MockConnection) Begin(ctx context.Context) (pgx.Tx, error) {
-	panic("not implemented")
+func (c *MockConnection) Begin(ctx context.Context) (pgx.Tx, error) {
+	return &MockTx{conn: c}, nil
 }
-func (c MockConnection) Exec(ctx context.Context, sql string, arguments ...interface{}) (pgconn.CommandTag, error) {
+func (c *MockConnection) Exec(ctx context.Context, sql string, arguments ...interface{}) (pgconn.CommandTag, error) {
+	c.record(sql, arguments)
 	tag := pgconn.NewCommandTag("tag")
 	if c.err != nil {
 		return tag, c.err
 	}
 	return tag, nil
 }
-func (c MockConnection) Query(ctx context.Context, sql string, optionsAndArgs ...interface{}) (pgx.Rows, error) {
+func (c *MockConnection) Query(ctx context.Context, sql string, optionsAndArgs ...interface{}) (pgx.Rows, error) {
+	c.record(sql, optionsAndArgs)
 	if c.err != nil {
 		return nil, c.err
 	}
@@ -36,7 +45,11 @@ func (c MockConnection) Query(ctx context.Context, sql string, optionsAndArgs ..
 	}
 	return c.Rows, nil
 }
-func (c MockConnection) QueryRow(ctx context.Context, sql string, optionsAndArgs ...interface{}) pgx.Row {
+func (c *MockConnection) QueryRow(ctx context.Context, sql string, optionsAndArgs ...interface{}) pgx.Row {
+	c.record(sql, optionsAndArgs)
+	if c.err != nil {
+		return &ErrorRow{Error: c.err}
+	}
 	if c.Row == nil {
 		panic("row field not set")
 	}
@@ -55,3 +68,14 @@ func (c *MockConnection) SetRow(row pgx.Row) {
 func (c *MockConnection) SetError(err error) {
 	c.err = err
 }
+
+// Statement is a statement run on a MockConnection.
+type Statement struct {
+	SQL  string
+	Args []any
+}
+
+func (c *MockConnection) record(sql string, args []any) {
+	c.SQL, c.Args = sql, args
+	c.Statements = append(c.Statements, Statement{SQL: sql, Args: args})
+}
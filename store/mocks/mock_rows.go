@@ -6,7 +6,10 @@ import (
 )
 
 type MockRows struct {
-	Rows    []pgx.Row
+	Rows []pgx.Row
+	// Error is reported by Err once the rows are read, as when the connection
+	// is lost part way through a result.
+	Error   error
 	cusrsor int
 }
 
@@ -14,7 +17,7 @@ func (sr MockRows) Close() {
 
 }
 func (sr *MockRows) Err() error {
-	panic("implement me")
+	return sr.Error
 }
 func (sr *MockRows) CommandTag() pgconn.CommandTag {
 	panic("implement me")
@@ -60,3 +63,16 @@ func (ir *InsertRow) Scan(dest ...any) error {
 	*id = ir.Id
 	return nil
 }
+
+// RowSequence answers successive scans with successive rows, for code that
+// runs several single row queries.
+type RowSequence struct {
+	Rows []pgx.Row
+	next int
+}
+
+func (rs *RowSequence) Scan(dest ...any) error {
+	row := rs.Rows[rs.next]
+	rs.next++
+	return row.Scan(dest...)
+}
@@ -0,0 +1,65 @@
+package mocks
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// MockTx runs its statements on the connection that began it and records how
+// it ended.
+type MockTx struct {
+	conn       *MockConnection
+	Committed  bool
+	RolledBack bool
+}
+
+func (tx *MockTx) Begin(ctx context.Context) (pgx.Tx, error) {
+	return tx, nil
+}
+
+func (tx *MockTx) Commit(ctx context.Context) error {
+	tx.Committed = true
+	tx.conn.Committed = true
+	return nil
+}
+
+func (tx *MockTx) Rollback(ctx context.Context) error {
+	if !tx.Committed {
+		tx.RolledBack = true
+	}
+	return nil
+}
+
+func (tx *MockTx) CopyFrom(ctx context.Context, tableName pgx.Identifier, columnNames []string, rowSrc pgx.CopyFromSource) (int64, error) {
+	panic("not implemented")
+}
+
+func (tx *MockTx) SendBatch(ctx context.Context, b *pgx.Batch) pgx.BatchResults {
+	panic("not implemented")
+}
+
+func (tx *MockTx) LargeObjects() pgx.LargeObjects {
+	panic("not implemented")
+}
+
+func (tx *MockTx) Prepare(ctx context.Context, name, sql string) (*pgconn.StatementDescription, error) {
+	panic("not implemented")
+}
+
+func (tx *MockTx) Exec(ctx context.Context, sql string, arguments ...any) (pgconn.CommandTag, error) {
+	return tx.conn.Exec(ctx, sql, arguments...)
+}
+
+func (tx *MockTx) Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error) {
+	return tx.conn.Query(ctx, sql, args...)
+}
+
+func (tx *MockTx) QueryRow(ctx context.Context, sql string, args ...any) pgx.Row {
+	return tx.conn.QueryRow(ctx, sql, args...)
+}
+
+func (tx *MockTx) Conn() *pgx.Conn {
+	return nil
+}
@@ -0,0 +1,11 @@
+package store
+
+import "context"
+
+// Owned fails with a not found error unless the settlement exists and belongs
+// to owner, so that other users cannot tell it exists.
+func Owned(ctx context.Context, q Querier, owner string, settlementId int) error {
+	var id int
+	err := q.QueryRow(ctx, `SELECT id FROM campaign.settlement WHERE id = $1 AND owner = $2`, settlementId, owner).Scan(&id)
+	return TranslateError(err, "settlement")
+}
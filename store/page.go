@@ -0,0 +1,54 @@
+package store
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Page selects one page of rows ordered by Column and then by id. Pages are
+// found by position rather than offset, so they stay stable while rows are
+// added. Column is written into the query and must never come from user input.
+type Page struct {
+	Column string
+	Desc   bool
+	Limit  int
+	// After is the position of the last row of the previous page, nil on the
+	// first page.
+	After *Position
+}
+
+// Position identifies a row by its sort column value and id.
+type Position struct {
+	Value any
+	Id    int
+}
+
+// Apply completes base, a query without WHERE clause, with the conditions in
+// where and the page's own condition, ordering and limit, appending their
+// arguments to args. One row more than Limit is selected so that callers can
+// tell, using More, whether another page follows.
+func (p Page) Apply(base string, where []string, args []any) (string, []any) {
+	dir, cmp := "ASC", ">"
+	if p.Desc {
+		dir, cmp = "DESC", "<"
+	}
+	if p.After != nil {
+		args = append(args, p.After.Value, p.After.Id)
+		where = append(where, fmt.Sprintf("(%s, id) %s ($%d, $%d)", p.Column, cmp, len(args)-1, len(args)))
+	}
+	query := base
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	args = append(args, p.Limit+1)
+	query += fmt.Sprintf(" ORDER BY %s %s, id %s LIMIT $%d", p.Column, dir, dir, len(args))
+	return query, args
+}
+
+// More trims the extra row Apply selects and reports whether there was one.
+func More[T any](rows []T, p Page) ([]T, bool) {
+	if len(rows) > p.Limit {
+		return rows[:p.Limit], true
+	}
+	return rows, false
+}
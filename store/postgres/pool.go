@@ -2,26 +2,27 @@ package pool
 
 import (
 	"context"
-	"log"
-	"os"
+	"fmt"
 
+	"github.com/failuretoload/datamonster/config"
 	pgxuuid "github.com/jackc/pgx-gofrs-uuid"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
-func InitConnPool(ctx context.Context) *pgxpool.Pool {
-	dbconfig, err := pgxpool.ParseConfig(os.Getenv("CONN_STRING"))
+func InitConnPool(ctx context.Context, cfg config.Database) (*pgxpool.Pool, error) {
+	dbconfig, err := pgxpool.ParseConfig(cfg.ConnString)
 	if err != nil {
-		log.Fatalf("Unable to parse db config: %v\n", err)
+		return nil, fmt.Errorf("unable to parse db config: %w", err)
 	}
+	dbconfig.ConnConfig.Tracer = queryTracer{}
 	dbconfig.AfterConnect = func(ctx context.Context, conn *pgx.Conn) error {
 		pgxuuid.Register(conn.TypeMap())
 		return nil
 	}
 	dbpool, err := pgxpool.NewWithConfig(ctx, dbconfig)
 	if err != nil {
-		log.Fatalf("Unable to create connection pool: %v\n", err)
+		return nil, fmt.Errorf("unable to create connection pool: %w", err)
 	}
-	return dbpool
+	return dbpool, nil
 }
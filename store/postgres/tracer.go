@@ -0,0 +1,61 @@
+package pool
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/failuretoload/datamonster/logging"
+	"github.com/failuretoload/datamonster/tracing"
+	"github.com/jackc/pgx/v5"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// queryTracer records a span for every statement and logs it at debug level
+// with the logger of the request that ran it. Only the SQL text is recorded;
+// arguments never are.
+type queryTracer struct{}
+
+type queryStart struct {
+	sql   string
+	start time.Time
+	span  trace.Span
+}
+
+type ctxQueryKey struct{}
+
+func (queryTracer) TraceQueryStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	ctx, span := tracing.Start(ctx, operation(data.SQL),
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.DBSystemPostgreSQL, semconv.DBStatement(data.SQL)),
+	)
+	return context.WithValue(ctx, ctxQueryKey{}, queryStart{sql: data.SQL, start: time.Now(), span: span})
+}
+
+func (queryTracer) TraceQueryEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryEndData) {
+	q, ok := ctx.Value(ctxQueryKey{}).(queryStart)
+	if !ok {
+		return
+	}
+	defer q.span.End()
+	logger := logging.FromContext(ctx)
+	if data.Err != nil {
+		q.span.RecordError(data.Err)
+		q.span.SetStatus(codes.Error, data.Err.Error())
+		logger.LogAttrs(ctx, slog.LevelDebug, "query failed", slog.String("sql", q.sql), slog.Duration("duration", time.Since(q.start)), slog.Any("error", data.Err))
+		return
+	}
+	logger.LogAttrs(ctx, slog.LevelDebug, "query", slog.String("sql", q.sql), slog.Duration("duration", time.Since(q.start)), slog.String("command", data.CommandTag.String()))
+}
+
+// operation names a query span after the statement's leading keyword, such as
+// SELECT or INSERT, to keep span names low cardinality.
+func operation(sql string) string {
+	if fields := strings.Fields(sql); len(fields) > 0 {
+		return "postgres " + strings.ToUpper(fields[0])
+	}
+	return "postgres"
+}
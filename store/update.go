@@ -0,0 +1,52 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/failuretoload/datamonster/domain"
+)
+
+// Update collects the assignments of a partial update and the arguments of the
+// statement applying them.
+type Update struct {
+	sets []string
+	Args []any
+}
+
+// Arg adds an argument to the statement and returns its placeholder.
+func (u *Update) Arg(value any) string {
+	u.Args = append(u.Args, value)
+	return fmt.Sprintf("$%d", len(u.Args))
+}
+
+// Set assigns value to column. column is written into the statement and must
+// never come from user input.
+func (u *Update) Set(column string, value any) {
+	u.sets = append(u.sets, column+" = "+u.Arg(value))
+}
+
+// SetIf assigns *value to column unless value is nil.
+func SetIf[T any](u *Update, column string, value *T) {
+	if value != nil {
+		u.Set(column, *value)
+	}
+}
+
+// Assignments returns the SET list. The version column is always incremented so
+// that every update produces a new entity tag.
+func (u *Update) Assignments() string {
+	return strings.Join(append(u.sets, "version = version + 1"), ", ")
+}
+
+// Stale explains why a conditional update of entity matched no row: either the
+// row has moved on to another version or it does not exist. exists must select
+// the version of the row the update targeted.
+func Stale(ctx context.Context, conn Connection, entity string, exists string, args ...any) error {
+	var version int
+	if err := conn.QueryRow(ctx, exists, args...).Scan(&version); err != nil {
+		return TranslateError(err, entity)
+	}
+	return domain.PreconditionFailed("%s has changed, its current version is %d", entity, version)
+}
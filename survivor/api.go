@@ -2,12 +2,16 @@ package survivor
 
 import (
 	"context"
-	"encoding/json"
-	"errors"
 	"fmt"
 	"net/http"
-	"strconv"
+	"strings"
 
+	"github.com/failuretoload/datamonster/auth"
+	"github.com/failuretoload/datamonster/history"
+	"github.com/failuretoload/datamonster/metrics"
+	"github.com/failuretoload/datamonster/notify"
+	"github.com/failuretoload/datamonster/openapi"
+	"github.com/failuretoload/datamonster/ratelimit"
 	"github.com/failuretoload/datamonster/store"
 	repo "github.com/failuretoload/datamonster/survivor/internal"
 	"github.com/failuretoload/datamonster/web"
@@ -15,85 +19,353 @@ import (
 	"github.com/go-chi/chi/v5"
 )
 
+// Scopes machine client tokens need to use the survivor routes.
+const (
+	ScopeRead  = "survivors:read"
+	ScopeWrite = "survivors:write"
+)
+
+// createBudget limits how quickly a caller can add survivors.
+var createBudget = ratelimit.PerMinute(60)
+
+// Controller serves survivors and announces their changes to publisher.
 type Controller struct {
-	db *repo.PostGresRepo
+	db        *repo.PostGresRepo
+	pool      store.Connection
+	publisher notify.Publisher
 }
 
-func NewController(conn store.Connection) *Controller {
+func NewController(conn store.Connection, publisher notify.Publisher) *Controller {
 	r := repo.NewRepo(conn)
-	return &Controller{db: r}
+	return &Controller{db: r, pool: conn, publisher: publisher}
 }
 
 func (c Controller) RegisterRoutes(r chi.Router) {
-	r.Use(settlementIdExtractor)
-	r.Get("/settlements/{id}/survivors", c.getSurvivors)
-	r.Post("/settlements/{id}/survivors", c.createSurvivor)
+	r.Group(func(r chi.Router) {
+		r.Use(c.ownedSettlement)
+		r.With(auth.RequireScope(ScopeRead)).Get("/settlements/{id}/survivors", c.getSurvivors)
+		r.With(auth.RequireScope(ScopeWrite), ratelimit.Limit("survivors.create", createBudget)).Post("/settlements/{id}/survivors", c.createSurvivor)
+		r.With(auth.RequireScope(ScopeRead)).Get("/settlements/{id}/survivors/{survivorId}", c.getSurvivor)
+		r.With(auth.RequireScope(ScopeWrite)).Patch("/settlements/{id}/survivors/{survivorId}", c.updateSurvivor)
+	})
+}
+
+func (c Controller) DescribeRoutes(d *openapi.Document) {
+	tags := []string{"survivors"}
+	d.Add(openapi.Route{Method: http.MethodGet, Path: "/settlements/{id}/survivors", ID: "listSurvivors", Summary: "List a page of the survivors of a settlement, linking to the next page in the Link header; as CSV or XLSX, export every survivor with every field and the columns derived from them", Tags: tags, Params: openapi.QueryParams(SurvivorQuery{}), Scopes: []string{ScopeRead}, Response: []SurvivorDTO{}, Table: true})
+	d.Add(openapi.Route{Method: http.MethodPost, Path: "/settlements/{id}/survivors", ID: "createSurvivor", Summary: "Add a survivor to a settlement", Tags: tags, Scopes: []string{ScopeWrite}, Request: SurvivorDTO{}, Status: http.StatusNoContent})
+	d.Add(openapi.Route{Method: http.MethodGet, Path: "/settlements/{id}/survivors/{survivorId}", ID: "getSurvivor", Summary: "Get a survivor, answering 304 when If-None-Match names its ETag", Tags: tags, Scopes: []string{ScopeRead}, Response: SurvivorDTO{}})
+	d.Add(openapi.Route{Method: http.MethodPatch, Path: "/settlements/{id}/survivors/{survivorId}", ID: "updateSurvivor", Summary: "Update a survivor, answering 412 when If-Match does not name its current ETag", Tags: tags, Scopes: []string{ScopeWrite}, Request: UpdateSurvivorRequest{}, Response: SurvivorDTO{}})
 }
 
 func (c Controller) getSurvivors(w http.ResponseWriter, r *http.Request) {
-	param := chi.URLParam(r, "id")
-	settlementId, convErr := strconv.Atoi(param)
-	if convErr != nil {
-		web.MakeJsonResponse(w, http.StatusInternalServerError, "unable to convert query param")
+	settlementId, ok := web.PathId(w, r, "id", "settlement")
+	if !ok {
+		return
+	}
+	query := SurvivorQuery{Limit: 50, Sort: "name"}
+	if err := web.DecodeQuery(r, &query); err != nil {
+		web.WriteError(w, r, err)
+		return
+	}
+	mediaType, err := web.NegotiateList(r)
+	if err != nil {
+		web.WriteError(w, r, err)
 		return
 	}
-	survivors, err := c.db.GetAllSurvivorsForSettlement(r.Context(), settlementId)
+	page, err := pageFor(query)
 	if err != nil {
-		web.MakeJsonResponse(w, http.StatusInternalServerError, "Error retrieving survivors")
+		web.WriteError(w, r, err)
 		return
 	}
+	filter := repo.Filter{Statuses: query.Status, Genders: query.Gender, Living: query.Living, Disorder: query.Disorder}
+	if mediaType != web.MediaJSON {
+		c.exportSurvivors(w, r, settlementId, filter, page, mediaType)
+		return
+	}
+	survivors, more, err := c.db.ListSurvivors(r.Context(), settlementId, filter, page)
+	if err != nil {
+		web.WriteError(w, r, err)
+		return
+	}
+	if more {
+		web.SetNextPage(w, r, cursorAfter(survivors[len(survivors)-1], query.Sort))
+	}
 	data := dtoListFromDomain(survivors)
 	web.MakeJsonResponse(w, http.StatusOK, data)
 }
 
+// exportPage is how many survivors an export reads at a time.
+const exportPage = 200
+
+// exportSurvivors writes every survivor filter selects, in the order of page,
+// as a CSV or XLSX table. Unlike JSON lists, exports are not paged, so the limit
+// and cursor of page are ignored.
+func (c Controller) exportSurvivors(w http.ResponseWriter, r *http.Request, settlementId int, filter repo.Filter, page store.Page, mediaType string) {
+	page.Limit, page.After = exportPage, nil
+	rows := []SheetRow{}
+	for {
+		survivors, more, err := c.db.ListSurvivors(r.Context(), settlementId, filter, page)
+		if err != nil {
+			web.WriteError(w, r, err)
+			return
+		}
+		rows = append(rows, rowsFromDTOs(dtoListFromDomain(survivors))...)
+		if !more {
+			break
+		}
+		page.After = positionOf(survivors[len(survivors)-1], page.Column)
+	}
+	if err := web.WriteTable(w, mediaType, fmt.Sprintf("settlement-%d-survivors", settlementId), rows); err != nil {
+		web.WriteError(w, r, err)
+	}
+}
+
 func (c Controller) createSurvivor(w http.ResponseWriter, r *http.Request) {
-	param := chi.URLParam(r, "id")
-	settlementId, convErr := strconv.Atoi(param)
-	if convErr != nil {
-		web.MakeJsonResponse(w, http.StatusInternalServerError, "settlement id must be a positive integer")
+	settlementId, ok := web.PathId(w, r, "id", "settlement")
+	if !ok {
 		return
 	}
 	survivorDTO := SurvivorDTO{}
-	decodeErr := json.NewDecoder(r.Body).Decode(&survivorDTO)
-	if decodeErr != nil || survivorDTO.Name == "" {
-		web.MakeJsonResponse(w, http.StatusInternalServerError, "unable to decode request body")
+	if err := web.DecodeAndValidate(w, r, &survivorDTO); err != nil {
+		web.WriteError(w, r, err)
 		return
 	}
 	survivorDTO.Settlement = settlementId
-	err := c.db.CreateSurvivor(r.Context(), domainFromDTO(survivorDTO))
+	survivorId, err := c.db.CreateSurvivor(r.Context(), domainFromDTO(survivorDTO))
 	if err != nil {
-		dupError := repo.DuplicateNameError{}
-		if errors.As(err, &dupError) {
-			web.MakeJsonResponse(w, http.StatusBadRequest, fmt.Sprintf("survivor with name %s already exists", survivorDTO.Name))
-			return
-		}
-		web.MakeJsonResponse(w, http.StatusInternalServerError, fmt.Sprintf("error creating survivor %s", survivorDTO.Name))
+		web.WriteError(w, r, err)
 		return
 	}
+	c.publisher.Publish(r.Context(), notify.Change{Settlement: settlementId, Entity: history.EntitySurvivor, EntityId: survivorId, Action: history.ActionCreate, Version: 1})
+	if survivorDTO.Status != nil && *survivorDTO.Status == StatusDead {
+		metrics.SurvivorsDied.Inc()
+	}
 	web.MakeJsonResponse(w, http.StatusNoContent, nil)
 }
 
+func (c Controller) getSurvivor(w http.ResponseWriter, r *http.Request) {
+	settlementId, survivorId, ok := survivorIds(w, r)
+	if !ok {
+		return
+	}
+	survivor, err := c.db.GetSurvivor(r.Context(), settlementId, survivorId)
+	if err != nil {
+		web.WriteError(w, r, err)
+		return
+	}
+	if web.NotModified(w, r, survivor.Version) {
+		return
+	}
+	web.MakeJsonResponse(w, http.StatusOK, dtoFromDomain(survivor))
+}
+
+func (c Controller) updateSurvivor(w http.ResponseWriter, r *http.Request) {
+	settlementId, survivorId, ok := survivorIds(w, r)
+	if !ok {
+		return
+	}
+	version, err := web.IfMatch(r)
+	if err != nil {
+		web.WriteError(w, r, err)
+		return
+	}
+	var body UpdateSurvivorRequest
+	if err := web.DecodeAndValidate(w, r, &body); err != nil {
+		web.WriteError(w, r, err)
+		return
+	}
+	changes := repo.Changes{
+		Name:             body.Name,
+		Status:           body.Status,
+		HuntXp:           body.HuntXp,
+		Survival:         body.Survival,
+		Movement:         body.Movement,
+		Accuracy:         body.Accuracy,
+		Strength:         body.Strength,
+		Evasion:          body.Evasion,
+		Luck:             body.Luck,
+		Speed:            body.Speed,
+		Insanity:         body.Insanity,
+		SystemicPressure: body.SystemicPressure,
+		Torment:          body.Torment,
+		Lumi:             body.Lumi,
+		Courage:          body.Courage,
+		Understanding:    body.Understanding,
+		Disorders:        body.Disorders,
+	}
+	if body.Status != nil && *body.Status == StatusActive {
+		changes.Status = new(string)
+	}
+	survivor, previous, err := c.db.UpdateSurvivor(r.Context(), settlementId, survivorId, version, changes)
+	if err != nil {
+		web.WriteError(w, r, err)
+		return
+	}
+	c.publisher.Publish(r.Context(), notify.Change{Settlement: settlementId, Entity: history.EntitySurvivor, EntityId: survivorId, Action: history.ActionUpdate, Version: survivor.Version})
+	if isDead(survivor.Status) && !isDead(previous) {
+		metrics.SurvivorsDied.Inc()
+	}
+	web.SetETag(w, survivor.Version)
+	web.MakeJsonResponse(w, http.StatusOK, dtoFromDomain(survivor))
+}
+
+// survivorIds reads the settlement and survivor ids of the request's path,
+// answering 400 when either is not a number.
+func survivorIds(w http.ResponseWriter, r *http.Request) (int, int, bool) {
+	settlementId, ok := web.PathId(w, r, "id", "settlement")
+	if !ok {
+		return 0, 0, false
+	}
+	survivorId, ok := web.PathId(w, r, "survivorId", "survivor")
+	return settlementId, survivorId, ok
+}
+
+func isDead(status *string) bool {
+	return status != nil && *status == StatusDead
+}
+
+const (
+	StatusDead      = "dead"
+	StatusRetired   = "retired"
+	StatusSkipsHunt = "skipsHunt"
+	// StatusActive clears a survivor's status in an update.
+	StatusActive = "active"
+)
+
 type SurvivorDTO struct {
-	Id               int     `json:"id"`
-	Settlement       int     `json:"settlement"`
-	Name             string  `json:"name"`
-	Birth            int     `json:"birth"`
-	Gender           string  `json:"gender"`
-	Status           *string `json:"status,omitempty"`
-	HuntXp           int     `json:"huntXp"`
-	Survival         int     `json:"survival"`
-	Movement         int     `json:"movement"`
-	Accuracy         int     `json:"accuracy"`
-	Strength         int     `json:"strength"`
-	Evasion          int     `json:"evasion"`
-	Luck             int     `json:"luck"`
-	Speed            int     `json:"speed"`
-	Insanity         int     `json:"insanity"`
-	SystemicPressure int     `json:"systemicPressure"`
-	Torment          int     `json:"torment"`
-	Lumi             int     `json:"lumi"`
-	Courage          int     `json:"courage"`
-	Understanding    int     `json:"understanding"`
+	Id               int      `json:"id"`
+	Settlement       int      `json:"settlement"`
+	Name             string   `json:"name" validate:"required,max=64"`
+	Birth            int      `json:"birth" validate:"min=0,max=99"`
+	Gender           string   `json:"gender" validate:"required,oneof=M F"`
+	Status           *string  `json:"status,omitempty" validate:"oneof=dead retired skipsHunt"`
+	HuntXp           int      `json:"huntXp" validate:"min=0,max=16"`
+	Survival         int      `json:"survival" validate:"min=0,max=99"`
+	Movement         int      `json:"movement" validate:"min=0,max=99"`
+	Accuracy         int      `json:"accuracy" validate:"min=-99,max=99"`
+	Strength         int      `json:"strength" validate:"min=-99,max=99"`
+	Evasion          int      `json:"evasion" validate:"min=-99,max=99"`
+	Luck             int      `json:"luck" validate:"min=-99,max=99"`
+	Speed            int      `json:"speed" validate:"min=-99,max=99"`
+	Insanity         int      `json:"insanity" validate:"min=0,max=999"`
+	SystemicPressure int      `json:"systemicPressure" validate:"min=0,max=99"`
+	Torment          int      `json:"torment" validate:"min=0,max=99"`
+	Lumi             int      `json:"lumi" validate:"min=0,max=999"`
+	Courage          int      `json:"courage" validate:"min=0,max=9"`
+	Understanding    int      `json:"understanding" validate:"min=0,max=9"`
+	Disorders        []string `json:"disorders" validate:"max=3"`
+	Version          int      `json:"version"`
+}
+
+// UpdateSurvivorRequest changes the fields it includes.
+type UpdateSurvivorRequest struct {
+	Name             *string   `json:"name" validate:"min=1,max=64"`
+	Status           *string   `json:"status" validate:"oneof=active dead retired skipsHunt"`
+	HuntXp           *int      `json:"huntXp" validate:"min=0,max=16"`
+	Survival         *int      `json:"survival" validate:"min=0,max=99"`
+	Movement         *int      `json:"movement" validate:"min=0,max=99"`
+	Accuracy         *int      `json:"accuracy" validate:"min=-99,max=99"`
+	Strength         *int      `json:"strength" validate:"min=-99,max=99"`
+	Evasion          *int      `json:"evasion" validate:"min=-99,max=99"`
+	Luck             *int      `json:"luck" validate:"min=-99,max=99"`
+	Speed            *int      `json:"speed" validate:"min=-99,max=99"`
+	Insanity         *int      `json:"insanity" validate:"min=0,max=999"`
+	SystemicPressure *int      `json:"systemicPressure" validate:"min=0,max=99"`
+	Torment          *int      `json:"torment" validate:"min=0,max=99"`
+	Lumi             *int      `json:"lumi" validate:"min=0,max=999"`
+	Courage          *int      `json:"courage" validate:"min=0,max=9"`
+	Understanding    *int      `json:"understanding" validate:"min=0,max=9"`
+	Disorders        *[]string `json:"disorders" validate:"max=3"`
+}
+
+// SheetRow is a survivor as a row of a CSV or XLSX list: every field of the
+// survivor and what follows from them.
+type SheetRow struct {
+	SurvivorDTO
+	Living  bool `json:"living"`
+	CanHunt bool `json:"canHunt"`
+}
+
+func rowsFromDTOs(survivors []SurvivorDTO) []SheetRow {
+	rows := make([]SheetRow, len(survivors))
+	for i, s := range survivors {
+		rows[i] = SheetRow{SurvivorDTO: s, Living: !isDead(s.Status), CanHunt: s.Status == nil}
+	}
+	return rows
+}
+
+// SurvivorQuery selects the page of survivors listed. Sort names a field,
+// prefixed with - for descending order. Format overrides the Accept header;
+// CSV and XLSX exports hold every survivor and ignore Limit and Cursor.
+type SurvivorQuery struct {
+	Limit    int      `query:"limit" validate:"min=1,max=200"`
+	Cursor   string   `query:"cursor"`
+	Sort     string   `query:"sort" validate:"oneof=name -name birth -birth huntXp -huntXp"`
+	Status   []string `query:"status" validate:"oneof=dead retired skipsHunt"`
+	Gender   []string `query:"gender" validate:"oneof=M F"`
+	Living   bool     `query:"living"`
+	Disorder string   `query:"disorder" validate:"max=64"`
+	Format   string   `query:"format"`
+}
+
+// sortColumns maps sortable fields to their columns.
+var sortColumns = map[string]string{"name": "name", "birth": "birth", "huntXp": "huntxp"}
+
+// cursor is the position of the last survivor of a page. Name holds the sort
+// value when sorting by name and Value otherwise.
+type cursor struct {
+	Sort  string `json:"s"`
+	Name  string `json:"n,omitempty"`
+	Value int    `json:"v,omitempty"`
+	Id    int    `json:"id"`
+}
+
+func pageFor(q SurvivorQuery) (store.Page, error) {
+	field, desc := strings.CutPrefix(q.Sort, "-")
+	page := store.Page{Column: sortColumns[field], Desc: desc, Limit: q.Limit}
+	if q.Cursor == "" {
+		return page, nil
+	}
+	var c cursor
+	if err := web.DecodeCursor(q.Cursor, &c); err != nil {
+		return page, err
+	}
+	if c.Sort != q.Sort {
+		return page, &web.ValidationError{Fields: []web.FieldError{{Field: "cursor", Message: "does not match the sort order"}}}
+	}
+	page.After = &store.Position{Value: c.Value, Id: c.Id}
+	if field == "name" {
+		page.After.Value = c.Name
+	}
+	return page, nil
+}
+
+// positionOf is the position of s in a page sorted by column.
+func positionOf(s repo.Survivor, column string) *store.Position {
+	p := &store.Position{Id: s.Id}
+	switch column {
+	case "name":
+		p.Value = s.Name
+	case "birth":
+		p.Value = s.Birth
+	case "huntxp":
+		p.Value = s.HuntXp
+	}
+	return p
+}
+
+func cursorAfter(s repo.Survivor, sort string) string {
+	c := cursor{Sort: sort, Id: s.Id}
+	switch strings.TrimPrefix(sort, "-") {
+	case "name":
+		c.Name = s.Name
+	case "birth":
+		c.Value = s.Birth
+	case "huntXp":
+		c.Value = s.HuntXp
+	}
+	return web.EncodeCursor(c)
 }
 
 func dtoFromDomain(s repo.Survivor) SurvivorDTO {
@@ -116,19 +388,20 @@ type ctxSettlementIdKey string
 
 const SettlementIdKey ctxSettlementIdKey = "settlementId"
 
-func settlementIdExtractor(next http.Handler) http.Handler {
+// ownedSettlement reads the settlement id of the request's path into the
+// context, answering 404 unless the settlement belongs to the caller.
+func (c Controller) ownedSettlement(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		settlementIdString := chi.URLParam(r, "id")
-		if settlementIdString != "" {
-			settlementId, convErr := strconv.Atoi(settlementIdString)
-			if convErr != nil {
-				web.MakeJsonResponse(w, http.StatusBadRequest, "settlement id should be a number")
-				return
-			}
-			ctx := context.WithValue(r.Context(), SettlementIdKey, settlementId)
-			next.ServeHTTP(w, r.WithContext(ctx))
-		} else {
-			next.ServeHTTP(w, r)
+		settlementId, ok := web.PathId(w, r, "id", "settlement")
+		if !ok {
+			return
+		}
+		p, _ := auth.PrincipalFrom(r.Context())
+		if err := store.Owned(r.Context(), c.pool, p.Subject, settlementId); err != nil {
+			web.WriteError(w, r, err)
+			return
 		}
+		ctx := context.WithValue(r.Context(), SettlementIdKey, settlementId)
+		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
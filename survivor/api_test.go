@@ -1,17 +1,28 @@
 package survivor
 
 import (
+	"archive/zip"
 	"bytes"
+	"encoding/csv"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
+	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
+	"github.com/failuretoload/datamonster/auth"
+	"github.com/failuretoload/datamonster/metrics"
+	"github.com/failuretoload/datamonster/notify"
 	storeMocks "github.com/failuretoload/datamonster/store/mocks"
+	"github.com/failuretoload/datamonster/web"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/suite"
 )
 
@@ -19,14 +30,19 @@ type SurvivorApiTestSuite struct {
 	suite.Suite
 	target *Controller
 	db     *storeMocks.MockConnection
+	hub    *notify.Hub
 	router *chi.Mux
 }
 
 func (suite *SurvivorApiTestSuite) SetupTest() {
 	suite.db = &storeMocks.MockConnection{}
-	suite.target = NewController(suite.db)
+	suite.hub = notify.NewHub()
+	suite.target = NewController(suite.db, suite.hub)
 	suite.router = chi.NewRouter()
+	suite.router.Use(asUser)
 	suite.target.RegisterRoutes(suite.router)
+	// The caller owns settlement 1; new survivors are given id 1.
+	suite.db.SetRow(&storeMocks.InsertRow{Id: 1})
 }
 
 func (suite *SurvivorApiTestSuite) Test_GetSurvivors_ReturnsSurvivorList() {
@@ -77,7 +93,7 @@ func (suite *SurvivorApiTestSuite) Test_GetSurvivors_ReturnsSurvivorList() {
 		},
 	}
 	suite.db.SetRows(&rows)
-	req := httptest.NewRequest("GET", "/settlement/1/survivor", nil)
+	req := httptest.NewRequest("GET", "/settlements/1/survivors", nil)
 	ctx := req.Context()
 	req = req.WithContext(ctx)
 	w := httptest.NewRecorder()
@@ -92,6 +108,193 @@ func (suite *SurvivorApiTestSuite) Test_GetSurvivors_ReturnsSurvivorList() {
 	suite.Equal(2, count, "2 settlements should be returned")
 }
 
+func (suite *SurvivorApiTestSuite) Test_GetSurvivors_AppliesFilters() {
+	suite.db.SetRows(&storeMocks.MockRows{Rows: []pgx.Row{
+		&SurvivorRow{Id: 3, Settlement: 1, Name: "Ezra", Gender: "F", HuntXp: 4, Disorders: []string{"Squeamish"}},
+	}})
+	w := httptest.NewRecorder()
+
+	suite.router.ServeHTTP(w, httptest.NewRequest("GET", "/settlements/1/survivors?gender=F&status=retired,skipsHunt&living=true&disorder=Squeamish&sort=-huntXp", nil))
+
+	suite.Equal(200, w.Code)
+	suite.Contains(suite.db.SQL, "FROM campaign.survivor WHERE settlement = $1 AND status = ANY($2) AND gender = ANY($3) AND status IS DISTINCT FROM 'dead' AND $4 = ANY(disorders) ORDER BY huntxp DESC, id DESC LIMIT $5")
+	suite.Equal([]any{1, []string{"retired", "skipsHunt"}, []string{"F"}, "Squeamish", 51}, suite.db.Args)
+	suite.Empty(w.Header().Get("Link"))
+	dto := []SurvivorDTO{}
+	suite.NoError(json.Unmarshal(w.Body.Bytes(), &dto))
+	suite.Equal([]string{"Squeamish"}, dto[0].Disorders)
+}
+
+func (suite *SurvivorApiTestSuite) Test_GetSurvivors_LinksToTheNextPage() {
+	suite.db.SetRows(&storeMocks.MockRows{Rows: []pgx.Row{
+		&SurvivorRow{Id: 3, Settlement: 1, Name: "Ezra", Gender: "F"},
+		&SurvivorRow{Id: 1, Settlement: 1, Name: "Zach", Gender: "M"},
+	}})
+	w := httptest.NewRecorder()
+
+	suite.router.ServeHTTP(w, httptest.NewRequest("GET", "/settlements/1/survivors?limit=1", nil))
+
+	suite.Equal(200, w.Code)
+	link := w.Header().Get("Link")
+	suite.Require().NotEmpty(link)
+	suite.db.SetRows(&storeMocks.MockRows{})
+
+	suite.router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", link[1:strings.Index(link, ">")], nil))
+
+	suite.Contains(suite.db.SQL, "(name, id) > ($2, $3)")
+	suite.Equal([]any{1, "Ezra", 3, 2}, suite.db.Args)
+}
+
+func (suite *SurvivorApiTestSuite) Test_GetSurvivors_RejectsInvalidFilters() {
+	w := httptest.NewRecorder()
+
+	suite.router.ServeHTTP(w, httptest.NewRequest("GET", "/settlements/1/survivors?gender=X&status=dead,missing&living=maybe&limit=500", nil))
+
+	suite.Equal(422, w.Code)
+	problem := web.ValidationProblem{}
+	suite.NoError(json.Unmarshal(w.Body.Bytes(), &problem))
+	suite.Equal([]web.FieldError{
+		{Field: "living", Message: "must be true or false"},
+		{Field: "limit", Message: "must be at most 200"},
+		{Field: "status", Message: "must only contain dead, retired, skipsHunt"},
+		{Field: "gender", Message: "must only contain M, F"},
+	}, problem.Errors)
+}
+
+func (suite *SurvivorApiTestSuite) Test_GetSurvivors_RejectsUnknownFormats() {
+	w := httptest.NewRecorder()
+
+	suite.router.ServeHTTP(w, httptest.NewRequest("GET", "/settlements/1/survivors?format=pdf", nil))
+
+	suite.Equal(422, w.Code)
+	problem := web.ValidationProblem{}
+	suite.NoError(json.Unmarshal(w.Body.Bytes(), &problem))
+	suite.Equal([]web.FieldError{{Field: "format", Message: "must be one of csv, json, xlsx"}}, problem.Errors)
+}
+
+func (suite *SurvivorApiTestSuite) Test_GetSurvivors_WritesCSV() {
+	retired := StatusRetired
+	suite.db.SetRows(&storeMocks.MockRows{Rows: []pgx.Row{
+		&SurvivorRow{Id: 3, Settlement: 1, Name: "=Ezra", Gender: "F", Accuracy: -1, Status: &retired, Disorders: []string{"Squeamish", "Vestiphobia"}, Version: 2},
+		&SurvivorRow{Id: 4, Settlement: 1, Name: "Zach", Gender: "M", Version: 1},
+	}})
+	w := httptest.NewRecorder()
+
+	suite.router.ServeHTTP(w, httptest.NewRequest("GET", "/settlements/1/survivors?format=csv", nil))
+
+	suite.Equal(200, w.Code)
+	suite.Equal("text/csv; charset=utf-8", w.Header().Get("Content-Type"))
+	suite.Equal(`attachment; filename="settlement-1-survivors.csv"`, w.Header().Get("Content-Disposition"))
+	records, err := csv.NewReader(w.Body).ReadAll()
+	suite.Require().NoError(err)
+	suite.Equal([]string{"id", "settlement", "name", "birth", "gender", "status", "huntXp", "survival", "movement", "accuracy", "strength", "evasion", "luck", "speed", "insanity", "systemicPressure", "torment", "lumi", "courage", "understanding", "disorders", "version", "living", "canHunt"}, records[0])
+	suite.Equal([]string{"3", "1", "'=Ezra", "0", "F", "retired", "0", "0", "0", "-1", "0", "0", "0", "0", "0", "0", "0", "0", "0", "0", "Squeamish; Vestiphobia", "2", "true", "false"}, records[1])
+	suite.Equal([]string{"4", "", "true", "true"}, []string{records[2][0], records[2][5], records[2][22], records[2][23]})
+}
+
+func (suite *SurvivorApiTestSuite) Test_GetSurvivors_ExportsEveryPage() {
+	rows := []pgx.Row{}
+	for i := 1; i <= 201; i++ {
+		rows = append(rows, &SurvivorRow{Id: i, Settlement: 1, Name: fmt.Sprintf("Survivor %03d", i), Gender: "F"})
+	}
+	suite.db.SetRows(&storeMocks.MockRows{Rows: rows})
+	w := httptest.NewRecorder()
+
+	suite.router.ServeHTTP(w, httptest.NewRequest("GET", "/settlements/1/survivors?format=csv&limit=1&gender=F", nil))
+
+	suite.Equal(200, w.Code)
+	suite.Empty(w.Header().Get("Link"), "exports are not paged")
+	suite.Require().Len(suite.db.Statements, 3, "the owner check and two pages")
+	suite.Equal([]any{1, []string{"F"}, 201}, suite.db.Statements[1].Args)
+	suite.Contains(suite.db.Statements[2].SQL, "(name, id) > ($3, $4)")
+	suite.Equal([]any{1, []string{"F"}, "Survivor 200", 200, 201}, suite.db.Statements[2].Args)
+	records, err := csv.NewReader(w.Body).ReadAll()
+	suite.Require().NoError(err)
+	suite.Len(records, 201, "the header and the survivors of the first page, which the mock does not repeat")
+}
+
+func (suite *SurvivorApiTestSuite) Test_GetSurvivors_ReportsExportErrors() {
+	suite.db.SetRows(&storeMocks.MockRows{Rows: []pgx.Row{&storeMocks.ErrorRow{Error: errors.New("connection lost")}}})
+	w := httptest.NewRecorder()
+
+	suite.router.ServeHTTP(w, httptest.NewRequest("GET", "/settlements/1/survivors?format=xlsx", nil))
+
+	suite.Equal(500, w.Code)
+	suite.Equal(web.ProblemContentType, w.Header().Get("Content-Type"))
+	suite.Empty(w.Header().Get("Content-Disposition"))
+	suite.Len(suite.db.Statements, 2)
+}
+
+func (suite *SurvivorApiTestSuite) Test_GetSurvivors_ReportsReadErrors() {
+	suite.db.SetRows(&storeMocks.MockRows{Error: errors.New("connection reset")})
+	w := httptest.NewRecorder()
+
+	suite.router.ServeHTTP(w, httptest.NewRequest("GET", "/settlements/1/survivors", nil))
+
+	suite.Equal(500, w.Code, "a partly read list should not be answered as complete")
+}
+
+func (suite *SurvivorApiTestSuite) Test_GetSurvivors_WritesXLSX() {
+	suite.db.SetRows(&storeMocks.MockRows{Rows: []pgx.Row{
+		&SurvivorRow{Id: 3, Settlement: 1, Name: "Ezra & Zach", Gender: "F"},
+	}})
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/settlements/1/survivors", nil)
+	req.Header.Set("Accept", "application/json;q=0.5, application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+
+	suite.router.ServeHTTP(w, req)
+
+	suite.Equal(200, w.Code)
+	suite.Equal(web.MediaXLSX, w.Header().Get("Content-Type"))
+	archive, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	suite.Require().NoError(err)
+	parts := map[string]string{}
+	for _, f := range archive.File {
+		r, err := f.Open()
+		suite.Require().NoError(err)
+		content, _ := io.ReadAll(r)
+		parts[f.Name] = string(content)
+	}
+	suite.Contains(parts["xl/workbook.xml"], `<sheet name="settlement-1-survivors"`)
+	sheet := parts["xl/worksheets/sheet1.xml"]
+	suite.Contains(sheet, `<c r="A1" t="inlineStr"><is><t xml:space="preserve">id</t></is></c>`)
+	suite.Contains(sheet, `<c r="X1" t="inlineStr"><is><t xml:space="preserve">canHunt</t></is></c>`)
+	suite.Contains(sheet, `<c r="A2"><v>3</v></c>`)
+	suite.Contains(sheet, `<c r="C2" t="inlineStr"><is><t xml:space="preserve">Ezra &amp; Zach</t></is></c>`)
+	suite.Contains(sheet, `<c r="W2" t="b"><v>1</v></c>`)
+}
+
+func (suite *SurvivorApiTestSuite) Test_GetSurvivors_NegotiatesTheAcceptHeader() {
+	for accept, want := range map[string]string{
+		"":                               "application/json",
+		"*/*":                            "application/json",
+		"text/*":                         "text/csv; charset=utf-8",
+		"text/csv;q=0.9, */*;q=0.1":      "text/csv; charset=utf-8",
+		"application/json;q=0, text/csv": "text/csv; charset=utf-8",
+	} {
+		suite.db.SetRows(&storeMocks.MockRows{})
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/settlements/1/survivors", nil)
+		req.Header.Set("Accept", accept)
+
+		suite.router.ServeHTTP(w, req)
+
+		suite.Equal(200, w.Code, accept)
+		suite.Equal(want, w.Header().Get("Content-Type"), accept)
+	}
+}
+
+func (suite *SurvivorApiTestSuite) Test_GetSurvivors_RejectsUnacceptableTypes() {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/settlements/1/survivors", nil)
+	req.Header.Set("Accept", "image/png, text/csv;q=0")
+
+	suite.router.ServeHTTP(w, req)
+
+	suite.Equal(http.StatusNotAcceptable, w.Code)
+	suite.Len(suite.db.Statements, 1, "only the owner of the settlement should be checked")
+}
+
 func (suite *SurvivorApiTestSuite) Test_CreateSurvivor_ReturnsNoContent() {
 	survivor := SurvivorDTO{
 		Settlement:       1,
@@ -117,7 +320,7 @@ func (suite *SurvivorApiTestSuite) Test_CreateSurvivor_ReturnsNoContent() {
 	if err != nil {
 		panic("Failed to marshal JSON")
 	}
-	req := httptest.NewRequest("POST", "/settlement/1/survivor", bytes.NewBuffer(reqBody))
+	req := httptest.NewRequest("POST", "/settlements/1/survivors", bytes.NewBuffer(reqBody))
 	ctx := req.Context()
 	req = req.WithContext(ctx)
 	w := httptest.NewRecorder()
@@ -127,6 +330,116 @@ func (suite *SurvivorApiTestSuite) Test_CreateSurvivor_ReturnsNoContent() {
 	suite.Equal(204, resp.StatusCode, "204 response should be returned")
 }
 
+func (suite *SurvivorApiTestSuite) Test_CreateSurvivor_CountsDeadSurvivors() {
+	before := testutil.ToFloat64(metrics.SurvivorsDied)
+	body := `{"name": "Zach", "gender": "M", "status": "dead"}`
+	w := httptest.NewRecorder()
+
+	suite.router.ServeHTTP(w, httptest.NewRequest("POST", "/settlements/1/survivors", strings.NewReader(body)))
+
+	suite.Equal(204, w.Code)
+	suite.Equal(before+1, testutil.ToFloat64(metrics.SurvivorsDied))
+}
+
+func (suite *SurvivorApiTestSuite) Test_CreateSurvivor_RecordsTheCreation() {
+	suite.db.SetRow(&storeMocks.InsertRow{Id: 4})
+	sub := suite.hub.Subscribe(1)
+	w := httptest.NewRecorder()
+
+	suite.router.ServeHTTP(w, httptest.NewRequest("POST", "/settlements/1/survivors", strings.NewReader(`{"name": "Zach", "gender": "M"}`)))
+
+	suite.Equal(204, w.Code)
+	suite.Contains(suite.db.SQL, "INSERT INTO campaign.event")
+	suite.Equal([]any{1, "survivor", 4, "create", "userId", []byte(nil), (*int)(nil)}, suite.db.Args)
+	born := suite.db.Statements[3]
+	suite.Contains(born.SQL, "INSERT INTO campaign.settlement_event")
+	suite.Equal([]any{1, "SurvivorBorn", []byte(`{"survivorId":4,"name":"Zach","gender":"M","birth":0}`), "userId"}, born.Args)
+	suite.True(suite.db.Committed)
+	suite.Require().Len(sub.C, 1, "watchers of the settlement should be notified")
+	suite.Equal(notify.Change{Settlement: 1, Entity: "survivor", EntityId: 4, Action: "create", Version: 1}, <-sub.C)
+}
+
+func (suite *SurvivorApiTestSuite) Test_GetSurvivor_AnswersNotModified() {
+	suite.db.SetRow(owned(&SurvivorRow{Id: 2, Settlement: 1, Name: "Lucy", Gender: "F", Version: 6}))
+	req := httptest.NewRequest("GET", "/settlements/1/survivors/2", nil)
+	req.Header.Set("If-None-Match", `W/"6"`)
+	w := httptest.NewRecorder()
+
+	suite.router.ServeHTTP(w, req)
+
+	suite.Equal(304, w.Code)
+	suite.Equal(`"6"`, w.Header().Get("ETag"))
+	suite.Equal([]any{2, 1}, suite.db.Args)
+}
+
+func (suite *SurvivorApiTestSuite) Test_GetSurvivor_ReturnsTheSurvivor() {
+	suite.db.SetRow(owned(&SurvivorRow{Id: 2, Settlement: 1, Name: "Lucy", Gender: "F", Version: 6}))
+	req := httptest.NewRequest("GET", "/settlements/1/survivors/2", nil)
+	req.Header.Set("If-None-Match", `"5"`)
+	w := httptest.NewRecorder()
+
+	suite.router.ServeHTTP(w, req)
+
+	suite.Equal(200, w.Code)
+	dto := SurvivorDTO{}
+	suite.NoError(json.Unmarshal(w.Body.Bytes(), &dto))
+	suite.Equal("Lucy", dto.Name)
+	suite.Equal(6, dto.Version)
+}
+
+func (suite *SurvivorApiTestSuite) Test_UpdateSurvivor_CountsDeaths() {
+	dead := StatusDead
+	suite.db.SetRow(owned(&SurvivorRow{Id: 2, Settlement: 1, Name: "Lucy", Gender: "F", Status: &dead, Version: 7}))
+	before := testutil.ToFloat64(metrics.SurvivorsDied)
+	req := httptest.NewRequest("PATCH", "/settlements/1/survivors/2", strings.NewReader(`{"status": "dead", "insanity": 3}`))
+	req.Header.Set("If-Match", `"6"`)
+	w := httptest.NewRecorder()
+
+	suite.router.ServeHTTP(w, req)
+
+	suite.Equal(200, w.Code)
+	update := suite.db.Statements[1]
+	suite.Contains(update.SQL, "WHERE id = $3 AND settlement = $4 FOR UPDATE) UPDATE campaign.survivor SET status = $1, insanity = $2, version = version + 1 FROM old WHERE id = old_id AND version = $5")
+	suite.Equal([]any{&dead, 3, 2, 1, 6}, update.Args)
+	suite.Contains(suite.db.SQL, "INSERT INTO campaign.event", "the change should be recorded")
+	suite.Equal(`"7"`, w.Header().Get("ETag"))
+	suite.Equal(before+1, testutil.ToFloat64(metrics.SurvivorsDied))
+}
+
+func (suite *SurvivorApiTestSuite) Test_UpdateSurvivor_DoesNotCountTheDeadTwice() {
+	dead := StatusDead
+	suite.db.SetRow(owned(&SurvivorRow{Id: 2, Settlement: 1, Name: "Lucy", Gender: "F", Status: &dead, Previous: &dead, Version: 7}))
+	before := testutil.ToFloat64(metrics.SurvivorsDied)
+	w := httptest.NewRecorder()
+
+	suite.router.ServeHTTP(w, httptest.NewRequest("PATCH", "/settlements/1/survivors/2", strings.NewReader(`{"status": "dead"}`)))
+
+	suite.Equal(200, w.Code)
+	suite.Equal(before, testutil.ToFloat64(metrics.SurvivorsDied))
+}
+
+func (suite *SurvivorApiTestSuite) Test_UpdateSurvivor_ClearsTheStatusOfActiveSurvivors() {
+	suite.db.SetRow(owned(&SurvivorRow{Id: 2, Settlement: 1, Name: "Lucy", Gender: "F", Version: 2}))
+	w := httptest.NewRecorder()
+
+	suite.router.ServeHTTP(w, httptest.NewRequest("PATCH", "/settlements/1/survivors/2", strings.NewReader(`{"status": "active"}`)))
+
+	suite.Equal(200, w.Code)
+	suite.Equal([]any{(*string)(nil), 2, 1}, suite.db.Statements[1].Args)
+}
+
+func (suite *SurvivorApiTestSuite) Test_UpdateSurvivor_RejectsStaleVersions() {
+	suite.db.SetRow(owned(&storeMocks.ErrorRow{Error: pgx.ErrNoRows}, &storeMocks.InsertRow{Id: 8}))
+	req := httptest.NewRequest("PATCH", "/settlements/1/survivors/2", strings.NewReader(`{"huntXp": 2}`))
+	req.Header.Set("If-Match", `"6"`)
+	w := httptest.NewRecorder()
+
+	suite.router.ServeHTTP(w, req)
+
+	suite.Equal(412, w.Code, "a player editing an old version should not overwrite newer changes")
+	suite.Equal([]any{2, 1}, suite.db.Args)
+}
+
 func (suite *SurvivorApiTestSuite) Test_CreateSurvivor_RequiresAValidSettlementId() {
 	survivor := SurvivorDTO{
 		Settlement:       1,
@@ -152,14 +465,14 @@ func (suite *SurvivorApiTestSuite) Test_CreateSurvivor_RequiresAValidSettlementI
 	if err != nil {
 		panic("Failed to marshal JSON")
 	}
-	req := httptest.NewRequest("POST", "/settlement/z/survivor", bytes.NewBuffer(reqBody))
+	req := httptest.NewRequest("POST", "/settlements/z/survivors", bytes.NewBuffer(reqBody))
 	ctx := req.Context()
 	req = req.WithContext(ctx)
 	w := httptest.NewRecorder()
 	suite.router.ServeHTTP(w, req)
 
 	resp := w.Result()
-	suite.Equal(500, resp.StatusCode, "500 should be returned if the param is invalid")
+	suite.Equal(400, resp.StatusCode, "400 should be returned if the param is invalid")
 }
 
 func (suite *SurvivorApiTestSuite) Test_CreateSurvivor_RequiresAUniqueName() {
@@ -188,15 +501,15 @@ func (suite *SurvivorApiTestSuite) Test_CreateSurvivor_RequiresAUniqueName() {
 	if err != nil {
 		panic("Failed to marshal JSON")
 	}
-	req := httptest.NewRequest("POST", "/settlement/1/survivor", bytes.NewBuffer(reqBody))
+	req := httptest.NewRequest("POST", "/settlements/1/survivors", bytes.NewBuffer(reqBody))
 	ctx := req.Context()
 	req = req.WithContext(ctx)
 	w := httptest.NewRecorder()
-	suite.db.SetError(errors.New("duplicate key value"))
+	suite.db.SetError(&pgconn.PgError{Code: "23505", Message: "duplicate key value violates unique constraint"})
 	suite.router.ServeHTTP(w, req)
 
 	resp := w.Result()
-	suite.Equal(400, resp.StatusCode, "400 should be returned if the survivor already exists")
+	suite.Equal(409, resp.StatusCode, "409 should be returned if the survivor already exists")
 }
 
 func (suite *SurvivorApiTestSuite) Test_CreateSurvivor_RequiresAValidBody() {
@@ -212,14 +525,52 @@ func (suite *SurvivorApiTestSuite) Test_CreateSurvivor_RequiresAValidBody() {
 	if err != nil {
 		panic("Failed to marshal JSON")
 	}
-	req := httptest.NewRequest("POST", "/settlement/1/survivor", bytes.NewBuffer(reqBody))
+	req := httptest.NewRequest("POST", "/settlements/1/survivors", bytes.NewBuffer(reqBody))
 	ctx := req.Context()
 	req = req.WithContext(ctx)
 	w := httptest.NewRecorder()
 	suite.router.ServeHTTP(w, req)
 
 	resp := w.Result()
-	suite.Equal(500, resp.StatusCode, "500 should be returned if the body is invalid")
+	suite.Equal(422, resp.StatusCode, "422 should be returned if the body is invalid")
+}
+
+func (suite *SurvivorApiTestSuite) Test_CreateSurvivor_ReportsEveryInvalidField() {
+	survivor := SurvivorDTO{
+		Name:     "Zach",
+		Gender:   "X",
+		HuntXp:   17,
+		Courage:  10,
+		Movement: 5,
+	}
+	reqBody, err := json.Marshal(survivor)
+	if err != nil {
+		panic("Failed to marshal JSON")
+	}
+	req := httptest.NewRequest("POST", "/settlements/1/survivors", bytes.NewBuffer(reqBody))
+	w := httptest.NewRecorder()
+	suite.router.ServeHTTP(w, req)
+
+	resp := w.Result()
+	suite.Equal(422, resp.StatusCode, "422 should be returned if fields are out of range")
+	body, _ := io.ReadAll(resp.Body)
+	problem := web.ValidationProblem{}
+	json.Unmarshal(body, &problem)
+	suite.Equal([]web.FieldError{
+		{Field: "gender", Message: "must be one of M, F"},
+		{Field: "huntXp", Message: "must be at most 16"},
+		{Field: "courage", Message: "must be at most 9"},
+	}, problem.Errors, "every invalid field should be reported")
+}
+
+func (suite *SurvivorApiTestSuite) Test_CreateSurvivor_RejectsOversizedBodies() {
+	reqBody := []byte(`{"name": "` + strings.Repeat("a", web.MaxRequestBodyBytes) + `"}`)
+	req := httptest.NewRequest("POST", "/settlements/1/survivors", bytes.NewBuffer(reqBody))
+	w := httptest.NewRecorder()
+	suite.router.ServeHTTP(w, req)
+
+	resp := w.Result()
+	suite.Equal(413, resp.StatusCode, "413 should be returned if the body is too large")
 }
 
 func (suite *SurvivorApiTestSuite) Test_CreateSurvivor_CommunicatesDbIssues() {
@@ -248,7 +599,7 @@ func (suite *SurvivorApiTestSuite) Test_CreateSurvivor_CommunicatesDbIssues() {
 	if err != nil {
 		panic("Failed to marshal JSON")
 	}
-	req := httptest.NewRequest("POST", "/settlement/1/survivor", bytes.NewBuffer(reqBody))
+	req := httptest.NewRequest("POST", "/settlements/1/survivors", bytes.NewBuffer(reqBody))
 	ctx := req.Context()
 	req = req.WithContext(ctx)
 	w := httptest.NewRecorder()
@@ -259,6 +610,56 @@ func (suite *SurvivorApiTestSuite) Test_CreateSurvivor_CommunicatesDbIssues() {
 	suite.Equal(500, resp.StatusCode, "500 should be returned as the default for DB issues")
 }
 
+func (suite *SurvivorApiTestSuite) Test_Survivors_HideOtherUsersSettlements() {
+	router := chi.NewRouter()
+	router.Use(func(next http.Handler) http.Handler { return as("otherUserId", next) })
+	suite.target.RegisterRoutes(router)
+	for _, req := range []*http.Request{
+		httptest.NewRequest("GET", "/settlements/1/survivors", nil),
+		httptest.NewRequest("GET", "/settlements/1/survivors?format=csv", nil),
+		httptest.NewRequest("GET", "/settlements/1/survivors/2", nil),
+		httptest.NewRequest("PATCH", "/settlements/1/survivors/2", strings.NewReader(`{"huntXp": 2}`)),
+		httptest.NewRequest("POST", "/settlements/1/survivors", strings.NewReader(`{"name": "Zach", "gender": "M"}`)),
+	} {
+		suite.db.Statements = nil
+		suite.db.SetRow(&storeMocks.ErrorRow{Error: pgx.ErrNoRows})
+		w := httptest.NewRecorder()
+
+		router.ServeHTTP(w, req)
+
+		suite.Equal(404, w.Code, req.Method+" "+req.URL.Path)
+		suite.Require().Len(suite.db.Statements, 1, "nothing but the owner should be queried")
+		suite.Contains(suite.db.SQL, "FROM campaign.settlement WHERE id = $1 AND owner = $2")
+		suite.Equal([]any{1, "otherUserId"}, suite.db.Args)
+		suite.Empty(w.Header().Get("ETag"))
+	}
+}
+
+func (suite *SurvivorApiTestSuite) Test_Survivors_RejectMalformedSettlementIds() {
+	req := httptest.NewRequest("GET", "/settlements/first/survivors", nil)
+	w := httptest.NewRecorder()
+
+	suite.router.ServeHTTP(w, req)
+
+	suite.Equal(400, w.Code, "settlement ids must be numeric")
+}
+
+// asUser authenticates every request as a user, who is not limited by scopes.
+func asUser(next http.Handler) http.Handler {
+	return as("userId", next)
+}
+
+func as(subject string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(auth.WithPrincipal(r.Context(), auth.Principal{Subject: subject})))
+	})
+}
+
+// owned answers the check that the caller owns the settlement before rows.
+func owned(rows ...pgx.Row) *storeMocks.RowSequence {
+	return &storeMocks.RowSequence{Rows: append([]pgx.Row{&storeMocks.InsertRow{Id: 1}}, rows...)}
+}
+
 func TestSurvivorApiTestSuite(t *testing.T) {
 	suite.Run(t, new(SurvivorApiTestSuite))
 }
@@ -283,6 +684,11 @@ type SurvivorRow struct {
 	Lumi             int
 	Courage          int
 	Understanding    int
+	Status           *string
+	Disorders        []string
+	Version          int
+	// Previous is the status before an update.
+	Previous *string
 }
 
 func (s *SurvivorRow) Scan(dest ...interface{}) error {
@@ -295,7 +701,7 @@ func (s *SurvivorRow) Scan(dest ...interface{}) error {
 	survival := dest[6].(*int)
 	movement := dest[7].(*int)
 	accuracy := dest[8].(*int)
-	strength := dest[0].(*int)
+	strength := dest[9].(*int)
 	evasion := dest[10].(*int)
 	luck := dest[11].(*int)
 	speed := dest[12].(*int)
@@ -325,5 +731,11 @@ func (s *SurvivorRow) Scan(dest ...interface{}) error {
 	*lumi = s.Lumi
 	*courage = s.Courage
 	*understanding = s.Understanding
+	*dest[19].(**string) = s.Status
+	*dest[20].(*[]string) = s.Disorders
+	*dest[21].(*int) = s.Version
+	if len(dest) > 22 {
+		*dest[22].(**string) = s.Previous
+	}
 	return nil
 }
@@ -2,12 +2,16 @@ package repo
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log"
-	"strings"
 
+	"github.com/failuretoload/datamonster/domain"
+	"github.com/failuretoload/datamonster/history"
+	"github.com/failuretoload/datamonster/logging"
 	"github.com/failuretoload/datamonster/store"
-	"github.com/failuretoload/datamonster/web"
+	"github.com/failuretoload/datamonster/timeline"
+	"github.com/failuretoload/datamonster/tracing"
+	"github.com/jackc/pgx/v5"
 )
 
 type PostGresRepo struct {
@@ -15,35 +19,78 @@ type PostGresRepo struct {
 }
 
 type Survivor struct {
-	Id               int     `db:"id"`
-	Settlement       int     `db:"settlement"`
-	Name             string  `db:"name"`
-	Birth            int     `db:"birth"`
-	Gender           string  `db:"gender"`
-	Status           *string `db:"status"`
-	HuntXp           int     `db:"huntxp"`
-	Survival         int     `db:"survival"`
-	Movement         int     `db:"movement"`
-	Accuracy         int     `db:"accuracy"`
-	Strength         int     `db:"strength"`
-	Evasion          int     `db:"evasion"`
-	Luck             int     `db:"luck"`
-	Speed            int     `db:"speed"`
-	Insanity         int     `db:"insanity"`
-	SystemicPressure int     `db:"systemic_pressure"`
-	Torment          int     `db:"torment"`
-	Lumi             int     `db:"lumi"`
-	Courage          int     `db:"courage"`
-	Understanding    int     `db:"understanding"`
+	Id               int      `db:"id"`
+	Settlement       int      `db:"settlement"`
+	Name             string   `db:"name"`
+	Birth            int      `db:"birth"`
+	Gender           string   `db:"gender"`
+	Status           *string  `db:"status"`
+	HuntXp           int      `db:"huntxp"`
+	Survival         int      `db:"survival"`
+	Movement         int      `db:"movement"`
+	Accuracy         int      `db:"accuracy"`
+	Strength         int      `db:"strength"`
+	Evasion          int      `db:"evasion"`
+	Luck             int      `db:"luck"`
+	Speed            int      `db:"speed"`
+	Insanity         int      `db:"insanity"`
+	SystemicPressure int      `db:"systemic_pressure"`
+	Torment          int      `db:"torment"`
+	Lumi             int      `db:"lumi"`
+	Courage          int      `db:"courage"`
+	Understanding    int      `db:"understanding"`
+	Disorders        []string `db:"disorders"`
+	Version          int      `db:"version"`
+}
+
+const columns = `id, settlement, name, gender, birth, huntxp, survival, movement, accuracy, strength, evasion, luck,
+	speed, insanity, systemic_pressure, torment, lumi, courage, understanding, status, disorders, version`
+
+// Changes lists the fields an update sets. Nil fields are left unchanged and an
+// empty Status clears the survivor's status.
+type Changes struct {
+	Name             *string
+	Status           *string
+	HuntXp           *int
+	Survival         *int
+	Movement         *int
+	Accuracy         *int
+	Strength         *int
+	Evasion          *int
+	Luck             *int
+	Speed            *int
+	Insanity         *int
+	SystemicPressure *int
+	Torment          *int
+	Lumi             *int
+	Courage          *int
+	Understanding    *int
+	Disorders        *[]string
+}
+
+// Filter narrows the survivors listed. Zero values do not filter.
+type Filter struct {
+	Statuses []string
+	Genders  []string
+	// Living excludes dead survivors.
+	Living bool
+	// Disorder selects survivors suffering from it.
+	Disorder string
 }
 
 func NewRepo(d store.Connection) *PostGresRepo {
 	return &PostGresRepo{pool: d}
 }
 
-func (r PostGresRepo) CreateSurvivor(ctx context.Context, s Survivor) error {
-	insert := "INSERT INTO campaign.survivor (settlement, name, birth, huntxp, gender, survival, movement, accuracy, strength, evasion, luck, speed, insanity, systemic_pressure, torment, lumi, courage, understanding) " +
-		fmt.Sprintf("VALUES (%d, '%s', %d, %d, '%s', %d, %d, %d, %d, %d, %d, %d, %d, %d, %d, %d, %d, %d)",
+// CreateSurvivor adds s to its settlement, records its creation and returns its
+// id.
+func (r PostGresRepo) CreateSurvivor(ctx context.Context, s Survivor) (int, error) {
+	ctx, span := tracing.Start(ctx, "survivors.CreateSurvivor")
+	defer span.End()
+	insert := `INSERT INTO campaign.survivor (settlement, name, birth, huntxp, gender, survival, movement, accuracy, strength, evasion, luck, speed, insanity, systemic_pressure, torment, lumi, courage, understanding, status, disorders)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20) RETURNING id`
+	err := pgx.BeginFunc(ctx, r.pool, func(tx pgx.Tx) error {
+		err := tx.QueryRow(ctx, insert,
 			s.Settlement,
 			s.Name,
 			s.Birth,
@@ -62,62 +109,186 @@ func (r PostGresRepo) CreateSurvivor(ctx context.Context, s Survivor) error {
 			s.Lumi,
 			s.Courage,
 			s.Understanding,
-		)
-	tag, err := r.pool.Exec(ctx, insert)
+			s.Status,
+			disorders(s.Disorders),
+		).Scan(&s.Id)
+		if err != nil {
+			return err
+		}
+		born := timeline.SurvivorBorn{SurvivorId: s.Id, Name: s.Name, Gender: s.Gender, Birth: s.Birth}
+		if err := timeline.Append(ctx, tx, s.Settlement, born); err != nil {
+			return err
+		}
+		return history.Record(ctx, tx, history.Change{Settlement: s.Settlement, Entity: history.EntitySurvivor, EntityId: s.Id, Action: history.ActionCreate})
+	})
 	if err != nil {
-		userId := ctx.Value(web.UserIdKey)
-		logString := fmt.Errorf("%s survivor creation failed for user %s with %w", tag, userId, err)
-		log.Default().Println(logString)
-		if strings.Contains(err.Error(), "duplicate key value") {
-			return NewDuplicateNameError(s.Name)
+		logging.FromContext(ctx).Error("survivor creation failed", "settlement", s.Settlement, "error", err)
+		err = store.TranslateError(err, "survivor")
+		if domain.KindOf(err) == domain.KindConflict {
+			return 0, domain.Wrap(domain.KindConflict, err, "survivor with name %s already exists", s.Name)
 		}
 	}
-	return err
+	return s.Id, err
+}
+
+// ListSurvivors returns one page of a settlement's survivors matching f and
+// whether another page follows.
+func (r PostGresRepo) ListSurvivors(ctx context.Context, settlementId int, f Filter, page store.Page) ([]Survivor, bool, error) {
+	ctx, span := tracing.Start(ctx, "survivors.ListSurvivors")
+	defer span.End()
+	args := []any{settlementId}
+	where := []string{"settlement = $1"}
+	if len(f.Statuses) > 0 {
+		args = append(args, f.Statuses)
+		where = append(where, fmt.Sprintf("status = ANY($%d)", len(args)))
+	}
+	if len(f.Genders) > 0 {
+		args = append(args, f.Genders)
+		where = append(where, fmt.Sprintf("gender = ANY($%d)", len(args)))
+	}
+	if f.Living {
+		where = append(where, "status IS DISTINCT FROM 'dead'")
+	}
+	if f.Disorder != "" {
+		args = append(args, f.Disorder)
+		where = append(where, fmt.Sprintf("$%d = ANY(disorders)", len(args)))
+	}
+	query, args := page.Apply("SELECT "+columns+" FROM campaign.survivor", where, args)
+	survivors, err := r.find(ctx, query, args...)
+	if err != nil {
+		return nil, false, err
+	}
+	survivors, more := store.More(survivors, page)
+	return survivors, more, nil
+}
+
+// GetSurvivor returns one of a settlement's survivors.
+func (r PostGresRepo) GetSurvivor(ctx context.Context, settlementId, survivorId int) (Survivor, error) {
+	ctx, span := tracing.Start(ctx, "survivors.GetSurvivor")
+	defer span.End()
+	query := `SELECT ` + columns + ` FROM campaign.survivor WHERE id = $1 AND settlement = $2`
+	var s Survivor
+	err := scan(r.pool.QueryRow(ctx, query, survivorId, settlementId), &s)
+	return s, store.TranslateError(err, "survivor")
 }
 
-func (r PostGresRepo) GetAllSurvivorsForSettlement(ctx context.Context, settlementId int) ([]Survivor, error) {
-	query := fmt.Sprintf("SELECT * FROM campaign.survivor WHERE settlement = %d", settlementId)
-	survivors, err := r.find(ctx, query)
-	return survivors, err
+// UpdateSurvivor applies c to one of a settlement's survivors, records the
+// change and returns the survivor along with the status it had before. When
+// version is not nil the update only applies if the survivor is still at that
+// version.
+func (r PostGresRepo) UpdateSurvivor(ctx context.Context, settlementId, survivorId int, version *int, c Changes) (Survivor, *string, error) {
+	ctx, span := tracing.Start(ctx, "survivors.UpdateSurvivor")
+	defer span.End()
+	u := &store.Update{}
+	store.SetIf(u, "name", c.Name)
+	if c.Status != nil {
+		var status *string
+		if *c.Status != "" {
+			status = c.Status
+		}
+		u.Set("status", status)
+	}
+	store.SetIf(u, "huntxp", c.HuntXp)
+	store.SetIf(u, "survival", c.Survival)
+	store.SetIf(u, "movement", c.Movement)
+	store.SetIf(u, "accuracy", c.Accuracy)
+	store.SetIf(u, "strength", c.Strength)
+	store.SetIf(u, "evasion", c.Evasion)
+	store.SetIf(u, "luck", c.Luck)
+	store.SetIf(u, "speed", c.Speed)
+	store.SetIf(u, "insanity", c.Insanity)
+	store.SetIf(u, "systemic_pressure", c.SystemicPressure)
+	store.SetIf(u, "torment", c.Torment)
+	store.SetIf(u, "lumi", c.Lumi)
+	store.SetIf(u, "courage", c.Courage)
+	store.SetIf(u, "understanding", c.Understanding)
+	if c.Disorders != nil {
+		u.Set("disorders", disorders(*c.Disorders))
+	}
+	// old locks the row and keeps the record as it was, which RETURNING would
+	// otherwise only report after the update.
+	old := `WITH old AS (SELECT id AS old_id, status AS old_status, to_jsonb(survivor) AS old_record FROM campaign.survivor WHERE id = ` + u.Arg(survivorId) +
+		` AND settlement = ` + u.Arg(settlementId) + ` FOR UPDATE)`
+	where := "id = old_id"
+	if version != nil {
+		where += " AND version = " + u.Arg(*version)
+	}
+	query := old + ` UPDATE campaign.survivor SET ` + u.Assignments() + ` FROM old WHERE ` + where + ` RETURNING ` + columns + `, old_status, old_record`
+	var s Survivor
+	var previous *string
+	err := pgx.BeginFunc(ctx, r.pool, func(tx pgx.Tx) error {
+		var before []byte
+		if err := scan(tx.QueryRow(ctx, query, u.Args...), &s, &previous, &before); err != nil {
+			return err
+		}
+		return history.Record(ctx, tx, history.Change{Settlement: s.Settlement, Entity: history.EntitySurvivor, EntityId: s.Id, Action: history.ActionUpdate, Before: before})
+	})
+	if errors.Is(err, pgx.ErrNoRows) && version != nil {
+		return s, nil, store.Stale(ctx, r.pool, "survivor", `SELECT version FROM campaign.survivor WHERE id = $1 AND settlement = $2`, survivorId, settlementId)
+	}
+	if err != nil {
+		err = store.TranslateError(err, "survivor")
+		if domain.KindOf(err) == domain.KindConflict && c.Name != nil {
+			return s, nil, domain.Wrap(domain.KindConflict, err, "survivor with name %s already exists", *c.Name)
+		}
+	}
+	return s, previous, err
 }
 
-func (r PostGresRepo) find(ctx context.Context, query string) ([]Survivor, error) {
-	log.Default().Println(query)
-	rows, queryErr := r.pool.Query(ctx, query)
+func (r PostGresRepo) find(ctx context.Context, query string, args ...any) ([]Survivor, error) {
+	rows, queryErr := r.pool.Query(ctx, query, args...)
 	if queryErr != nil {
-		log.Default().Println(queryErr.Error())
-		return nil, queryErr
+		return nil, store.TranslateError(queryErr, "survivor")
 	}
 	defer rows.Close()
 	survivors := []Survivor{}
 	for rows.Next() {
 		var s Survivor
-		err := rows.Scan(&s.Id,
-			&s.Settlement,
-			&s.Name,
-			&s.Gender,
-			&s.Birth,
-			&s.HuntXp,
-			&s.Survival,
-			&s.Movement,
-			&s.Accuracy,
-			&s.Strength,
-			&s.Evasion,
-			&s.Luck,
-			&s.Speed,
-			&s.Insanity,
-			&s.SystemicPressure,
-			&s.Torment,
-			&s.Lumi,
-			&s.Courage,
-			&s.Understanding,
-			&s.Status,
-		)
+		err := scan(rows, &s)
 		if err != nil {
-			log.Default().Println(err.Error())
-			return survivors, err
+			return survivors, store.TranslateError(err, "survivor")
 		}
 		survivors = append(survivors, s)
 	}
+	if err := rows.Err(); err != nil {
+		return survivors, store.TranslateError(err, "survivor")
+	}
 	return survivors, nil
 }
+
+// scan reads the survivor columns of row into s, followed by any extra columns.
+func scan(row pgx.Row, s *Survivor, extra ...any) error {
+	dest := []any{&s.Id,
+		&s.Settlement,
+		&s.Name,
+		&s.Gender,
+		&s.Birth,
+		&s.HuntXp,
+		&s.Survival,
+		&s.Movement,
+		&s.Accuracy,
+		&s.Strength,
+		&s.Evasion,
+		&s.Luck,
+		&s.Speed,
+		&s.Insanity,
+		&s.SystemicPressure,
+		&s.Torment,
+		&s.Lumi,
+		&s.Courage,
+		&s.Understanding,
+		&s.Status,
+		&s.Disorders,
+		&s.Version,
+	}
+	return row.Scan(append(dest, extra...)...)
+}
+
+// disorders stores a survivor without disorders as an empty array rather than
+// NULL.
+func disorders(d []string) []string {
+	if d == nil {
+		return []string{}
+	}
+	return d
+}
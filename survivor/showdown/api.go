@@ -0,0 +1,134 @@
+package showdown
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/failuretoload/datamonster/auth"
+	"github.com/failuretoload/datamonster/openapi"
+	"github.com/failuretoload/datamonster/store"
+	"github.com/failuretoload/datamonster/survivor"
+	"github.com/failuretoload/datamonster/user"
+	"github.com/failuretoload/datamonster/web"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// maxMessage bounds the size of a client message in bytes.
+	maxMessage = 1024
+	// sendBuffer is how many updates a client may fall behind before it is
+	// disconnected.
+	sendBuffer = 64
+	writeWait  = 10 * time.Second
+	pongWait   = 60 * time.Second
+	pingPeriod = pongWait * 9 / 10
+)
+
+// hunts are named by the clients, which share the name to join the same
+// showdown.
+var hunts = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// upgrader accepts every origin: the API authenticates with bearer tokens
+// rather than cookies, so another site cannot open a session as the user.
+var upgrader = websocket.Upgrader{CheckOrigin: func(*http.Request) bool { return true }}
+
+// Controller connects players to the showdowns of their settlements' hunts.
+type Controller struct {
+	pool     store.Connection
+	sessions *Sessions
+}
+
+func NewController(conn store.Connection, sessions *Sessions) *Controller {
+	return &Controller{pool: conn, sessions: sessions}
+}
+
+func (c Controller) RegisterRoutes(r chi.Router) {
+	r.With(auth.RequireScope(survivor.ScopeWrite), web.Unbounded).Get("/settlements/{id}/hunts/{hunt}/showdown", c.connect)
+}
+
+func (c Controller) DescribeRoutes(d *openapi.Document) {
+	hunt := openapi.Parameter{Name: "hunt", In: "path", Required: true, Schema: &openapi.Schema{Type: "string"}}
+	d.Add(openapi.Route{Method: http.MethodGet, Path: "/settlements/{id}/hunts/{hunt}/showdown", ID: "joinShowdown", Summary: "Join the showdown of a hunt over a WebSocket: send survivor changes and receive everyone's in order, with who is connected", Tags: []string{"showdown"}, Params: []openapi.Parameter{hunt}, Scopes: []string{survivor.ScopeWrite}, Response: Update{}, Status: http.StatusSwitchingProtocols})
+}
+
+func (c Controller) connect(w http.ResponseWriter, r *http.Request) {
+	settlementId, ok := web.PathId(w, r, "id", "settlement")
+	if !ok {
+		return
+	}
+	hunt := chi.URLParam(r, "hunt")
+	if !hunts.MatchString(hunt) {
+		web.WriteProblem(w, r, http.StatusBadRequest, "hunt must be 1 to 64 letters, digits, dashes or underscores")
+		return
+	}
+	u, _ := user.FromContext(r.Context())
+	if err := store.Owned(r.Context(), c.pool, u.Subject, settlementId); err != nil {
+		web.WriteError(w, r, err)
+		return
+	}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// The upgrader has answered the request already.
+		return
+	}
+	player := &client{ctx: context.WithoutCancel(r.Context()), user: u.Subject, send: make(chan Update, sendBuffer)}
+	session, ok := c.sessions.join(settlementId, hunt, player)
+	if !ok {
+		_ = conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, "the server is shutting down"), time.Now().Add(writeWait))
+		conn.Close()
+		return
+	}
+	go write(conn, player.send)
+	read(conn, session, player)
+}
+
+// read applies the player's messages until the connection closes, then leaves
+// the session.
+func read(conn *websocket.Conn, session *Session, player *client) {
+	defer session.leave(player)
+	conn.SetReadLimit(maxMessage)
+	_ = conn.SetReadDeadline(time.Now().Add(pongWait))
+	conn.SetPongHandler(func(string) error { return conn.SetReadDeadline(time.Now().Add(pongWait)) })
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		var m Message
+		if err := json.Unmarshal(data, &m); err != nil {
+			m = Message{Type: "malformed"}
+		}
+		session.handle(player, m)
+	}
+}
+
+// write sends updates and keeps the connection alive with pings until send is
+// closed, then closes the connection.
+func write(conn *websocket.Conn, send <-chan Update) {
+	ping := time.NewTicker(pingPeriod)
+	defer ping.Stop()
+	defer conn.Close()
+	for {
+		select {
+		case u, ok := <-send:
+			_ = conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if !ok {
+				_ = conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+				return
+			}
+			if err := conn.WriteJSON(u); err != nil {
+				return
+			}
+		case <-ping.C:
+			_ = conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
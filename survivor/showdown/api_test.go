@@ -0,0 +1,277 @@
+package showdown
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/failuretoload/datamonster/auth"
+	"github.com/failuretoload/datamonster/notify"
+	storeMocks "github.com/failuretoload/datamonster/store/mocks"
+	"github.com/failuretoload/datamonster/user"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/gorilla/websocket"
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/suite"
+)
+
+type ShowdownApiTestSuite struct {
+	suite.Suite
+	db       *storeMocks.MockConnection
+	hub      *notify.Hub
+	sessions *Sessions
+	server   *httptest.Server
+}
+
+func (suite *ShowdownApiTestSuite) SetupTest() {
+	suite.db = &storeMocks.MockConnection{}
+	suite.hub = notify.NewHub()
+	suite.sessions = NewSessions(suite.db, suite.hub)
+	router := chi.NewRouter()
+	router.Use(asUser)
+	NewController(suite.db, suite.sessions).RegisterRoutes(router)
+	suite.server = httptest.NewServer(router)
+}
+
+func (suite *ShowdownApiTestSuite) TearDownTest() {
+	suite.server.Close()
+}
+
+// owner answers the check that the player owns the settlement.
+var owner = &storeMocks.InsertRow{Id: 1}
+
+// rows answers the statements of the test in turn.
+func (suite *ShowdownApiTestSuite) rows(rows ...pgx.Row) {
+	suite.db.SetRow(&storeMocks.RowSequence{Rows: rows})
+}
+
+// dial joins the showdown of a hunt as player and returns the state it is sent.
+func (suite *ShowdownApiTestSuite) dial(player string, path string) (*websocket.Conn, Update) {
+	header := http.Header{"X-Player": []string{player}}
+	conn, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(suite.server.URL, "http")+path, header)
+	suite.Require().NoError(err)
+	resp.Body.Close()
+	state := suite.next(conn)
+	suite.Require().Equal(TypeState, state.Type)
+	return conn, state
+}
+
+func (suite *ShowdownApiTestSuite) next(conn *websocket.Conn) Update {
+	suite.Require().NoError(conn.SetReadDeadline(time.Now().Add(time.Second)))
+	var u Update
+	suite.Require().NoError(conn.ReadJSON(&u))
+	return u
+}
+
+func (suite *ShowdownApiTestSuite) Test_Showdown_SharesChangesInOrder() {
+	suite.rows(owner, owner, &SurvivorRow{Id: 2, Settlement: 1, Name: "Lucy", Survival: 1, Version: 4})
+	first, state := suite.dial("first", "/settlements/1/hunts/lion/showdown")
+	defer first.Close()
+	suite.Equal([]string{"first"}, state.Participants)
+	suite.Equal([]any{1, "first"}, suite.db.Args)
+	suite.Equal(Update{Type: TypePresence, Seq: 1, Participants: []string{"first"}}, suite.next(first))
+
+	second, state := suite.dial("second", "/settlements/1/hunts/lion/showdown")
+	defer second.Close()
+	suite.Equal(1, state.Seq)
+	suite.Equal([]string{"first", "second"}, suite.next(first).Participants)
+	suite.Equal([]string{"first", "second"}, suite.next(second).Participants)
+
+	suite.Require().NoError(first.WriteJSON(Message{Type: TypeChange, Survivor: 2, Stat: "survival", Delta: 2}))
+	three := 3
+	change := Update{Type: TypeChange, Seq: 3, By: "first", Survivor: 2, Stat: "survival", Delta: 2, Value: &three}
+	suite.Equal(change, suite.next(first))
+	suite.Require().NoError(second.WriteJSON(Message{Type: TypeChange, Survivor: 2, Stat: Bleeding, Delta: 1}))
+
+	one := 1
+	bleed := Update{Type: TypeChange, Seq: 4, By: "second", Survivor: 2, Stat: Bleeding, Delta: 1, Value: &one}
+	suite.Equal(bleed, suite.next(first))
+	suite.Equal(change, suite.next(second))
+	suite.Equal(bleed, suite.next(second))
+}
+
+func (suite *ShowdownApiTestSuite) Test_Showdown_IsNotHeldUpByASlowDatabase() {
+	slow := &SlowRow{Row: &SurvivorRow{Id: 2, Settlement: 1, Name: "Lucy"}, Started: make(chan struct{}), Release: make(chan struct{})}
+	defer slow.Finish()
+	suite.rows(owner, owner, slow, &SurvivorRow{Id: 3, Settlement: 1, Name: "Zachary"})
+	first, _ := suite.dial("first", "/settlements/1/hunts/lion/showdown")
+	defer first.Close()
+	suite.next(first)
+	second, _ := suite.dial("second", "/settlements/1/hunts/lion/showdown")
+	defer second.Close()
+	suite.next(first)
+	suite.next(second)
+
+	suite.Require().NoError(first.WriteJSON(Message{Type: TypeChange, Survivor: 2, Stat: "survival", Delta: 1}))
+	<-slow.Started
+	suite.Require().NoError(second.WriteJSON(Message{Type: TypeChange, Survivor: 3, Stat: "survival", Delta: 1}))
+
+	suite.Equal(3, suite.next(first).Survivor, "loading one survivor should not hold up changes to another")
+	suite.Equal(3, suite.next(second).Survivor)
+	slow.Finish()
+	one := 1
+	suite.Equal(Update{Type: TypeChange, Seq: 4, By: "first", Survivor: 2, Stat: "survival", Delta: 1, Value: &one}, suite.next(second))
+}
+
+func (suite *ShowdownApiTestSuite) Test_Showdown_RejectsInvalidChanges() {
+	suite.rows(owner, &SurvivorRow{Id: 2, Settlement: 1, Name: "Lucy"})
+	conn, _ := suite.dial("first", "/settlements/1/hunts/lion/showdown")
+	defer conn.Close()
+	suite.next(conn)
+
+	suite.Require().NoError(conn.WriteJSON(Message{Type: TypeChange, Survivor: 2, Stat: "hair", Delta: 1}))
+	suite.Equal(Update{Type: TypeError, Error: `unknown stat "hair"`}, suite.next(conn))
+	suite.Require().NoError(conn.WriteJSON(Message{Type: TypeChange, Survivor: 2, Stat: Bleeding, Delta: -1}))
+	suite.Equal(Update{Type: TypeError, Error: "bleeding cannot drop below 0"}, suite.next(conn))
+	suite.Require().NoError(conn.WriteJSON(Message{Type: TypeChange, Survivor: 2, Stat: "survival", Delta: 100}))
+	suite.Equal(Update{Type: TypeError, Error: "survival must be at most 99"}, suite.next(conn), "showdowns should follow the rules of survivor updates")
+	suite.Require().NoError(conn.WriteJSON(Message{Type: TypeChange, Survivor: 2, Stat: Bleeding, Delta: 1 << 62}))
+	suite.Equal(Update{Type: TypeError, Error: "delta must be between -999 and 999 and not 0"}, suite.next(conn))
+	suite.Require().NoError(conn.WriteJSON(map[string]any{"type": "injury", "survivor": 2, "injury": "Broken arm"}))
+	suite.Equal(Update{Type: TypeError, Error: `unknown message type "injury"`}, suite.next(conn), "survivors have nowhere to keep injuries")
+	suite.Require().NoError(conn.WriteMessage(websocket.TextMessage, []byte("{")))
+	suite.Equal(TypeError, suite.next(conn).Type)
+}
+
+func (suite *ShowdownApiTestSuite) Test_Showdown_SavesSurvivorsWhenItEnds() {
+	suite.rows(
+		owner,
+		&SurvivorRow{Id: 2, Settlement: 1, Name: "Lucy", Survival: 1, Version: 4},
+		&SurvivorRow{Id: 2, Settlement: 1, Name: "Lucy", Survival: 2, Version: 5},
+		&SurvivorRow{Id: 2, Settlement: 1, Name: "Lucy", Survival: 4, Version: 6},
+	)
+	sub := suite.hub.Subscribe(1)
+	defer sub.Close()
+	conn, _ := suite.dial("first", "/settlements/1/hunts/lion/showdown")
+	defer conn.Close()
+	suite.next(conn)
+
+	suite.Require().NoError(conn.WriteJSON(Message{Type: TypeChange, Survivor: 2, Stat: "survival", Delta: 2}))
+	suite.Require().NoError(conn.WriteJSON(Message{Type: TypeChange, Survivor: 2, Stat: Bleeding, Delta: 1}))
+	suite.Require().NoError(conn.WriteJSON(Message{Type: TypeEnd}))
+	suite.next(conn)
+	suite.next(conn)
+
+	suite.Equal(Update{Type: TypeEnded, Seq: 4, By: "first"}, suite.next(conn))
+	update := suite.db.Statements[len(suite.db.Statements)-2]
+	suite.Contains(update.SQL, "UPDATE campaign.survivor SET survival = $1")
+	suite.Equal([]any{4, 2, 1, 5}, update.Args, "the change should apply to the survivor as it is now")
+	suite.Require().Len(sub.C, 1)
+	suite.Equal(notify.Change{Settlement: 1, Entity: "survivor", EntityId: 2, Action: "update", Version: 6}, <-sub.C)
+	_, _, err := conn.ReadMessage()
+	suite.Error(err, "the connection should be closed once the showdown ends")
+}
+
+func (suite *ShowdownApiTestSuite) Test_Showdown_DoesNotSaveValuesOutOfRange() {
+	suite.rows(
+		owner,
+		&SurvivorRow{Id: 2, Settlement: 1, Name: "Lucy", Survival: 1, Version: 4},
+		&SurvivorRow{Id: 2, Settlement: 1, Name: "Lucy", Survival: 98, Version: 5},
+	)
+	conn, _ := suite.dial("first", "/settlements/1/hunts/lion/showdown")
+	defer conn.Close()
+	suite.next(conn)
+
+	suite.Require().NoError(conn.WriteJSON(Message{Type: TypeChange, Survivor: 2, Stat: "survival", Delta: 2}))
+	suite.next(conn)
+	suite.Require().NoError(conn.WriteJSON(Message{Type: TypeEnd}))
+
+	ended := suite.next(conn)
+	suite.Equal(TypeEnded, ended.Type)
+	suite.Equal("some survivors could not be saved", ended.Error, "survival raised elsewhere leaves no room for the showdown's change")
+	for _, statement := range suite.db.Statements {
+		suite.NotContains(statement.SQL, "UPDATE campaign.survivor")
+	}
+}
+
+func (suite *ShowdownApiTestSuite) Test_Close_EndsShowdowns() {
+	suite.rows(owner, owner)
+	conn, _ := suite.dial("first", "/settlements/1/hunts/lion/showdown")
+	defer conn.Close()
+	suite.next(conn)
+
+	suite.NoError(suite.sessions.Close(context.Background()))
+
+	suite.Equal(TypeEnded, suite.next(conn).Type)
+	late, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(suite.server.URL, "http")+"/settlements/1/hunts/lion/showdown", nil)
+	suite.Require().NoError(err)
+	defer late.Close()
+	resp.Body.Close()
+	_, _, err = late.ReadMessage()
+	suite.True(websocket.IsCloseError(err, websocket.CloseGoingAway), "no showdown should start once the server shuts down")
+}
+
+func (suite *ShowdownApiTestSuite) Test_Connect_HidesOtherPlayersSettlements() {
+	suite.db.SetRow(&storeMocks.ErrorRow{Error: pgx.ErrNoRows})
+
+	resp, err := http.Get(suite.server.URL + "/settlements/1/hunts/lion/showdown")
+
+	suite.Require().NoError(err)
+	defer resp.Body.Close()
+	suite.Equal(404, resp.StatusCode)
+}
+
+func (suite *ShowdownApiTestSuite) Test_Connect_RejectsInvalidHunts() {
+	resp, err := http.Get(suite.server.URL + "/settlements/1/hunts/a%20b/showdown")
+
+	suite.Require().NoError(err)
+	defer resp.Body.Close()
+	suite.Equal(400, resp.StatusCode)
+}
+
+// asUser authenticates every request as the player named by the X-Player
+// header, who is not limited by scopes.
+func asUser(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		player := r.Header.Get("X-Player")
+		ctx := auth.WithPrincipal(r.Context(), auth.Principal{Subject: player})
+		next.ServeHTTP(w, r.WithContext(user.WithUser(ctx, user.User{Subject: player})))
+	})
+}
+
+func TestShowdownApiTestSuite(t *testing.T) {
+	suite.Run(t, new(ShowdownApiTestSuite))
+}
+
+// SurvivorRow scans as a survivor, leaving the columns showdowns do not use
+// unset.
+type SurvivorRow struct {
+	Id         int
+	Settlement int
+	Name       string
+	Survival   int
+	Version    int
+}
+
+func (s *SurvivorRow) Scan(dest ...any) error {
+	*dest[0].(*int) = s.Id
+	*dest[1].(*int) = s.Settlement
+	*dest[2].(*string) = s.Name
+	*dest[6].(*int) = s.Survival
+	*dest[21].(*int) = s.Version
+	return nil
+}
+
+// SlowRow scans as Row once released, closing Started when the scan begins.
+type SlowRow struct {
+	Row     pgx.Row
+	Started chan struct{}
+	Release chan struct{}
+	once    sync.Once
+}
+
+func (s *SlowRow) Scan(dest ...any) error {
+	close(s.Started)
+	<-s.Release
+	return s.Row.Scan(dest...)
+}
+
+// Finish releases the scan.
+func (s *SlowRow) Finish() {
+	s.once.Do(func() { close(s.Release) })
+}
@@ -0,0 +1,516 @@
+// Package showdown runs the live session of a hunt's showdown, where every
+// player connected over a WebSocket sees the changes the others make to the
+// hunting survivors, in the order the server applied them.
+package showdown
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/failuretoload/datamonster/auth"
+	"github.com/failuretoload/datamonster/domain"
+	"github.com/failuretoload/datamonster/history"
+	"github.com/failuretoload/datamonster/logging"
+	"github.com/failuretoload/datamonster/notify"
+	"github.com/failuretoload/datamonster/store"
+	"github.com/failuretoload/datamonster/survivor"
+	repo "github.com/failuretoload/datamonster/survivor/internal"
+	"github.com/failuretoload/datamonster/web"
+)
+
+// Message types clients send.
+const (
+	TypeChange = "change"
+	TypeEnd    = "end"
+)
+
+// Update types the server sends. Changes are echoed with their own type.
+const (
+	TypeState    = "state"
+	TypePresence = "presence"
+	TypeEnded    = "ended"
+	TypeError    = "error"
+)
+
+// Bleeding is tracked for the showdown only; every other stat is saved to the
+// survivor when the showdown ends.
+const Bleeding = "bleeding"
+
+// Message is sent by a client to change a survivor or end the showdown.
+type Message struct {
+	Type     string `json:"type"`
+	Survivor int    `json:"survivor,omitempty"`
+	Stat     string `json:"stat,omitempty"`
+	Delta    int    `json:"delta,omitempty"`
+}
+
+// Update is sent to clients. Seq numbers the updates of a session in the order
+// they were applied; errors only concern the client they are sent to and are
+// not numbered.
+type Update struct {
+	Type         string          `json:"type"`
+	Seq          int             `json:"seq,omitempty"`
+	By           string          `json:"by,omitempty"`
+	Survivor     int             `json:"survivor,omitempty"`
+	Stat         string          `json:"stat,omitempty"`
+	Delta        int             `json:"delta,omitempty"`
+	Value        *int            `json:"value,omitempty"`
+	Participants []string        `json:"participants,omitempty"`
+	Survivors    []SurvivorState `json:"survivors,omitempty"`
+	Error        string          `json:"error,omitempty"`
+}
+
+// SurvivorState is a survivor as the showdown has left them so far.
+type SurvivorState struct {
+	Id    int            `json:"id"`
+	Name  string         `json:"name"`
+	Stats map[string]int `json:"stats"`
+	// deltas are the net changes made to each stat during the showdown.
+	deltas map[string]int
+}
+
+// stat reads and changes a stat kept on survivors.
+type stat struct {
+	get func(repo.Survivor) int
+	set func(*repo.Changes, int)
+}
+
+var saved = map[string]stat{
+	"survival": {func(s repo.Survivor) int { return s.Survival }, func(c *repo.Changes, v int) { c.Survival = &v }},
+	"insanity": {func(s repo.Survivor) int { return s.Insanity }, func(c *repo.Changes, v int) { c.Insanity = &v }},
+	"movement": {func(s repo.Survivor) int { return s.Movement }, func(c *repo.Changes, v int) { c.Movement = &v }},
+	"accuracy": {func(s repo.Survivor) int { return s.Accuracy }, func(c *repo.Changes, v int) { c.Accuracy = &v }},
+	"strength": {func(s repo.Survivor) int { return s.Strength }, func(c *repo.Changes, v int) { c.Strength = &v }},
+	"evasion":  {func(s repo.Survivor) int { return s.Evasion }, func(c *repo.Changes, v int) { c.Evasion = &v }},
+	"luck":     {func(s repo.Survivor) int { return s.Luck }, func(c *repo.Changes, v int) { c.Luck = &v }},
+	"speed":    {func(s repo.Survivor) int { return s.Speed }, func(c *repo.Changes, v int) { c.Speed = &v }},
+}
+
+// nonNegative stats cannot drop below zero. Saved stats are also bound by the
+// rules of survivor updates, see inRange.
+var nonNegative = []string{"survival", "insanity", Bleeding}
+
+// maxDelta bounds a single change, keeping bleeding, which has no upper bound,
+// from overflowing.
+const maxDelta = 999
+
+// saveAttempts bounds how often saving a survivor is retried when it changed
+// outside the showdown in the meantime.
+const saveAttempts = 3
+
+// ioTimeout bounds each read of a survivor and each save, so that a slow
+// database cannot hold a showdown open indefinitely.
+const ioTimeout = 10 * time.Second
+
+// client is one connection to a session.
+type client struct {
+	// ctx carries the caller's principal and logger beyond the request.
+	ctx  context.Context
+	user string
+	send chan Update
+}
+
+type key struct {
+	settlement int
+	hunt       string
+}
+
+// Sessions holds the running showdowns, one per hunt.
+type Sessions struct {
+	repo      *repo.PostGresRepo
+	publisher notify.Publisher
+	mu        sync.Mutex
+	running   map[key]*Session
+	// finishing holds the showdowns that ended and are being saved; their
+	// channel is closed once they are.
+	finishing map[*Session]chan struct{}
+	closed    bool
+}
+
+func NewSessions(conn store.Connection, publisher notify.Publisher) *Sessions {
+	return &Sessions{repo: repo.NewRepo(conn), publisher: publisher, running: map[key]*Session{}, finishing: map[*Session]chan struct{}{}}
+}
+
+// join adds c to the showdown of a hunt, starting it if needed. It fails once
+// the server is shutting down.
+func (m *Sessions) join(settlementId int, hunt string, c *client) (*Session, bool) {
+	for {
+		m.mu.Lock()
+		if m.closed {
+			m.mu.Unlock()
+			return nil, false
+		}
+		k := key{settlement: settlementId, hunt: hunt}
+		s, ok := m.running[k]
+		if !ok {
+			s = &Session{key: k, sessions: m, clients: map[*client]struct{}{}, survivors: map[int]*SurvivorState{}}
+			m.running[k] = s
+		}
+		m.mu.Unlock()
+		// A session that ended in the meantime is replaced on the next attempt.
+		if s.join(c) {
+			return s, true
+		}
+	}
+}
+
+// retire moves s from the running showdowns to the ones being saved, so that
+// the next player of the hunt starts a new showdown.
+func (m *Sessions) retire(s *Session) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.running[s.key] == s {
+		delete(m.running, s.key)
+	}
+	m.finishing[s] = make(chan struct{})
+}
+
+// finished records that s is saved.
+func (m *Sessions) finished(s *Session) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	close(m.finishing[s])
+	delete(m.finishing, s)
+}
+
+// Close ends every showdown, saving its survivors, waits for the showdowns
+// that ended on their own to be saved, and refuses new ones. The server does
+// not track WebSocket connections, so shutdown has to end them.
+func (m *Sessions) Close(ctx context.Context) error {
+	m.mu.Lock()
+	m.closed = true
+	running := make([]*Session, 0, len(m.running))
+	for _, s := range m.running {
+		running = append(running, s)
+	}
+	m.mu.Unlock()
+	var errs []error
+	for _, s := range running {
+		errs = append(errs, s.end(ctx, "")...)
+	}
+
+	m.mu.Lock()
+	finishing := make([]chan struct{}, 0, len(m.finishing))
+	for _, done := range m.finishing {
+		finishing = append(finishing, done)
+	}
+	m.mu.Unlock()
+	for _, done := range finishing {
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return errors.Join(append(errs, ctx.Err())...)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Session is the showdown of one hunt. Its lock orders every change, so all
+// clients receive updates in the same order. It is never held while reading or
+// saving survivors, so a slow database only holds up the player waiting on it.
+type Session struct {
+	key       key
+	sessions  *Sessions
+	mu        sync.Mutex
+	seq       int
+	clients   map[*client]struct{}
+	survivors map[int]*SurvivorState
+	ended     bool
+}
+
+func (s *Session) join(c *client) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ended {
+		return false
+	}
+	s.clients[c] = struct{}{}
+	s.deliver(c, Update{Type: TypeState, Seq: s.seq, Participants: s.participants(), Survivors: s.state()})
+	s.broadcast(Update{Type: TypePresence, Participants: s.participants()})
+	return true
+}
+
+// leave removes c, ending the showdown when the last client leaves. c may have
+// been dropped already for falling behind.
+func (s *Session) leave(c *client) {
+	s.mu.Lock()
+	s.drop(c)
+	last := !s.ended && len(s.clients) == 0
+	var survivors []SurvivorState
+	switch {
+	case last:
+		survivors = s.stop()
+	case !s.ended:
+		s.broadcast(Update{Type: TypePresence, Participants: s.participants()})
+	}
+	s.mu.Unlock()
+	if last {
+		s.finish(c.ctx, c.user, survivors)
+	}
+}
+
+// handle applies a message of c.
+func (s *Session) handle(c *client, m Message) {
+	var err error
+	switch m.Type {
+	case TypeChange:
+		err = s.change(c, m)
+	case TypeEnd:
+		s.end(c.ctx, c.user)
+	default:
+		err = domain.Validation("unknown message type %q", m.Type)
+	}
+	if err != nil {
+		s.mu.Lock()
+		s.deliver(c, Update{Type: TypeError, Error: err.Error()})
+		s.mu.Unlock()
+	}
+}
+
+func (s *Session) change(c *client, m Message) error {
+	if _, ok := saved[m.Stat]; !ok && m.Stat != Bleeding {
+		return domain.Validation("unknown stat %q", m.Stat)
+	}
+	if m.Delta == 0 || m.Delta < -maxDelta || m.Delta > maxDelta {
+		return domain.Validation("delta must be between %d and %d and not 0", -maxDelta, maxDelta)
+	}
+	if err := s.load(c.ctx, m.Survivor); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ended {
+		return nil
+	}
+	survivor := s.survivors[m.Survivor]
+	value := survivor.Stats[m.Stat] + m.Delta
+	if value < 0 && slices.Contains(nonNegative, m.Stat) {
+		return domain.Validation("%s cannot drop below 0", m.Stat)
+	}
+	if _, ok := saved[m.Stat]; ok {
+		if err := inRange(m.Stat, value); err != nil {
+			return err
+		}
+	}
+	survivor.Stats[m.Stat] = value
+	survivor.deltas[m.Stat] += m.Delta
+	s.broadcast(Update{Type: TypeChange, By: c.user, Survivor: survivor.Id, Stat: m.Stat, Delta: m.Delta, Value: &value})
+	return nil
+}
+
+// inRange checks the value of the saved stat name against the rules survivor
+// updates apply to it, so that a showdown cannot save what an update would
+// refuse.
+func inRange(name string, value int) error {
+	var update survivor.UpdateSurvivorRequest
+	field := reflect.ValueOf(&update).Elem().FieldByNameFunc(func(f string) bool { return strings.EqualFold(f, name) })
+	field.Set(reflect.ValueOf(&value))
+	var invalid *web.ValidationError
+	if errors.As(web.Validate(update), &invalid) {
+		return domain.Validation("%s %s", name, invalid.Fields[0].Message)
+	}
+	return nil
+}
+
+// load adds a survivor of the settlement to the showdown when it is first
+// changed. The caller must not hold s.mu.
+func (s *Session) load(ctx context.Context, id int) error {
+	s.mu.Lock()
+	_, ok := s.survivors[id]
+	s.mu.Unlock()
+	if ok {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, ioTimeout)
+	defer cancel()
+	loaded, err := s.sessions.repo.GetSurvivor(ctx, s.key.settlement, id)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// Another player may have loaded the survivor in the meantime.
+	if _, ok := s.survivors[id]; ok {
+		return nil
+	}
+	survivor := &SurvivorState{Id: loaded.Id, Name: loaded.Name, Stats: map[string]int{Bleeding: 0}, deltas: map[string]int{}}
+	for name, st := range saved {
+		survivor.Stats[name] = st.get(loaded)
+	}
+	s.survivors[id] = survivor
+	return nil
+}
+
+// onBehalf returns ctx acting as one of the connected players, so that the
+// saved changes are attributed to them. The caller holds s.mu.
+func (s *Session) onBehalf(ctx context.Context) context.Context {
+	for c := range s.clients {
+		if p, ok := auth.PrincipalFrom(c.ctx); ok {
+			return auth.WithPrincipal(ctx, p)
+		}
+	}
+	return ctx
+}
+
+// end ends the showdown unless it already has. Without a principal in ctx the
+// changes are saved on behalf of one of the connected players. The caller must
+// not hold s.mu.
+func (s *Session) end(ctx context.Context, by string) []error {
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return nil
+	}
+	if _, ok := auth.PrincipalFrom(ctx); !ok {
+		ctx = s.onBehalf(ctx)
+	}
+	survivors := s.stop()
+	s.mu.Unlock()
+	return s.finish(ctx, by, survivors)
+}
+
+// stop refuses further messages and players and returns the survivors to
+// save. The caller holds s.mu.
+func (s *Session) stop() []SurvivorState {
+	s.ended = true
+	s.sessions.retire(s)
+	return s.state()
+}
+
+// finish saves the survivors stop returned, then tells the clients and
+// disconnects them. The caller must not hold s.mu.
+func (s *Session) finish(ctx context.Context, by string, survivors []SurvivorState) []error {
+	errs := s.save(ctx, survivors)
+	ended := Update{Type: TypeEnded, By: by}
+	if len(errs) > 0 {
+		ended.Error = "some survivors could not be saved"
+	}
+	s.mu.Lock()
+	s.broadcast(ended)
+	for c := range s.clients {
+		s.drop(c)
+	}
+	s.mu.Unlock()
+	s.sessions.finished(s)
+	return errs
+}
+
+// save applies the net changes of the showdown to the survivors as they are
+// now, so that changes made outside the showdown are kept.
+func (s *Session) save(ctx context.Context, survivors []SurvivorState) []error {
+	var errs []error
+	for _, survivor := range survivors {
+		var err error
+		for attempt := 1; attempt <= saveAttempts; attempt++ {
+			err = s.saveSurvivor(ctx, survivor.Id, survivor.deltas)
+			if domain.KindOf(err) != domain.KindPreconditionFailed {
+				break
+			}
+		}
+		if err != nil {
+			logging.FromContext(ctx).Error("unable to save showdown survivor", "settlement", s.key.settlement, "survivor", survivor.Id, "error", err)
+			errs = append(errs, fmt.Errorf("saving survivor %d: %w", survivor.Id, err))
+		}
+	}
+	return errs
+}
+
+// saveSurvivor applies deltas to a survivor as it is now, failing when the
+// result breaks the rules of survivor updates or when the survivor changed
+// while it was being saved.
+func (s *Session) saveSurvivor(ctx context.Context, id int, deltas map[string]int) error {
+	ctx, cancel := context.WithTimeout(ctx, ioTimeout)
+	defer cancel()
+	current, err := s.sessions.repo.GetSurvivor(ctx, s.key.settlement, id)
+	if err != nil {
+		return err
+	}
+	changes, changed := repo.Changes{}, false
+	for name, delta := range deltas {
+		st, ok := saved[name]
+		if !ok || delta == 0 {
+			continue
+		}
+		value := st.get(current) + delta
+		if err := inRange(name, value); err != nil {
+			return err
+		}
+		st.set(&changes, value)
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+	updated, _, err := s.sessions.repo.UpdateSurvivor(ctx, s.key.settlement, id, &current.Version, changes)
+	if err != nil {
+		return err
+	}
+	s.sessions.publisher.Publish(ctx, notify.Change{Settlement: s.key.settlement, Entity: history.EntitySurvivor, EntityId: id, Action: history.ActionUpdate, Version: updated.Version})
+	return nil
+}
+
+// broadcast numbers u and sends it to every client. The caller holds s.mu.
+func (s *Session) broadcast(u Update) {
+	s.seq++
+	u.Seq = s.seq
+	for c := range s.clients {
+		s.deliver(c, u)
+	}
+}
+
+// deliver sends u to c, dropping c when it has fallen too far behind to catch
+// up. The caller holds s.mu.
+func (s *Session) deliver(c *client, u Update) {
+	if _, ok := s.clients[c]; !ok {
+		return
+	}
+	select {
+	case c.send <- u:
+	default:
+		s.drop(c)
+	}
+}
+
+// drop disconnects c. The caller holds s.mu.
+func (s *Session) drop(c *client) {
+	if _, ok := s.clients[c]; ok {
+		delete(s.clients, c)
+		close(c.send)
+	}
+}
+
+// participants lists the connected players once each.
+func (s *Session) participants() []string {
+	users := []string{}
+	for c := range s.clients {
+		if !slices.Contains(users, c.user) {
+			users = append(users, c.user)
+		}
+	}
+	sort.Strings(users)
+	return users
+}
+
+// state lists the survivors changed so far by id. The caller holds s.mu.
+func (s *Session) state() []SurvivorState {
+	survivors := make([]SurvivorState, 0, len(s.survivors))
+	for _, survivor := range s.survivors {
+		copied := *survivor
+		copied.Stats = make(map[string]int, len(survivor.Stats))
+		for name, v := range survivor.Stats {
+			copied.Stats[name] = v
+		}
+		copied.deltas = maps.Clone(survivor.deltas)
+		survivors = append(survivors, copied)
+	}
+	sort.Slice(survivors, func(i, j int) bool { return survivors[i].Id < survivors[j].Id })
+	return survivors
+}
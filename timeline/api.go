@@ -0,0 +1,112 @@
+package timeline
+
+import (
+	"net/http"
+
+	"github.com/failuretoload/datamonster/auth"
+	"github.com/failuretoload/datamonster/openapi"
+	"github.com/failuretoload/datamonster/store"
+	"github.com/failuretoload/datamonster/user"
+	"github.com/failuretoload/datamonster/web"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Controller serves settlement state derived from the settlements' events.
+// Reading it requires readScope and adopting innovations writeScope, the scopes
+// guarding the settlement itself.
+type Controller struct {
+	repo       *postgresRepo
+	readScope  string
+	writeScope string
+}
+
+func NewController(conn store.Connection, readScope, writeScope string) *Controller {
+	return &Controller{repo: newRepo(conn), readScope: readScope, writeScope: writeScope}
+}
+
+type StateDTO struct {
+	Year        int           `json:"year"`
+	Survivors   []SurvivorDTO `json:"survivors"`
+	Innovations []string      `json:"innovations"`
+	// Event is the number of the last event the state includes.
+	Event int `json:"event"`
+}
+
+type SurvivorDTO struct {
+	Id     int    `json:"id"`
+	Name   string `json:"name"`
+	Gender string `json:"gender"`
+	Birth  int    `json:"birth"`
+}
+
+// StateQuery selects the lantern year whose end the state is rebuilt at, by
+// default the current state.
+type StateQuery struct {
+	Year *int `query:"year" validate:"min=1"`
+}
+
+type AdoptInnovationRequest struct {
+	Innovation string `json:"innovation" validate:"required,max=64"`
+}
+
+func (c Controller) RegisterRoutes(r chi.Router) {
+	r.With(auth.RequireScope(c.readScope)).Get("/settlements/{id}/state", c.getState)
+	r.With(auth.RequireScope(c.writeScope)).Post("/settlements/{id}/innovations", c.adoptInnovation)
+}
+
+func (c Controller) DescribeRoutes(d *openapi.Document) {
+	tags := []string{"timeline"}
+	d.Add(openapi.Route{Method: http.MethodGet, Path: "/settlements/{id}/state", ID: "getSettlementState", Summary: "Rebuild a settlement from its events, as it is or as it was at the end of a lantern year", Tags: tags, Params: openapi.QueryParams(StateQuery{}), Scopes: []string{c.readScope}, Response: StateDTO{}})
+	d.Add(openapi.Route{Method: http.MethodPost, Path: "/settlements/{id}/innovations", ID: "adoptInnovation", Summary: "Adopt an innovation", Tags: tags, Scopes: []string{c.writeScope}, Request: AdoptInnovationRequest{}, Response: StateDTO{}})
+}
+
+func (c Controller) getState(w http.ResponseWriter, r *http.Request) {
+	settlementId, ok := web.PathId(w, r, "id", "settlement")
+	if !ok {
+		return
+	}
+	var query StateQuery
+	if err := web.DecodeQuery(r, &query); err != nil {
+		web.WriteError(w, r, err)
+		return
+	}
+	u, _ := user.FromContext(r.Context())
+	state, err := c.repo.State(r.Context(), u.Subject, settlementId, query.Year)
+	if err != nil {
+		web.WriteError(w, r, err)
+		return
+	}
+	web.MakeJsonResponse(w, http.StatusOK, domainToDto(state))
+}
+
+func (c Controller) adoptInnovation(w http.ResponseWriter, r *http.Request) {
+	settlementId, ok := web.PathId(w, r, "id", "settlement")
+	if !ok {
+		return
+	}
+	var body AdoptInnovationRequest
+	if err := web.DecodeAndValidate(w, r, &body); err != nil {
+		web.WriteError(w, r, err)
+		return
+	}
+	u, _ := user.FromContext(r.Context())
+	state, err := c.repo.Adopt(r.Context(), u.Subject, settlementId, body.Innovation)
+	if err != nil {
+		web.WriteError(w, r, err)
+		return
+	}
+	web.MakeJsonResponse(w, http.StatusOK, domainToDto(state))
+}
+
+func domainToDto(s State) StateDTO {
+	survivors := make([]SurvivorDTO, len(s.Survivors))
+	for i, v := range s.Survivors {
+		survivors[i] = SurvivorDTO(v)
+	}
+	innovations := s.Innovations
+	if innovations == nil {
+		innovations = []string{}
+	}
+	return StateDTO{Year: s.Year, Survivors: survivors, Innovations: innovations, Event: s.Seq}
+}
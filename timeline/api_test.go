@@ -0,0 +1,244 @@
+package timeline
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/failuretoload/datamonster/auth"
+	storeMocks "github.com/failuretoload/datamonster/store/mocks"
+	"github.com/failuretoload/datamonster/user"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/suite"
+)
+
+const testUserId = "userId"
+
+type TimelineApiTestSuite struct {
+	suite.Suite
+	db     *storeMocks.MockConnection
+	router *chi.Mux
+}
+
+func (suite *TimelineApiTestSuite) SetupTest() {
+	suite.db = &storeMocks.MockConnection{}
+	suite.router = chi.NewRouter()
+	suite.router.Use(asUser)
+	NewController(suite.db, "settlements:read", "settlements:write").RegisterRoutes(suite.router)
+}
+
+// stream answers the ownership check, finds no snapshot and replays events.
+func (suite *TimelineApiTestSuite) stream(events ...Event) {
+	suite.db.SetRow(&storeMocks.RowSequence{Rows: []pgx.Row{
+		&storeMocks.InsertRow{Id: 1},
+		&storeMocks.ErrorRow{Error: pgx.ErrNoRows},
+	}})
+	suite.db.SetRows(eventRows(0, events...))
+}
+
+func (suite *TimelineApiTestSuite) getState(url string) (int, StateDTO) {
+	w := httptest.NewRecorder()
+	suite.router.ServeHTTP(w, httptest.NewRequest("GET", url, nil))
+	state := StateDTO{}
+	if w.Code == 200 {
+		suite.NoError(json.Unmarshal(w.Body.Bytes(), &state))
+	}
+	return w.Code, state
+}
+
+var founding = []Event{
+	SettlementFounded{Name: "Ashes"},
+	SurvivorBorn{SurvivorId: 3, Name: "Zach", Gender: "M", Birth: 1},
+	LanternYearAdvanced{Year: 2},
+	InnovationAdopted{Innovation: "Language"},
+	SurvivorBorn{SurvivorId: 4, Name: "Lucy", Gender: "F", Birth: 2},
+}
+
+func (suite *TimelineApiTestSuite) Test_GetState_ReplaysEveryEvent() {
+	suite.stream(founding...)
+
+	code, state := suite.getState("/settlements/1/state")
+
+	suite.Equal(200, code)
+	suite.Equal([]any{1, testUserId}, suite.db.Statements[0].Args)
+	suite.Equal([]any{1, 0}, suite.db.Statements[2].Args, "all events should be replayed without a snapshot")
+	suite.Equal(2, state.Year)
+	suite.Equal([]string{"Language"}, state.Innovations)
+	suite.Equal([]SurvivorDTO{{Id: 3, Name: "Zach", Gender: "M", Birth: 1}, {Id: 4, Name: "Lucy", Gender: "F", Birth: 2}}, state.Survivors)
+	suite.Equal(5, state.Event)
+}
+
+func (suite *TimelineApiTestSuite) Test_GetState_StopsAtTheEndOfTheLanternYear() {
+	suite.stream(founding...)
+
+	code, state := suite.getState("/settlements/1/state?year=1")
+
+	suite.Equal(200, code)
+	suite.Equal(1, state.Year)
+	suite.Empty(state.Innovations, "innovations of later years should not be included")
+	suite.Equal([]SurvivorDTO{{Id: 3, Name: "Zach", Gender: "M", Birth: 1}}, state.Survivors)
+	suite.Equal(2, state.Event)
+}
+
+func (suite *TimelineApiTestSuite) Test_GetState_FollowsCorrectionsOfTheYear() {
+	suite.stream(
+		SettlementFounded{Name: "Ashes"},
+		LanternYearAdvanced{Year: 3},
+		LanternYearAdvanced{Year: 2},
+		SurvivorRemoved{SurvivorId: 3},
+	)
+
+	code, state := suite.getState("/settlements/1/state?year=3")
+
+	suite.Equal(200, code)
+	suite.Equal(2, state.Year)
+	suite.Equal(4, state.Event)
+}
+
+func (suite *TimelineApiTestSuite) Test_GetState_ReportsYearsNotReached() {
+	suite.stream(founding...)
+
+	code, _ := suite.getState("/settlements/1/state?year=3")
+
+	suite.Equal(404, code)
+}
+
+func (suite *TimelineApiTestSuite) Test_GetState_ReportsReadErrors() {
+	suite.stream(founding...)
+	rows := eventRows(0, founding...)
+	rows.Error = errors.New("connection reset")
+	suite.db.SetRows(rows)
+
+	code, _ := suite.getState("/settlements/1/state")
+
+	suite.Equal(500, code, "a partly replayed timeline should not be answered as the state")
+}
+
+func (suite *TimelineApiTestSuite) Test_GetState_StartsFromTheLatestUsableSnapshot() {
+	saved, _ := json.Marshal(State{Year: 4, Survivors: []Survivor{{Id: 3, Name: "Zach"}}, Seq: 30})
+	suite.db.SetRow(&storeMocks.RowSequence{Rows: []pgx.Row{
+		&storeMocks.InsertRow{Id: 1},
+		&SnapshotRow{Reached: 4, State: saved},
+	}})
+	suite.db.SetRows(eventRows(30, LanternYearAdvanced{Year: 5}, InnovationAdopted{Innovation: "Paint"}))
+
+	code, state := suite.getState("/settlements/1/state?year=4")
+
+	suite.Equal(200, code)
+	suite.Contains(suite.db.Statements[1].SQL, "reached_year <= $2 ORDER BY seq DESC LIMIT 1")
+	suite.Equal([]any{1, 4}, suite.db.Statements[1].Args)
+	suite.Equal([]any{1, 30}, suite.db.Statements[2].Args, "only events after the snapshot should be replayed")
+	suite.Equal(4, state.Year)
+	suite.Equal(30, state.Event)
+	suite.Len(state.Survivors, 1)
+}
+
+func (suite *TimelineApiTestSuite) Test_GetState_SavesSnapshotsOfLongReplays() {
+	events := []Event{SettlementFounded{Name: "Ashes"}}
+	for year := 2; len(events) < SnapshotInterval; year++ {
+		events = append(events, LanternYearAdvanced{Year: year})
+	}
+	suite.stream(events...)
+
+	code, _ := suite.getState("/settlements/1/state")
+
+	suite.Equal(200, code)
+	suite.Contains(suite.db.SQL, "INSERT INTO campaign.settlement_snapshot")
+	suite.Equal([]any{1, SnapshotInterval, SnapshotInterval}, suite.db.Args[:3])
+}
+
+func (suite *TimelineApiTestSuite) Test_GetState_HidesOtherPlayersSettlements() {
+	suite.db.SetRow(&storeMocks.ErrorRow{Error: pgx.ErrNoRows})
+
+	code, _ := suite.getState("/settlements/1/state")
+
+	suite.Equal(404, code)
+}
+
+func (suite *TimelineApiTestSuite) Test_AdoptInnovation_AppendsTheEvent() {
+	suite.stream(founding...)
+	w := httptest.NewRecorder()
+
+	suite.router.ServeHTTP(w, httptest.NewRequest("POST", "/settlements/1/innovations", strings.NewReader(`{"innovation": "Paint"}`)))
+
+	suite.Equal(200, w.Code)
+	suite.Contains(suite.db.SQL, "INSERT INTO campaign.settlement_event")
+	suite.Equal([]any{1, "InnovationAdopted", []byte(`{"innovation":"Paint"}`), testUserId}, suite.db.Args)
+	suite.True(suite.db.Committed)
+	state := StateDTO{}
+	suite.NoError(json.Unmarshal(w.Body.Bytes(), &state))
+	suite.Equal([]string{"Language", "Paint"}, state.Innovations)
+	suite.Equal(6, state.Event)
+}
+
+func (suite *TimelineApiTestSuite) Test_AdoptInnovation_RefusesInnovationsAlreadyAdopted() {
+	suite.stream(founding...)
+	w := httptest.NewRecorder()
+
+	suite.router.ServeHTTP(w, httptest.NewRequest("POST", "/settlements/1/innovations", strings.NewReader(`{"innovation": "Language"}`)))
+
+	suite.Equal(409, w.Code)
+	suite.False(suite.db.Committed)
+}
+
+func (suite *TimelineApiTestSuite) Test_AdoptInnovation_RequiresAnInnovation() {
+	w := httptest.NewRecorder()
+
+	suite.router.ServeHTTP(w, httptest.NewRequest("POST", "/settlements/1/innovations", strings.NewReader(`{"innovation": ""}`)))
+
+	suite.Equal(422, w.Code)
+	suite.Empty(suite.db.Statements)
+}
+
+// asUser authenticates every request as a user, who is not limited by scopes.
+func asUser(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := auth.WithPrincipal(r.Context(), auth.Principal{Subject: testUserId})
+		next.ServeHTTP(w, r.WithContext(user.WithUser(ctx, user.User{Subject: testUserId})))
+	})
+}
+
+func TestTimelineApiTestSuite(t *testing.T) {
+	suite.Run(t, new(TimelineApiTestSuite))
+}
+
+// eventRows numbers events from after + 1.
+func eventRows(after int, events ...Event) *storeMocks.MockRows {
+	rows := make([]pgx.Row, len(events))
+	for i, e := range events {
+		rows[i] = &EventRow{Seq: after + i + 1, Event: e}
+	}
+	return &storeMocks.MockRows{Rows: rows}
+}
+
+type EventRow struct {
+	Seq   int
+	Event Event
+}
+
+func (er *EventRow) Scan(dest ...any) error {
+	payload, err := json.Marshal(er.Event)
+	if err != nil {
+		return err
+	}
+	*dest[0].(*int) = er.Seq
+	*dest[1].(*string) = er.Event.Type()
+	*dest[2].(*[]byte) = payload
+	return nil
+}
+
+type SnapshotRow struct {
+	Reached int
+	State   []byte
+}
+
+func (sr *SnapshotRow) Scan(dest ...any) error {
+	*dest[0].(*int) = sr.Reached
+	*dest[1].(*[]byte) = sr.State
+	return nil
+}
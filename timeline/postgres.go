@@ -0,0 +1,164 @@
+package timeline
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"slices"
+
+	"github.com/failuretoload/datamonster/domain"
+	"github.com/failuretoload/datamonster/logging"
+	"github.com/failuretoload/datamonster/store"
+	"github.com/failuretoload/datamonster/tracing"
+	"github.com/jackc/pgx/v5"
+)
+
+// SnapshotInterval is how many events a rebuild replays before it saves a
+// snapshot for later rebuilds to start from.
+const SnapshotInterval = 25
+
+type postgresRepo struct {
+	pool store.Connection
+}
+
+func newRepo(conn store.Connection) *postgresRepo {
+	return &postgresRepo{pool: conn}
+}
+
+// State rebuilds one of owner's settlements, as of the end of lantern year asOf
+// unless it is nil.
+func (r postgresRepo) State(ctx context.Context, owner string, settlementId int, asOf *int) (State, error) {
+	ctx, span := tracing.Start(ctx, "timeline.State")
+	defer span.End()
+	if err := store.Owned(ctx, r.pool, owner, settlementId); err != nil {
+		return State{}, err
+	}
+	state, replayed, err := rebuild(ctx, r.pool, settlementId, asOf)
+	if err == nil && replayed >= SnapshotInterval {
+		save(ctx, r.pool, settlementId, state)
+	}
+	return state, err
+}
+
+// Adopt records that one of owner's settlements adopted an innovation and
+// returns the resulting state.
+func (r postgresRepo) Adopt(ctx context.Context, owner string, settlementId int, innovation string) (State, error) {
+	ctx, span := tracing.Start(ctx, "timeline.Adopt")
+	defer span.End()
+	var state State
+	err := pgx.BeginFunc(ctx, r.pool, func(tx pgx.Tx) error {
+		if err := store.Owned(ctx, tx, owner, settlementId); err != nil {
+			return err
+		}
+		current, _, err := rebuild(ctx, tx, settlementId, nil)
+		if err != nil {
+			return err
+		}
+		e := InnovationAdopted{Innovation: innovation}
+		if slices.Contains(current.Innovations, innovation) {
+			return domain.Conflict("the settlement has already adopted %s", innovation)
+		}
+		if err := Append(ctx, tx, settlementId, e); err != nil {
+			return err
+		}
+		state = current.Apply(current.Seq+1, e)
+		return nil
+	})
+	return state, err
+}
+
+// Innovations lists the innovations a settlement adopted, in the order it
+// adopted them.
+func Innovations(ctx context.Context, q store.Querier, settlementId int) ([]string, error) {
+	query := `SELECT COALESCE(array_agg(payload->>'innovation' ORDER BY seq), '{}') FROM campaign.settlement_event
+		WHERE settlement = $1 AND type = $2`
+	var innovations []string
+	err := q.QueryRow(ctx, query, settlementId, InnovationAdopted{}.Type()).Scan(&innovations)
+	return innovations, store.TranslateError(err, "settlement event")
+}
+
+// rebuild replays the settlement's events from its latest usable snapshot and
+// reports how many it replayed.
+func rebuild(ctx context.Context, q store.Querier, settlementId int, asOf *int) (State, int, error) {
+	state, err := snapshot(ctx, q, settlementId, asOf)
+	if err != nil {
+		return state, 0, err
+	}
+	query := `SELECT seq, type, payload FROM campaign.settlement_event WHERE settlement = $1 AND seq > $2 ORDER BY seq`
+	rows, err := q.Query(ctx, query, settlementId, state.Seq)
+	if err != nil {
+		return state, 0, store.TranslateError(err, "settlement event")
+	}
+	defer rows.Close()
+	replayed := 0
+	passed := false
+	for rows.Next() {
+		var seq int
+		var eventType string
+		var payload []byte
+		if err := rows.Scan(&seq, &eventType, &payload); err != nil {
+			return state, replayed, store.TranslateError(err, "settlement event")
+		}
+		e, err := decode(eventType, payload)
+		if err != nil {
+			return state, replayed, err
+		}
+		if asOf != nil && ends(*asOf, e) {
+			passed = true
+			break
+		}
+		state = state.Apply(seq, e)
+		replayed++
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return state, replayed, store.TranslateError(err, "settlement event")
+	}
+	if state.Seq == 0 {
+		return state, replayed, domain.NotFound("settlement has no recorded events")
+	}
+	if asOf != nil && !passed && state.reached < *asOf {
+		return state, replayed, domain.NotFound("settlement has not reached lantern year %d", *asOf)
+	}
+	return state, replayed, nil
+}
+
+// snapshot loads the latest snapshot taken before the settlement moved past
+// lantern year asOf, or an empty state when there is none.
+func snapshot(ctx context.Context, q store.Querier, settlementId int, asOf *int) (State, error) {
+	query := `SELECT reached_year, state FROM campaign.settlement_snapshot WHERE settlement = $1`
+	args := []any{settlementId}
+	if asOf != nil {
+		query += ` AND reached_year <= $2`
+		args = append(args, *asOf)
+	}
+	var state State
+	var reached int
+	var saved []byte
+	err := q.QueryRow(ctx, query+` ORDER BY seq DESC LIMIT 1`, args...).Scan(&reached, &saved)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return state, nil
+	}
+	if err != nil {
+		return state, store.TranslateError(err, "settlement snapshot")
+	}
+	if err := json.Unmarshal(saved, &state); err != nil {
+		return state, err
+	}
+	state.reached = reached
+	return state, nil
+}
+
+// save stores a snapshot of state. Snapshots only speed up rebuilds, so failing
+// to save one is logged rather than reported.
+func save(ctx context.Context, q store.Querier, settlementId int, state State) {
+	saved, err := json.Marshal(state)
+	if err == nil {
+		query := `INSERT INTO campaign.settlement_snapshot (settlement, seq, reached_year, state) VALUES ($1, $2, $3, $4)
+			ON CONFLICT DO NOTHING`
+		_, err = q.Exec(ctx, query, settlementId, state.Seq, state.reached, saved)
+	}
+	if err != nil {
+		logging.FromContext(ctx).Warn("unable to save settlement snapshot", "settlement", settlementId, "error", err)
+	}
+}
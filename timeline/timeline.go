@@ -0,0 +1,145 @@
+// Package timeline derives the state of a settlement from the stream of domain
+// events that happened to it, and rebuilds it as of any lantern year.
+package timeline
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+
+	"github.com/failuretoload/datamonster/auth"
+	"github.com/failuretoload/datamonster/store"
+)
+
+// Event is a domain event of a settlement's stream.
+type Event interface {
+	// Type names the event in the stream.
+	Type() string
+}
+
+type SettlementFounded struct {
+	Name string `json:"name"`
+}
+
+type SurvivorBorn struct {
+	SurvivorId int    `json:"survivorId"`
+	Name       string `json:"name"`
+	Gender     string `json:"gender"`
+	Birth      int    `json:"birth"`
+}
+
+// SurvivorRemoved takes back the birth of a survivor added by mistake.
+type SurvivorRemoved struct {
+	SurvivorId int `json:"survivorId"`
+}
+
+// LanternYearAdvanced moves the settlement to Year. Corrections may move it
+// back.
+type LanternYearAdvanced struct {
+	Year int `json:"year"`
+}
+
+type InnovationAdopted struct {
+	Innovation string `json:"innovation"`
+}
+
+func (SettlementFounded) Type() string   { return "SettlementFounded" }
+func (SurvivorBorn) Type() string        { return "SurvivorBorn" }
+func (SurvivorRemoved) Type() string     { return "SurvivorRemoved" }
+func (LanternYearAdvanced) Type() string { return "LanternYearAdvanced" }
+func (InnovationAdopted) Type() string   { return "InnovationAdopted" }
+
+// decode reads the payload of an event of the named type.
+func decode(eventType string, payload []byte) (Event, error) {
+	switch eventType {
+	case SettlementFounded{}.Type():
+		return unmarshal[SettlementFounded](payload)
+	case SurvivorBorn{}.Type():
+		return unmarshal[SurvivorBorn](payload)
+	case SurvivorRemoved{}.Type():
+		return unmarshal[SurvivorRemoved](payload)
+	case LanternYearAdvanced{}.Type():
+		return unmarshal[LanternYearAdvanced](payload)
+	case InnovationAdopted{}.Type():
+		return unmarshal[InnovationAdopted](payload)
+	}
+	return nil, fmt.Errorf("unknown settlement event type %q", eventType)
+}
+
+func unmarshal[E Event](payload []byte) (Event, error) {
+	var e E
+	if err := json.Unmarshal(payload, &e); err != nil {
+		return nil, fmt.Errorf("decoding %s event: %w", e.Type(), err)
+	}
+	return e, nil
+}
+
+// State is a settlement as derived from its events.
+type State struct {
+	Year        int        `json:"year"`
+	Survivors   []Survivor `json:"survivors"`
+	Innovations []string   `json:"innovations"`
+	// Seq is the number of the last event applied.
+	Seq int `json:"seq"`
+	// reached is the latest lantern year the settlement has reached.
+	reached int
+}
+
+// Survivor is a survivor of the settlement as they were born.
+type Survivor struct {
+	Id     int    `json:"id"`
+	Name   string `json:"name"`
+	Gender string `json:"gender"`
+	Birth  int    `json:"birth"`
+}
+
+// Apply returns the state after event number seq.
+func (s State) Apply(seq int, e Event) State {
+	s.Seq = seq
+	switch e := e.(type) {
+	case SettlementFounded:
+		s.Year = 1
+	case SurvivorBorn:
+		s.Survivors = append(slices.Clip(s.Survivors), Survivor{Id: e.SurvivorId, Name: e.Name, Gender: e.Gender, Birth: e.Birth})
+	case SurvivorRemoved:
+		s.Survivors = slices.DeleteFunc(slices.Clone(s.Survivors), func(v Survivor) bool { return v.Id == e.SurvivorId })
+	case LanternYearAdvanced:
+		s.Year = e.Year
+	case InnovationAdopted:
+		if !slices.Contains(s.Innovations, e.Innovation) {
+			s.Innovations = append(slices.Clip(s.Innovations), e.Innovation)
+		}
+	}
+	s.reached = max(s.reached, s.Year)
+	return s
+}
+
+// ends reports whether e moves the settlement past lantern year asOf, so that a
+// state as of that year must not include it.
+func ends(asOf int, e Event) bool {
+	advanced, ok := e.(LanternYearAdvanced)
+	return ok && advanced.Year > asOf
+}
+
+// Append adds e to the end of the settlement's stream, made by the caller of the
+// request. It must run in the transaction that made the change e describes.
+func Append(ctx context.Context, q store.Querier, settlementId int, e Event) error {
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	actor := ""
+	if p, ok := auth.PrincipalFrom(ctx); ok {
+		actor = p.Subject
+	}
+	// Locking the settlement serializes appends, so no two events get the same
+	// number.
+	if _, err := q.Exec(ctx, `SELECT id FROM campaign.settlement WHERE id = $1 FOR NO KEY UPDATE`, settlementId); err != nil {
+		return store.TranslateError(err, "settlement")
+	}
+	query := `INSERT INTO campaign.settlement_event (settlement, seq, type, payload, actor)
+		SELECT $1, COALESCE(MAX(seq), 0) + 1, $2::text, $3::jsonb, $4::text FROM campaign.settlement_event WHERE settlement = $1`
+	_, err = q.Exec(ctx, query, settlementId, e.Type(), payload, actor)
+	return store.TranslateError(err, "settlement event")
+}
@@ -0,0 +1,161 @@
+package token
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/failuretoload/datamonster/auth"
+	"github.com/failuretoload/datamonster/openapi"
+	"github.com/failuretoload/datamonster/ratelimit"
+	"github.com/failuretoload/datamonster/store"
+	postgres "github.com/failuretoload/datamonster/token/internal"
+	"github.com/failuretoload/datamonster/web"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// createBudget limits how many tokens a user can create.
+var createBudget = ratelimit.PerHour(20)
+
+// Controller lets users manage their personal access tokens. Tokens can be
+// granted any of the scopes the controller was created with.
+type Controller struct {
+	repo   *postgres.PostgresRepo
+	scopes []string
+}
+
+func NewController(conn store.Connection, scopes ...string) *Controller {
+	return &Controller{repo: postgres.New(conn), scopes: scopes}
+}
+
+type CreateTokenRequest struct {
+	Name          string   `json:"name" validate:"required,max=64"`
+	Scopes        []string `json:"scopes" validate:"required"`
+	ExpiresInDays int      `json:"expiresInDays" validate:"required,min=1,max=365"`
+}
+
+type TokenDTO struct {
+	Id         int        `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	ExpiresAt  time.Time  `json:"expiresAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt"`
+}
+
+// CreatedTokenDTO is the only response that includes the token itself.
+type CreatedTokenDTO struct {
+	TokenDTO
+	Token string `json:"token"`
+}
+
+func (c Controller) RegisterRoutes(r chi.Router) {
+	r.Group(func(r chi.Router) {
+		r.Use(usersOnly)
+		r.Get("/tokens", c.listTokens)
+		r.With(ratelimit.Limit("tokens.create", createBudget)).Post("/tokens", c.createToken)
+		r.Delete("/tokens/{id}", c.revokeToken)
+	})
+}
+
+func (c Controller) DescribeRoutes(d *openapi.Document) {
+	tags := []string{"tokens"}
+	d.Add(openapi.Route{Method: http.MethodGet, Path: "/tokens", ID: "listTokens", Summary: "List the caller's personal access tokens", Tags: tags, Response: []TokenDTO{}})
+	d.Add(openapi.Route{Method: http.MethodPost, Path: "/tokens", ID: "createToken", Summary: fmt.Sprintf("Create a personal access token with any of the scopes %s", strings.Join(c.scopes, ", ")), Tags: tags, Request: CreateTokenRequest{}, Response: CreatedTokenDTO{}, Status: http.StatusCreated})
+	d.Add(openapi.Route{Method: http.MethodDelete, Path: "/tokens/{id}", ID: "revokeToken", Summary: "Revoke a personal access token", Tags: tags, Status: http.StatusNoContent})
+}
+
+// usersOnly keeps tokens from being used to mint or revoke other tokens.
+func usersOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if principal, _ := auth.PrincipalFrom(r.Context()); principal.Machine {
+			web.WriteProblem(w, r, http.StatusForbidden, "personal access tokens can only be managed by users")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (c Controller) listTokens(w http.ResponseWriter, r *http.Request) {
+	principal, _ := auth.PrincipalFrom(r.Context())
+	tokens, err := c.repo.List(r.Context(), principal.Subject)
+	if err != nil {
+		web.WriteError(w, r, err)
+		return
+	}
+	data := []TokenDTO{}
+	for _, t := range tokens {
+		data = append(data, domainToDto(t))
+	}
+	web.MakeJsonResponse(w, http.StatusOK, data)
+}
+
+func (c Controller) createToken(w http.ResponseWriter, r *http.Request) {
+	principal, _ := auth.PrincipalFrom(r.Context())
+	var body CreateTokenRequest
+	if err := web.DecodeAndValidate(w, r, &body); err != nil {
+		web.WriteError(w, r, err)
+		return
+	}
+	if err := c.checkScopes(body.Scopes); err != nil {
+		web.WriteError(w, r, err)
+		return
+	}
+	secret, err := Generate()
+	if err != nil {
+		web.WriteError(w, r, err)
+		return
+	}
+	token := postgres.Token{
+		Owner:     principal.Subject,
+		Name:      body.Name,
+		Prefix:    secret[:len(Prefix)+6],
+		Scopes:    body.Scopes,
+		ExpiresAt: time.Now().Add(time.Duration(body.ExpiresInDays) * 24 * time.Hour).UTC(),
+	}
+	created, err := c.repo.Insert(r.Context(), token, Hash(secret))
+	if err != nil {
+		web.WriteError(w, r, err)
+		return
+	}
+	web.MakeJsonResponse(w, http.StatusCreated, CreatedTokenDTO{TokenDTO: domainToDto(created), Token: secret})
+}
+
+func (c Controller) revokeToken(w http.ResponseWriter, r *http.Request) {
+	principal, _ := auth.PrincipalFrom(r.Context())
+	id, ok := web.PathId(w, r, "id", "token")
+	if !ok {
+		return
+	}
+	if err := c.repo.Revoke(r.Context(), principal.Subject, id); err != nil {
+		web.WriteError(w, r, err)
+		return
+	}
+	web.MakeJsonResponse(w, http.StatusNoContent, nil)
+}
+
+func (c Controller) checkScopes(scopes []string) error {
+	errs := &web.ValidationError{}
+	for _, scope := range scopes {
+		if !slices.Contains(c.scopes, scope) {
+			errs.Add("scopes", fmt.Sprintf("%q must be one of %s", scope, strings.Join(c.scopes, ", ")))
+		}
+	}
+	return errs.OrNil()
+}
+
+func domainToDto(t postgres.Token) TokenDTO {
+	return TokenDTO{
+		Id:         t.Id,
+		Name:       t.Name,
+		Prefix:     t.Prefix,
+		Scopes:     t.Scopes,
+		CreatedAt:  t.CreatedAt,
+		ExpiresAt:  t.ExpiresAt,
+		LastUsedAt: t.LastUsedAt,
+	}
+}
@@ -0,0 +1,187 @@
+package token
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/failuretoload/datamonster/auth"
+	storeMocks "github.com/failuretoload/datamonster/store/mocks"
+	postgres "github.com/failuretoload/datamonster/token/internal"
+	"github.com/failuretoload/datamonster/web"
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/suite"
+)
+
+var testUserId = "userId"
+
+type TokenApiTestSuite struct {
+	suite.Suite
+	target    *Controller
+	db        *storeMocks.MockConnection
+	router    *chi.Mux
+	principal auth.Principal
+}
+
+func (suite *TokenApiTestSuite) SetupTest() {
+	suite.db = &storeMocks.MockConnection{}
+	suite.target = NewController(suite.db, "settlements:read", "survivors:write")
+	suite.principal = auth.Principal{Subject: testUserId}
+	suite.router = chi.NewRouter()
+	suite.router.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r.WithContext(auth.WithPrincipal(r.Context(), suite.principal)))
+		})
+	})
+	suite.target.RegisterRoutes(suite.router)
+}
+
+func (suite *TokenApiTestSuite) Test_CreateToken_ReturnsTheTokenOnce() {
+	suite.db.SetRow(&TokenRow{Token: postgres.Token{Id: 7, CreatedAt: time.Now()}})
+	body := `{"name": "bulk import", "scopes": ["survivors:write"], "expiresInDays": 30}`
+	w := httptest.NewRecorder()
+
+	suite.router.ServeHTTP(w, httptest.NewRequest("POST", "/tokens", strings.NewReader(body)))
+
+	suite.Equal(201, w.Code, "201 should be returned")
+	created := CreatedTokenDTO{}
+	suite.NoError(json.Unmarshal(w.Body.Bytes(), &created))
+	suite.Equal(7, created.Id)
+	suite.True(strings.HasPrefix(created.Token, Prefix), "the token should be returned with its prefix")
+	suite.True(strings.HasPrefix(created.Token, created.Prefix), "the displayed prefix should identify the token")
+	suite.Less(len(created.Prefix), len(created.Token), "the displayed prefix should not reveal the token")
+	suite.WithinDuration(time.Now().Add(30*24*time.Hour), created.ExpiresAt, time.Minute)
+}
+
+func (suite *TokenApiTestSuite) Test_CreateToken_RejectsUnknownScopes() {
+	body := `{"name": "bulk import", "scopes": ["settlements:read", "everything"], "expiresInDays": 30}`
+	w := httptest.NewRecorder()
+
+	suite.router.ServeHTTP(w, httptest.NewRequest("POST", "/tokens", strings.NewReader(body)))
+
+	suite.Equal(422, w.Code, "422 should be returned for scopes that cannot be granted")
+	problem := web.ValidationProblem{}
+	suite.NoError(json.Unmarshal(w.Body.Bytes(), &problem))
+	suite.Equal([]web.FieldError{{Field: "scopes", Message: `"everything" must be one of settlements:read, survivors:write`}}, problem.Errors)
+}
+
+func (suite *TokenApiTestSuite) Test_CreateToken_RequiresScopesAndExpiry() {
+	w := httptest.NewRecorder()
+
+	suite.router.ServeHTTP(w, httptest.NewRequest("POST", "/tokens", strings.NewReader(`{"name": "bulk import", "scopes": []}`)))
+
+	suite.Equal(422, w.Code)
+	problem := web.ValidationProblem{}
+	suite.NoError(json.Unmarshal(w.Body.Bytes(), &problem))
+	suite.Equal([]web.FieldError{{Field: "scopes", Message: "is required"}, {Field: "expiresInDays", Message: "must be at least 1"}}, problem.Errors)
+}
+
+func (suite *TokenApiTestSuite) Test_Tokens_CannotBeManagedWithATokenOrClient() {
+	suite.principal = auth.Principal{Subject: testUserId, Machine: true, Scopes: []string{"settlements:read"}}
+	w := httptest.NewRecorder()
+
+	suite.router.ServeHTTP(w, httptest.NewRequest("POST", "/tokens", strings.NewReader(`{}`)))
+
+	suite.Equal(403, w.Code, "tokens should not be able to create more tokens")
+}
+
+func (suite *TokenApiTestSuite) Test_ListTokens_ReturnsTokens() {
+	used := time.Now()
+	suite.db.SetRows(&storeMocks.MockRows{Rows: []pgx.Row{
+		&TokenRow{Token: postgres.Token{Id: 1, Owner: testUserId, Name: "a", Prefix: "dm_pat_abcdef", Scopes: []string{"settlements:read"}, LastUsedAt: &used}},
+		&TokenRow{Token: postgres.Token{Id: 2, Owner: testUserId, Name: "b", Prefix: "dm_pat_ghijkl", Scopes: []string{"survivors:write"}}},
+	}})
+	w := httptest.NewRecorder()
+
+	suite.router.ServeHTTP(w, httptest.NewRequest("GET", "/tokens", nil))
+
+	suite.Equal(200, w.Code)
+	body, _ := io.ReadAll(w.Body)
+	suite.NotContains(string(body), "hash", "token hashes should never be returned")
+	dto := []TokenDTO{}
+	suite.NoError(json.Unmarshal(body, &dto))
+	suite.Len(dto, 2)
+	suite.NotNil(dto[0].LastUsedAt, "the last use should be reported")
+	suite.Nil(dto[1].LastUsedAt, "unused tokens should say so")
+}
+
+func (suite *TokenApiTestSuite) Test_ListTokens_ReportsReadErrors() {
+	suite.db.SetRows(&storeMocks.MockRows{Error: errors.New("connection reset")})
+	w := httptest.NewRecorder()
+
+	suite.router.ServeHTTP(w, httptest.NewRequest("GET", "/tokens", nil))
+
+	suite.Equal(500, w.Code, "a partly read list should not be answered as complete")
+}
+
+func (suite *TokenApiTestSuite) Test_RevokeToken_ReportsMissingTokens() {
+	suite.db.SetRow(&storeMocks.ErrorRow{Error: pgx.ErrNoRows})
+	w := httptest.NewRecorder()
+
+	suite.router.ServeHTTP(w, httptest.NewRequest("DELETE", "/tokens/3", nil))
+
+	suite.Equal(404, w.Code, "revoking another user's or a revoked token should not be possible")
+}
+
+func (suite *TokenApiTestSuite) Test_RevokeToken_RejectsMalformedIds() {
+	w := httptest.NewRecorder()
+
+	suite.router.ServeHTTP(w, httptest.NewRequest("DELETE", "/tokens/first", nil))
+
+	suite.Equal(400, w.Code)
+	problem := web.Problem{}
+	suite.NoError(json.Unmarshal(w.Body.Bytes(), &problem))
+	suite.Equal("token id must be a positive integer", problem.Detail)
+	suite.Empty(suite.db.SQL, "nothing should be revoked")
+}
+
+func (suite *TokenApiTestSuite) Test_Authenticator_ActsAsTheOwnerWithTheTokensScopes() {
+	suite.db.SetRow(&TokenRow{Token: postgres.Token{Id: 1, Owner: testUserId, Scopes: []string{"survivors:write"}}})
+
+	principal, err := NewAuthenticator(suite.db).Authenticate(context.Background(), Prefix+"secret")
+
+	suite.NoError(err)
+	suite.Equal(auth.Principal{Subject: testUserId, Machine: true, Scopes: []string{"survivors:write"}}, principal)
+}
+
+func (suite *TokenApiTestSuite) Test_Authenticator_RejectsUnknownTokens() {
+	suite.db.SetRow(&storeMocks.ErrorRow{Error: pgx.ErrNoRows})
+
+	_, err := NewAuthenticator(suite.db).Authenticate(context.Background(), Prefix+"revoked-or-expired")
+
+	suite.ErrorIs(err, auth.ErrInvalidToken)
+}
+
+func TestTokenApiTestSuite(t *testing.T) {
+	suite.Run(t, new(TokenApiTestSuite))
+}
+
+// TokenRow scans a token in the column order of the repository's queries. The
+// insert only returns id and created_at.
+type TokenRow struct {
+	Token postgres.Token
+}
+
+func (t *TokenRow) Scan(dest ...any) error {
+	if len(dest) == 2 {
+		*dest[0].(*int) = t.Token.Id
+		*dest[1].(*time.Time) = t.Token.CreatedAt
+		return nil
+	}
+	*dest[0].(*int) = t.Token.Id
+	*dest[1].(*string) = t.Token.Owner
+	*dest[2].(*string) = t.Token.Name
+	*dest[3].(*string) = t.Token.Prefix
+	*dest[4].(*[]string) = t.Token.Scopes
+	*dest[5].(*time.Time) = t.Token.CreatedAt
+	*dest[6].(*time.Time) = t.Token.ExpiresAt
+	*dest[7].(**time.Time) = t.Token.LastUsedAt
+	return nil
+}
@@ -0,0 +1,85 @@
+package internal
+
+import (
+	"context"
+	"time"
+
+	"github.com/failuretoload/datamonster/store"
+	"github.com/failuretoload/datamonster/tracing"
+)
+
+type PostgresRepo struct {
+	pool store.Connection
+}
+
+// Token is a personal access token as stored; the secret itself is never kept.
+type Token struct {
+	Id         int
+	Owner      string
+	Name       string
+	Prefix     string
+	Scopes     []string
+	CreatedAt  time.Time
+	ExpiresAt  time.Time
+	LastUsedAt *time.Time
+}
+
+func New(d store.Connection) *PostgresRepo {
+	return &PostgresRepo{pool: d}
+}
+
+const columns = "id, owner, name, prefix, scopes, created_at, expires_at, last_used_at"
+
+func (r PostgresRepo) Insert(ctx context.Context, t Token, hash string) (Token, error) {
+	ctx, span := tracing.Start(ctx, "tokens.Insert")
+	defer span.End()
+	query := `INSERT INTO campaign.personal_access_token (owner, name, prefix, hash, scopes, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6) RETURNING id, created_at`
+	err := r.pool.QueryRow(ctx, query, t.Owner, t.Name, t.Prefix, hash, t.Scopes, t.ExpiresAt).Scan(&t.Id, &t.CreatedAt)
+	return t, store.TranslateError(err, "token")
+}
+
+// List returns the owner's tokens that have not been revoked, including expired ones.
+func (r PostgresRepo) List(ctx context.Context, owner string) ([]Token, error) {
+	ctx, span := tracing.Start(ctx, "tokens.List")
+	defer span.End()
+	query := `SELECT ` + columns + ` FROM campaign.personal_access_token
+		WHERE owner = $1 AND revoked_at IS NULL ORDER BY created_at DESC`
+	rows, err := r.pool.Query(ctx, query, owner)
+	if err != nil {
+		return []Token{}, store.TranslateError(err, "token")
+	}
+	defer rows.Close()
+	tokens := []Token{}
+	for rows.Next() {
+		var t Token
+		if err := rows.Scan(&t.Id, &t.Owner, &t.Name, &t.Prefix, &t.Scopes, &t.CreatedAt, &t.ExpiresAt, &t.LastUsedAt); err != nil {
+			return tokens, store.TranslateError(err, "token")
+		}
+		tokens = append(tokens, t)
+	}
+	if err := rows.Err(); err != nil {
+		return tokens, store.TranslateError(err, "token")
+	}
+	return tokens, nil
+}
+
+func (r PostgresRepo) Revoke(ctx context.Context, owner string, id int) error {
+	ctx, span := tracing.Start(ctx, "tokens.Revoke")
+	defer span.End()
+	query := `UPDATE campaign.personal_access_token SET revoked_at = now()
+		WHERE id = $1 AND owner = $2 AND revoked_at IS NULL RETURNING id`
+	err := r.pool.QueryRow(ctx, query, id, owner).Scan(&id)
+	return store.TranslateError(err, "token")
+}
+
+// Use finds the live token with hash and records that it was just used.
+func (r PostgresRepo) Use(ctx context.Context, hash string) (Token, error) {
+	ctx, span := tracing.Start(ctx, "tokens.Use")
+	defer span.End()
+	query := `UPDATE campaign.personal_access_token SET last_used_at = now()
+		WHERE hash = $1 AND revoked_at IS NULL AND expires_at > now() RETURNING ` + columns
+	var t Token
+	err := r.pool.QueryRow(ctx, query, hash).Scan(&t.Id, &t.Owner, &t.Name, &t.Prefix, &t.Scopes, &t.CreatedAt, &t.ExpiresAt, &t.LastUsedAt)
+	return t, store.TranslateError(err, "token")
+}
@@ -0,0 +1,70 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/failuretoload/datamonster/config"
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/suite"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+type TracingTestSuite struct {
+	suite.Suite
+	spans  *tracetest.SpanRecorder
+	router *chi.Mux
+}
+
+func (suite *TracingTestSuite) SetupTest() {
+	suite.spans = tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(suite.spans)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	suite.router = chi.NewRouter()
+	suite.router.Use(Middleware)
+	suite.router.Get("/settlements/{id}", func(w http.ResponseWriter, r *http.Request) {
+		_, span := Start(r.Context(), "settlements.Get")
+		span.End()
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+}
+
+func (suite *TracingTestSuite) TearDownTest() {
+	otel.SetTracerProvider(sdktrace.NewTracerProvider())
+}
+
+func (suite *TracingTestSuite) Test_Middleware_ContinuesIncomingTraces() {
+	req := httptest.NewRequest("GET", "/settlements/1", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+
+	suite.router.ServeHTTP(httptest.NewRecorder(), req)
+
+	ended := suite.spans.Ended()
+	suite.Require().Len(ended, 2)
+	child, server := ended[0], ended[1]
+	suite.Equal("4bf92f3577b34da6a3ce929d0e0e4736", server.SpanContext().TraceID().String())
+	suite.Equal("00f067aa0ba902b7", server.Parent().SpanID().String())
+	suite.Equal(server.SpanContext().SpanID(), child.Parent().SpanID(), "spans started by handlers should be children of the request span")
+	suite.Equal("GET /settlements/{id}", server.Name())
+	suite.Contains(server.Attributes(), attribute.Int("http.response.status_code", 500))
+	suite.Equal(codes.Error, server.Status().Code)
+}
+
+func (suite *TracingTestSuite) Test_Init_DisabledExporterStillPropagates() {
+	flush, err := Init(context.Background(), config.Tracing{Exporter: "none", SampleRatio: 1}, nil)
+
+	suite.NoError(err)
+	suite.NoError(flush(context.Background()))
+	suite.Contains(otel.GetTextMapPropagator().Fields(), "traceparent")
+}
+
+func TestTracingTestSuite(t *testing.T) {
+	suite.Run(t, new(TracingTestSuite))
+}
@@ -0,0 +1,134 @@
+package user
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/failuretoload/datamonster/auth"
+	"github.com/failuretoload/datamonster/openapi"
+	"github.com/failuretoload/datamonster/store"
+	repo "github.com/failuretoload/datamonster/user/internal"
+	"github.com/failuretoload/datamonster/web"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	ScopeRead  = "profile:read"
+	ScopeWrite = "profile:write"
+)
+
+type Controller struct {
+	repo *repo.PostgresRepo
+}
+
+func NewController(conn store.Connection) *Controller {
+	return &Controller{repo: repo.New(conn)}
+}
+
+type UserDTO struct {
+	Id                int            `json:"id"`
+	DisplayName       string         `json:"displayName"`
+	DefaultExpansions []string       `json:"defaultExpansions"`
+	Preferences       PreferencesDTO `json:"preferences"`
+	CreatedAt         time.Time      `json:"createdAt"`
+	UpdatedAt         time.Time      `json:"updatedAt"`
+}
+
+type PreferencesDTO struct {
+	Theme        string `json:"theme"`
+	Locale       string `json:"locale"`
+	CompactLists bool   `json:"compactLists"`
+}
+
+// UpdateUserRequest changes only the fields it includes. Preferences are merged
+// with the stored ones.
+type UpdateUserRequest struct {
+	DisplayName       *string                   `json:"displayName" validate:"max=64"`
+	DefaultExpansions *[]string                 `json:"defaultExpansions" validate:"oneof=dragon-king dung-beetle-knight flower-knight gorm green-knight-armor lion-god lion-knight lonely-tree manhunter slenderman spidicules sunstalker"`
+	Preferences       *UpdatePreferencesRequest `json:"preferences"`
+}
+
+type UpdatePreferencesRequest struct {
+	Theme        *string `json:"theme,omitempty" validate:"oneof=system light dark"`
+	Locale       *string `json:"locale,omitempty" validate:"max=35"`
+	CompactLists *bool   `json:"compactLists,omitempty"`
+}
+
+func (c Controller) RegisterRoutes(r chi.Router) {
+	r.With(auth.RequireScope(ScopeRead)).Get("/me", c.getMe)
+	r.With(auth.RequireScope(ScopeWrite)).Patch("/me", c.updateMe)
+}
+
+func (c Controller) DescribeRoutes(d *openapi.Document) {
+	tags := []string{"users"}
+	d.Add(openapi.Route{Method: http.MethodGet, Path: "/me", ID: "getMe", Summary: "Get the caller's profile, creating it on first use", Tags: tags, Scopes: []string{ScopeRead}, Response: UserDTO{}})
+	d.Add(openapi.Route{Method: http.MethodPatch, Path: "/me", ID: "updateMe", Summary: "Update the caller's profile", Tags: tags, Scopes: []string{ScopeWrite}, Request: UpdateUserRequest{}, Response: UserDTO{}})
+}
+
+func (c Controller) getMe(w http.ResponseWriter, r *http.Request) {
+	u, ok := FromContext(r.Context())
+	if !ok {
+		web.WriteProblem(w, r, http.StatusUnauthorized, "no user provided")
+		return
+	}
+	web.MakeJsonResponse(w, http.StatusOK, domainToDto(u))
+}
+
+func (c Controller) updateMe(w http.ResponseWriter, r *http.Request) {
+	u, ok := FromContext(r.Context())
+	if !ok {
+		web.WriteProblem(w, r, http.StatusUnauthorized, "no user provided")
+		return
+	}
+	var body UpdateUserRequest
+	if err := web.DecodeAndValidate(w, r, &body); err != nil {
+		web.WriteError(w, r, err)
+		return
+	}
+	changes := repo.Changes{DisplayName: body.DisplayName}
+	if body.DefaultExpansions != nil {
+		changes.DefaultExpansions = append([]string{}, *body.DefaultExpansions...)
+	}
+	if p := body.Preferences; p != nil {
+		changes.Preferences = map[string]any{}
+		if p.Theme != nil {
+			changes.Preferences["theme"] = *p.Theme
+		}
+		if p.Locale != nil {
+			changes.Preferences["locale"] = *p.Locale
+		}
+		if p.CompactLists != nil {
+			changes.Preferences["compactLists"] = *p.CompactLists
+		}
+	}
+	updated, err := c.repo.Update(r.Context(), u.Subject, changes)
+	if err != nil {
+		web.WriteError(w, r, err)
+		return
+	}
+	web.MakeJsonResponse(w, http.StatusOK, domainToDto(updated))
+}
+
+func domainToDto(u User) UserDTO {
+	expansions := u.DefaultExpansions
+	if expansions == nil {
+		expansions = []string{}
+	}
+	theme := u.Preferences.Theme
+	if theme == "" {
+		theme = "system"
+	}
+	return UserDTO{
+		Id:                u.Id,
+		DisplayName:       u.DisplayName,
+		DefaultExpansions: expansions,
+		Preferences: PreferencesDTO{
+			Theme:        theme,
+			Locale:       u.Preferences.Locale,
+			CompactLists: u.Preferences.CompactLists,
+		},
+		CreatedAt: u.CreatedAt,
+		UpdatedAt: u.UpdatedAt,
+	}
+}
@@ -0,0 +1,193 @@
+package user
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/failuretoload/datamonster/auth"
+	storeMocks "github.com/failuretoload/datamonster/store/mocks"
+	"github.com/failuretoload/datamonster/web"
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/suite"
+)
+
+var testUser = User{Id: 1, Subject: "userId", DisplayName: "Lantern Bearer", DefaultExpansions: []string{"gorm"}}
+
+type UserApiTestSuite struct {
+	suite.Suite
+	target *Controller
+	db     *storeMocks.MockConnection
+	router *chi.Mux
+}
+
+func (suite *UserApiTestSuite) SetupTest() {
+	suite.db = &storeMocks.MockConnection{}
+	suite.target = NewController(suite.db)
+	suite.router = chi.NewRouter()
+	suite.router.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := auth.WithPrincipal(r.Context(), auth.Principal{Subject: testUser.Subject})
+			next.ServeHTTP(w, r.WithContext(WithUser(ctx, testUser)))
+		})
+	})
+	suite.target.RegisterRoutes(suite.router)
+}
+
+func (suite *UserApiTestSuite) Test_GetMe_ReturnsTheProfile() {
+	w := httptest.NewRecorder()
+
+	suite.router.ServeHTTP(w, httptest.NewRequest("GET", "/me", nil))
+
+	suite.Equal(200, w.Code)
+	dto := UserDTO{}
+	suite.NoError(json.Unmarshal(w.Body.Bytes(), &dto))
+	suite.Equal("Lantern Bearer", dto.DisplayName)
+	suite.Equal([]string{"gorm"}, dto.DefaultExpansions)
+	suite.Equal("system", dto.Preferences.Theme, "the theme should default to the system one")
+}
+
+func (suite *UserApiTestSuite) Test_UpdateMe_ReturnsTheUpdatedProfile() {
+	updated := testUser
+	updated.Preferences = Preferences{Theme: "dark"}
+	suite.db.SetRow(&UserRow{User: updated})
+	w := httptest.NewRecorder()
+
+	suite.router.ServeHTTP(w, httptest.NewRequest("PATCH", "/me", strings.NewReader(`{"preferences": {"theme": "dark"}}`)))
+
+	suite.Equal(200, w.Code)
+	dto := UserDTO{}
+	suite.NoError(json.Unmarshal(w.Body.Bytes(), &dto))
+	suite.Equal("dark", dto.Preferences.Theme)
+}
+
+func (suite *UserApiTestSuite) Test_UpdateMe_ReportsEveryInvalidField() {
+	body := `{"displayName": "` + strings.Repeat("a", 65) + `", "defaultExpansions": ["gorm", "white-box"], "preferences": {"theme": "neon"}}`
+	w := httptest.NewRecorder()
+
+	suite.router.ServeHTTP(w, httptest.NewRequest("PATCH", "/me", strings.NewReader(body)))
+
+	suite.Equal(422, w.Code)
+	problem := web.ValidationProblem{}
+	suite.NoError(json.Unmarshal(w.Body.Bytes(), &problem))
+	suite.Equal([]web.FieldError{
+		{Field: "displayName", Message: "must be at most 64 characters"},
+		{Field: "defaultExpansions", Message: "must only contain dragon-king, dung-beetle-knight, flower-knight, gorm, green-knight-armor, lion-god, lion-knight, lonely-tree, manhunter, slenderman, spidicules, sunstalker"},
+		{Field: "preferences.theme", Message: "must be one of system, light, dark"},
+	}, problem.Errors)
+}
+
+func (suite *UserApiTestSuite) Test_UpdateMe_RequiresTheWriteScopeForMachineClients() {
+	router := chi.NewRouter()
+	router.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			client := auth.Principal{Subject: "userId", Machine: true, Scopes: []string{ScopeRead}}
+			ctx := auth.WithPrincipal(r.Context(), client)
+			next.ServeHTTP(w, r.WithContext(WithUser(ctx, testUser)))
+		})
+	})
+	suite.target.RegisterRoutes(router)
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, httptest.NewRequest("PATCH", "/me", strings.NewReader(`{"displayName": "Script"}`)))
+
+	suite.Equal(403, w.Code, "tokens should be limited to their scopes")
+	suite.Contains(w.Header().Get("WWW-Authenticate"), `scope="profile:write"`)
+	suite.Empty(suite.db.SQL, "the profile should not be changed")
+}
+
+func (suite *UserApiTestSuite) Test_Provision_LoadsTheCallersProfile() {
+	suite.db.SetRow(&UserRow{User: testUser})
+	var loaded User
+	handler := Provision(suite.db)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		loaded, _ = FromContext(r.Context())
+	}))
+	req := httptest.NewRequest("GET", "/v2/settlements", nil)
+	req = req.WithContext(auth.WithPrincipal(req.Context(), auth.Principal{Subject: "userId"}))
+
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	suite.Equal(testUser, loaded, "the full user should be available to handlers")
+}
+
+func (suite *UserApiTestSuite) Test_Provision_CreatesProfiles() {
+	tests := []struct {
+		name       string
+		rows       []pgx.Row
+		statements []string
+	}{
+		{"existing user", []pgx.Row{&UserRow{User: testUser}}, []string{"SELECT"}},
+		{"new user", []pgx.Row{&storeMocks.ErrorRow{Error: pgx.ErrNoRows}, &UserRow{User: testUser}}, []string{"SELECT", "INSERT"}},
+		{"created concurrently", []pgx.Row{&storeMocks.ErrorRow{Error: pgx.ErrNoRows}, &storeMocks.ErrorRow{Error: pgx.ErrNoRows}, &UserRow{User: testUser}}, []string{"SELECT", "INSERT", "SELECT"}},
+	}
+
+	for _, tt := range tests {
+		suite.Run(tt.name, func() {
+			suite.db.Statements = nil
+			suite.db.SetRow(&storeMocks.RowSequence{Rows: tt.rows})
+			var loaded User
+			handler := Provision(suite.db)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				loaded, _ = FromContext(r.Context())
+			}))
+			req := httptest.NewRequest("GET", "/v2/settlements", nil)
+			req = req.WithContext(auth.WithPrincipal(req.Context(), auth.Principal{Subject: "userId"}))
+
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+
+			suite.Equal(testUser, loaded)
+			suite.Require().Len(suite.db.Statements, len(tt.statements))
+			for i, statement := range suite.db.Statements {
+				suite.True(strings.HasPrefix(strings.TrimSpace(statement.SQL), tt.statements[i]), statement.SQL)
+			}
+		})
+	}
+}
+
+func (suite *UserApiTestSuite) Test_Provision_SkipsAnonymousRequests() {
+	reached := false
+	handler := Provision(suite.db)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reached = true
+	}))
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/healthz", nil))
+
+	suite.True(reached, "anonymous requests should not touch the database")
+}
+
+func (suite *UserApiTestSuite) Test_Provision_ReportsDatabaseErrors() {
+	suite.db.SetRow(&storeMocks.ErrorRow{Error: errors.New("connection refused")})
+	handler := Provision(suite.db)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		suite.Fail("handlers should not run without a user")
+	}))
+	req := httptest.NewRequest("GET", "/v2/settlements", nil)
+	req = req.WithContext(auth.WithPrincipal(req.Context(), auth.Principal{Subject: "userId"}))
+	w := httptest.NewRecorder()
+
+	handler.ServeHTTP(w, req)
+
+	suite.Equal(500, w.Code)
+}
+
+func TestUserApiTestSuite(t *testing.T) {
+	suite.Run(t, new(UserApiTestSuite))
+}
+
+type UserRow struct {
+	User User
+}
+
+func (u *UserRow) Scan(dest ...any) error {
+	*dest[0].(*int) = u.User.Id
+	*dest[1].(*string) = u.User.Subject
+	*dest[2].(*string) = u.User.DisplayName
+	*dest[3].(*[]string) = u.User.DefaultExpansions
+	*dest[4].(*Preferences) = u.User.Preferences
+	*dest[5].(*time.Time) = u.User.CreatedAt
+	*dest[6].(*time.Time) = u.User.UpdatedAt
+	return nil
+}
@@ -0,0 +1,102 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/failuretoload/datamonster/store"
+	"github.com/failuretoload/datamonster/tracing"
+
+	"github.com/jackc/pgx/v5"
+)
+
+type PostgresRepo struct {
+	pool store.Connection
+}
+
+type User struct {
+	Id                int
+	Subject           string
+	DisplayName       string
+	DefaultExpansions []string
+	Preferences       Preferences
+	CreatedAt         time.Time
+	UpdatedAt         time.Time
+}
+
+// Preferences are stored as a JSON document so new settings need no migration.
+type Preferences struct {
+	Theme        string `json:"theme,omitempty"`
+	Locale       string `json:"locale,omitempty"`
+	CompactLists bool   `json:"compactLists,omitempty"`
+}
+
+// Changes holds the fields of a partial profile update; nil fields are left
+// untouched and Preferences is merged key by key.
+type Changes struct {
+	DisplayName       *string
+	DefaultExpansions []string
+	Preferences       map[string]any
+}
+
+func New(d store.Connection) *PostgresRepo {
+	return &PostgresRepo{pool: d}
+}
+
+const columns = "id, subject, display_name, default_expansions, preferences, created_at, updated_at"
+
+// Provision returns the user for subject, creating it on first sight. Existing
+// users are read without a write. When a concurrent request creates the user
+// between the read and the insert, the insert does nothing and the user it
+// created is read instead.
+func (r PostgresRepo) Provision(ctx context.Context, subject string) (User, error) {
+	ctx, span := tracing.Start(ctx, "users.Provision")
+	defer span.End()
+	find := `SELECT ` + columns + ` FROM campaign.users WHERE subject = $1`
+	u, err := r.scan(r.pool.QueryRow(ctx, find, subject))
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return u, store.TranslateError(err, "user")
+	}
+	insert := `INSERT INTO campaign.users (subject) VALUES ($1)
+		ON CONFLICT (subject) DO NOTHING
+		RETURNING ` + columns
+	u, err = r.scan(r.pool.QueryRow(ctx, insert, subject))
+	if errors.Is(err, pgx.ErrNoRows) {
+		u, err = r.scan(r.pool.QueryRow(ctx, find, subject))
+	}
+	return u, store.TranslateError(err, "user")
+}
+
+func (r PostgresRepo) Update(ctx context.Context, subject string, c Changes) (User, error) {
+	ctx, span := tracing.Start(ctx, "users.Update")
+	defer span.End()
+	var preferences []byte
+	if c.Preferences != nil {
+		encoded, err := json.Marshal(c.Preferences)
+		if err != nil {
+			return User{}, err
+		}
+		preferences = encoded
+	}
+	query := `UPDATE campaign.users SET
+			display_name = COALESCE($2, display_name),
+			default_expansions = COALESCE($3, default_expansions),
+			preferences = preferences || COALESCE($4::jsonb, '{}'),
+			updated_at = now()
+		WHERE subject = $1
+		RETURNING ` + columns
+	u, err := r.scan(r.pool.QueryRow(ctx, query, subject, c.DisplayName, c.DefaultExpansions, preferences))
+	return u, store.TranslateError(err, "user")
+}
+
+type row interface {
+	Scan(dest ...any) error
+}
+
+func (r PostgresRepo) scan(row row) (User, error) {
+	var u User
+	err := row.Scan(&u.Id, &u.Subject, &u.DisplayName, &u.DefaultExpansions, &u.Preferences, &u.CreatedAt, &u.UpdatedAt)
+	return u, err
+}
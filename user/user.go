@@ -0,0 +1,49 @@
+package user
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/failuretoload/datamonster/auth"
+	"github.com/failuretoload/datamonster/store"
+	repo "github.com/failuretoload/datamonster/user/internal"
+	"github.com/failuretoload/datamonster/web"
+)
+
+type ctxUserKey struct{}
+
+// User is the profile of the authenticated caller.
+type User = repo.User
+
+// Preferences are the caller's UI settings.
+type Preferences = repo.Preferences
+
+func WithUser(ctx context.Context, u User) context.Context {
+	return context.WithValue(ctx, ctxUserKey{}, u)
+}
+
+func FromContext(ctx context.Context) (User, bool) {
+	u, ok := ctx.Value(ctxUserKey{}).(User)
+	return u, ok
+}
+
+// Provision loads the profile of every authenticated caller into the request
+// context, creating it on their first request. Anonymous requests pass through.
+func Provision(conn store.Connection) func(http.Handler) http.Handler {
+	users := repo.New(conn)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			principal, ok := auth.PrincipalFrom(r.Context())
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+			u, err := users.Provision(r.Context(), principal.Subject)
+			if err != nil {
+				web.WriteError(w, r, err)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(WithUser(r.Context(), u)))
+		})
+	}
+}
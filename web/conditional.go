@@ -0,0 +1,64 @@
+package web
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/failuretoload/datamonster/domain"
+)
+
+// ETag formats the version of a resource as a strong entity tag.
+func ETag(version int) string {
+	return `"` + strconv.Itoa(version) + `"`
+}
+
+// SetETag tags the response with the resource's version and lets clients cache
+// it as long as they revalidate, replacing the default no-store policy.
+func SetETag(w http.ResponseWriter, version int) {
+	headers := w.Header()
+	headers.Set("ETag", ETag(version))
+	headers.Set("Cache-Control", "private, no-cache")
+	headers.Del("Pragma")
+	headers.Del("Expires")
+}
+
+// NotModified tags the response with version and, when the request's
+// If-None-Match names it, answers 304 Not Modified. Handlers must not write a
+// body when it returns true.
+func NotModified(w http.ResponseWriter, r *http.Request, version int) bool {
+	SetETag(w, version)
+	header := r.Header.Get("If-None-Match")
+	if header == "" {
+		return false
+	}
+	current := ETag(version)
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == current {
+			w.WriteHeader(http.StatusNotModified)
+			return true
+		}
+	}
+	return false
+}
+
+// IfMatch returns the version an update must apply to, or nil when the request
+// has no If-Match header or If-Match: *, which any current version satisfies.
+// Weak and malformed tags can never match and are reported as a failed
+// precondition.
+func IfMatch(r *http.Request) (*int, error) {
+	header := strings.TrimSpace(r.Header.Get("If-Match"))
+	if header == "" || header == "*" {
+		return nil, nil
+	}
+	tag, ok := strings.CutPrefix(header, `"`)
+	if ok {
+		tag, ok = strings.CutSuffix(tag, `"`)
+	}
+	version, err := strconv.Atoi(tag)
+	if !ok || err != nil {
+		return nil, domain.PreconditionFailed("If-Match must be a single entity tag returned by this API")
+	}
+	return &version, nil
+}
@@ -0,0 +1,92 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/failuretoload/datamonster/domain"
+	"github.com/stretchr/testify/suite"
+)
+
+type ConditionalTestSuite struct {
+	suite.Suite
+}
+
+func (suite *ConditionalTestSuite) Test_NotModified() {
+	tests := []struct {
+		name        string
+		ifNoneMatch string
+		expected    bool
+	}{
+		{"no header", "", false},
+		{"current tag", `"3"`, true},
+		{"older tag", `"2"`, false},
+		{"weak tag", `W/"3"`, true},
+		{"any tag", "*", true},
+		{"list naming the current tag", `"1", W/"2",  "3"`, true},
+		{"list without the current tag", `"1","2"`, false},
+		{"unquoted tag", "3", false},
+	}
+
+	for _, tt := range tests {
+		suite.Run(tt.name, func() {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("GET", "/settlements/1", nil)
+			if tt.ifNoneMatch != "" {
+				r.Header.Set("If-None-Match", tt.ifNoneMatch)
+			}
+
+			modified := NotModified(w, r, 3)
+
+			suite.Equal(tt.expected, modified)
+			suite.Equal(`"3"`, w.Header().Get("ETag"), "every response should be tagged")
+			suite.Equal("private, no-cache", w.Header().Get("Cache-Control"))
+			if tt.expected {
+				suite.Equal(http.StatusNotModified, w.Code)
+			}
+		})
+	}
+}
+
+func (suite *ConditionalTestSuite) Test_IfMatch() {
+	three := 3
+	tests := []struct {
+		name     string
+		ifMatch  string
+		expected *int
+		fails    bool
+	}{
+		{"no header", "", nil, false},
+		{"any version", "*", nil, false},
+		{"strong tag", `"3"`, &three, false},
+		{"surrounding spaces", ` "3" `, &three, false},
+		{"weak tags never match", `W/"3"`, nil, true},
+		{"lists are refused", `"2", "3"`, nil, true},
+		{"unquoted tag", "3", nil, true},
+		{"not a version", `"abc"`, nil, true},
+		{"unterminated tag", `"3`, nil, true},
+	}
+
+	for _, tt := range tests {
+		suite.Run(tt.name, func() {
+			r := httptest.NewRequest("PATCH", "/settlements/1", nil)
+			if tt.ifMatch != "" {
+				r.Header.Set("If-Match", tt.ifMatch)
+			}
+
+			version, err := IfMatch(r)
+
+			suite.Equal(tt.expected, version)
+			if tt.fails {
+				suite.Equal(domain.KindPreconditionFailed, domain.KindOf(err))
+				return
+			}
+			suite.NoError(err)
+		})
+	}
+}
+
+func TestConditionalTestSuite(t *testing.T) {
+	suite.Run(t, new(ConditionalTestSuite))
+}
@@ -0,0 +1,102 @@
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/failuretoload/datamonster/domain"
+	"github.com/failuretoload/datamonster/logging"
+)
+
+const ProblemContentType = "application/problem+json"
+
+// Problem is an RFC 7807 problem details document.
+type Problem struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+}
+
+func NewProblem(r *http.Request, status int, detail string) Problem {
+	p := Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+	}
+	if r != nil {
+		p.Instance = r.URL.Path
+	}
+	return p
+}
+
+func WriteProblem(w http.ResponseWriter, r *http.Request, status int, detail string) {
+	writeProblemDocument(w, status, NewProblem(r, status, detail))
+}
+
+// ValidationProblem extends Problem with the individual field errors of a request.
+type ValidationProblem struct {
+	Problem
+	Errors []FieldError `json:"errors"`
+}
+
+// WriteError renders err as a problem document, choosing the status code from its
+// domain kind. Internal errors are logged and their details withheld from the client.
+func WriteError(w http.ResponseWriter, r *http.Request, err error) {
+	var validationErr *ValidationError
+	if errors.As(err, &validationErr) {
+		status := http.StatusUnprocessableEntity
+		writeProblemDocument(w, status, ValidationProblem{
+			Problem: NewProblem(r, status, "the request contains invalid fields"),
+			Errors:  validationErr.Fields,
+		})
+		return
+	}
+	var requestErr *RequestError
+	if errors.As(err, &requestErr) {
+		WriteProblem(w, r, requestErr.Status, requestErr.Message)
+		return
+	}
+	status := StatusForKind(domain.KindOf(err))
+	if status == http.StatusInternalServerError {
+		logging.FromContext(r.Context()).Error("internal server error", "error", err)
+		WriteProblem(w, r, status, "an unexpected error occurred")
+		return
+	}
+	WriteProblem(w, r, status, domain.MessageOf(err))
+}
+
+func StatusForKind(kind domain.Kind) int {
+	switch kind {
+	case domain.KindNotFound:
+		return http.StatusNotFound
+	case domain.KindConflict:
+		return http.StatusConflict
+	case domain.KindValidation:
+		return http.StatusUnprocessableEntity
+	case domain.KindForbidden:
+		return http.StatusForbidden
+	case domain.KindPreconditionFailed:
+		return http.StatusPreconditionFailed
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func writeProblemDocument(w http.ResponseWriter, status int, doc any) {
+	body, err := json.Marshal(doc)
+	if err != nil {
+		slog.Error("unable to encode problem document", "error", err)
+		w.WriteHeader(status)
+		return
+	}
+	w.Header().Set("Content-Type", ProblemContentType)
+	w.WriteHeader(status)
+	if _, err = w.Write(body); err != nil {
+		slog.Error("unable to write problem document", "error", err)
+	}
+}
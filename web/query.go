@@ -0,0 +1,143 @@
+package web
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DecodeQuery fills the `query` tagged fields of dst from the request's query
+// string and then applies their validation rules, reporting every problem
+// together like DecodeAndValidate. Fields may be strings, integers, booleans,
+// string slices or pointers to them; slices accept comma separated values,
+// repeated parameters or both. Parameters dst does not declare are rejected and
+// absent ones leave the field untouched, so defaults can be set beforehand.
+func DecodeQuery(r *http.Request, dst any) error {
+	errs := &ValidationError{}
+	values := r.URL.Query()
+	rv := reflect.ValueOf(dst).Elem()
+	rt := rv.Type()
+	known := map[string]bool{}
+	for i := 0; i < rt.NumField(); i++ {
+		field := rt.Field(i)
+		name := field.Tag.Get("query")
+		if name == "" || !field.IsExported() {
+			continue
+		}
+		known[name] = true
+		raw, ok := values[name]
+		if !ok {
+			continue
+		}
+		if msg := setQueryValue(rv.Field(i), raw); msg != "" {
+			errs.Add(name, msg)
+		}
+	}
+
+	unknown := []string{}
+	for key := range values {
+		if !known[key] {
+			unknown = append(unknown, key)
+		}
+	}
+	sort.Strings(unknown)
+	for _, key := range unknown {
+		errs.Add(key, "is not a recognized parameter")
+	}
+
+	var ruleErrs *ValidationError
+	if errors.As(Validate(dst), &ruleErrs) {
+		reported := map[string]bool{}
+		for _, f := range errs.Fields {
+			reported[f.Field] = true
+		}
+		for _, f := range ruleErrs.Fields {
+			if !reported[f.Field] {
+				errs.Add(f.Field, f.Message)
+			}
+		}
+	}
+	return errs.OrNil()
+}
+
+func setQueryValue(v reflect.Value, raw []string) string {
+	if v.Kind() == reflect.Pointer {
+		elem := reflect.New(v.Type().Elem())
+		if msg := setQueryValue(elem.Elem(), raw); msg != "" {
+			return msg
+		}
+		v.Set(elem)
+		return ""
+	}
+	last := raw[len(raw)-1]
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(last)
+	case reflect.Int:
+		n, err := strconv.Atoi(last)
+		if err != nil {
+			return "must be an integer"
+		}
+		v.SetInt(int64(n))
+	case reflect.Bool:
+		b, err := strconv.ParseBool(last)
+		if err != nil {
+			return "must be true or false"
+		}
+		v.SetBool(b)
+	case reflect.Slice:
+		items := []string{}
+		for _, value := range raw {
+			for _, item := range strings.Split(value, ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					items = append(items, item)
+				}
+			}
+		}
+		v.Set(reflect.ValueOf(items))
+	default:
+		panic("unsupported query parameter type " + v.Type().String())
+	}
+	return ""
+}
+
+// EncodeCursor turns the position of the last item of a page into an opaque
+// token clients pass back to get the next page.
+func EncodeCursor(position any) string {
+	data, err := json.Marshal(position)
+	if err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// DecodeCursor reads a token made by EncodeCursor into position. Tampered or
+// truncated tokens are reported as an invalid cursor parameter.
+func DecodeCursor(cursor string, position any) error {
+	invalid := &ValidationError{Fields: []FieldError{{Field: "cursor", Message: "is invalid"}}}
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return invalid
+	}
+	decoder := json.NewDecoder(strings.NewReader(string(data)))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(position); err != nil {
+		return invalid
+	}
+	return nil
+}
+
+// SetNextPage links to the page after the current one with an RFC 8288 Link
+// header, keeping the request's other query parameters.
+func SetNextPage(w http.ResponseWriter, r *http.Request, cursor string) {
+	query := r.URL.Query()
+	query.Set("cursor", cursor)
+	next := url.URL{Path: r.URL.Path, RawQuery: query.Encode()}
+	w.Header().Add("Link", "<"+next.String()+`>; rel="next"`)
+}
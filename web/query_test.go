@@ -0,0 +1,112 @@
+package web
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type QueryTestSuite struct {
+	suite.Suite
+}
+
+type listQuery struct {
+	Limit  int      `query:"limit" validate:"min=1,max=200"`
+	Sort   string   `query:"sort" validate:"oneof=name year"`
+	Dead   *bool    `query:"dead"`
+	Status []string `query:"status" validate:"oneof=alive dead"`
+	Cursor *string  `query:"cursor"`
+}
+
+type position struct {
+	Name string `json:"name"`
+	Id   int    `json:"id"`
+}
+
+func (suite *QueryTestSuite) Test_DecodeQuery() {
+	yes := true
+	after := "abc"
+	tests := []struct {
+		name     string
+		query    string
+		expected listQuery
+		invalid  []FieldError
+	}{
+		{"defaults are kept", "", listQuery{Limit: 50, Sort: "name"}, nil},
+		{"every kind of field", "limit=10&sort=year&dead=true&cursor=abc", listQuery{Limit: 10, Sort: "year", Dead: &yes, Cursor: &after}, nil},
+		{"comma separated slice", "status=alive,%20dead", listQuery{Limit: 50, Sort: "name", Status: []string{"alive", "dead"}}, nil},
+		{"repeated slice", "status=alive&status=dead,", listQuery{Limit: 50, Sort: "name", Status: []string{"alive", "dead"}}, nil},
+		{"last value wins", "limit=5&limit=6", listQuery{Limit: 6, Sort: "name"}, nil},
+		{"not an integer", "limit=ten", listQuery{}, []FieldError{{"limit", "must be an integer"}}},
+		{"not a boolean", "dead=maybe", listQuery{}, []FieldError{{"dead", "must be true or false"}}},
+		{"breaks a rule", "limit=500&status=lost", listQuery{}, []FieldError{{"limit", "must be at most 200"}, {"status", "must only contain alive, dead"}}},
+		{"unknown parameters", "size=3&page=1", listQuery{}, []FieldError{{"page", "is not a recognized parameter"}, {"size", "is not a recognized parameter"}}},
+		{"parse errors are not reported twice", "limit=0x&sort=age", listQuery{}, []FieldError{{"limit", "must be an integer"}, {"sort", "must be one of name, year"}}},
+	}
+
+	for _, tt := range tests {
+		suite.Run(tt.name, func() {
+			q := listQuery{Limit: 50, Sort: "name"}
+
+			err := DecodeQuery(httptest.NewRequest("GET", "/settlements?"+tt.query, nil), &q)
+
+			if tt.invalid != nil {
+				var invalid *ValidationError
+				suite.Require().ErrorAs(err, &invalid)
+				suite.Equal(tt.invalid, invalid.Fields)
+				return
+			}
+			suite.NoError(err)
+			suite.Equal(tt.expected, q)
+		})
+	}
+}
+
+func (suite *QueryTestSuite) Test_Cursor_RoundTrips() {
+	cursor := EncodeCursor(position{Name: "Lucy & Zachary", Id: 7})
+
+	var decoded position
+	err := DecodeCursor(cursor, &decoded)
+
+	suite.NoError(err)
+	suite.Equal(position{Name: "Lucy & Zachary", Id: 7}, decoded)
+	suite.NotContains(cursor, "=", "cursors should be safe in query strings")
+}
+
+func (suite *QueryTestSuite) Test_DecodeCursor_RejectsInvalidCursors() {
+	valid := EncodeCursor(position{Name: "Lucy", Id: 7})
+	tests := []struct {
+		name   string
+		cursor string
+	}{
+		{"not base64", "%%%"},
+		{"truncated", valid[:len(valid)-4]},
+		{"not json", EncodeCursor("Lucy")},
+		{"unknown fields", EncodeCursor(map[string]any{"name": "Lucy", "rank": 1})},
+	}
+
+	for _, tt := range tests {
+		suite.Run(tt.name, func() {
+			var decoded position
+
+			err := DecodeCursor(tt.cursor, &decoded)
+
+			var invalid *ValidationError
+			suite.Require().ErrorAs(err, &invalid)
+			suite.Equal([]FieldError{{"cursor", "is invalid"}}, invalid.Fields)
+		})
+	}
+}
+
+func (suite *QueryTestSuite) Test_SetNextPage_KeepsTheQuery() {
+	w := httptest.NewRecorder()
+
+	SetNextPage(w, httptest.NewRequest("GET", "/v1/settlements?sort=year&cursor=old", nil), "new")
+
+	suite.Equal(`</v1/settlements?cursor=new&sort=year>; rel="next"`, w.Header().Get("Link"))
+}
+
+func TestQueryTestSuite(t *testing.T) {
+	suite.Run(t, new(QueryTestSuite))
+}
@@ -2,15 +2,90 @@ package web
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
 )
 
-type ctxUserIdKey string
+// MaxRequestBodyBytes bounds the size of JSON request bodies.
+const MaxRequestBodyBytes = 1 << 20
+
+// RequestError reports a request body that could not be read or parsed at all.
+type RequestError struct {
+	Status  int
+	Message string
+}
+
+func (e *RequestError) Error() string {
+	return e.Message
+}
 
-const UserIdKey ctxUserIdKey = "userId"
+// DecodeAndValidate reads a JSON body into data, rejecting oversized bodies and
+// fields data does not declare, and then applies its validation rules. Unknown
+// fields, type mismatches and rule violations are reported together.
+func DecodeAndValidate(w http.ResponseWriter, r *http.Request, data any) error {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, MaxRequestBodyBytes))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return &RequestError{Status: http.StatusRequestEntityTooLarge, Message: fmt.Sprintf("request body must not exceed %d bytes", MaxRequestBodyBytes)}
+		}
+		return &RequestError{Status: http.StatusBadRequest, Message: "unable to read request body"}
+	}
+
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(body, &raw); err != nil {
+		return &RequestError{Status: http.StatusBadRequest, Message: "request body must be a JSON object"}
+	}
+
+	errs := &ValidationError{}
+	known := jsonFieldNames(reflect.TypeOf(data))
+	unknown := []string{}
+	for key := range raw {
+		if !known[key] {
+			unknown = append(unknown, key)
+		}
+	}
+	sort.Strings(unknown)
+	for _, key := range unknown {
+		errs.Add(key, "is not a recognized field")
+	}
+
+	if err := json.Unmarshal(body, data); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if !errors.As(err, &typeErr) {
+			return &RequestError{Status: http.StatusBadRequest, Message: "request body must be a JSON object"}
+		}
+		errs.Add(typeErr.Field, "must be a "+typeErr.Type.String())
+	}
+	var ruleErrs *ValidationError
+	if errors.As(Validate(data), &ruleErrs) {
+		reported := map[string]bool{}
+		for _, f := range errs.Fields {
+			reported[f.Field] = true
+		}
+		for _, f := range ruleErrs.Fields {
+			if !reported[f.Field] {
+				errs.Add(f.Field, f.Message)
+			}
+		}
+	}
+	return errs.OrNil()
+}
 
-func DecodeJsonRequest(rc io.ReadCloser, data interface{}) error {
-	defer rc.Close()
-	decoder := json.NewDecoder(rc)
-	return decoder.Decode(data)
+// PathId reads the integer id held by the path parameter param, answering 400
+// when it is not a number. name names the entity in the problem.
+func PathId(w http.ResponseWriter, r *http.Request, param, name string) (int, bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, param))
+	if err != nil {
+		WriteProblem(w, r, http.StatusBadRequest, name+" id must be a positive integer")
+		return 0, false
+	}
+	return id, true
 }
@@ -2,14 +2,9 @@ package web
 
 import (
 	"encoding/json"
-	"log"
 	"net/http"
 )
 
-type ErrorMessage struct {
-	Message string `json:"message"`
-}
-
 func WriteJSON(rw http.ResponseWriter, status int, data interface{}) error {
 	js, err := json.Marshal(data)
 	if err != nil {
@@ -25,26 +20,16 @@ func WriteJSON(rw http.ResponseWriter, status int, data interface{}) error {
 	return nil
 }
 
-func InternalServerError(rw http.ResponseWriter, errMessage string) {
-	writeError := WriteJSON(rw, http.StatusInternalServerError, errMessage)
-	if writeError != nil {
-		log.Println("Error writing error message: ", writeError.Error())
-	}
-	log.Print("Internal error server: ", errMessage)
-}
-
-func Unauthorized(rw http.ResponseWriter, message string) {
-	writeError := WriteJSON(rw, http.StatusUnauthorized, message)
-	if writeError != nil {
-		log.Println("Error writing error message: ", writeError.Error())
-	}
-	log.Println("Unauthorized: ", message)
+func Unauthorized(rw http.ResponseWriter, r *http.Request, message string) {
+	WriteProblem(rw, r, http.StatusUnauthorized, message)
 }
 
 func MakeJsonResponse(w http.ResponseWriter, status int, data interface{}) {
-	w.WriteHeader(status)
 	if data != nil {
 		w.Header().Set("Content-Type", "application/json")
+	}
+	w.WriteHeader(status)
+	if data != nil {
 		body, _ := json.Marshal(data)
 		_, _ = w.Write(body)
 	}
@@ -0,0 +1,67 @@
+package web
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Streaming reports whether r asks to open a long-lived stream: a server-sent
+// event stream or a WebSocket. Clients choose these headers, so it only tells
+// how a client may pass its credentials and never exempts a request from
+// limits; routes that stream opt out of Timeout with Unbounded instead.
+func Streaming(r *http.Request) bool {
+	if r.Method != http.MethodGet {
+		return false
+	}
+	return strings.Contains(r.Header.Get("Accept"), "text/event-stream") ||
+		strings.EqualFold(r.Header.Get("Upgrade"), "websocket")
+}
+
+type boundKey struct{}
+
+// bound is the limit Timeout placed on a request.
+type bound struct {
+	// parent is the request's context before the limit, cancelled when the
+	// client disconnects or the server shuts down.
+	parent context.Context
+	lifted bool
+}
+
+// Timeout is middleware that cancels requests taking longer than d and answers
+// 504 Gateway Timeout when they did not answer in time. Routes wrapped in
+// Unbounded are exempt.
+func Timeout(d time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			b := &bound{parent: r.Context()}
+			ctx, cancel := context.WithTimeout(context.WithValue(r.Context(), boundKey{}, b), d)
+			defer cancel()
+			next.ServeHTTP(w, r.WithContext(ctx))
+			if !b.lifted && ctx.Err() == context.DeadlineExceeded {
+				w.WriteHeader(http.StatusGatewayTimeout)
+			}
+		})
+	}
+}
+
+// Unbounded is middleware for routes that stay open until the client
+// disconnects or the server shuts down, such as event streams and WebSockets.
+// It lifts the limit of Timeout while keeping the rest of the request's
+// context.
+func Unbounded(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, ok := r.Context().Value(boundKey{}).(*bound)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		b.lifted = true
+		ctx, cancel := context.WithCancel(context.WithoutCancel(r.Context()))
+		defer cancel()
+		stop := context.AfterFunc(b.parent, cancel)
+		defer stop()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
@@ -0,0 +1,231 @@
+package web
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Media types lists can be written in.
+const (
+	MediaJSON = "application/json"
+	MediaCSV  = "text/csv"
+	MediaXLSX = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+)
+
+// FormatParam is the query parameter that names the media type of a list,
+// taking precedence over the Accept header. Endpoints offering it declare it in
+// their query without rules: NegotiateList checks it against ListFormats.
+const FormatParam = "format"
+
+// ListFormats maps the values of FormatParam to the media types they name.
+var ListFormats = map[string]string{"json": MediaJSON, "csv": MediaCSV, "xlsx": MediaXLSX}
+
+// ListFormatNames returns the names of ListFormats in order.
+func ListFormatNames() []string {
+	names := make([]string, 0, len(ListFormats))
+	for name := range ListFormats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NegotiateList picks the media type to write a list in: the one named by the
+// format query parameter or else the one the Accept header prefers among JSON,
+// CSV and XLSX.
+func NegotiateList(r *http.Request) (string, error) {
+	if format := r.URL.Query().Get(FormatParam); format != "" {
+		mediaType, ok := ListFormats[format]
+		if !ok {
+			return "", &ValidationError{Fields: []FieldError{{Field: FormatParam, Message: "must be one of " + strings.Join(ListFormatNames(), ", ")}}}
+		}
+		return mediaType, nil
+	}
+	return Negotiate(r.Header.Get("Accept"), MediaJSON, MediaCSV, MediaXLSX)
+}
+
+// Negotiate picks the offered media type accept prefers, matching the most
+// specific of its media ranges and weighing them by their q parameter. Ties go
+// to the earlier offer, as does an empty header. It fails with 406 Not
+// Acceptable when accept rules out every offer.
+func Negotiate(accept string, offers ...string) (string, error) {
+	if strings.TrimSpace(accept) == "" {
+		return offers[0], nil
+	}
+	best, bestQ := "", 0.0
+	for _, offer := range offers {
+		if q := quality(accept, offer); q > bestQ {
+			best, bestQ = offer, q
+		}
+	}
+	if best == "" {
+		return "", &RequestError{Status: http.StatusNotAcceptable, Message: "the response can only be written as " + strings.Join(offers, ", ")}
+	}
+	return best, nil
+}
+
+// quality returns the weight accept gives mediaType, 0 when it does not accept
+// it.
+func quality(accept, mediaType string) float64 {
+	kind, _, _ := strings.Cut(mediaType, "/")
+	specificity, q := 0, 0.0
+	for _, part := range strings.Split(accept, ",") {
+		params := strings.Split(part, ";")
+		rangeName := strings.ToLower(strings.TrimSpace(params[0]))
+		s := 0
+		switch rangeName {
+		case mediaType:
+			s = 3
+		case kind + "/*":
+			s = 2
+		case "*/*":
+			s = 1
+		}
+		if s <= specificity {
+			continue
+		}
+		weight := 1.0
+		for _, p := range params[1:] {
+			if value, ok := strings.CutPrefix(strings.TrimSpace(p), "q="); ok {
+				if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+					weight = parsed
+				}
+			}
+		}
+		specificity, q = s, weight
+	}
+	return q
+}
+
+// WriteTable writes rows, a slice of structs, as a CSV or XLSX attachment
+// named name with one column per field. Columns are headed by the fields' JSON
+// names and embedded structs add their own fields. Nil pointers are written as
+// empty cells and string slices as their items joined by "; ". The table is
+// rendered before anything is written, so when it fails callers can still
+// answer with an error.
+func WriteTable(w http.ResponseWriter, mediaType, name string, rows any) error {
+	header, cells := table(rows)
+	var body bytes.Buffer
+	var err error
+	contentType, extension := mediaType, ""
+	switch mediaType {
+	case MediaCSV:
+		contentType, extension = MediaCSV+"; charset=utf-8", "csv"
+		err = writeCSV(&body, header, cells)
+	case MediaXLSX:
+		extension = "xlsx"
+		err = writeXLSX(&body, name, header, cells)
+	default:
+		panic("unsupported table media type " + mediaType)
+	}
+	if err != nil {
+		return fmt.Errorf("unable to write the %s table: %w", extension, err)
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.%s"`, name, extension))
+	w.Header().Set("Content-Length", strconv.Itoa(body.Len()))
+	w.WriteHeader(http.StatusOK)
+	_, _ = body.WriteTo(w)
+	return nil
+}
+
+// table reads the header and the cells of rows. Cells hold strings, int64s or
+// bools.
+func table(rows any) ([]string, [][]any) {
+	rv := reflect.ValueOf(rows)
+	var header []string
+	var paths [][]int
+	var columns func(t reflect.Type, path []int)
+	columns = func(t reflect.Type, path []int) {
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			tag := field.Tag.Get("json")
+			name, _, _ := strings.Cut(tag, ",")
+			fieldPath := append(append([]int{}, path...), i)
+			switch {
+			case !field.IsExported() || name == "-":
+			case field.Anonymous && tag == "" && field.Type.Kind() == reflect.Struct:
+				columns(field.Type, fieldPath)
+			default:
+				if name == "" {
+					name = field.Name
+				}
+				header = append(header, name)
+				paths = append(paths, fieldPath)
+			}
+		}
+	}
+	columns(rv.Type().Elem(), nil)
+
+	cells := make([][]any, rv.Len())
+	for i := range cells {
+		row := make([]any, len(paths))
+		for j, path := range paths {
+			row[j] = cell(rv.Index(i).FieldByIndex(path))
+		}
+		cells[i] = row
+	}
+	return header, cells
+}
+
+func cell(v reflect.Value) any {
+	if v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			return ""
+		}
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.String:
+		return v.String()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int()
+	case reflect.Bool:
+		return v.Bool()
+	case reflect.Slice:
+		items := make([]string, v.Len())
+		for i := range items {
+			items[i] = fmt.Sprint(v.Index(i).Interface())
+		}
+		return strings.Join(items, "; ")
+	}
+	return fmt.Sprint(v.Interface())
+}
+
+func writeCSV(w io.Writer, header []string, cells [][]any) error {
+	out := csv.NewWriter(w)
+	if err := out.Write(header); err != nil {
+		return err
+	}
+	record := make([]string, len(header))
+	for _, row := range cells {
+		for i, c := range row {
+			switch c := c.(type) {
+			case string:
+				record[i] = defuse(c)
+			default:
+				record[i] = fmt.Sprint(c)
+			}
+		}
+		if err := out.Write(record); err != nil {
+			return err
+		}
+	}
+	out.Flush()
+	return out.Error()
+}
+
+// defuse quotes text that spreadsheets would otherwise run as a formula.
+func defuse(text string) string {
+	if text != "" && strings.ContainsRune("=+-@\t\r", rune(text[0])) {
+		return "'" + text
+	}
+	return text
+}
@@ -0,0 +1,225 @@
+package web
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type TableTestSuite struct {
+	suite.Suite
+}
+
+type SheetBase struct {
+	Id int `json:"id"`
+}
+
+type sheetRow struct {
+	SheetBase
+	Name    string   `json:"name"`
+	Notes   *string  `json:"notes"`
+	Dead    bool     `json:"dead"`
+	Fingers []string `json:"fingers"`
+	Secret  string   `json:"-"`
+	hidden  string
+}
+
+func (suite *TableTestSuite) Test_Negotiate() {
+	offers := []string{MediaJSON, MediaCSV, MediaXLSX}
+	tests := []struct {
+		name     string
+		accept   string
+		expected string
+	}{
+		{"no header", "", MediaJSON},
+		{"exact type", "text/csv", MediaCSV},
+		{"case insensitive", "Text/CSV", MediaCSV},
+		{"any type", "*/*", MediaJSON},
+		{"type wildcard", "text/*", MediaCSV},
+		{"highest weight", "application/json;q=0.5, text/csv;q=0.9", MediaCSV},
+		{"specific range overrides wildcard", "*/*;q=1, application/json;q=0.1", MediaCSV},
+		{"excluded type", "application/json;q=0, */*;q=0.1", MediaCSV},
+		{"ties go to the first offer", "text/csv, application/json", MediaJSON},
+		{"nothing acceptable", "image/png", ""},
+	}
+
+	for _, tt := range tests {
+		suite.Run(tt.name, func() {
+			mediaType, err := Negotiate(tt.accept, offers...)
+
+			suite.Equal(tt.expected, mediaType)
+			if tt.expected == "" {
+				var rejected *RequestError
+				suite.Require().ErrorAs(err, &rejected)
+				suite.Equal(http.StatusNotAcceptable, rejected.Status)
+				return
+			}
+			suite.NoError(err)
+		})
+	}
+}
+
+func (suite *TableTestSuite) Test_NegotiateList() {
+	tests := []struct {
+		name     string
+		query    string
+		accept   string
+		expected string
+		invalid  bool
+	}{
+		{"accept header", "", "text/csv", MediaCSV, false},
+		{"format parameter", "?format=xlsx", "", MediaXLSX, false},
+		{"format overrides accept", "?format=json", "text/csv", MediaJSON, false},
+		{"unknown format", "?format=pdf", "", "", true},
+	}
+
+	for _, tt := range tests {
+		suite.Run(tt.name, func() {
+			r := httptest.NewRequest("GET", "/settlements/1/survivors"+tt.query, nil)
+			r.Header.Set("Accept", tt.accept)
+
+			mediaType, err := NegotiateList(r)
+
+			suite.Equal(tt.expected, mediaType)
+			if tt.invalid {
+				suite.EqualError(err, "validation failed: format must be one of csv, json, xlsx")
+				return
+			}
+			suite.NoError(err)
+		})
+	}
+}
+
+func (suite *TableTestSuite) rows() []sheetRow {
+	notes := "Lost an arm,\n\"again\""
+	return []sheetRow{
+		{SheetBase: SheetBase{Id: 1}, Name: "=HYPERLINK(\"x\")", Notes: &notes, Dead: true, Fingers: []string{"left", "right"}, Secret: "s", hidden: "h"},
+		{SheetBase: SheetBase{Id: 2}, Name: "Zachary"},
+	}
+}
+
+func (suite *TableTestSuite) Test_WriteTable_WritesCSV() {
+	w := httptest.NewRecorder()
+
+	err := WriteTable(w, MediaCSV, "survivors", suite.rows())
+
+	suite.Require().NoError(err)
+	suite.Equal("text/csv; charset=utf-8", w.Header().Get("Content-Type"))
+	suite.Equal(`attachment; filename="survivors.csv"`, w.Header().Get("Content-Disposition"))
+	records, err := csv.NewReader(w.Body).ReadAll()
+	suite.Require().NoError(err)
+	suite.Equal([][]string{
+		{"id", "name", "notes", "dead", "fingers"},
+		{"1", "'=HYPERLINK(\"x\")", "Lost an arm,\n\"again\"", "true", "left; right"},
+		{"2", "Zachary", "", "false", ""},
+	}, records, "cells should be quoted and formulas defused")
+}
+
+func (suite *TableTestSuite) Test_Defuse() {
+	tests := []struct {
+		text     string
+		expected string
+	}{
+		{"", ""},
+		{"Lucy", "Lucy"},
+		{"=1+1", "'=1+1"},
+		{"+1", "'+1"},
+		{"-1", "'-1"},
+		{"@SUM(A1)", "'@SUM(A1)"},
+		{"\tcmd", "'\tcmd"},
+		{"a=b", "a=b"},
+	}
+
+	for _, tt := range tests {
+		suite.Run(tt.text, func() {
+			suite.Equal(tt.expected, defuse(tt.text))
+		})
+	}
+}
+
+func (suite *TableTestSuite) Test_WriteTable_WritesXLSX() {
+	w := httptest.NewRecorder()
+
+	err := WriteTable(w, MediaXLSX, "Survivors of Lantern [1]", suite.rows())
+
+	suite.Require().NoError(err)
+	suite.Equal(MediaXLSX, w.Header().Get("Content-Type"))
+	suite.Equal(strconv.Itoa(w.Body.Len()), w.Header().Get("Content-Length"), "the table should be rendered before it is written")
+	archive, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	suite.Require().NoError(err)
+	parts := map[string]string{}
+	for _, f := range archive.File {
+		part, err := f.Open()
+		suite.Require().NoError(err)
+		content, err := io.ReadAll(part)
+		suite.Require().NoError(err)
+		parts[f.Name] = string(content)
+	}
+	suite.ElementsMatch([]string{"[Content_Types].xml", "_rels/.rels", "xl/_rels/workbook.xml.rels", "xl/workbook.xml", "xl/worksheets/sheet1.xml"}, keys(parts))
+	for name, content := range parts {
+		suite.NoError(wellFormed(content), "%s should be well formed", name)
+	}
+	suite.Contains(parts["xl/workbook.xml"], `<sheet name="Survivors of Lantern _1_"`, "reserved characters should be replaced in sheet names")
+
+	sheet := parts["xl/worksheets/sheet1.xml"]
+	suite.Contains(sheet, `<c r="A1" t="inlineStr"><is><t xml:space="preserve">id</t></is></c>`)
+	suite.Contains(sheet, `<c r="A2"><v>1</v></c>`, "integers should be numbers")
+	suite.Contains(sheet, `<c r="B2" t="inlineStr"><is><t xml:space="preserve">=HYPERLINK(&#34;x&#34;)</t></is></c>`, "text should be escaped and never run as a formula")
+	suite.Contains(sheet, `<c r="D2" t="b"><v>1</v></c>`, "booleans should be booleans")
+	suite.Contains(sheet, `<c r="E2" t="inlineStr"><is><t xml:space="preserve">left; right</t></is></c>`)
+	suite.NotContains(sheet, `r="C3"`, "empty cells should be left out")
+}
+
+func (suite *TableTestSuite) Test_SheetNames_AreTruncated() {
+	var b bytes.Buffer
+
+	suite.Require().NoError(writeXLSX(&b, strings.Repeat("é", 40), []string{"id"}, nil))
+
+	archive, err := zip.NewReader(bytes.NewReader(b.Bytes()), int64(b.Len()))
+	suite.Require().NoError(err)
+	part, err := archive.Open("xl/workbook.xml")
+	suite.Require().NoError(err)
+	content, err := io.ReadAll(part)
+	suite.Require().NoError(err)
+	suite.Contains(string(content), `name="`+strings.Repeat("é", 31)+`"`)
+}
+
+func (suite *TableTestSuite) Test_ColumnName() {
+	tests := map[int]string{0: "A", 25: "Z", 26: "AA", 51: "AZ", 52: "BA", 701: "ZZ", 702: "AAA"}
+
+	for i, expected := range tests {
+		suite.Equal(expected, columnName(i), "column %d", i)
+	}
+}
+
+func keys(m map[string]string) []string {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	return names
+}
+
+func wellFormed(content string) error {
+	decoder := xml.NewDecoder(strings.NewReader(content))
+	for {
+		if _, err := decoder.Token(); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+func TestTableTestSuite(t *testing.T) {
+	suite.Run(t, new(TableTestSuite))
+}
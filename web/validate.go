@@ -0,0 +1,190 @@
+package web
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// FieldError describes a single invalid field using its JSON name.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationError collects every field error found in a request so that clients
+// can fix them all at once.
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		messages[i] = f.Field + " " + f.Message
+	}
+	return "validation failed: " + strings.Join(messages, "; ")
+}
+
+func (e *ValidationError) Add(field, message string) {
+	e.Fields = append(e.Fields, FieldError{Field: field, Message: message})
+}
+
+func (e *ValidationError) OrNil() error {
+	if e == nil || len(e.Fields) == 0 {
+		return nil
+	}
+	return e
+}
+
+// Validate checks the `validate` struct tags of v and reports every violation.
+// Supported rules are required, min=N, max=N and oneof=a b c. For strings min and
+// max bound the length, for slices the number of items and for integers the
+// value. Required slices must not be empty and oneof applies to every element of
+// a string slice. Rules on a nil pointer are skipped unless the field is
+// required. Nested structs are validated too and their fields reported as
+// parent.child.
+func Validate(v any) error {
+	errs := &ValidationError{}
+	validateStruct(reflect.Indirect(reflect.ValueOf(v)), "", errs)
+	return errs.OrNil()
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+func validateStruct(rv reflect.Value, prefix string, errs *ValidationError) {
+	if rv.Kind() != reflect.Struct {
+		return
+	}
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		field := rt.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name := prefix + jsonName(field)
+		rules := field.Tag.Get("validate")
+		value := rv.Field(i)
+		if value.Kind() == reflect.Pointer {
+			if value.IsNil() {
+				if strings.Contains(","+rules+",", ",required,") {
+					errs.Add(name, "is required")
+				}
+				continue
+			}
+			value = value.Elem()
+		}
+		if value.Kind() == reflect.Struct && value.Type() != timeType {
+			validateStruct(value, name+".", errs)
+			continue
+		}
+		if rules == "" {
+			continue
+		}
+		for _, rule := range strings.Split(rules, ",") {
+			if msg := checkRule(value, rule); msg != "" {
+				errs.Add(name, msg)
+				break
+			}
+		}
+	}
+}
+
+func checkRule(value reflect.Value, rule string) string {
+	name, arg, _ := strings.Cut(rule, "=")
+	switch name {
+	case "required":
+		if value.Kind() == reflect.String && strings.TrimSpace(value.String()) == "" {
+			return "is required"
+		}
+		if value.Kind() == reflect.Slice && value.Len() == 0 {
+			return "is required"
+		}
+	case "min", "max":
+		bound, err := strconv.Atoi(arg)
+		if err != nil {
+			panic(fmt.Sprintf("invalid validation rule %q", rule))
+		}
+		return checkBound(value, name, bound)
+	case "oneof":
+		allowed := strings.Fields(arg)
+		if value.Kind() == reflect.Slice {
+			for i := 0; i < value.Len(); i++ {
+				if !slices.Contains(allowed, value.Index(i).String()) {
+					return "must only contain " + strings.Join(allowed, ", ")
+				}
+			}
+			return ""
+		}
+		if !slices.Contains(allowed, value.String()) {
+			return "must be one of " + strings.Join(allowed, ", ")
+		}
+	default:
+		panic(fmt.Sprintf("unknown validation rule %q", rule))
+	}
+	return ""
+}
+
+func checkBound(value reflect.Value, name string, bound int) string {
+	switch value.Kind() {
+	case reflect.String:
+		length := utf8.RuneCountInString(value.String())
+		if name == "min" && length < bound {
+			return fmt.Sprintf("must be at least %d characters", bound)
+		}
+		if name == "max" && length > bound {
+			return fmt.Sprintf("must be at most %d characters", bound)
+		}
+	case reflect.Slice:
+		if name == "min" && value.Len() < bound {
+			return fmt.Sprintf("must have at least %d items", bound)
+		}
+		if name == "max" && value.Len() > bound {
+			return fmt.Sprintf("must have at most %d items", bound)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n := value.Int()
+		if name == "min" && n < int64(bound) {
+			return fmt.Sprintf("must be at least %d", bound)
+		}
+		if name == "max" && n > int64(bound) {
+			return fmt.Sprintf("must be at most %d", bound)
+		}
+	}
+	return ""
+}
+
+// jsonName is the name a field is reported under: its JSON name, or its query
+// parameter name for query structs.
+func jsonName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "" || name == "-" {
+		if query := field.Tag.Get("query"); query != "" {
+			return query
+		}
+		return field.Name
+	}
+	return name
+}
+
+func jsonFieldNames(t reflect.Type) map[string]bool {
+	names := map[string]bool{}
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return names
+	}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() || field.Tag.Get("json") == "-" {
+			continue
+		}
+		names[jsonName(field)] = true
+	}
+	return names
+}
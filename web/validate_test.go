@@ -0,0 +1,96 @@
+package web
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type ValidateTestSuite struct {
+	suite.Suite
+}
+
+type hunter struct {
+	Name     string    `json:"name" validate:"required,max=8"`
+	Survival *int      `json:"survival" validate:"min=0,max=3"`
+	Gender   string    `json:"gender,omitempty" validate:"oneof=M F"`
+	Tags     []string  `json:"tags" validate:"max=2,oneof=a b"`
+	Gear     []string  `json:"gear" validate:"required"`
+	Born     time.Time `json:"born"`
+	Hunt     *hunt     `json:"hunt"`
+	Notes    *string   `json:"notes" validate:"required"`
+	secret   string    `validate:"required"`
+}
+
+type hunt struct {
+	Quarry string `json:"quarry" validate:"required"`
+	Level  int    `query:"level" validate:"min=1,max=3"`
+}
+
+func (suite *ValidateTestSuite) valid() hunter {
+	notes := "Lantern year 1"
+	return hunter{Name: "Lucy", Gender: "F", Gear: []string{"Founding stone"}, Notes: &notes}
+}
+
+func (suite *ValidateTestSuite) Test_Validate() {
+	four, minus := 4, -1
+	tests := []struct {
+		name     string
+		change   func(h *hunter)
+		expected []FieldError
+	}{
+		{"valid", func(h *hunter) {}, nil},
+		{"blank required string", func(h *hunter) { h.Name = "  " }, []FieldError{{"name", "is required"}}},
+		{"string too long", func(h *hunter) { h.Name = "Zachariah" }, []FieldError{{"name", "must be at most 8 characters"}}},
+		{"string length counts runes", func(h *hunter) { h.Name = "Ŝŝŝŝŝŝŝŝ" }, nil},
+		{"nil optional pointer", func(h *hunter) { h.Survival = nil }, nil},
+		{"integer above max", func(h *hunter) { h.Survival = &four }, []FieldError{{"survival", "must be at most 3"}}},
+		{"integer below min", func(h *hunter) { h.Survival = &minus }, []FieldError{{"survival", "must be at least 0"}}},
+		{"value not allowed", func(h *hunter) { h.Gender = "X" }, []FieldError{{"gender", "must be one of M, F"}}},
+		{"slice item not allowed", func(h *hunter) { h.Tags = []string{"a", "c"} }, []FieldError{{"tags", "must only contain a, b"}}},
+		{"slice too long", func(h *hunter) { h.Tags = []string{"a", "b", "a"} }, []FieldError{{"tags", "must have at most 2 items"}}},
+		{"empty required slice", func(h *hunter) { h.Gear = []string{} }, []FieldError{{"gear", "is required"}}},
+		{"nil required pointer", func(h *hunter) { h.Notes = nil }, []FieldError{{"notes", "is required"}}},
+		{"nested struct", func(h *hunter) { h.Hunt = &hunt{Level: 4} }, []FieldError{{"hunt.quarry", "is required"}, {"hunt.level", "must be at most 3"}}},
+		{"every field at once", func(h *hunter) { h.Name, h.Gender = "", "X" }, []FieldError{{"name", "is required"}, {"gender", "must be one of M, F"}}},
+	}
+
+	for _, tt := range tests {
+		suite.Run(tt.name, func() {
+			h := suite.valid()
+			tt.change(&h)
+
+			err := Validate(&h)
+
+			if tt.expected == nil {
+				suite.NoError(err)
+				return
+			}
+			var invalid *ValidationError
+			suite.Require().ErrorAs(err, &invalid)
+			suite.Equal(tt.expected, invalid.Fields)
+		})
+	}
+}
+
+func (suite *ValidateTestSuite) Test_Validate_PanicsOnUnknownRules() {
+	suite.Panics(func() {
+		_ = Validate(struct {
+			Name string `validate:"lowercase"`
+		}{})
+	})
+}
+
+func (suite *ValidateTestSuite) Test_ValidationError_ListsEveryField() {
+	err := &ValidationError{}
+	suite.NoError(err.OrNil(), "no fields should mean no error")
+	err.Add("name", "is required")
+	err.Add("year", "must be at least 1")
+
+	suite.EqualError(err.OrNil(), "validation failed: name is required; year must be at least 1")
+}
+
+func TestValidateTestSuite(t *testing.T) {
+	suite.Run(t, new(ValidateTestSuite))
+}
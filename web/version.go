@@ -0,0 +1,42 @@
+package web
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Version describes an API version mounted under /{Name}. A version with a
+// Deprecation date advertises it with the Deprecation (RFC 9745), Sunset (RFC 8594)
+// and successor Link headers so clients can plan their migration.
+type Version struct {
+	Name        string
+	Deprecation time.Time
+	Sunset      time.Time
+	Successor   string
+}
+
+func (v Version) Prefix() string {
+	return "/" + v.Name
+}
+
+func (v Version) Deprecated() bool {
+	return !v.Deprecation.IsZero()
+}
+
+// Headers is middleware that adds the version's lifecycle headers to every response.
+func (v Version) Headers(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if v.Deprecated() {
+			headers := w.Header()
+			headers.Set("Deprecation", fmt.Sprintf("@%d", v.Deprecation.Unix()))
+			if !v.Sunset.IsZero() {
+				headers.Set("Sunset", v.Sunset.UTC().Format(http.TimeFormat))
+			}
+			if v.Successor != "" {
+				headers.Add("Link", fmt.Sprintf(`</%s>; rel="successor-version"`, v.Successor))
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,118 @@
+package web
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// The parts of a workbook besides its only worksheet.
+var workbookParts = []struct{ name, content string }{
+	{"[Content_Types].xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types"><Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/><Default Extension="xml" ContentType="application/xml"/><Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/><Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/></Types>`},
+	{"_rels/.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/></Relationships>`},
+	{"xl/_rels/workbook.xml.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/></Relationships>`},
+}
+
+// writeXLSX writes a workbook with a single worksheet named sheet. Strings are
+// written inline so the workbook needs no shared string table.
+func writeXLSX(w io.Writer, sheet string, header []string, cells [][]any) error {
+	archive := zip.NewWriter(w)
+	for _, part := range workbookParts {
+		if err := writePart(archive, part.name, part.content); err != nil {
+			return err
+		}
+	}
+	// Sheet names are limited to 31 characters, some of which are reserved.
+	sheet = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`[]:*?/\`, r) {
+			return '_'
+		}
+		return r
+	}, sheet)
+	if runes := []rune(sheet); len(runes) > 31 {
+		sheet = string(runes[:31])
+	}
+	var workbook strings.Builder
+	workbook.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets><sheet name="`)
+	escape(&workbook, sheet)
+	workbook.WriteString(`" sheetId="1" r:id="rId1"/></sheets></workbook>`)
+	if err := writePart(archive, "xl/workbook.xml", workbook.String()); err != nil {
+		return err
+	}
+
+	part, err := archive.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return err
+	}
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+	titles := make([]any, len(header))
+	for i, h := range header {
+		titles[i] = h
+	}
+	writeRow(&b, 1, titles)
+	for i, row := range cells {
+		writeRow(&b, i+2, row)
+	}
+	b.WriteString(`</sheetData></worksheet>`)
+	if _, err := io.WriteString(part, b.String()); err != nil {
+		return err
+	}
+	return archive.Close()
+}
+
+func writePart(archive *zip.Writer, name, content string) error {
+	part, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(part, content)
+	return err
+}
+
+func writeRow(b *strings.Builder, number int, cells []any) {
+	line := strconv.Itoa(number)
+	b.WriteString(`<row r="` + line + `">`)
+	for i, c := range cells {
+		ref := columnName(i) + line
+		switch c := c.(type) {
+		case int64:
+			b.WriteString(`<c r="` + ref + `"><v>` + strconv.FormatInt(c, 10) + `</v></c>`)
+		case bool:
+			v := "0"
+			if c {
+				v = "1"
+			}
+			b.WriteString(`<c r="` + ref + `" t="b"><v>` + v + `</v></c>`)
+		case string:
+			if c == "" {
+				continue
+			}
+			b.WriteString(`<c r="` + ref + `" t="inlineStr"><is><t xml:space="preserve">`)
+			escape(b, c)
+			b.WriteString(`</t></is></c>`)
+		}
+	}
+	b.WriteString(`</row>`)
+}
+
+// columnName returns the letters naming the zero based column i: A, B, ... Z,
+// AA and so on.
+func columnName(i int) string {
+	name := ""
+	for i++; i > 0; i = (i - 1) / 26 {
+		name = string(rune('A'+(i-1)%26)) + name
+	}
+	return name
+}
+
+func escape(b *strings.Builder, text string) {
+	_ = xml.EscapeText(b, []byte(text))
+}